@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -56,11 +58,12 @@ func UserClientService() error {
 	defer c.Close()
 
 	// login
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 	username, password := "abc@gmail.com", "stringstring"
-	token, err := c.Login(username, password)
-	if err != nil {
+	if _, err := c.Login(ctx, username, password); err != nil {
 		return logError(zapLogger, err)
 	}
-	zapLogger.Bg().Info("login resp", zap.String("token", token))
+	zapLogger.Bg().Info("login resp", zap.String("token", c.Token()))
 	return nil
 }
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	certOut         string
+	certHosts       []string
+	certClients     []string
+	certTrustDomain string
+	certValidity    time.Duration
+)
+
+var genCertCmd = &cobra.Command{
+	Use:   "gen-cert",
+	Short: "Generate local CA, server & client certificates",
+	Long: `Generate a local CA, a server certificate & client certificates for local development & tests.
+Client certificates carry the subject common name <client> & the URI SAN spiffe://<trust-domain>/<client>.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return genCert()
+	},
+}
+
+func init() {
+	genCertCmd.Flags().StringVar(&certOut, "out", "./cert", "output directory")
+	genCertCmd.Flags().StringSliceVar(&certHosts, "hosts", []string{"localhost", "127.0.0.1", "0.0.0.0", "user-service"}, "server certificate DNS names & IPs")
+	genCertCmd.Flags().StringSliceVar(&certClients, "clients", []string{"gateway", "user-client"}, "client certificates names")
+	genCertCmd.Flags().StringVar(&certTrustDomain, "trust-domain", "moneyforward", "trust domain of client URI SANs")
+	genCertCmd.Flags().DurationVar(&certValidity, "validity", 365*24*time.Hour, "certificates validity")
+	rootCmd.AddCommand(genCertCmd)
+}
+
+func genCert() error {
+	logger := log.With(zap.String("cmd", "gen-cert"))
+	if err := os.MkdirAll(certOut, 0755); err != nil {
+		return logError(logger, err)
+	}
+	path := func(name string) string {
+		return filepath.Join(certOut, name)
+	}
+
+	// local CA
+	ca, err := utils.GenerateCA("moneyforward local CA", certValidity)
+	if err != nil {
+		return logError(logger, err)
+	}
+	if err := ca.WritePEM(path("ca-cert.pem"), path("ca-key.pem")); err != nil {
+		return logError(logger, err)
+	}
+
+	// server
+	server, err := utils.GenerateLeaf(ca, utils.LeafOptions{
+		CommonName: "user-service",
+		Hosts:      certHosts,
+		URIs:       []string{"spiffe://" + certTrustDomain + "/user-service"},
+		Server:     true,
+		Validity:   certValidity,
+	})
+	if err != nil {
+		return logError(logger, err)
+	}
+	if err := server.WritePEM(path("server-cert.pem"), path("server-key.pem")); err != nil {
+		return logError(logger, err)
+	}
+
+	// clients
+	for _, name := range certClients {
+		client, err := utils.GenerateLeaf(ca, utils.LeafOptions{
+			CommonName: name,
+			URIs:       []string{"spiffe://" + certTrustDomain + "/" + name},
+			Validity:   certValidity,
+		})
+		if err != nil {
+			return logError(logger, err)
+		}
+		if err := client.WritePEM(path(name+"-cert.pem"), path(name+"-key.pem")); err != nil {
+			return logError(logger, err)
+		}
+	}
+	logger.Bg().Info("certificates generated", zap.String("out", certOut), zap.Strings("hosts", certHosts), zap.Strings("clients", certClients))
+	return nil
+}
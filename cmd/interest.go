@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user"
+)
+
+var interestDay string
+
+var accrueInterestCmd = &cobra.Command{
+	Use:   "accrue-interest",
+	Short: "Accrue interest & post monthly fees of rate plans",
+	Long: `Accrue interest of rate plans on account balances through a day & post interest & fees of the month ends reached.
+Days missed are caught up & days accrued already are skipped, re-running a day posts nothing.
+The user service accrues the last closed day in-process as well, on the database it migrated.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return accrueInterest()
+	},
+}
+
+func init() {
+	accrueInterestCmd.Flags().StringVar(&interestDay, "day", "", "last day accrued, 2006-01-02 in the interest time zone, yesterday or before (default yesterday)")
+	rootCmd.AddCommand(accrueInterestCmd)
+}
+
+func accrueInterest() error {
+	logger := log.With(zap.String("cmd", "accrue-interest"))
+	ctx := context.Background()
+	dal, err := postgres.NewDataAccessLayer(ctx, cfgs.Database)
+	if err != nil {
+		return logError(logger, err)
+	}
+	defer dal.Disconnect()
+
+	accrual, err := user.NewInterestAccrual(dal, cfgs.Interest)
+	if err != nil {
+		return logError(logger, err)
+	}
+	day := interestDay
+	if day == "" {
+		day = accrual.LastClosedDay()
+	} else if err := accrual.CheckDay(day); err != nil {
+		return logError(logger, fmt.Errorf("invalid --day: %w", err))
+	}
+	n, err := accrual.Accrue(ctx, day)
+	if err != nil {
+		return logError(logger, err)
+	}
+	logger.Bg().Info("Interest accrued", zap.String("day", day), zap.Int("accounts", n))
+	return nil
+}
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"context"
+	"time"
+
 	grpcClient "github.com/1412335/moneyforward-go-coding-challenge/pkg/client"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
@@ -31,9 +34,13 @@ func userService() error {
 	zapLogger := log.With(zap.String("service", cfgs.ServiceName), zap.String("version", cfgs.Version))
 
 	// server
-	server := user.NewServer(
+	server, err := user.NewServer(
 		cfgs,
 	)
+	if err != nil {
+		zapLogger.Error("Init gRPC server error", zap.Error(err))
+		return err
+	}
 
 	// run grpc server
 	// return logError(zapLogger, server.Run())
@@ -49,8 +56,9 @@ func userService() error {
 	}()
 
 	// run grpc-gateway
-	handler := handler.NewHandler(cfgs)
-	err := handler.Run()
+	handler := handler.NewHandler(cfgs, handler.WithKeySet(server.TokenService()), handler.WithOIDC(server.OIDCLinker()),
+		handler.WithRateLimiter(server.GatewayRateLimiter(), server.TokenService()))
+	err = handler.Run()
 	if err != nil {
 		zapLogger.Error("Starting gRPC-gateway error", zap.Error(err))
 	}
@@ -69,11 +77,12 @@ func testGrpcClient(cfgs *configs.ClientConfig) error {
 	defer c.Close()
 
 	// login
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 	username, password := "abc@gmail.com", "stringstring"
-	token, err := c.Login(username, password)
-	if err != nil {
+	if _, err := c.Login(ctx, username, password); err != nil {
 		return err
 	}
-	log.Info("login resp", zap.String("token", token))
+	log.Info("login resp", zap.String("token", c.Token()))
 	return nil
 }
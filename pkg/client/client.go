@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto/tls"
 	"net"
 	"strconv"
 
@@ -29,11 +30,20 @@ func WithInterceptors(interceptor ...interceptor.ClientInterceptor) Option {
 	}
 }
 
+// WithDialOptions appends extra grpc dial options, ex: custom dialer
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *Client) error {
+		c.dialOptions = append(c.dialOptions, opts...)
+		return nil
+	}
+}
+
 type Client struct {
 	config       *configs.ClientConfig
 	ClientConn   *grpc.ClientConn
 	logger       log.Factory
 	interceptors []interceptor.ClientInterceptor
+	dialOptions  []grpc.DialOption
 }
 
 func New(cfgs *configs.ClientConfig, opt ...Option) (*Client, error) {
@@ -51,6 +61,13 @@ func New(cfgs *configs.ClientConfig, opt ...Option) (*Client, error) {
 		return nil, err
 	}
 
+	// retry & circuit breaker wrap other interceptors
+	if cfgs.Retry != nil || cfgs.CircuitBreaker != nil {
+		client.interceptors = append([]interceptor.ClientInterceptor{
+			interceptor.NewRetryClientInterceptor(cfgs.Retry, cfgs.CircuitBreaker),
+		}, client.interceptors...)
+	}
+
 	// resolve grpc-server address
 	addr := net.JoinHostPort(cfgs.GRPC.Host, strconv.Itoa(cfgs.GRPC.Port))
 
@@ -87,6 +104,9 @@ func New(cfgs *configs.ClientConfig, opt ...Option) (*Client, error) {
 		opts = append(opts, grpc.WithDefaultCallOptions(callOptions...))
 	}
 
+	// extra dial options
+	opts = append(opts, client.dialOptions...)
+
 	// connect grpc server
 	conn, err := grpc.Dial(
 		addr,
@@ -111,10 +131,19 @@ func (c *Client) Init(opt ...Option) error {
 }
 
 func (c *Client) loadClientTLSCredentials() (credentials.TransportCredentials, error) {
-	config, err := utils.LoadClientTLSConfig(c.config.TLSCert.CACert)
+	cert := c.config.TLSCert
+	var config *tls.Config
+	var err error
+	if cert.MutualTLS {
+		// present client certificate
+		config, err = utils.LoadMutualClientTLSConfig(cert.CACert, cert.CertPem, cert.KeyPem)
+	} else {
+		config, err = utils.LoadClientTLSConfig(cert.CACert)
+	}
 	if err != nil {
 		return nil, err
 	}
+	config.ServerName = cert.ServerName
 	// Create the credentials and return it
 	return credentials.NewTLS(config), nil
 }
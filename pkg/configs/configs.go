@@ -12,11 +12,19 @@ const (
 	ConfigPath = "."
 )
 
+// Database drivers
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
 type ServiceConfig struct {
 	Version     string
 	ServiceName string
 	GRPC        *GRPC
 	Proxy       *Proxy
+	// internal listener of debug endpoints (expvar metrics), disabled if nil
+	Debug *Debug
 	// client services
 	ClientConfig map[string]*ClientConfig
 	Database     *Database
@@ -28,6 +36,202 @@ type ServiceConfig struct {
 	Log *Log
 	//
 	AuthRequiredMethods map[string]bool
+	// client certificates identities
+	ServiceIdentities []*ServiceIdentity
+	// login brute-force protection
+	LoginProtection *LoginProtection
+	// emails of users allowed to call admin methods
+	Admins []string
+	// proxies (ex: grpc-gateway) trusted to forward client IP in x-forwarded-for, CIDRs or IPs, defaults to loopback
+	TrustedProxies []string
+	// validity of password reset tokens, defaults to 1h
+	PasswordResetTTL time.Duration
+	// email verification on signup & email change
+	EmailVerification *EmailVerification
+	// TOTP 2FA
+	TwoFactor *TwoFactor
+	// login w an external OpenID Connect provider on the gateway
+	OIDC *OIDC
+	// rules of new passwords, defaults to 8 chars min
+	PasswordPolicy *PasswordPolicy
+	// algorithm & cost of new password hashes, stored hashes are upgraded on login
+	PasswordHashing *PasswordHashing
+	// token bucket limits per method & caller on the grpc server & gateway
+	RateLimit *RateLimit
+	// state shared by service instances
+	Redis *Redis
+	// withdrawal & transaction limits of user tiers, accounts may have their own
+	TransactionLimits *TransactionLimits
+	// daily fee of overdrawn accounts
+	Overdraft *Overdraft
+	// pending holds of authorize/capture flows
+	Holds *Holds
+	// posting of scheduled transactions
+	Scheduler *Scheduler
+	// interest accrual & monthly fees of rate plans
+	Interest *Interest
+	// exchange rates of transfers between currencies
+	FX *FX
+}
+
+type FX struct {
+	// static | file, defaults to static
+	Provider string
+	// currency of static rates, cross rates go through it
+	Base string
+	// static rates of 1 base in other currencies, ex: VND: "23000"
+	Rates map[string]string
+	// JSON file of {"base": "USD", "rates": {"VND": "23000"}} w file provider, reloaded when it changes
+	File string
+}
+
+type Interest struct {
+	// how often the last closed day is accrued, defaults to 1h
+	CheckInterval time.Duration
+	// time zone of accrual days & month ends, defaults to UTC
+	Location string
+}
+
+type Scheduler struct {
+	// how often due schedules are posted, defaults to 1m
+	CheckInterval time.Duration
+}
+
+type Holds struct {
+	// validity of a hold until voided, defaults to 7 days
+	TTL time.Duration
+	// how often expired holds are voided, defaults to 1m
+	CheckInterval time.Duration
+}
+
+type Overdraft struct {
+	// fee posted once a day on accounts w a negative balance, 0 disables it
+	DailyFee float64
+	// currency of the fee, converted at FX rates for accounts in other currencies, defaults to VND
+	Currency string
+	// how often overdrawn accounts are checked for the fee of the day, defaults to 1h
+	CheckInterval time.Duration
+	// time zone of fee days, defaults to UTC
+	Location string
+}
+
+type TransactionLimits struct {
+	// tier of users w/o one, defaults to standard
+	DefaultTier string
+	// limits per tier name
+	Tiers map[string]*TransactionLimit
+	// time zone of daily & monthly windows, defaults to UTC
+	Location string
+	// currency of tier limits, withdrawals in other currencies are converted at FX rates, defaults to VND
+	Currency string
+}
+
+// limits of a tier, 0 is unlimited
+type TransactionLimit struct {
+	MaxWithdrawal     float64
+	DailyWithdrawal   float64
+	MonthlyWithdrawal float64
+	DailyTransactions int
+}
+
+type RateLimit struct {
+	// limits of grpc methods, a request must pass every matching rule
+	Rules []*RateLimitRule
+	// limits of gateway requests, checked before proxying to the grpc server
+	GatewayRules []*RateLimitRule
+	// memory | redis, defaults to memory: buckets are kept per service instance
+	Store string
+}
+
+type RateLimitRule struct {
+	// full grpc methods or "<HTTP method> <path>" of gateway rules, a trailing "*" matches any suffix
+	Methods []string
+	// caller identity a bucket is kept for: user (JWT user id) | apikey | ip,
+	// the rule is skipped for requests w/o that identity
+	Per string
+	// tokens refilled per second
+	Rate float64
+	// bucket size, defaults to rate rounded up
+	Burst int
+}
+
+type Redis struct {
+	// a single node or cluster nodes
+	Nodes    []string
+	Password string
+	DB       int
+	// prefix of keys
+	Prefix string
+}
+
+type PasswordPolicy struct {
+	// defaults to 8
+	MinLength int
+	// classes among lowercase, uppercase, digits & symbols a password must contain
+	MinCharClasses int
+	// reject passwords of the bundled common passwords list
+	RejectCommon bool
+	// last passwords of a user a new one must differ from, disabled if 0
+	HistorySize int
+}
+
+type PasswordHashing struct {
+	// bcrypt | argon2id, defaults to bcrypt
+	Algorithm string
+	// defaults to 10
+	BcryptCost int
+	// argon2id iterations, memory in KiB & parallelism, default to 3, 65536 & 4
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+type OIDC struct {
+	// provider issuer, its discovery document is served at <issuer>/.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// gateway callback registered at the provider, ex: https://localhost:8082/auth/oidc/callback
+	RedirectURL string
+	// scopes requested besides openid, defaults to email
+	Scopes []string
+	// timeout of requests to the provider, defaults to 10s
+	Timeout time.Duration
+}
+
+type TwoFactor struct {
+	// issuer shown by authenticator apps
+	Issuer string
+	// validity of login challenge tokens, defaults to 5m
+	ChallengeTTL time.Duration
+	// methods requiring a 2FA code in x-totp-code metadata, rejected for users w/o 2FA
+	StepUpMethods map[string]bool
+	// withdrawals & holds of at least this amount require a 2FA code, disabled if 0
+	StepUpWithdrawAmount float64
+	// currency of StepUpWithdrawAmount, amounts of accounts in other currencies are converted at FX rates, defaults to VND
+	StepUpWithdrawCurrency string
+}
+
+type EmailVerification struct {
+	// validity of verification tokens, defaults to 24h
+	TokenTTL time.Duration
+	// methods rejected for users w unverified email
+	RequiredMethods map[string]bool
+}
+
+// login failed attempts tracking per email & IP
+type LoginProtection struct {
+	// failed attempts w/o delay
+	FreeAttempts int
+	// delay after each following failure, doubled per failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// failed attempts before lockout, per email & per IP
+	MaxAttempts     int
+	MaxIPAttempts   int
+	LockoutDuration time.Duration
+	// failures are forgotten after this period w/o failure
+	Window time.Duration
 }
 
 type ClientConfig struct {
@@ -38,12 +242,60 @@ type ClientConfig struct {
 	// tls secure service
 	EnableTLS bool
 	TLSCert   *TLSCert
+	// retry Unavailable, ResourceExhausted & Aborted calls
+	Retry *Retry
+	// circuit breaker per target
+	CircuitBreaker *CircuitBreaker
+}
+
+// client retry policy w jittered exponential backoff
+type Retry struct {
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// methods safe to retry, others are retried only w an idempotency key
+	IdempotentMethods map[string]bool
+}
+
+// client circuit breaker
+type CircuitBreaker struct {
+	// consecutive failures opening the breaker
+	MaxFailures uint32
+	// requests allowed while half-open
+	MaxRequests uint32
+	// cyclic period clearing failure counts while closed
+	Interval time.Duration
+	// period of open state before half-open
+	Timeout time.Duration
 }
 
 type TLSCert struct {
 	CACert  string
 	CertPem string
 	KeyPem  string
+	// mutual TLS: server requires client certificates signed by CACert,
+	// clients present CertPem/KeyPem
+	MutualTLS bool
+	// name verified against the server certificate, defaults to dialed host
+	ServerName string
+	// client certificate presented by the gateway to the grpc server
+	ClientCertPem string
+	ClientKeyPem  string
+	// interval between checks of CertPem/KeyPem/CACert changes on disk
+	ReloadInterval time.Duration
+	// log warnings when the server certificate expires within this period
+	ExpiryWarning time.Duration
+}
+
+// service identity of a verified client certificate
+type ServiceIdentity struct {
+	Name string
+	// matched against certificate URI SANs (ex: spiffe://moneyforward/gateway)
+	URI string
+	// matched against certificate subject common name if no URI matches
+	CommonName string
+	// methods authorized w/o JWT, "*" for all
+	Methods []string
 }
 
 // grpc-server
@@ -59,15 +311,41 @@ type Proxy struct {
 	Port int
 }
 
+// internal listener, not to be exposed publicly
+type Debug struct {
+	// ex: "127.0.0.1:6060", disabled if empty
+	Addr string
+}
+
 // json web token
 type JWT struct {
 	Issuer    string
 	SecretKey string
 	Duration  time.Duration
+	// signing algorithm: HS256 (default, w SecretKey) | RS256 | EdDSA
+	Algorithm string
+	// private keys of RS256/EdDSA, the first one signs tokens, others only verify tokens
+	Keys []*JWTKey
+	// generate a new signing key every interval, 0 disables rotation
+	RotationInterval time.Duration
+	// rotated keys keep verifying tokens during this period, defaults to Duration
+	KeyRetention time.Duration
+}
+
+// JWT signing key
+type JWTKey struct {
+	// kid, defaults to the public key thumbprint
+	ID string
+	// PKCS#8 or PKCS#1 private key file
+	PrivateKeyPem string
 }
 
 // Database config
 type Database struct {
+	// postgres (default) or sqlite
+	Driver string
+	// sqlite database file, empty for in-memory
+	Path           string
 	Host           string
 	Port           string
 	User           string
@@ -77,6 +355,20 @@ type Database struct {
 	MaxIdleConns   int
 	MaxOpenConns   int
 	ConnectTimeout time.Duration
+	// retry policy of transactions failed by serialization/deadlock errors,
+	// max retries defaults to 3 if unset, 0 disables retries
+	TxMaxRetries   *int
+	TxRetryBackoff time.Duration
+	// read replicas serving read-only queries, empty fields inherit from primary
+	Replicas []*Database
+	// interval between primary & replicas health checks
+	HealthCheckInterval time.Duration
+	// connect retry policy at startup: max retries (-1 retries forever) w exponential backoff
+	ConnectMaxRetries   int
+	ConnectRetryBackoff time.Duration
+	// serve w NOT_SERVING health status & keep reconnecting instead of failing
+	// when the database is unreachable at startup
+	AllowDegraded bool
 }
 
 // Log config
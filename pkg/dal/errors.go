@@ -0,0 +1,150 @@
+package dal
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Typed database errors, drivers specific errors are translated into one of these
+var (
+	ErrUniqueViolation      = errors.New("unique violation")
+	ErrForeignKeyViolation  = errors.New("foreign key violation")
+	ErrSerializationFailure = errors.New("serialization failure")
+	ErrDeadlock             = errors.New("deadlock detected")
+	ErrConnectionRefused    = errors.New("connection refused")
+	ErrDeadlineExceeded     = errors.New("deadline exceeded")
+	ErrNetworkTimeout       = errors.New("network timeout")
+)
+
+// postgres error codes
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation      = "23505"
+	pgForeignKeyViolation  = "23503"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	pgQueryCanceled        = "57014"
+	pgAdminShutdown        = "57P01"
+	pgCannotConnectNow     = "57P03"
+	// class 08: connection exception
+	pgConnectionException = "08"
+)
+
+// Error is a driver error classified as one of the typed errors
+type Error struct {
+	// one of typed errors
+	Kind error
+	// violated constraint if reported by the driver
+	Constraint string
+	// original driver error
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Constraint != "" {
+		return e.Kind.Error() + " (" + e.Constraint + "): " + e.Err.Error()
+	}
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the typed error kind, ex: errors.Is(err, dal.ErrUniqueViolation)
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+// Translate maps driver errors onto typed errors.
+// Unknown errors (incl. gorm.ErrRecordNotFound) are returned as is.
+func Translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	kind, constraint := classify(err)
+	if kind == nil {
+		return err
+	}
+	return &Error{Kind: kind, Constraint: constraint, Err: err}
+}
+
+// IsUniqueViolation reports whether err is a unique constraint violation
+// returned by one of the supported drivers
+func IsUniqueViolation(err error) bool {
+	return errors.Is(Translate(err), ErrUniqueViolation)
+}
+
+// IsRetryable reports whether a failed transaction was rolled back by the
+// database and could succeed when re-run
+func IsRetryable(err error) bool {
+	err = Translate(err)
+	return errors.Is(err, ErrSerializationFailure) ||
+		errors.Is(err, ErrDeadlock)
+}
+
+func classify(err error) (error, string) {
+	// postgres
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == pgUniqueViolation:
+			return ErrUniqueViolation, pgErr.ConstraintName
+		case pgErr.Code == pgForeignKeyViolation:
+			return ErrForeignKeyViolation, pgErr.ConstraintName
+		case pgErr.Code == pgSerializationFailure:
+			return ErrSerializationFailure, ""
+		case pgErr.Code == pgDeadlockDetected:
+			return ErrDeadlock, ""
+		case pgErr.Code == pgQueryCanceled:
+			return ErrDeadlineExceeded, ""
+		case pgErr.Code == pgAdminShutdown,
+			pgErr.Code == pgCannotConnectNow,
+			strings.HasPrefix(pgErr.Code, pgConnectionException):
+			return ErrConnectionRefused, ""
+		}
+		return nil, ""
+	}
+	// sqlite
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch {
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique,
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey:
+			return ErrUniqueViolation, ""
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey:
+			return ErrForeignKeyViolation, ""
+		case sqliteErr.Code == sqlite3.ErrBusy,
+			sqliteErr.Code == sqlite3.ErrLocked:
+			// database is locked by a concurrent writer
+			return ErrSerializationFailure, ""
+		case sqliteErr.Code == sqlite3.ErrCantOpen:
+			return ErrConnectionRefused, ""
+		}
+		return nil, ""
+	}
+	// context & network, other network errors (ex: DNS failures, resets) are not classified
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrDeadlineExceeded, ""
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, driver.ErrBadConn):
+		return ErrConnectionRefused, ""
+	}
+	// dialing or reading timed out
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrNetworkTimeout, ""
+	}
+	return nil, ""
+}
@@ -0,0 +1,85 @@
+package dal
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestTranslate(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	tests := []struct {
+		name       string
+		err        error
+		kind       error
+		constraint string
+	}{
+		{"pg unique", &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"}, ErrUniqueViolation, "users_email_key"},
+		{"pg foreign key", &pgconn.PgError{Code: "23503", ConstraintName: "fk_accounts_user"}, ErrForeignKeyViolation, "fk_accounts_user"},
+		{"pg serialization", &pgconn.PgError{Code: "40001"}, ErrSerializationFailure, ""},
+		{"pg deadlock", &pgconn.PgError{Code: "40P01"}, ErrDeadlock, ""},
+		{"pg query canceled", &pgconn.PgError{Code: "57014"}, ErrDeadlineExceeded, ""},
+		{"pg admin shutdown", &pgconn.PgError{Code: "57P01"}, ErrConnectionRefused, ""},
+		{"pg cannot connect", &pgconn.PgError{Code: "57P03"}, ErrConnectionRefused, ""},
+		{"pg connection failure", &pgconn.PgError{Code: "08006"}, ErrConnectionRefused, ""},
+		{"pg undefined table", &pgconn.PgError{Code: "42P01"}, nil, ""},
+		{"sqlite unique", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, ErrUniqueViolation, ""},
+		{"sqlite primary key", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey}, ErrUniqueViolation, ""},
+		{"sqlite foreign key", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintForeignKey}, ErrForeignKeyViolation, ""},
+		{"sqlite busy", sqlite3.Error{Code: sqlite3.ErrBusy}, ErrSerializationFailure, ""},
+		{"sqlite locked", sqlite3.Error{Code: sqlite3.ErrLocked}, ErrSerializationFailure, ""},
+		{"sqlite cant open", sqlite3.Error{Code: sqlite3.ErrCantOpen}, ErrConnectionRefused, ""},
+		{"sqlite not null", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintNotNull}, nil, ""},
+		{"record not found", gorm.ErrRecordNotFound, nil, ""},
+		{"deadline", context.DeadlineExceeded, ErrDeadlineExceeded, ""},
+		{"canceled", context.Canceled, nil, ""},
+		{"bad conn", driver.ErrBadConn, ErrConnectionRefused, ""},
+		{"connection refused", refused, ErrConnectionRefused, ""},
+		{"network timeout", &net.OpError{Op: "read", Net: "tcp", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, ErrNetworkTimeout, ""},
+		{"dns failure", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "postgres", IsNotFound: true}}, nil, ""},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, nil, ""},
+		{"wrapped pg", fmt.Errorf("create user: %w", &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"}), ErrUniqueViolation, "users_email_key"},
+		{"wrapped refused", fmt.Errorf("connect: %w", refused), ErrConnectionRefused, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Translate(tt.err)
+			if tt.kind == nil {
+				require.Equal(t, tt.err, err)
+				var e *Error
+				require.False(t, errors.As(err, &e))
+				return
+			}
+			var e *Error
+			require.True(t, errors.As(err, &e))
+			require.Equal(t, tt.kind, e.Kind)
+			require.Equal(t, tt.constraint, e.Constraint)
+			require.ErrorIs(t, err, tt.kind)
+			// original error is kept
+			require.ErrorIs(t, err, tt.err)
+			// translated once
+			require.Equal(t, err, Translate(err))
+		})
+	}
+	require.NoError(t, Translate(nil))
+}
+
+func TestIsRetryable(t *testing.T) {
+	require.True(t, IsRetryable(&pgconn.PgError{Code: "40001"}))
+	require.True(t, IsRetryable(fmt.Errorf("tx: %w", &pgconn.PgError{Code: "40P01"})))
+	require.True(t, IsRetryable(sqlite3.Error{Code: sqlite3.ErrBusy}))
+	require.False(t, IsRetryable(&pgconn.PgError{Code: "23505"}))
+	require.False(t, IsRetryable(gorm.ErrRecordNotFound))
+	require.True(t, IsUniqueViolation(&pgconn.PgError{Code: "23505"}))
+	require.False(t, IsUniqueViolation(&pgconn.PgError{Code: "23503"}))
+}
@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Default connect retry policy
+const (
+	DefaultConnectBackoff    = 500 * time.Millisecond
+	DefaultConnectMaxBackoff = 30 * time.Second
+)
+
+// ErrNotConnected is returned by queries made before the database is connected
+var ErrNotConnected = &dal.Error{
+	Kind: dal.ErrConnectionRefused,
+	Err:  errors.New("database is not connected"),
+}
+
+// ConnectWithRetry connects to the database w exponential backoff
+// until it succeeds, ctx is done or max retries is reached
+func (dal *DataAccessLayer) ConnectWithRetry(ctx context.Context) (*gorm.DB, error) {
+	backoff := dal.dbConfig.ConnectRetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultConnectBackoff
+	}
+	maxRetries := dal.dbConfig.ConnectMaxRetries
+	logger := log.With(zap.String("dal", "connect"))
+	for retry := 0; ; retry++ {
+		db, err := dal.Connect(ctx)
+		if err == nil {
+			return db, nil
+		}
+		if maxRetries >= 0 && retry >= maxRetries {
+			logger.For(ctx).Error("connect db failed", zap.Int("retries", retry), zap.Error(err))
+			return nil, err
+		}
+		wait := backoff << uint(retry)
+		if wait <= 0 || wait > DefaultConnectMaxBackoff {
+			wait = DefaultConnectMaxBackoff
+		}
+		logger.For(ctx).Info("retry connect db", zap.Int("retry", retry+1), zap.Duration("backoff", wait), zap.Error(err))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// Ping checks the primary database is reachable
+func (dal *DataAccessLayer) Ping(ctx context.Context) error {
+	db := dal.GetDatabase()
+	if db == nil {
+		return ErrNotConnected
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
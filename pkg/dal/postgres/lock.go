@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+)
+
+// TryLock takes the advisory lock key on a dedicated connection of the primary,
+// held until unlock is called or the connection is lost,
+// ok is false if another session holds it. Sqlite has a single writer & always locks.
+func (dal *DataAccessLayer) TryLock(ctx context.Context, key int64) (unlock func(), ok bool, err error) {
+	db := dal.GetDatabase()
+	if db == nil {
+		return nil, false, ErrNotConnected
+	}
+	if dal.dbConfig.Driver == configs.DriverSQLite {
+		return func() {}, true, nil
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, false, err
+	}
+	// session locks belong to the connection taking them
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok); err != nil || !ok {
+		conn.Close()
+		return nil, false, err
+	}
+	return func() {
+		// a connection failing to unlock is discarded, not returned locked to the pool
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, true, nil
+}
@@ -3,89 +3,161 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
 
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+// sqlite in-memory database shared by all connections of the pool
+const sqliteMemoryDSN = "file::memory:?cache=shared"
+
 type DataAccessLayer struct {
 	dbConfig *configs.Database
-	//Used during creation of singleton client object in GetMongoClient().
+	// guards dbInstance & replicas which are set once connected,
+	// a failed connection attempt is not cached so Connect can be called again
+	mu sync.RWMutex
+	// primary, returned by GetDatabase for writes & transactions
 	dbInstance *gorm.DB
-	//Used to execute client creation procedure only once.
-	once sync.Once
+	// read replicas, picked by Reader for read-only queries
+	replicas *replicaSet
 }
 
-func NewDataAccessLayer(ctx context.Context, cfg *configs.Database) (*DataAccessLayer, error) {
-	dal := &DataAccessLayer{
+// New creates data access layer w/o connecting, call Connect or ConnectWithRetry before use
+func New(cfg *configs.Database) *DataAccessLayer {
+	return &DataAccessLayer{
 		dbConfig: cfg,
-		once:     sync.Once{},
 	}
-	if _, err := dal.Connect(ctx); err != nil {
+}
+
+// NewDataAccessLayer creates data access layer & connects to the database w retries
+func NewDataAccessLayer(ctx context.Context, cfg *configs.Database) (*DataAccessLayer, error) {
+	dal := New(cfg)
+	if _, err := dal.ConnectWithRetry(ctx); err != nil {
 		return nil, err
 	}
 	return dal, nil
 }
 
 // Build connection string
-func (dal *DataAccessLayer) buildConnectionDSN() string {
-	cfg := dal.dbConfig
+func buildConnectionDSN(cfg *configs.Database) string {
+	if cfg.Driver == configs.DriverSQLite {
+		return buildSQLiteDSN(cfg.Path)
+	}
 	return fmt.Sprintf("host=%s port=%v user=%s dbname=%s sslmode=disable password=%s", cfg.Host, cfg.Port, cfg.User, cfg.Scheme, cfg.Password)
 }
 
-// Connect
-func (dal *DataAccessLayer) Connect(ctx context.Context) (*gorm.DB, error) {
-	//Perform connection creation operation only once.
-	var err error
-	dal.once.Do(func() {
-		// build connection string
-		dsn := dal.buildConnectionDSN()
-		// connect db
-		db, e := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-		if e != nil {
-			err = e
-			return
-		}
+// Build sqlite connection string w foreign keys enabled like postgres
+func buildSQLiteDSN(path string) string {
+	if path == "" || path == ":memory:" {
+		path = sqliteMemoryDSN
+	}
+	if strings.Contains(path, "?") {
+		return path + "&_foreign_keys=1"
+	}
+	return path + "?_foreign_keys=1"
+}
 
-		// debug
-		if dal.dbConfig.Debug {
-			db = db.Debug()
-		}
+// Open gorm dialector by configured driver
+func dialector(cfg *configs.Database) (gorm.Dialector, error) {
+	dsn := buildConnectionDSN(cfg)
+	switch cfg.Driver {
+	case "", configs.DriverPostgres:
+		return postgres.Open(dsn), nil
+	case configs.DriverSQLite:
+		return sqlite.Open(dsn), nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+}
 
-		sqlDB, e := db.DB()
-		if e != nil {
-			err = e
-			return
-		}
-		// SetMaxIdleConns sets the maximum number of connections in the idle connection pool.
-		sqlDB.SetMaxIdleConns(dal.dbConfig.MaxIdleConns)
+// in-memory database is dropped when its last connection is closed
+func isInMemory(cfg *configs.Database) bool {
+	return cfg.Driver == configs.DriverSQLite && strings.Contains(buildConnectionDSN(cfg), "memory")
+}
+
+// open db connection pool, lazy opened pool does not ping the database
+func open(cfg *configs.Database, lazy bool) (*gorm.DB, error) {
+	// open dialector by driver
+	dialector, err := dialector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// connect db
+	db, err := gorm.Open(dialector, &gorm.Config{
+		DisableAutomaticPing: lazy,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// SetMaxOpenConns sets the maximum number of open connections to the database.
-		sqlDB.SetMaxOpenConns(dal.dbConfig.MaxOpenConns)
+	// debug
+	if cfg.Debug {
+		db = db.Debug()
+	}
 
-		// SetConnMaxLifetime sets the maximum amount of time a connection may be reused.
-		sqlDB.SetConnMaxLifetime(dal.dbConfig.ConnectTimeout)
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// SetMaxIdleConns sets the maximum number of connections in the idle connection pool.
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
 
-		dal.dbInstance = db
-	})
-	return dal.dbInstance, err
+	// SetMaxOpenConns sets the maximum number of open connections to the database.
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+
+	// SetConnMaxLifetime sets the maximum amount of time a connection may be reused.
+	sqlDB.SetConnMaxLifetime(cfg.ConnectTimeout)
+
+	// keep in-memory database alive w at least one idle connection
+	if isInMemory(cfg) {
+		if cfg.MaxIdleConns < 1 {
+			sqlDB.SetMaxIdleConns(1)
+		}
+		sqlDB.SetConnMaxLifetime(0)
+	}
+	return db, nil
+}
+
+// Connect opens the database if not connected yet
+func (dal *DataAccessLayer) Connect(ctx context.Context) (*gorm.DB, error) {
+	dal.mu.Lock()
+	defer dal.mu.Unlock()
+	if dal.dbInstance != nil {
+		return dal.dbInstance, nil
+	}
+	db, err := open(dal.dbConfig, false)
+	if err != nil {
+		return nil, err
+	}
+	dal.dbInstance = db
+
+	// read replicas
+	dal.connectReplicas(ctx)
+	return db, nil
 }
 
 func (dal *DataAccessLayer) Disconnect() error {
+	dal.mu.Lock()
+	defer dal.mu.Unlock()
+	dal.disconnectReplicas()
+	if dal.dbInstance == nil {
+		return nil
+	}
 	sqlDB, err := dal.dbInstance.DB()
 	if err != nil {
 		return err
 	}
+	dal.dbInstance = nil
 	return sqlDB.Close()
 }
 
+// GetDatabase returns primary database, nil if not connected
 func (dal *DataAccessLayer) GetDatabase() *gorm.DB {
+	dal.mu.RLock()
+	defer dal.mu.RUnlock()
 	return dal.dbInstance
 }
-
-func (dal *DataAccessLayer) Transaction(ctx context.Context, trans func(tx *gorm.DB) error) error {
-	return dal.dbInstance.WithContext(ctx).Transaction(trans)
-}
@@ -0,0 +1,209 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Default interval between database health checks
+const DefaultHealthCheckInterval = 5 * time.Second
+
+type primaryKey struct{}
+
+// WithPrimary forces read-only queries made w ctx to be served by the primary,
+// used to read your own writes when replicas may lag behind
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// IsPrimary reports whether ctx requires reads from the primary
+func IsPrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+type replica struct {
+	name    string
+	db      *gorm.DB
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// check pings replica and updates its health
+func (r *replica) check(ctx context.Context, timeout time.Duration) {
+	healthy := int32(0)
+	if sqlDB, err := r.db.DB(); err == nil {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		err = sqlDB.PingContext(ctx)
+		cancel()
+		if err == nil {
+			healthy = 1
+		}
+	}
+	if old := atomic.SwapInt32(&r.healthy, healthy); old != healthy {
+		logger := log.With(zap.String("dal", "replica"))
+		if healthy == 1 {
+			logger.Bg().Info("replica is up", zap.String("replica", r.name))
+		} else {
+			logger.Bg().Error("replica is down", zap.String("replica", r.name))
+		}
+	}
+}
+
+type replicaSet struct {
+	replicas []*replica
+	next     uint32
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// pick next healthy replica round-robin, nil if all replicas are down
+func (s *replicaSet) pick() *replica {
+	n := uint32(len(s.replicas))
+	for i := uint32(0); i < n; i++ {
+		r := s.replicas[(atomic.AddUint32(&s.next, 1)-1)%n]
+		if r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (s *replicaSet) checkAll(ctx context.Context, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, r := range s.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			r.check(ctx, timeout)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// replica config inherits empty fields from primary
+func replicaConfig(primary, cfg *configs.Database) *configs.Database {
+	c := *primary
+	c.Replicas = nil
+	if cfg.Driver != "" {
+		c.Driver = cfg.Driver
+	}
+	if cfg.Path != "" {
+		c.Path = cfg.Path
+	}
+	if cfg.Host != "" {
+		c.Host = cfg.Host
+	}
+	if cfg.Port != "" {
+		c.Port = cfg.Port
+	}
+	if cfg.User != "" {
+		c.User = cfg.User
+	}
+	if cfg.Password != "" {
+		c.Password = cfg.Password
+	}
+	if cfg.Scheme != "" {
+		c.Scheme = cfg.Scheme
+	}
+	if cfg.MaxIdleConns > 0 {
+		c.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxOpenConns > 0 {
+		c.MaxOpenConns = cfg.MaxOpenConns
+	}
+	if cfg.ConnectTimeout > 0 {
+		c.ConnectTimeout = cfg.ConnectTimeout
+	}
+	c.Debug = c.Debug || cfg.Debug
+	return &c
+}
+
+// HealthCheckInterval returns interval between database health checks
+func (dal *DataAccessLayer) HealthCheckInterval() time.Duration {
+	if dal.dbConfig.HealthCheckInterval > 0 {
+		return dal.dbConfig.HealthCheckInterval
+	}
+	return DefaultHealthCheckInterval
+}
+
+// connectReplicas opens configured replicas, a replica failing to open is skipped
+// and unreachable replicas are marked down until a health check succeeds
+func (dal *DataAccessLayer) connectReplicas(ctx context.Context) {
+	if len(dal.dbConfig.Replicas) == 0 {
+		return
+	}
+	logger := log.With(zap.String("dal", "replica"))
+	set := &replicaSet{stop: make(chan struct{})}
+	for _, cfg := range dal.dbConfig.Replicas {
+		c := replicaConfig(dal.dbConfig, cfg)
+		name := c.Host + ":" + c.Port
+		if c.Driver == configs.DriverSQLite {
+			name = c.Path
+		}
+		db, err := open(c, true)
+		if err != nil {
+			logger.Bg().Error("open replica", zap.String("replica", name), zap.Error(err))
+			continue
+		}
+		set.replicas = append(set.replicas, &replica{name: name, db: db})
+	}
+	if len(set.replicas) == 0 {
+		return
+	}
+	interval := dal.HealthCheckInterval()
+	set.checkAll(ctx, interval)
+
+	set.wg.Add(1)
+	go func() {
+		defer set.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-set.stop:
+				return
+			case <-ticker.C:
+				set.checkAll(context.Background(), interval)
+			}
+		}
+	}()
+	dal.replicas = set
+}
+
+func (dal *DataAccessLayer) disconnectReplicas() {
+	if dal.replicas == nil {
+		return
+	}
+	close(dal.replicas.stop)
+	dal.replicas.wg.Wait()
+	for _, r := range dal.replicas.replicas {
+		if sqlDB, err := r.db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+	dal.replicas = nil
+}
+
+// Reader returns a session for read-only queries: a healthy replica picked
+// round-robin, or the primary if no replica is up or ctx requires the primary
+func (dal *DataAccessLayer) Reader(ctx context.Context) *gorm.DB {
+	dal.mu.RLock()
+	defer dal.mu.RUnlock()
+	if dal.replicas != nil && !IsPrimary(ctx) {
+		if r := dal.replicas.pick(); r != nil {
+			return r.db.WithContext(ctx)
+		}
+	}
+	return dal.dbInstance.WithContext(ctx)
+}
@@ -0,0 +1,148 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"math/rand"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Default retry policy of transactions failed by serialization/deadlock errors
+const (
+	DefaultTxMaxRetries = 3
+	DefaultTxBackoff    = 20 * time.Millisecond
+	DefaultTxMaxBackoff = 1 * time.Second
+)
+
+// Transaction metrics, exposed on /debug/vars
+var txMetrics = expvar.NewMap("dal_transactions")
+
+// TxOption overrides transaction options
+type TxOption func(*txOptions)
+
+type txOptions struct {
+	isolation  sql.IsolationLevel
+	readOnly   bool
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// WithIsolation sets transaction isolation level
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.isolation = level
+	}
+}
+
+// WithReadOnly marks transaction read-only
+func WithReadOnly() TxOption {
+	return func(o *txOptions) {
+		o.readOnly = true
+	}
+}
+
+// WithMaxRetries sets max number of retries on retryable errors, 0 disables retry
+func WithMaxRetries(n int) TxOption {
+	return func(o *txOptions) {
+		o.maxRetries = n
+	}
+}
+
+// WithBackoff sets exponential backoff between retries
+func WithBackoff(base, max time.Duration) TxOption {
+	return func(o *txOptions) {
+		o.backoff = base
+		o.maxBackoff = max
+	}
+}
+
+func (dal *DataAccessLayer) txOptions(opts ...TxOption) *txOptions {
+	o := &txOptions{
+		isolation:  sql.LevelDefault,
+		maxRetries: DefaultTxMaxRetries,
+		backoff:    DefaultTxBackoff,
+		maxBackoff: DefaultTxMaxBackoff,
+	}
+	if n := dal.dbConfig.TxMaxRetries; n != nil && *n >= 0 {
+		o.maxRetries = *n
+	}
+	if dal.dbConfig.TxRetryBackoff > 0 {
+		o.backoff = dal.dbConfig.TxRetryBackoff
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// serialization failures & deadlocks are worth retrying
+func isRetryable(err error) bool {
+	return dal.IsRetryable(err)
+}
+
+// delay before n-th retry: exponential backoff w full jitter
+func (o *txOptions) delay(retry int) time.Duration {
+	d := o.backoff << uint(retry)
+	if d <= 0 || d > o.maxBackoff {
+		d = o.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// Transaction runs trans in a database transaction, transparently re-running it
+// when it fails w a retryable error (serialization failure, deadlock...)
+// until max retries is reached or the context deadline would be exceeded
+func (dal *DataAccessLayer) Transaction(ctx context.Context, trans func(tx *gorm.DB) error, opts ...TxOption) error {
+	o := dal.txOptions(opts...)
+	txOpts := &sql.TxOptions{
+		Isolation: o.isolation,
+		ReadOnly:  o.readOnly,
+	}
+	db := dal.GetDatabase()
+	if db == nil {
+		return ErrNotConnected
+	}
+	logger := log.With(zap.String("dal", "transaction"))
+	for retry := 0; ; retry++ {
+		txMetrics.Add("attempts", 1)
+		err := db.WithContext(ctx).Transaction(trans, txOpts)
+		if err == nil {
+			if retry > 0 {
+				logger.For(ctx).Info("transaction succeeded after retries", zap.Int("retries", retry))
+			}
+			return nil
+		}
+		if !isRetryable(err) {
+			txMetrics.Add("failures", 1)
+			return err
+		}
+		if retry >= o.maxRetries {
+			txMetrics.Add("retries_exhausted", 1)
+			logger.For(ctx).Error("transaction retries exhausted", zap.Int("retries", retry), zap.Error(err))
+			return err
+		}
+		// give up if the context deadline would be exceeded while waiting
+		wait := o.delay(retry)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			txMetrics.Add("failures", 1)
+			return err
+		}
+		txMetrics.Add("retries", 1)
+		logger.For(ctx).Info("retry transaction", zap.Int("retry", retry+1), zap.Duration("backoff", wait), zap.Error(err))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			txMetrics.Add("failures", 1)
+			return err
+		case <-timer.C:
+		}
+	}
+}
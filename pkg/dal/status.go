@@ -0,0 +1,71 @@
+package dal
+
+import (
+	stderrors "errors"
+
+	"google.golang.org/grpc/status"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+)
+
+// DatabaseError converts a database error into a gRPC status error by its
+// typed classification. Unclassified errors are replaced by fallback.
+func DatabaseError(err error, fallback error) error {
+	if err == nil {
+		return nil
+	}
+	err = Translate(err)
+	var dbErr *Error
+	if !stderrors.As(err, &dbErr) {
+		return fallback
+	}
+	return &statusError{
+		st:    status.Convert(dbStatusError(dbErr)),
+		cause: dbErr,
+	}
+}
+
+func dbStatusError(dbErr *Error) error {
+	subject := dbErr.Constraint
+	if subject == "" {
+		subject = "database"
+	}
+	switch dbErr.Kind {
+	case ErrUniqueViolation:
+		return errors.AlreadyExists("Resource already exists", map[string]string{subject: "Violates unique constraint"})
+	case ErrForeignKeyViolation:
+		return errors.FailedPrecondition("Referenced resource does not exist", "ForeignKey", map[string]string{subject: "Violates foreign key constraint"})
+	case ErrSerializationFailure:
+		return errors.Aborted("Concurrent update conflict, please retry", errors.ConflictRetryDelay)
+	case ErrDeadlock:
+		return errors.Aborted("Deadlock detected, please retry", errors.ConflictRetryDelay)
+	case ErrConnectionRefused, ErrNetworkTimeout:
+		return errors.Unavailable("Database unavailable", errors.UnavailableRetryDelay)
+	case ErrDeadlineExceeded:
+		return errors.DeadlineExceeded("Database deadline exceeded")
+	}
+	return errors.InternalServerError("Database error", dbErr.Error())
+}
+
+// statusError is a gRPC status error which keeps its typed database error cause,
+// so transactions can still be retried on errors mapped inside them
+type statusError struct {
+	st    *status.Status
+	cause error
+}
+
+func (e *statusError) Error() string {
+	return e.st.Err().Error()
+}
+
+func (e *statusError) GRPCStatus() *status.Status {
+	return e.st
+}
+
+func (e *statusError) Unwrap() error {
+	return e.cause
+}
+
+func (e *statusError) Is(target error) bool {
+	return stderrors.Is(e.st.Err(), target)
+}
@@ -0,0 +1,64 @@
+package dal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+func TestDatabaseError(t *testing.T) {
+	fallback := errors.New("fallback")
+	tests := []struct {
+		name  string
+		err   error
+		code  codes.Code
+		retry bool
+		kind  error
+	}{
+		{"unique", &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"}, codes.AlreadyExists, false, ErrUniqueViolation},
+		{"sqlite unique", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, codes.AlreadyExists, false, ErrUniqueViolation},
+		{"foreign key", &pgconn.PgError{Code: "23503"}, codes.FailedPrecondition, false, ErrForeignKeyViolation},
+		{"serialization", &pgconn.PgError{Code: "40001"}, codes.Aborted, true, ErrSerializationFailure},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, codes.Aborted, true, ErrDeadlock},
+		{"sqlite busy", sqlite3.Error{Code: sqlite3.ErrBusy}, codes.Aborted, true, ErrSerializationFailure},
+		{"connection", &pgconn.PgError{Code: "08006"}, codes.Unavailable, true, ErrConnectionRefused},
+		{"network timeout", &net.OpError{Op: "read", Net: "tcp", Err: &net.DNSError{IsTimeout: true}}, codes.Unavailable, true, ErrNetworkTimeout},
+		{"query canceled", &pgconn.PgError{Code: "57014"}, codes.DeadlineExceeded, false, ErrDeadlineExceeded},
+		{"wrapped", fmt.Errorf("tx: %w", &pgconn.PgError{Code: "40001"}), codes.Aborted, true, ErrSerializationFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := DatabaseError(tt.err, fallback)
+			st, ok := status.FromError(err)
+			require.True(t, ok)
+			require.Equal(t, tt.code, st.Code())
+			var retry *errdetails.RetryInfo
+			for _, d := range st.Details() {
+				if r, ok := d.(*errdetails.RetryInfo); ok {
+					retry = r
+				}
+			}
+			require.Equal(t, tt.retry, retry != nil)
+			// typed cause is kept for retries of transactions
+			require.ErrorIs(t, err, tt.kind)
+			require.Equal(t, IsRetryable(tt.err), IsRetryable(err))
+		})
+	}
+
+	// unclassified errors are replaced by fallback
+	require.Equal(t, fallback, DatabaseError(gorm.ErrRecordNotFound, fallback))
+	require.Equal(t, fallback, DatabaseError(&pgconn.PgError{Code: "42P01"}, fallback))
+	require.NoError(t, DatabaseError(nil, fallback))
+	// matches its status error
+	st := status.Convert(DatabaseError(&pgconn.PgError{Code: "23505"}, fallback))
+	require.ErrorIs(t, DatabaseError(&pgconn.PgError{Code: "23505"}, fallback), st.Err())
+}
@@ -0,0 +1,102 @@
+package errors
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Violation of a precondition (NotFound, FailedPrecondition)
+type Violation struct {
+	Type        string
+	Subject     string
+	Description string
+}
+
+// Error is a service error decoded on the client side from the grpc status
+// & the errdetails payloads built by this package
+type Error struct {
+	Code    codes.Code
+	Message string
+	// BadRequest & AlreadyExists: field -> description
+	FieldViolations map[string]string
+	// NotFound & FailedPrecondition
+	Violations []Violation
+	// Unauthenticated
+	Reason string
+	Domain string
+	// InternalServerError
+	Detail string
+	// Aborted & Unavailable: delay before retrying
+	RetryDelay time.Duration
+
+	status *status.Status
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// GRPCStatus keeps the original status, ex: status.Code(err)
+func (e *Error) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// Is matches service errors by code & message, ex: errors.Is(err, errorSrv.ErrUserNotFound)
+func (e *Error) Is(target error) bool {
+	if target == nil {
+		return false
+	}
+	st, ok := status.FromError(target)
+	if !ok {
+		return false
+	}
+	return st.Code() == e.Code && st.Message() == e.Message
+}
+
+// Decode converts a grpc status error into *Error, other errors are returned as is
+func Decode(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	e := &Error{
+		Code:    st.Code(),
+		Message: st.Message(),
+		status:  st,
+	}
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.BadRequest:
+			e.FieldViolations = make(map[string]string, len(d.GetFieldViolations()))
+			for _, v := range d.GetFieldViolations() {
+				e.FieldViolations[v.GetField()] = v.GetDescription()
+			}
+		case *errdetails.PreconditionFailure:
+			for _, v := range d.GetViolations() {
+				e.Violations = append(e.Violations, Violation{
+					Type:        v.GetType(),
+					Subject:     v.GetSubject(),
+					Description: v.GetDescription(),
+				})
+			}
+		case *errdetails.ErrorInfo:
+			e.Reason = d.GetReason()
+			e.Domain = d.GetDomain()
+		case *errdetails.DebugInfo:
+			e.Detail = d.GetDetail()
+		case *errdetails.RetryInfo:
+			e.RetryDelay = d.GetRetryDelay().AsDuration()
+		}
+	}
+	return e
+}
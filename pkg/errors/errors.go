@@ -2,9 +2,12 @@
 package errors
 
 import (
+	"time"
+
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func BadRequest(msg string, fields map[string]string) error {
@@ -72,3 +75,80 @@ func NotFound(msg string, fields map[string]string) error {
 	}
 	return des.Err()
 }
+
+func AlreadyExists(msg string, fields map[string]string) error {
+	st := status.New(codes.AlreadyExists, msg)
+	if len(fields) == 0 {
+		return st.Err()
+	}
+	var fieldViolations []*errdetails.BadRequest_FieldViolation
+	for field, desc := range fields {
+		fieldViolations = append(fieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: desc,
+		})
+	}
+	des, err := st.WithDetails(&errdetails.BadRequest{
+		FieldViolations: fieldViolations,
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return des.Err()
+}
+
+func FailedPrecondition(msg, violationType string, fields map[string]string) error {
+	st := status.New(codes.FailedPrecondition, msg)
+	if len(fields) == 0 {
+		return st.Err()
+	}
+	var violations []*errdetails.PreconditionFailure_Violation
+	for field, desc := range fields {
+		violations = append(violations, &errdetails.PreconditionFailure_Violation{
+			Type:        violationType,
+			Subject:     field,
+			Description: desc,
+		})
+	}
+	des, err := st.WithDetails(&errdetails.PreconditionFailure{
+		Violations: violations,
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return des.Err()
+}
+
+// Retry hints sent w retryable errors, ex: database conflicts & outages
+var (
+	ConflictRetryDelay    = 50 * time.Millisecond
+	UnavailableRetryDelay = 1 * time.Second
+)
+
+// retryable errors carry a RetryInfo hint for the client
+func withRetryInfo(c codes.Code, msg string, retryDelay time.Duration) error {
+	st := status.New(c, msg)
+	des, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryDelay),
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return des.Err()
+}
+
+func Aborted(msg string, retryDelay time.Duration) error {
+	return withRetryInfo(codes.Aborted, msg, retryDelay)
+}
+
+func Unavailable(msg string, retryDelay time.Duration) error {
+	return withRetryInfo(codes.Unavailable, msg, retryDelay)
+}
+
+func ResourceExhausted(msg string, retryDelay time.Duration) error {
+	return withRetryInfo(codes.ResourceExhausted, msg, retryDelay)
+}
+
+func DeadlineExceeded(msg string) error {
+	return status.New(codes.DeadlineExceeded, msg).Err()
+}
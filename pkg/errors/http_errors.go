@@ -3,7 +3,9 @@ package errors
 import (
 	"context"
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
@@ -19,7 +21,6 @@ func CustomHTTPError(ctx context.Context, _ *runtime.ServeMux, marshaler runtime
 	const fallback = `{"error": "failed to marshal error message"}`
 
 	w.Header().Set("Content-type", marshaler.ContentType())
-	w.WriteHeader(runtime.HTTPStatusFromCode(status.Code(err)))
 
 	st := status.Convert(err)
 
@@ -34,9 +35,20 @@ func CustomHTTPError(ctx context.Context, _ *runtime.ServeMux, marshaler runtime
 			for _, violation := range t.GetFieldViolations() {
 				errBd.Data[violation.GetField()] = violation.GetDescription()
 			}
+		case *errdetails.PreconditionFailure:
+			errBd.Data = make(map[string]string, len(t.GetViolations()))
+			for _, violation := range t.GetViolations() {
+				errBd.Data[violation.GetSubject()] = violation.GetDescription()
+			}
+		case *errdetails.RetryInfo:
+			// retry hint in seconds, round up
+			delay := t.GetRetryDelay().AsDuration()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
 		}
 	}
 
+	w.WriteHeader(runtime.HTTPStatusFromCode(status.Code(err)))
+
 	jErr := json.NewEncoder(w).Encode(errBd)
 	if jErr != nil {
 		w.Write([]byte(fallback))
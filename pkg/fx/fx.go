@@ -0,0 +1,186 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/money"
+)
+
+// Providers of configs.FX
+const (
+	ProviderStatic = "static"
+	ProviderFile   = "file"
+)
+
+// ErrRateNotFound is returned for currencies w/o a rate
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+// rate of a currency in itself
+var one, _ = money.ParseRate("1")
+
+// RateProvider quotes exchange rates between ISO 4217 currencies
+type RateProvider interface {
+	// Rate of 1 from in to
+	Rate(ctx context.Context, from, to string) (money.Rate, error)
+}
+
+// New rate provider of config, nil if not configured
+func New(config *configs.FX) (RateProvider, error) {
+	if config == nil {
+		return nil, nil
+	}
+	switch config.Provider {
+	case "", ProviderStatic:
+		return NewStatic(config.Base, config.Rates)
+	case ProviderFile:
+		return NewFile(config.File)
+	}
+	return nil, fmt.Errorf("unsupported fx provider %q", config.Provider)
+}
+
+// Static rates of a base currency, cross rates go through the base
+type Static struct {
+	base  string
+	rates map[string]money.Rate
+}
+
+// NewStatic of rates of 1 base in other currencies, ex: base USD w VND: "23000"
+func NewStatic(base string, rates map[string]string) (*Static, error) {
+	base, err := money.ParseCurrency(base)
+	if err != nil {
+		return nil, fmt.Errorf("fx base: %w", err)
+	}
+	s := &Static{
+		base:  base,
+		rates: make(map[string]money.Rate, len(rates)),
+	}
+	for code, rate := range rates {
+		// config keys may be lower case
+		c, err := money.ParseCurrency(code)
+		if err != nil {
+			return nil, fmt.Errorf("fx rate of %q: %w", code, err)
+		}
+		r, err := money.ParseRate(rate)
+		if err != nil || r.IsZero() {
+			return nil, fmt.Errorf("fx rate of %s: %w", c, money.ErrInvalidRate)
+		}
+		s.rates[c] = r
+	}
+	return s, nil
+}
+
+// Rate of 1 from in to
+func (s *Static) Rate(_ context.Context, from, to string) (money.Rate, error) {
+	if from == to {
+		return one, nil
+	}
+	f, err := s.baseRate(from)
+	if err != nil {
+		return money.Rate{}, err
+	}
+	t, err := s.baseRate(to)
+	if err != nil {
+		return money.Rate{}, err
+	}
+	return t.Quo(f)
+}
+
+// rate of 1 base in code
+func (s *Static) baseRate(code string) (money.Rate, error) {
+	if code == s.base {
+		return one, nil
+	}
+	r, ok := s.rates[code]
+	if !ok {
+		return money.Rate{}, fmt.Errorf("%w: %s/%s", ErrRateNotFound, s.base, code)
+	}
+	return r, nil
+}
+
+// rates file of File
+type fileRates struct {
+	Base  string            `json:"base"`
+	Rates map[string]string `json:"rates"`
+}
+
+// file modification stamp
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// File rates read from a JSON file of {"base": "USD", "rates": {"VND": "23000"}},
+// reloaded when it changes, the last valid rates are kept if it fails to load
+type File struct {
+	path   string
+	logger log.Factory
+
+	mu    sync.Mutex
+	stamp fileStamp
+	rates *Static
+}
+
+// NewFile of rates in path, loaded once valid
+func NewFile(path string) (*File, error) {
+	if path == "" {
+		return nil, errors.New("fx rates file required")
+	}
+	f := &File{
+		path:   path,
+		logger: log.With(zap.String("fx", path)),
+	}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Rate of 1 from in to
+func (f *File) Rate(ctx context.Context, from, to string) (money.Rate, error) {
+	f.mu.Lock()
+	if err := f.reload(); err != nil {
+		f.logger.For(ctx).Error("Error reload fx rates, keeping last rates", zap.Error(err))
+	}
+	rates := f.rates
+	f.mu.Unlock()
+	return rates.Rate(ctx, from, to)
+}
+
+// load rates if the file changed since the last load, w mu held
+func (f *File) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	stamp := fileStamp{modTime: info.ModTime(), size: info.Size()}
+	if f.rates != nil && stamp == f.stamp {
+		return nil
+	}
+	// an invalid file is reported once per change
+	f.stamp = stamp
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var fr fileRates
+	if err := json.Unmarshal(data, &fr); err != nil {
+		return fmt.Errorf("parse fx rates: %w", err)
+	}
+	rates, err := NewStatic(fr.Base, fr.Rates)
+	if err != nil {
+		return err
+	}
+	f.rates = rates
+	return nil
+}
@@ -0,0 +1,92 @@
+package fx
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/money"
+)
+
+func TestStatic(t *testing.T) {
+	_, err := NewStatic("usd1", nil)
+	require.ErrorIs(t, err, money.ErrInvalidCurrency)
+	_, err = NewStatic("USD", map[string]string{"abc": "1"})
+	require.ErrorIs(t, err, money.ErrInvalidCurrency)
+	_, err = NewStatic("USD", map[string]string{"VND": "0"})
+	require.ErrorIs(t, err, money.ErrInvalidRate)
+
+	// viper lower cases config keys
+	s, err := NewStatic("usd", map[string]string{"vnd": "23000", "jpy": "110"})
+	require.NoError(t, err)
+	ctx := context.Background()
+	tests := []struct {
+		from, to, want string
+	}{
+		{"USD", "VND", "23000"},
+		{"VND", "USD", "0.000043478261"},
+		{"JPY", "VND", "209.090909090909"},
+		{"VND", "VND", "1"},
+		{"EUR", "EUR", "1"},
+	}
+	for _, tt := range tests {
+		r, err := s.Rate(ctx, tt.from, tt.to)
+		require.NoError(t, err, tt.from+tt.to)
+		require.Equal(t, tt.want, r.String(), tt.from+tt.to)
+	}
+	_, err = s.Rate(ctx, "USD", "EUR")
+	require.ErrorIs(t, err, ErrRateNotFound)
+	_, err = s.Rate(ctx, "EUR", "USD")
+	require.ErrorIs(t, err, ErrRateNotFound)
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fx")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rates.json")
+
+	_, err = NewFile(path)
+	require.Error(t, err)
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"base":"USD","rates":{"VND":"23000"}}`), 0600))
+	f, err := NewFile(path)
+	require.NoError(t, err)
+	ctx := context.Background()
+	r, err := f.Rate(ctx, "USD", "VND")
+	require.NoError(t, err)
+	require.Equal(t, "23000", r.String())
+
+	// reloaded when changed
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"base":"USD","rates":{"VND":"23100","EUR":"0.85"}}`), 0600))
+	r, err = f.Rate(ctx, "EUR", "VND")
+	require.NoError(t, err)
+	require.Equal(t, "27176.470588235294", r.String())
+
+	// last valid rates are kept
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"base":"USD","rates":{"VND":"abc"}}`), 0600))
+	r, err = f.Rate(ctx, "USD", "VND")
+	require.NoError(t, err)
+	require.Equal(t, "23100", r.String())
+	require.NoError(t, os.Remove(path))
+	r, err = f.Rate(ctx, "USD", "VND")
+	require.NoError(t, err)
+	require.Equal(t, "23100", r.String())
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(nil)
+	require.NoError(t, err)
+	require.Nil(t, p)
+	p, err = New(&configs.FX{Base: "USD", Rates: map[string]string{"VND": "23000"}})
+	require.NoError(t, err)
+	require.IsType(t, &Static{}, p)
+	_, err = New(&configs.FX{Provider: ProviderFile})
+	require.Error(t, err)
+	_, err = New(&configs.FX{Provider: "http"})
+	require.Error(t, err)
+}
@@ -0,0 +1,228 @@
+package interceptor
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/sony/gobreaker"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// metadata key marking a non-idempotent call safe to retry
+const IdempotencyKey = "idempotency-key"
+
+// Default retry policy & circuit breaker settings
+const (
+	DefaultMaxRetries         = 3
+	DefaultBackoff            = 100 * time.Millisecond
+	DefaultMaxBackoff         = 5 * time.Second
+	DefaultBreakerMaxFailures = 5
+	DefaultBreakerTimeout     = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned w/o calling the server while the target circuit breaker is open
+var ErrCircuitOpen = status.Error(codes.Unavailable, "circuit breaker is open")
+
+// WithIdempotencyKey attaches an idempotency key allowing a non-idempotent call to be retried
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, IdempotencyKey, key)
+}
+
+// Retry interceptor retries failed calls w jittered exponential backoff
+// & guards each target w a circuit breaker.
+// Stream calls are retried only when the stream fails to be established.
+type RetryClientInterceptor struct {
+	retry   *configs.Retry
+	breaker *configs.CircuitBreaker
+
+	mu       sync.Mutex
+	breakers map[string]*gobreaker.TwoStepCircuitBreaker
+}
+
+var _ ClientInterceptor = (*RetryClientInterceptor)(nil)
+
+// NewRetryClientInterceptor creates interceptor, nil retry disables retries & nil breaker disables circuit breaker
+func NewRetryClientInterceptor(retry *configs.Retry, breaker *configs.CircuitBreaker) *RetryClientInterceptor {
+	return &RetryClientInterceptor{
+		retry:    retry,
+		breaker:  breaker,
+		breakers: make(map[string]*gobreaker.TwoStepCircuitBreaker),
+	}
+}
+
+func (i *RetryClientInterceptor) Log() log.Factory {
+	return DefaultLogger.With(zap.String("interceptor-name", "retry"))
+}
+
+func (i *RetryClientInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return i.unaryClientInterceptor
+}
+
+func (i *RetryClientInterceptor) Stream() grpc.StreamClientInterceptor {
+	return i.streamClientInterceptor
+}
+
+func (i *RetryClientInterceptor) unaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return i.invoke(ctx, method, cc.Target(), func() error {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+}
+
+func (i *RetryClientInterceptor) streamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	var clientStream grpc.ClientStream
+	err := i.invoke(ctx, method, cc.Target(), func() error {
+		var err error
+		clientStream, err = streamer(ctx, desc, cc, method, opts...)
+		return err
+	})
+	return clientStream, err
+}
+
+// server may succeed on retry
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// server is down or overloaded, other errors are responses of a healthy server
+func isBreakerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// idempotent methods & calls carrying an idempotency key are safe to retry
+func (i *RetryClientInterceptor) canRetry(ctx context.Context, method string) bool {
+	if i.retry == nil {
+		return false
+	}
+	if i.retry.IdempotentMethods[method] {
+		return true
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return len(md.Get(IdempotencyKey)) > 0
+}
+
+func (i *RetryClientInterceptor) maxRetries() int {
+	if i.retry.MaxRetries > 0 {
+		return i.retry.MaxRetries
+	}
+	return DefaultMaxRetries
+}
+
+// delay before n-th retry: exponential backoff w full jitter,
+// at least the delay advised by the server retry info
+func (i *RetryClientInterceptor) delay(retry int, err error) time.Duration {
+	backoff, maxBackoff := DefaultBackoff, DefaultMaxBackoff
+	if i.retry.Backoff > 0 {
+		backoff = i.retry.Backoff
+	}
+	if i.retry.MaxBackoff > 0 {
+		maxBackoff = i.retry.MaxBackoff
+	}
+	d := backoff << uint(retry)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	wait := time.Duration(rand.Int63n(int64(d)) + 1)
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			if advised := info.GetRetryDelay().AsDuration(); advised > wait {
+				wait = advised
+			}
+		}
+	}
+	return wait
+}
+
+// circuit breaker of target, nil if disabled
+func (i *RetryClientInterceptor) breakerFor(target string) *gobreaker.TwoStepCircuitBreaker {
+	if i.breaker == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if cb, ok := i.breakers[target]; ok {
+		return cb
+	}
+	maxFailures := i.breaker.MaxFailures
+	if maxFailures == 0 {
+		maxFailures = DefaultBreakerMaxFailures
+	}
+	timeout := i.breaker.Timeout
+	if timeout == 0 {
+		timeout = DefaultBreakerTimeout
+	}
+	cb := gobreaker.NewTwoStepCircuitBreaker(gobreaker.Settings{
+		Name:        target,
+		MaxRequests: i.breaker.MaxRequests,
+		Interval:    i.breaker.Interval,
+		Timeout:     timeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= maxFailures
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			i.Log().Bg().Info("circuit breaker state changed", zap.String("target", name), zap.Stringer("from", from), zap.Stringer("to", to))
+		},
+	})
+	i.breakers[target] = cb
+	return cb
+}
+
+// call runs one attempt through the target circuit breaker
+func (i *RetryClientInterceptor) call(target string, attempt func() error) error {
+	cb := i.breakerFor(target)
+	if cb == nil {
+		return attempt()
+	}
+	done, err := cb.Allow()
+	if err != nil {
+		return ErrCircuitOpen
+	}
+	err = attempt()
+	done(!isBreakerFailure(err))
+	return err
+}
+
+// invoke attempt w retries until success, non-retryable error, max retries
+// or the call deadline would be exceeded
+func (i *RetryClientInterceptor) invoke(ctx context.Context, method, target string, attempt func() error) error {
+	retryable := i.canRetry(ctx, method)
+	for retry := 0; ; retry++ {
+		err := i.call(target, attempt)
+		if err == nil || err == ErrCircuitOpen || !retryable || !isRetryable(err) || retry >= i.maxRetries() {
+			return err
+		}
+		wait := i.delay(retry, err)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		i.Log().For(ctx).Info("retry request",
+			zap.String("method", method),
+			zap.Int("retry", retry+1),
+			zap.Duration("backoff", wait),
+			zap.Error(err),
+		)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package interceptor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const idempotentMethod = "/user.UserService/List"
+
+func TestRetryClientInterceptor_invoke(t *testing.T) {
+	retry := &configs.Retry{
+		MaxRetries:        2,
+		Backoff:           time.Millisecond,
+		MaxBackoff:        time.Millisecond,
+		IdempotentMethods: map[string]bool{idempotentMethod: true},
+	}
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		errs     []codes.Code
+		wantCode codes.Code
+		wantCall int
+	}{
+		{
+			name:     "RetryUntilSuccess",
+			ctx:      context.Background(),
+			method:   idempotentMethod,
+			errs:     []codes.Code{codes.Unavailable, codes.Aborted, codes.OK},
+			wantCode: codes.OK,
+			wantCall: 3,
+		},
+		{
+			name:     "MaxRetries",
+			ctx:      context.Background(),
+			method:   idempotentMethod,
+			errs:     []codes.Code{codes.ResourceExhausted, codes.ResourceExhausted, codes.ResourceExhausted, codes.OK},
+			wantCode: codes.ResourceExhausted,
+			wantCall: 3,
+		},
+		{
+			name:     "NotRetryableCode",
+			ctx:      context.Background(),
+			method:   idempotentMethod,
+			errs:     []codes.Code{codes.InvalidArgument, codes.OK},
+			wantCode: codes.InvalidArgument,
+			wantCall: 1,
+		},
+		{
+			name:     "NonIdempotentMethod",
+			ctx:      context.Background(),
+			method:   "/user.UserService/CreateTransaction",
+			errs:     []codes.Code{codes.Unavailable, codes.OK},
+			wantCode: codes.Unavailable,
+			wantCall: 1,
+		},
+		{
+			name:     "IdempotencyKey",
+			ctx:      WithIdempotencyKey(context.Background(), "key"),
+			method:   "/user.UserService/CreateTransaction",
+			errs:     []codes.Code{codes.Unavailable, codes.OK},
+			wantCode: codes.OK,
+			wantCall: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := NewRetryClientInterceptor(retry, nil)
+			calls := 0
+			err := i.invoke(tt.ctx, tt.method, "target", func() error {
+				code := tt.errs[calls]
+				calls++
+				return status.Error(code, code.String())
+			})
+			require.Equal(t, tt.wantCode, status.Code(err))
+			require.Equal(t, tt.wantCall, calls)
+		})
+	}
+}
+
+func TestRetryClientInterceptor_deadline(t *testing.T) {
+	// server advises a retry delay beyond the call deadline
+	i := NewRetryClientInterceptor(&configs.Retry{IdempotentMethods: map[string]bool{idempotentMethod: true}}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls := 0
+	err := i.invoke(ctx, idempotentMethod, "target", func() error {
+		calls++
+		st, _ := status.New(codes.Unavailable, "unavailable").WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Second)})
+		return st.Err()
+	})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, 1, calls)
+}
+
+func TestRetryClientInterceptor_circuitBreaker(t *testing.T) {
+	i := NewRetryClientInterceptor(nil, &configs.CircuitBreaker{MaxFailures: 2, Timeout: time.Hour})
+	calls := 0
+	fail := func() error {
+		calls++
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+	for n := 0; n < 2; n++ {
+		require.Equal(t, codes.Unavailable, status.Code(i.invoke(context.Background(), idempotentMethod, "target", fail)))
+	}
+	// breaker is open: server is not called
+	require.Equal(t, ErrCircuitOpen, i.invoke(context.Background(), idempotentMethod, "target", fail))
+	require.Equal(t, 2, calls)
+	// breakers are per target
+	require.NoError(t, i.invoke(context.Background(), idempotentMethod, "other", func() error { return nil }))
+}
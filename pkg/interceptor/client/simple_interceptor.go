@@ -66,7 +66,6 @@ func (i *SimpleClientInterceptor) unaryClientInterceptor(ctx context.Context, me
 		zap.Strings("x-response-id", xrespid),
 		zap.Strings("custom-resp-header", customHeader),
 		zap.Duration("duration", time.Since(start)),
-		zap.Any("resp", reply),
 		zap.Error(err),
 	)
 	return err
@@ -97,19 +96,12 @@ func (i *SimpleClientInterceptor) streamClientInterceptor(ctx context.Context, d
 	// }
 	// xrespid := md.Get("x-response-id")
 
-	var xrespid []string
-	var customHeader []string
-	header, ok := clientStream.Header()
-	if ok == nil {
-		xrespid = header.Get("x-response-id")
-		customHeader = header.Get("custom-resp-header")
-	}
+	// NOT WAIT for response headers: the request of a server stream is sent
+	// after the stream is returned, so the server has not responded yet
 
 	i.Log().For(ctx).Info("stream client request",
 		zap.String("method", method),
 		zap.String("x-request-id", xrid),
-		zap.Strings("x-response-id", xrespid),
-		zap.Strings("custom-resp-header", customHeader),
 		zap.Duration("duration", time.Since(start)),
 		zap.Error(err),
 	)
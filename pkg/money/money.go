@@ -0,0 +1,136 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+
+	"golang.org/x/text/currency"
+)
+
+// Amount of money in exact micro units, a millionth of a currency unit
+type Amount int64
+
+// Units of amounts
+const (
+	Micro Amount = 1
+	Cent  Amount = 10000
+	Unit  Amount = 1000000
+)
+
+// Errors of ParseRate & ParseCurrency
+var (
+	ErrInvalidRate     = errors.New("rate must be a non negative decimal, ex: 0.045")
+	ErrInvalidCurrency = errors.New("currency must be an ISO 4217 code, ex: USD")
+)
+
+// digits of rates formatted by Rate.String
+const rateDecimals = 12
+
+// FromFloat rounds v to the nearest micro unit
+func FromFloat(v float64) Amount {
+	return Amount(math.Round(v * float64(Unit)))
+}
+
+// Float64 of a, as balances & transaction amounts are stored
+func (a Amount) Float64() float64 {
+	return float64(a) / float64(Unit)
+}
+
+// Round a to a multiple of unit, half to even
+func (a Amount) Round(unit Amount) Amount {
+	return Amount(roundHalfEven(big.NewRat(int64(a), int64(unit))).Int64()) * unit
+}
+
+// Mul a by rate * num / den, rounded to a micro unit half to even
+func (a Amount) Mul(rate Rate, num, den int64) Amount {
+	r := new(big.Rat).SetInt64(int64(a))
+	r.Mul(r, rate.rat())
+	r.Mul(r, big.NewRat(num, den))
+	return Amount(roundHalfEven(r).Int64())
+}
+
+// Rate is an exact decimal rate, ex: an annual interest rate
+type Rate struct {
+	r *big.Rat
+}
+
+// ParseRate of a decimal fraction, ex: "0.045" for 4.5%
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSpace(s)
+	// decimals only, no fractions nor exponents
+	if s == "" || strings.ContainsAny(s, "/eE") {
+		return Rate{}, ErrInvalidRate
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || r.Sign() < 0 {
+		return Rate{}, ErrInvalidRate
+	}
+	return Rate{r: r}, nil
+}
+
+// Quo is the rate r / d, ex: a cross rate of two rates of a base currency
+func (r Rate) Quo(d Rate) (Rate, error) {
+	if d.IsZero() {
+		return Rate{}, ErrInvalidRate
+	}
+	return Rate{r: new(big.Rat).Quo(r.rat(), d.r)}, nil
+}
+
+// String of r as a decimal, rounded to 12 decimals
+func (r Rate) String() string {
+	s := r.rat().FloatString(rateDecimals)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// IsZero reports whether the rate is 0
+func (r Rate) IsZero() bool {
+	return r.r == nil || r.r.Sign() == 0
+}
+
+func (r Rate) rat() *big.Rat {
+	if r.r == nil {
+		return new(big.Rat)
+	}
+	return r.r
+}
+
+// nearest integer of r, half to even
+func roundHalfEven(r *big.Rat) *big.Int {
+	// euclidean division by the positive denominator, q is the floor of r
+	q, m := new(big.Int).DivMod(r.Num(), r.Denom(), new(big.Int))
+	switch m.Lsh(m, 1).Cmp(r.Denom()) {
+	case 1:
+		q.Add(q, big.NewInt(1))
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// ParseCurrency of an ISO 4217 code, case insensitive, returns it in upper case
+func ParseCurrency(code string) (string, error) {
+	unit, err := currency.ParseISO(strings.TrimSpace(code))
+	if err != nil {
+		return "", ErrInvalidCurrency
+	}
+	return unit.String(), nil
+}
+
+// MinorUnit of an ISO 4217 currency, ex: Cent of USD, Unit of JPY or VND
+func MinorUnit(code string) Amount {
+	unit, err := currency.ParseISO(code)
+	if err != nil {
+		return Cent
+	}
+	scale, _ := currency.Standard.Rounding(unit)
+	minor := Unit
+	for i := 0; i < scale && minor > Micro; i++ {
+		minor /= 10
+	}
+	return minor
+}
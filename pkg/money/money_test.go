@@ -0,0 +1,98 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAmount_Round(t *testing.T) {
+	tests := []struct {
+		a, want Amount
+	}{
+		{12345, 10000},
+		{15000, 20000},
+		{25000, 20000},
+		{25001, 30000},
+		{-15000, -20000},
+		{-25000, -20000},
+		{-5000, 0},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, tt.a.Round(Cent), "round %d", tt.a)
+	}
+}
+
+func TestAmount_Mul(t *testing.T) {
+	rate, err := ParseRate("0.045")
+	require.NoError(t, err)
+	// 1000 at 4.5% for a day of 365: 0.123287671...
+	require.Equal(t, Amount(123288), (1000*Unit).Mul(rate, 1, 365))
+	// a year of daily accruals rounded each day
+	var accrued Amount
+	for i := 0; i < 365; i++ {
+		accrued += (1000 * Unit).Mul(rate, 1, 365)
+	}
+	require.Equal(t, Amount(45000120), accrued)
+	require.Equal(t, Amount(45000000), accrued.Round(Cent))
+	// half to even
+	half, err := ParseRate("0.5")
+	require.NoError(t, err)
+	require.Equal(t, Amount(2), Amount(5).Mul(half, 1, 1))
+	require.Equal(t, Amount(4), Amount(7).Mul(half, 1, 1))
+	require.Equal(t, Amount(-2), Amount(-5).Mul(half, 1, 1))
+}
+
+func TestParseRate(t *testing.T) {
+	for _, s := range []string{"", "abc", "-0.01", "1/3", "4.5e-2"} {
+		_, err := ParseRate(s)
+		require.ErrorIs(t, err, ErrInvalidRate, s)
+	}
+	r, err := ParseRate(" 0 ")
+	require.NoError(t, err)
+	require.True(t, r.IsZero())
+	require.True(t, Rate{}.IsZero())
+	r, err = ParseRate("0.0425")
+	require.NoError(t, err)
+	require.False(t, r.IsZero())
+	require.Equal(t, Amount(425), (10000*Micro).Mul(r, 1, 1))
+}
+
+func TestFromFloat(t *testing.T) {
+	require.Equal(t, Amount(100100000), FromFloat(100.1))
+	require.Equal(t, Amount(-1), FromFloat(-0.000001))
+	require.Equal(t, 100.1, FromFloat(100.1).Float64())
+	require.Equal(t, 0.3, (FromFloat(0.1) + FromFloat(0.2)).Float64())
+}
+
+func TestRate_Quo(t *testing.T) {
+	usdVND, err := ParseRate("23000")
+	require.NoError(t, err)
+	usdJPY, err := ParseRate("110")
+	require.NoError(t, err)
+	// cross rates are rounded to 12 decimals when formatted
+	jpyVND, err := usdVND.Quo(usdJPY)
+	require.NoError(t, err)
+	require.Equal(t, "209.090909090909", jpyVND.String())
+	vndUSD, err := Rate{}.Quo(usdVND)
+	require.NoError(t, err)
+	require.Equal(t, "0", vndUSD.String())
+	_, err = usdVND.Quo(Rate{})
+	require.ErrorIs(t, err, ErrInvalidRate)
+	require.Equal(t, "23000", usdVND.String())
+}
+
+func TestParseCurrency(t *testing.T) {
+	for _, code := range []string{"", "US", "ABC", "usd1"} {
+		_, err := ParseCurrency(code)
+		require.ErrorIs(t, err, ErrInvalidCurrency, code)
+	}
+	code, err := ParseCurrency(" usd")
+	require.NoError(t, err)
+	require.Equal(t, "USD", code)
+	require.Equal(t, Cent, MinorUnit("USD"))
+	require.Equal(t, Unit, MinorUnit("VND"))
+	require.Equal(t, Unit, MinorUnit("JPY"))
+	require.Equal(t, Amount(1000), MinorUnit("KWD"))
+}
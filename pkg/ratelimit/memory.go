@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// interval between removals of full buckets
+const memoryPruneInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full again after
+	full time.Time
+}
+
+// MemoryStore keeps buckets in memory, per service instance
+type MemoryStore struct {
+	// clock, replaced in tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	nextPrune time.Time
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.prune(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	var delay time.Duration
+	if b.tokens < 1 {
+		delay = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	} else {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+	return delay, nil
+}
+
+// forget full buckets, equivalent to unknown ones
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Before(s.nextPrune) {
+		return
+	}
+	s.nextPrune = now.Add(memoryPruneInterval)
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+)
+
+// Caller identities buckets are kept for
+const (
+	PerUser   = "user"
+	PerAPIKey = "apikey"
+	PerIP     = "ip"
+)
+
+// Stores of buckets
+const (
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
+)
+
+// Store keeps token buckets of keys
+type Store interface {
+	// Take takes a token of bucket key refilled at rate tokens per second up to burst,
+	// returns 0 if taken or the delay before a token is available
+	Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error)
+}
+
+// NewStore of config, redis buckets are shared by service instances
+func NewStore(config *configs.RateLimit, redisConfig *configs.Redis) (Store, error) {
+	store := StoreMemory
+	if config != nil && config.Store != "" {
+		store = config.Store
+	}
+	switch store {
+	case StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreRedis:
+		if redisConfig == nil || len(redisConfig.Nodes) == 0 {
+			return nil, fmt.Errorf("rate limit store %q requires redis nodes", store)
+		}
+		return NewRedisStore(redisConfig), nil
+	}
+	return nil, fmt.Errorf("unsupported rate limit store %q", store)
+}
+
+// ErrLimited rejects requests over a limit until retryDelay
+func ErrLimited(retryDelay time.Duration) error {
+	return errors.ResourceExhausted("Too many requests, try again later", retryDelay)
+}
+
+// Identity of a caller, empty fields are unknown
+type Identity struct {
+	UserID int64
+	APIKey string
+	IP     string
+}
+
+// bucket key part of identity per, false if unknown
+func (id Identity) key(per string) (string, bool) {
+	switch per {
+	case PerUser:
+		return strconv.FormatInt(id.UserID, 10), id.UserID != 0
+	case PerAPIKey:
+		// keys aren't kept in clear
+		sum := sha256.Sum256([]byte(id.APIKey))
+		return hex.EncodeToString(sum[:]), id.APIKey != ""
+	case PerIP:
+		return id.IP, id.IP != ""
+	}
+	return "", false
+}
+
+type rule struct {
+	methods []string
+	per     string
+	rate    float64
+	burst   int
+}
+
+func (r *rule) matches(method string) bool {
+	for _, m := range r.methods {
+		if m == method || strings.HasSuffix(m, "*") && strings.HasPrefix(method, strings.TrimSuffix(m, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Limiter checks requests against token bucket rules per method & caller
+type Limiter struct {
+	// name scoping keys of rules, limiters sharing a store have distinct names
+	name   string
+	rules  []*rule
+	store  Store
+	logger log.Factory
+}
+
+// New limiter of rules named name, keeping buckets in store
+func New(name string, rules []*configs.RateLimitRule, store Store) (*Limiter, error) {
+	l := &Limiter{
+		name:   name,
+		rules:  make([]*rule, 0, len(rules)),
+		store:  store,
+		logger: log.With(zap.String("srv", "rate-limit"), zap.String("limiter", name)),
+	}
+	for i, r := range rules {
+		switch r.Per {
+		case PerUser, PerAPIKey, PerIP:
+		default:
+			return nil, fmt.Errorf("rate limit rule %d: invalid identity %q", i, r.Per)
+		}
+		if r.Rate <= 0 || len(r.Methods) == 0 {
+			return nil, fmt.Errorf("rate limit rule %d: rate & methods are required", i)
+		}
+		burst := r.Burst
+		if burst <= 0 {
+			burst = int(math.Ceil(r.Rate))
+		}
+		l.rules = append(l.rules, &rule{methods: r.Methods, per: r.Per, rate: r.Rate, burst: burst})
+	}
+	return l, nil
+}
+
+// Allow takes a token of every rule matching method for caller id,
+// returns ErrLimited w the longest delay if a bucket is empty.
+// Requests are allowed when the store fails so an outage doesn't take the service down.
+func (l *Limiter) Allow(ctx context.Context, method string, id Identity) error {
+	var retryDelay time.Duration
+	for i, r := range l.rules {
+		if !r.matches(method) {
+			continue
+		}
+		value, ok := id.key(r.per)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("ratelimit:%s:%d:%s:%s", l.name, i, r.per, value)
+		delay, err := l.store.Take(ctx, key, r.rate, r.burst)
+		if err != nil {
+			l.logger.For(ctx).Error("take token failed", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		if delay > retryDelay {
+			retryDelay = delay
+		}
+	}
+	if retryDelay > 0 {
+		l.logger.For(ctx).Info("rate limited", zap.String("method", method), zap.Int64("userID", id.UserID), zap.String("ip", id.IP), zap.Duration("retryDelay", retryDelay))
+		return ErrLimited(retryDelay)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+)
+
+func TestStore_Take(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	stores := []struct {
+		name string
+		// new store w its clock setter
+		new func(t *testing.T) (Store, func(time.Time))
+	}{
+		{
+			name: "Memory",
+			new: func(t *testing.T) (Store, func(time.Time)) {
+				s := NewMemoryStore()
+				return s, func(now time.Time) { s.now = func() time.Time { return now } }
+			},
+		},
+		{
+			name: "Redis",
+			new: func(t *testing.T) (Store, func(time.Time)) {
+				m, err := miniredis.Run()
+				require.NoError(t, err)
+				t.Cleanup(m.Close)
+				s := NewRedisStore(&configs.Redis{Nodes: []string{m.Addr()}, Prefix: "test"})
+				t.Cleanup(func() { s.Close() })
+				return s, m.SetTime
+			},
+		},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			ctx := context.Background()
+			store, setNow := st.new(t)
+			setNow(start)
+
+			// burst of 3, refilled at 2 tokens per second
+			for i := 0; i < 3; i++ {
+				delay, err := store.Take(ctx, "a", 2, 3)
+				require.NoError(t, err)
+				require.Zero(t, delay)
+			}
+			delay, err := store.Take(ctx, "a", 2, 3)
+			require.NoError(t, err)
+			require.InDelta(t, 500*time.Millisecond, delay, float64(time.Millisecond))
+
+			// other keys have their own bucket
+			delay, err = store.Take(ctx, "b", 2, 3)
+			require.NoError(t, err)
+			require.Zero(t, delay)
+
+			// a token is refilled after 500ms
+			setNow(start.Add(500 * time.Millisecond))
+			delay, err = store.Take(ctx, "a", 2, 3)
+			require.NoError(t, err)
+			require.Zero(t, delay)
+			delay, err = store.Take(ctx, "a", 2, 3)
+			require.NoError(t, err)
+			require.NotZero(t, delay)
+
+			// refill is capped by burst
+			setNow(start.Add(time.Hour))
+			for i := 0; i < 3; i++ {
+				delay, err := store.Take(ctx, "a", 2, 3)
+				require.NoError(t, err)
+				require.Zero(t, delay)
+			}
+			delay, err = store.Take(ctx, "a", 2, 3)
+			require.NoError(t, err)
+			require.NotZero(t, delay)
+		})
+	}
+}
+
+func TestMemoryStore_Prune(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	_, _ = s.Take(context.Background(), "a", 1, 1)
+	_, _ = s.Take(context.Background(), "b", 1, 1)
+	require.Len(t, s.buckets, 2)
+
+	// full buckets are forgotten
+	now = now.Add(2 * memoryPruneInterval)
+	_, _ = s.Take(context.Background(), "b", 1, 1)
+	require.Len(t, s.buckets, 1)
+}
+
+// store failing every take
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	return 0, errors.New("store down")
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []*configs.RateLimitRule
+	}{
+		{name: "InvalidIdentity", rules: []*configs.RateLimitRule{{Methods: []string{"*"}, Per: "email", Rate: 1}}},
+		{name: "MissingRate", rules: []*configs.RateLimitRule{{Methods: []string{"*"}, Per: PerIP}}},
+		{name: "MissingMethods", rules: []*configs.RateLimitRule{{Per: PerIP, Rate: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New("test", tt.rules, NewMemoryStore())
+			require.Error(t, err)
+		})
+	}
+
+	_, err := NewStore(&configs.RateLimit{Store: StoreRedis}, nil)
+	require.Error(t, err)
+	_, err = NewStore(&configs.RateLimit{Store: "disk"}, nil)
+	require.Error(t, err)
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	ctx := context.Background()
+	limiter, err := New("test", []*configs.RateLimitRule{
+		{Methods: []string{"/user.UserService/*"}, Per: PerUser, Rate: 1, Burst: 2},
+		{Methods: []string{"/user.UserService/Login"}, Per: PerIP, Rate: 0.1, Burst: 1},
+		{Methods: []string{"/user.UserService/List"}, Per: PerAPIKey, Rate: 1},
+	}, NewMemoryStore())
+	require.NoError(t, err)
+
+	requireLimited := func(t *testing.T, err error, minDelay time.Duration) {
+		st := status.Convert(err)
+		require.Equal(t, codes.ResourceExhausted, st.Code())
+		require.Len(t, st.Details(), 1)
+		info, ok := st.Details()[0].(*errdetails.RetryInfo)
+		require.True(t, ok)
+		require.GreaterOrEqual(t, info.GetRetryDelay().AsDuration(), minDelay)
+	}
+
+	// per user on all methods
+	user := Identity{UserID: 1, IP: "1.2.3.4"}
+	require.NoError(t, limiter.Allow(ctx, "/user.UserService/Get", user))
+	require.NoError(t, limiter.Allow(ctx, "/user.UserService/Get", user))
+	requireLimited(t, limiter.Allow(ctx, "/user.UserService/Update", user), 500*time.Millisecond)
+	require.NoError(t, limiter.Allow(ctx, "/user.UserService/Get", Identity{UserID: 2, IP: "1.2.3.4"}))
+	// other services don't match
+	require.NoError(t, limiter.Allow(ctx, "/grpc.health.v1.Health/Check", user))
+
+	// per IP on login, the longest delay is reported
+	anonymous := Identity{IP: "5.6.7.8"}
+	require.NoError(t, limiter.Allow(ctx, "/user.UserService/Login", anonymous))
+	requireLimited(t, limiter.Allow(ctx, "/user.UserService/Login", anonymous), 5*time.Second)
+	// callers w/o IP aren't limited per IP
+	require.NoError(t, limiter.Allow(ctx, "/user.UserService/Login", Identity{}))
+	require.NoError(t, limiter.Allow(ctx, "/user.UserService/Login", Identity{}))
+
+	// per API key, burst defaults to rate
+	key := Identity{APIKey: "mfk_key"}
+	require.NoError(t, limiter.Allow(ctx, "/user.UserService/List", key))
+	requireLimited(t, limiter.Allow(ctx, "/user.UserService/List", key), 0)
+	require.NoError(t, limiter.Allow(ctx, "/user.UserService/List", Identity{APIKey: "mfk_other"}))
+
+	// requests are allowed when the store fails
+	failing, err := New("test", []*configs.RateLimitRule{{Methods: []string{"*"}, Per: PerIP, Rate: 1}}, failingStore{})
+	require.NoError(t, err)
+	require.NoError(t, failing.Allow(ctx, "/user.UserService/Get", anonymous))
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+)
+
+// token bucket of KEYS[1] in a hash of tokens & last update time of the redis clock,
+// ARGV are rate per second & burst, returns delay in ms before a token is available, 0 if taken
+var takeScript = redis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local wait = 0
+if tokens < 1 then
+  wait = math.ceil((1 - tokens) / rate * 1000)
+else
+  tokens = tokens - 1
+end
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.6f', tokens), 'ts', string.format('%.6f', now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return wait
+`)
+
+// RedisStore keeps buckets in redis, shared by service instances
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+var _ Store = (*RedisStore)(nil)
+
+// NewRedisStore of a single node or a cluster
+func NewRedisStore(config *configs.Redis) *RedisStore {
+	s := &RedisStore{
+		client: redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:    config.Nodes,
+			Password: config.Password,
+			DB:       config.DB,
+		}),
+	}
+	if config.Prefix != "" {
+		s.prefix = config.Prefix + ":"
+	}
+	return s
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	wait, err := takeScript.Run(ctx, s.client, []string{s.prefix + key}, rate, burst).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// Close connections to redis
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
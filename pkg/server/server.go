@@ -39,6 +39,7 @@ type Server struct {
 	grpcServer   *grpc.Server
 	logger       log.Factory
 	interceptors []interceptor.ServerInterceptor
+	certWatcher  *utils.CertWatcher
 }
 
 func NewServer(srvConfig *configs.ServiceConfig, opt ...Option) *Server {
@@ -89,12 +90,23 @@ func (s *Server) setLogger() {
 }
 
 func (s *Server) loadServerTLSCredentials() (credentials.TransportCredentials, error) {
-	config, err := utils.LoadServerTLSConfig(s.config.TLSCert.CertPem, s.config.TLSCert.KeyPem)
+	cert := s.config.TLSCert
+	caCert := ""
+	if cert.MutualTLS {
+		// require client certificates signed by CA
+		caCert = cert.CACert
+	}
+	// reload certificates on change w/o restarting server
+	watcher, err := utils.NewCertWatcher(cert.CertPem, cert.KeyPem, caCert,
+		utils.WithReloadInterval(cert.ReloadInterval),
+		utils.WithExpiryWarning(cert.ExpiryWarning),
+	)
 	if err != nil {
 		return nil, err
 	}
+	s.certWatcher = watcher
 	// Create the credentials and return it
-	return credentials.NewTLS(config), nil
+	return credentials.NewTLS(watcher.TLSConfig()), nil
 }
 
 func (s *Server) insecureServer() grpc.ServerOption {
@@ -141,6 +153,12 @@ func (s *Server) Run(registerService func(*grpc.Server) error, stopper func()) e
 		return err
 	}
 
+	// watch certificates changes
+	if s.certWatcher != nil {
+		s.certWatcher.Start()
+		defer s.certWatcher.Stop()
+	}
+
 	// graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"time"
+)
+
+// CertKey is a generated certificate & its private key
+type CertKey struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+	// DER encoded certificate
+	Raw []byte
+}
+
+// LeafOptions of a certificate signed by the local CA
+type LeafOptions struct {
+	CommonName string
+	// DNS names & IPs, server certificates only
+	Hosts []string
+	// URI SANs, ex: spiffe://moneyforward/gateway
+	URIs []string
+	// server or client certificate
+	Server   bool
+	Validity time.Duration
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// GenerateCA creates a self-signed CA for local development & tests
+func GenerateCA(commonName string, validity time.Duration) (*CertKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &CertKey{Cert: cert, Key: key, Raw: raw}, nil
+}
+
+// GenerateLeaf creates a server or client certificate signed by ca
+func GenerateLeaf(ca *CertKey, opts LeafOptions) (*CertKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: opts.CommonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(opts.Validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if opts.Server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		for _, h := range opts.Hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		}
+	}
+	for _, u := range opts.URIs {
+		uri, err := url.Parse(u)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.URIs = append(tmpl.URIs, uri)
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &CertKey{Cert: cert, Key: key, Raw: raw}, nil
+}
+
+// CertPEM encodes certificate
+func (c *CertKey) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+}
+
+// KeyPEM encodes private key
+func (c *CertKey) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// WritePEM writes certificate & private key files
+func (c *CertKey) WritePEM(certFile, keyFile string) error {
+	if err := ioutil.WriteFile(certFile, c.CertPEM(), 0644); err != nil {
+		return err
+	}
+	key, err := c.KeyPEM()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, key, 0600)
+}
@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// Default interval between checks of certificate files changes
+	DefaultCertReloadInterval = 30 * time.Second
+	// Default period before expiry to start logging warnings
+	DefaultCertExpiryWarning = 30 * 24 * time.Hour
+	// interval between repeated expiry warnings of the same certificate
+	certExpiryLogInterval = time.Hour
+)
+
+type CertWatcherOption func(*CertWatcher)
+
+// WithReloadInterval sets interval between checks of certificate files changes
+func WithReloadInterval(interval time.Duration) CertWatcherOption {
+	return func(w *CertWatcher) {
+		if interval > 0 {
+			w.interval = interval
+		}
+	}
+}
+
+// WithExpiryWarning sets period before expiry to start logging warnings
+func WithExpiryWarning(period time.Duration) CertWatcherOption {
+	return func(w *CertWatcher) {
+		if period > 0 {
+			w.expiryWarning = period
+		}
+	}
+}
+
+// file modification stamp
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// CertWatcher serves a server certificate (& client CAs for mutual TLS)
+// reloaded when its files change on disk, the last valid certificate is kept
+// if new files fail to load
+type CertWatcher struct {
+	certPem string
+	keyPem  string
+	// empty: client certificates are not requested
+	caCert        string
+	interval      time.Duration
+	expiryWarning time.Duration
+	logger        log.Factory
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	stamps    map[string]fileStamp
+	warnedAt  time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewCertWatcher loads certificate files, fails if they are invalid at startup
+func NewCertWatcher(certPem, keyPem, caCert string, opts ...CertWatcherOption) (*CertWatcher, error) {
+	w := &CertWatcher{
+		certPem:       certPem,
+		keyPem:        keyPem,
+		caCert:        caCert,
+		interval:      DefaultCertReloadInterval,
+		expiryWarning: DefaultCertExpiryWarning,
+		logger:        log.With(zap.String("tls", "cert-watcher"), zap.String("cert", certPem)),
+		stop:          make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(w)
+	}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *CertWatcher) files() []string {
+	files := []string{w.certPem, w.keyPem}
+	if w.caCert != "" {
+		files = append(files, w.caCert)
+	}
+	return files
+}
+
+func (w *CertWatcher) readStamps() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp)
+	for _, f := range w.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		stamps[f] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+// changed reports whether certificate files changed since last load
+func (w *CertWatcher) changed() bool {
+	stamps, err := w.readStamps()
+	if err != nil {
+		// file missing while being replaced, retry on next check
+		return false
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for f, s := range stamps {
+		if old, ok := w.stamps[f]; !ok || old != s {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload loads certificate files, keeps serving the current certificate on failure
+func (w *CertWatcher) Reload() error {
+	stamps, err := w.readStamps()
+	if err == nil {
+		err = w.load(stamps)
+	}
+	if err != nil {
+		w.logger.Bg().Error("reload certificate failed, keep serving current certificate", zap.Error(err))
+		if stamps != nil {
+			// retry once files change again
+			w.mu.Lock()
+			w.stamps = stamps
+			w.mu.Unlock()
+		}
+		return err
+	}
+	return nil
+}
+
+func (w *CertWatcher) load(stamps map[string]fileStamp) error {
+	cert, err := tls.LoadX509KeyPair(w.certPem, w.keyPem)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	var clientCAs *x509.CertPool
+	if w.caCert != "" {
+		if clientCAs, err = loadCertPool(w.caCert); err != nil {
+			return fmt.Errorf("load client CA: %w", err)
+		}
+	}
+
+	w.mu.Lock()
+	w.cert = &cert
+	w.clientCAs = clientCAs
+	w.stamps = stamps
+	w.warnedAt = time.Time{}
+	w.mu.Unlock()
+
+	w.logger.Bg().Info("certificate loaded",
+		zap.String("subject", cert.Leaf.Subject.CommonName),
+		zap.Time("notAfter", cert.Leaf.NotAfter),
+	)
+	w.checkExpiry()
+	return nil
+}
+
+// checkExpiry logs warnings ahead of certificate expiry, at most once per certExpiryLogInterval
+func (w *CertWatcher) checkExpiry() {
+	now := time.Now()
+	w.mu.Lock()
+	leaf := w.cert.Leaf
+	if now.Add(w.expiryWarning).Before(leaf.NotAfter) || now.Sub(w.warnedAt) < certExpiryLogInterval {
+		w.mu.Unlock()
+		return
+	}
+	w.warnedAt = now
+	w.mu.Unlock()
+
+	if now.After(leaf.NotAfter) {
+		w.logger.Bg().Error("certificate expired", zap.Time("notAfter", leaf.NotAfter))
+		return
+	}
+	w.logger.Bg().Error("certificate expires soon",
+		zap.Time("notAfter", leaf.NotAfter),
+		zap.Duration("expiresIn", leaf.NotAfter.Sub(now)),
+	)
+}
+
+// Start watches certificate files changes in background until Stop
+func (w *CertWatcher) Start() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+			if w.changed() {
+				// error logged, old certificate kept
+				_ = w.Reload()
+			}
+			w.checkExpiry()
+		}
+	}()
+}
+
+// Stop watching certificate files
+func (w *CertWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	w.wg.Wait()
+}
+
+// Certificate returns current certificate
+func (w *CertWatcher) Certificate() *tls.Certificate {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.cert
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (w *CertWatcher) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return w.Certificate(), nil
+}
+
+// GetConfigForClient implements tls.Config.GetConfigForClient,
+// picks up current certificate & client CAs per handshake
+func (w *CertWatcher) GetConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	config := &tls.Config{
+		Certificates: []tls.Certificate{*w.cert},
+		ClientAuth:   tls.NoClientCert,
+		MinVersion:   tls.VersionTLS12,
+		// returned config replaces the one set up by grpc/net/http, keep http2 ALPN
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if w.clientCAs != nil {
+		config.ClientCAs = w.clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// TLSConfig returns server config reloading certificates
+func (w *CertWatcher) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetCertificate:     w.GetCertificate,
+		GetConfigForClient: w.GetConfigForClient,
+	}
+}
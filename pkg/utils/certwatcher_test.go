@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serial of the certificate served by server config
+func servedSerial(t *testing.T, serverConfig, clientConfig *tls.Config) *big.Int {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+	}()
+	conn, err := tls.Dial("tcp", lis.Addr().String(), clientConfig)
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber
+}
+
+func TestCertWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	ca, err := GenerateCA("test CA", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, ca.WritePEM(path("ca-cert.pem"), path("ca-key.pem")))
+	writeServerCert := func() *big.Int {
+		server, err := GenerateLeaf(ca, LeafOptions{CommonName: "user-service", Hosts: []string{"user-service"}, Server: true, Validity: time.Hour})
+		require.NoError(t, err)
+		require.NoError(t, server.WritePEM(path("server-cert.pem"), path("server-key.pem")))
+		return server.Cert.SerialNumber
+	}
+	first := writeServerCert()
+
+	// invalid files at startup
+	_, err = NewCertWatcher(path("ca-key.pem"), path("server-key.pem"), "")
+	require.Error(t, err)
+
+	w, err := NewCertWatcher(path("server-cert.pem"), path("server-key.pem"), "", WithReloadInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	clientConfig, err := LoadClientTLSConfig(path("ca-cert.pem"))
+	require.NoError(t, err)
+	clientConfig.ServerName = "user-service"
+	require.Equal(t, first, servedSerial(t, w.TLSConfig(), clientConfig))
+
+	// new certificate is served after reload
+	second := writeServerCert()
+	require.NoError(t, w.Reload())
+	require.Equal(t, second, servedSerial(t, w.TLSConfig(), clientConfig))
+
+	// invalid certificate is rejected, current certificate kept
+	require.NoError(t, ioutil.WriteFile(path("server-cert.pem"), []byte("invalid"), 0644))
+	require.Error(t, w.Reload())
+	require.Equal(t, second, servedSerial(t, w.TLSConfig(), clientConfig))
+
+	// watcher picks up files changes
+	w.Start()
+	defer w.Stop()
+	third := writeServerCert()
+	require.Eventually(t, func() bool {
+		return w.Certificate().Leaf.SerialNumber.Cmp(third) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, third, servedSerial(t, w.TLSConfig(), clientConfig))
+}
+
+func TestCertWatcher_MutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	genTestCerts(t, dir)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	w, err := NewCertWatcher(path("server-cert.pem"), path("server-key.pem"), path("ca-cert.pem"))
+	require.NoError(t, err)
+
+	// client certificate required
+	clientConfig, err := LoadClientTLSConfig(path("ca-cert.pem"))
+	require.NoError(t, err)
+	clientConfig.ServerName = "user-service"
+	_, err = handshake(t, w.TLSConfig(), clientConfig)
+	require.Error(t, err)
+
+	clientConfig, err = LoadMutualClientTLSConfig(path("ca-cert.pem"), path("client-cert.pem"), path("client-key.pem"))
+	require.NoError(t, err)
+	clientConfig.ServerName = "user-service"
+	state, err := handshake(t, w.TLSConfig(), clientConfig)
+	require.NoError(t, err)
+	require.NotEmpty(t, state.VerifiedChains)
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerCertificate returns the verified client certificate of the grpc peer, nil if none
+func PeerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil
+	}
+	return chains[0][0]
+}
+
+// CertificateIdentity maps certificate to configured identity:
+// URI SANs (SPIFFE-like ids) first, then subject common name
+func CertificateIdentity(cert *x509.Certificate, identities []*configs.ServiceIdentity) *configs.ServiceIdentity {
+	if cert == nil {
+		return nil
+	}
+	for _, uri := range cert.URIs {
+		for _, id := range identities {
+			if id.URI != "" && id.URI == uri.String() {
+				return id
+			}
+		}
+	}
+	for _, id := range identities {
+		if id.CommonName != "" && id.CommonName == cert.Subject.CommonName {
+			return id
+		}
+	}
+	return nil
+}
+
+// PeerIdentity returns identity of the grpc peer verified client certificate, nil if none
+func PeerIdentity(ctx context.Context, identities []*configs.ServiceIdentity) *configs.ServiceIdentity {
+	return CertificateIdentity(PeerCertificate(ctx), identities)
+}
+
+// IdentityAllows reports whether identity is authorized to call method w/o JWT
+func IdentityAllows(id *configs.ServiceIdentity, method string) bool {
+	if id == nil {
+		return false
+	}
+	for _, m := range id.Methods {
+		if m == "*" || m == method {
+			return true
+		}
+	}
+	return false
+}
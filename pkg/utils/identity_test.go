@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/stretchr/testify/require"
+)
+
+// generate CA, server & client certificates into dir
+func genTestCerts(t *testing.T, dir string) {
+	ca, err := GenerateCA("test CA", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, ca.WritePEM(filepath.Join(dir, "ca-cert.pem"), filepath.Join(dir, "ca-key.pem")))
+	server, err := GenerateLeaf(ca, LeafOptions{CommonName: "user-service", Hosts: []string{"user-service", "127.0.0.1"}, Server: true, Validity: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, server.WritePEM(filepath.Join(dir, "server-cert.pem"), filepath.Join(dir, "server-key.pem")))
+	client, err := GenerateLeaf(ca, LeafOptions{CommonName: "gateway", URIs: []string{"spiffe://test/gateway"}, Validity: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, client.WritePEM(filepath.Join(dir, "client-cert.pem"), filepath.Join(dir, "client-key.pem")))
+}
+
+// handshake over loopback tcp, returns server side connection state
+func handshake(t *testing.T, serverConfig, clientConfig *tls.Config) (tls.ConnectionState, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), clientConfig)
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		// tls 1.3 client cert is rejected after client handshake, wait for server alert
+		_, err = conn.Read(make([]byte, 1))
+		errc <- err
+	}()
+	conn, err := lis.Accept()
+	require.NoError(t, err)
+	server := tls.Server(conn, serverConfig)
+	err = server.Handshake()
+	state := server.ConnectionState()
+	server.Close()
+	if cerr := <-errc; err == nil && cerr != io.EOF {
+		err = cerr
+	}
+	return state, err
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	genTestCerts(t, dir)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	serverConfig, err := LoadMutualServerTLSConfig(path("server-cert.pem"), path("server-key.pem"), path("ca-cert.pem"))
+	require.NoError(t, err)
+	identities := []*configs.ServiceIdentity{
+		{Name: "gateway-by-cn", CommonName: "gateway"},
+		{Name: "gateway", URI: "spiffe://test/gateway", Methods: []string{"/user.UserService/List"}},
+	}
+
+	tests := []struct {
+		name       string
+		withCert   bool
+		serverName string
+		wantErr    bool
+	}{
+		{name: "ClientCert", withCert: true, serverName: "user-service"},
+		{name: "MissingClientCert", serverName: "user-service", wantErr: true},
+		{name: "WrongServerName", withCert: true, serverName: "other-service", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var clientConfig *tls.Config
+			if tt.withCert {
+				clientConfig, err = LoadMutualClientTLSConfig(path("ca-cert.pem"), path("client-cert.pem"), path("client-key.pem"))
+			} else {
+				clientConfig, err = LoadClientTLSConfig(path("ca-cert.pem"))
+			}
+			require.NoError(t, err)
+			clientConfig.ServerName = tt.serverName
+
+			state, err := handshake(t, serverConfig, clientConfig)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotEmpty(t, state.VerifiedChains)
+
+			// URI SAN takes precedence over common name
+			id := CertificateIdentity(state.VerifiedChains[0][0], identities)
+			require.NotNil(t, id)
+			require.Equal(t, "gateway", id.Name)
+			require.True(t, IdentityAllows(id, "/user.UserService/List"))
+			require.False(t, IdentityAllows(id, "/user.UserService/Delete"))
+		})
+	}
+}
@@ -7,28 +7,50 @@ import (
 	"io/ioutil"
 )
 
-func LoadClientTLSConfig(caCert string) (*tls.Config, error) {
-	// Load certificate of the CA who signed server's certificate
-	pemServerCA, err := ioutil.ReadFile(caCert)
+func loadCertPool(caCert string) (*x509.CertPool, error) {
+	// Load certificate of the CA who signed peer's certificate
+	pemCA, err := ioutil.ReadFile(caCert)
 	if err != nil {
 		return nil, err
 	}
 
 	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(pemServerCA) {
-		return nil, fmt.Errorf("failed to add server CA's certificate")
+	if !certPool.AppendCertsFromPEM(pemCA) {
+		return nil, fmt.Errorf("failed to add CA's certificate")
+	}
+	return certPool, nil
+}
+
+func LoadClientTLSConfig(caCert string) (*tls.Config, error) {
+	// Load certificate of the CA who signed server's certificate
+	certPool, err := loadCertPool(caCert)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create the credentials and return it
 	config := &tls.Config{
 		RootCAs:    certPool,
 		MinVersion: tls.VersionTLS12,
-		// ServerName:         c.addr,
-		// InsecureSkipVerify: true,
 	}
 	return config, nil
 }
 
+// LoadMutualClientTLSConfig verifies server certificate & presents client certificate
+func LoadMutualClientTLSConfig(caCert, certPem, keyPem string) (*tls.Config, error) {
+	config, err := LoadClientTLSConfig(caCert)
+	if err != nil {
+		return nil, err
+	}
+	// Load client's certificate and private key
+	clientCert, err := tls.LoadX509KeyPair(certPem, keyPem)
+	if err != nil {
+		return nil, err
+	}
+	config.Certificates = []tls.Certificate{clientCert}
+	return config, nil
+}
+
 func LoadServerTLSConfig(certPem, keyPem string) (*tls.Config, error) {
 	// Load server's certificate and private key
 	serverCert, err := tls.LoadX509KeyPair(certPem, keyPem)
@@ -43,3 +65,18 @@ func LoadServerTLSConfig(certPem, keyPem string) (*tls.Config, error) {
 	}
 	return config, nil
 }
+
+// LoadMutualServerTLSConfig requires client certificates signed by caCert
+func LoadMutualServerTLSConfig(certPem, keyPem, caCert string) (*tls.Config, error) {
+	config, err := LoadServerTLSConfig(certPem, keyPem)
+	if err != nil {
+		return nil, err
+	}
+	certPool, err := loadCertPool(caCert)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = certPool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
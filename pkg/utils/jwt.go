@@ -11,16 +11,20 @@ import (
 
 type JWTManager struct {
 	issuer        string
-	secretKey     []byte
 	tokenDuration time.Duration
+	keys          *KeyRing
 }
 
-func NewJWTManager(config *configs.JWT) *JWTManager {
+func NewJWTManager(config *configs.JWT) (*JWTManager, error) {
+	keys, err := NewKeyRing(config)
+	if err != nil {
+		return nil, err
+	}
 	return &JWTManager{
 		issuer:        config.Issuer,
-		secretKey:     []byte(config.SecretKey),
 		tokenDuration: config.Duration,
-	}
+		keys:          keys,
+	}, nil
 }
 
 func (manager *JWTManager) GetStandardClaims() jwt.StandardClaims {
@@ -30,9 +34,31 @@ func (manager *JWTManager) GetStandardClaims() jwt.StandardClaims {
 	}
 }
 
+// TokenDuration of issued tokens
+func (manager *JWTManager) TokenDuration() time.Duration {
+	return manager.tokenDuration
+}
+
+// KeyRing of signing keys
+func (manager *JWTManager) KeyRing() *KeyRing {
+	return manager.keys
+}
+
+// JWKS returns public keys verifying tokens
+func (manager *JWTManager) JWKS() *JWKS {
+	return manager.keys.JWKS()
+}
+
 func (manager *JWTManager) Generate(claims jwt.Claims) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(manager.secretKey)
+	key, err := manager.keys.Signer()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.signKey)
 }
 
 func (manager *JWTManager) Verify(accessToken string, claims jwt.Claims) (jwt.Claims, error) {
@@ -40,10 +66,16 @@ func (manager *JWTManager) Verify(accessToken string, claims jwt.Claims) (jwt.Cl
 		accessToken,
 		claims,
 		func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			kid, _ := token.Header["kid"].(string)
+			key := manager.keys.Lookup(kid)
+			if key == nil {
+				return nil, fmt.Errorf("unknown token signing key %q", kid)
+			}
+			// reject tokens signed w another algorithm than the key's
+			if token.Method.Alg() != key.Algorithm {
 				return nil, fmt.Errorf("unexpected token signing method")
 			}
-			return manager.secretKey, nil
+			return key.verifyKey, nil
 		},
 	)
 	if err != nil {
@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+// verify key rebuilt from its JWK, as a remote verifier would
+func jwkPublicKey(t *testing.T, k JWK) interface{} {
+	enc := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := enc.DecodeString(k.N)
+		require.NoError(t, err)
+		e, err := enc.DecodeString(k.E)
+		require.NoError(t, err)
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "OKP":
+		x, err := enc.DecodeString(k.X)
+		require.NoError(t, err)
+		return ed25519.PublicKey(x)
+	}
+	t.Fatalf("unexpected jwk type %s", k.Kty)
+	return nil
+}
+
+func findJWK(set *JWKS, kid string) (JWK, bool) {
+	for _, k := range set.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return JWK{}, false
+}
+
+func TestJWTManager(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		wantKid   bool
+	}{
+		{algorithm: ""},
+		{algorithm: AlgorithmHS256},
+		{algorithm: AlgorithmRS256, wantKid: true},
+		{algorithm: AlgorithmEdDSA, wantKid: true},
+	}
+	for _, tt := range tests {
+		t.Run("Algorithm"+tt.algorithm, func(t *testing.T) {
+			m, err := NewJWTManager(&configs.JWT{
+				Issuer:    "lu",
+				SecretKey: "lu",
+				Duration:  time.Minute,
+				Algorithm: tt.algorithm,
+			})
+			require.NoError(t, err)
+
+			token, err := m.Generate(m.GetStandardClaims())
+			require.NoError(t, err)
+			claims, err := m.Verify(token, &jwt.StandardClaims{})
+			require.NoError(t, err)
+			require.Equal(t, "lu", claims.(*jwt.StandardClaims).Issuer)
+
+			// tampered signature
+			_, err = m.Verify(token[:len(token)-4]+"AAAA", &jwt.StandardClaims{})
+			require.Error(t, err)
+
+			parsed, _, err := new(jwt.Parser).ParseUnverified(token, &jwt.StandardClaims{})
+			require.NoError(t, err)
+			kid, _ := parsed.Header["kid"].(string)
+			if !tt.wantKid {
+				require.Empty(t, kid)
+				require.Empty(t, m.JWKS().Keys)
+				return
+			}
+			require.NotEmpty(t, kid)
+
+			// verifiable w published key only
+			jwk, ok := findJWK(m.JWKS(), kid)
+			require.True(t, ok)
+			require.Equal(t, tt.algorithm, jwk.Alg)
+			_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+				return jwkPublicKey(t, jwk), nil
+			})
+			require.NoError(t, err)
+
+			// token signed w HMAC over the public key is rejected
+			forged := jwt.NewWithClaims(jwt.SigningMethodHS256, m.GetStandardClaims())
+			forged.Header["kid"] = kid
+			forgedToken, err := forged.SignedString([]byte(jwk.N + jwk.X))
+			require.NoError(t, err)
+			_, err = m.Verify(forgedToken, &jwt.StandardClaims{})
+			require.Error(t, err)
+		})
+	}
+
+	_, err := NewJWTManager(&configs.JWT{Algorithm: "none"})
+	require.Error(t, err)
+	_, err = NewJWTManager(&configs.JWT{Algorithm: AlgorithmHS256})
+	require.Error(t, err)
+}
+
+func TestKeyRing_Rotation(t *testing.T) {
+	ring, err := NewKeyRing(&configs.JWT{
+		Algorithm:        AlgorithmEdDSA,
+		RotationInterval: time.Hour,
+		KeyRetention:     30 * time.Minute,
+	})
+	require.NoError(t, err)
+	now := time.Now()
+	ring.now = func() time.Time { return now }
+	m := &JWTManager{issuer: "lu", tokenDuration: 24 * time.Hour, keys: ring}
+
+	first, err := ring.Signer()
+	require.NoError(t, err)
+	token, err := m.Generate(m.GetStandardClaims())
+	require.NoError(t, err)
+
+	// next key is published before it signs tokens
+	set := ring.JWKS()
+	require.Len(t, set.Keys, 2)
+	nextKid := set.Keys[1].Kid
+
+	// rotated on schedule, old key keeps verifying tokens
+	now = now.Add(time.Hour)
+	second, err := ring.Signer()
+	require.NoError(t, err)
+	require.NotEqual(t, first.ID, second.ID)
+	require.Equal(t, nextKid, second.ID)
+	_, err = m.Verify(token, &jwt.StandardClaims{})
+	require.NoError(t, err)
+	_, ok := findJWK(ring.JWKS(), first.ID)
+	require.True(t, ok)
+
+	// old key dropped after retention
+	now = now.Add(30 * time.Minute)
+	_, err = m.Verify(token, &jwt.StandardClaims{})
+	require.Error(t, err)
+	_, ok = findJWK(ring.JWKS(), first.ID)
+	require.False(t, ok)
+
+	// forced rotation
+	require.NoError(t, ring.Rotate())
+	third, err := ring.Signer()
+	require.NoError(t, err)
+	require.NotEqual(t, second.ID, third.ID)
+	require.NotNil(t, ring.Lookup(second.ID))
+}
+
+func TestKeyRing_Keys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeKey := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+		return path
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	rsaPem := writeKey("rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	rsaKey2, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey2)
+	require.NoError(t, err)
+	rsaPem2 := writeKey("rsa2.pem", "PRIVATE KEY", der)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edPem := writeKey("ed.pem", "PRIVATE KEY", der)
+
+	// first key signs, others verify
+	ring, err := NewKeyRing(&configs.JWT{
+		Algorithm: AlgorithmRS256,
+		Keys: []*configs.JWTKey{
+			{ID: "new", PrivateKeyPem: rsaPem},
+			{ID: "old", PrivateKeyPem: rsaPem2},
+		},
+	})
+	require.NoError(t, err)
+	signer, err := ring.Signer()
+	require.NoError(t, err)
+	require.Equal(t, "new", signer.ID)
+	require.NotNil(t, ring.Lookup("old"))
+	require.Nil(t, ring.Lookup(""))
+	require.Len(t, ring.JWKS().Keys, 2)
+
+	// key type mismatching algorithm
+	_, err = NewKeyRing(&configs.JWT{
+		Algorithm: AlgorithmRS256,
+		Keys:      []*configs.JWTKey{{PrivateKeyPem: edPem}},
+	})
+	require.Error(t, err)
+
+	// kid defaults to thumbprint
+	ring, err = NewKeyRing(&configs.JWT{
+		Algorithm: AlgorithmEdDSA,
+		Keys:      []*configs.JWTKey{{PrivateKeyPem: edPem}},
+	})
+	require.NoError(t, err)
+	signer, err = ring.Signer()
+	require.NoError(t, err)
+	kid, err := keyID(edKey.Public())
+	require.NoError(t, err)
+	require.Equal(t, kid, signer.ID)
+}
+
+func TestJWK_PublicKey(t *testing.T) {
+	for _, alg := range []string{AlgorithmRS256, AlgorithmEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			ring, err := NewKeyRing(&configs.JWT{Algorithm: alg})
+			require.NoError(t, err)
+			jwk := ring.JWKS().Keys[0]
+			pub, err := jwk.PublicKey()
+			require.NoError(t, err)
+			require.Equal(t, jwkPublicKey(t, jwk), pub)
+		})
+	}
+	_, err := JWK{Kty: "EC", Kid: "ec"}.PublicKey()
+	require.Error(t, err)
+	_, err = JWK{Kty: "OKP", Crv: "Ed25519", X: "short"}.PublicKey()
+	require.Error(t, err)
+}
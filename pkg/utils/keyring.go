@@ -0,0 +1,370 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// JWT signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// size of generated RSA keys
+const rsaKeyBits = 2048
+
+// SigningKey signs & verifies tokens
+type SigningKey struct {
+	// kid header of signed tokens, empty for HS256 secret
+	ID        string
+	Algorithm string
+	// []byte | *rsa.PrivateKey | ed25519.PrivateKey
+	signKey interface{}
+	// []byte | *rsa.PublicKey | ed25519.PublicKey
+	verifyKey interface{}
+	// zero while key signs tokens or for configured keys
+	expiresAt time.Time
+}
+
+// SigningMethod of key algorithm
+func (k *SigningKey) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k *SigningKey) expired(now time.Time) bool {
+	return !k.expiresAt.IsZero() && !now.Before(k.expiresAt)
+}
+
+// JWK public key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS public keys set served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeyRing holds the active signing key, keys verifying tokens & the next key,
+// published in JWKS ahead of signing tokens so verifiers caching JWKS know it before rotation
+type KeyRing struct {
+	algorithm string
+	rotation  time.Duration
+	retention time.Duration
+	// clock, replaced in tests
+	now func() time.Time
+
+	mu          sync.Mutex
+	active      *SigningKey
+	activatedAt time.Time
+	next        *SigningKey
+	// verify only keys, including rotated keys until expired
+	keys []*SigningKey
+}
+
+// NewKeyRing loads configured keys or generates one for RS256/EdDSA
+func NewKeyRing(config *configs.JWT) (*KeyRing, error) {
+	r := &KeyRing{
+		algorithm: config.Algorithm,
+		rotation:  config.RotationInterval,
+		retention: config.KeyRetention,
+		now:       time.Now,
+	}
+	if r.algorithm == "" {
+		r.algorithm = AlgorithmHS256
+	}
+	if r.retention <= 0 {
+		r.retention = config.Duration
+	}
+
+	switch r.algorithm {
+	case AlgorithmHS256:
+		if config.SecretKey == "" {
+			return nil, fmt.Errorf("jwt secret key is required for %s", r.algorithm)
+		}
+		// symmetric secret is not rotated, its tokens carry no kid
+		r.rotation = 0
+		secret := []byte(config.SecretKey)
+		r.active = &SigningKey{Algorithm: r.algorithm, signKey: secret, verifyKey: secret}
+	case AlgorithmRS256, AlgorithmEdDSA:
+		for _, k := range config.Keys {
+			key, err := loadSigningKey(r.algorithm, k)
+			if err != nil {
+				return nil, err
+			}
+			if r.active == nil {
+				r.active = key
+			} else {
+				r.keys = append(r.keys, key)
+			}
+		}
+		if r.active == nil {
+			key, err := generateSigningKey(r.algorithm)
+			if err != nil {
+				return nil, err
+			}
+			r.active = key
+		}
+	default:
+		return nil, fmt.Errorf("unsupported jwt signing algorithm %q", r.algorithm)
+	}
+	r.activatedAt = r.now()
+
+	if r.rotation > 0 {
+		next, err := generateSigningKey(r.algorithm)
+		if err != nil {
+			return nil, err
+		}
+		r.next = next
+	}
+	return r, nil
+}
+
+// Algorithm of signing keys
+func (r *KeyRing) Algorithm() string {
+	return r.algorithm
+}
+
+// Signer returns the active key, rotating it if due
+func (r *KeyRing) Signer() (*SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rotation > 0 && !r.now().Before(r.activatedAt.Add(r.rotation)) {
+		if err := r.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return r.active, nil
+}
+
+// Rotate activates the next key, the current key only verifies tokens until retention passes
+func (r *KeyRing) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.algorithm == AlgorithmHS256 {
+		return fmt.Errorf("%s secret key is not rotated", r.algorithm)
+	}
+	return r.rotate()
+}
+
+func (r *KeyRing) rotate() error {
+	next := r.next
+	if next == nil {
+		key, err := generateSigningKey(r.algorithm)
+		if err != nil {
+			return err
+		}
+		next = key
+	}
+	// generate following key before retiring current one, ring is unchanged on failure
+	following, err := generateSigningKey(r.algorithm)
+	if err != nil {
+		return err
+	}
+	now := r.now()
+	r.active.expiresAt = now.Add(r.retention)
+	r.keys = append(r.keys, r.active)
+	r.active = next
+	r.activatedAt = now
+	r.next = following
+	r.prune(now)
+	return nil
+}
+
+// drop expired keys
+func (r *KeyRing) prune(now time.Time) {
+	keys := r.keys[:0]
+	for _, k := range r.keys {
+		if !k.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	r.keys = keys
+}
+
+// Lookup returns key verifying tokens signed w kid, nil if unknown or expired
+func (r *KeyRing) Lookup(kid string) *SigningKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.active.ID == kid {
+		return r.active
+	}
+	now := r.now()
+	for _, k := range r.keys {
+		if k.ID == kid && !k.expired(now) {
+			return k
+		}
+	}
+	return nil
+}
+
+// JWKS returns public keys of active, verify only & next keys
+func (r *KeyRing) JWKS() *JWKS {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.prune(now)
+	keys := append([]*SigningKey{r.active}, r.keys...)
+	if r.next != nil {
+		keys = append(keys, r.next)
+	}
+	set := &JWKS{Keys: []JWK{}}
+	for _, k := range keys {
+		if jwk, ok := publicJWK(k); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+func publicJWK(k *SigningKey) (JWK, bool) {
+	enc := base64.RawURLEncoding
+	switch pub := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: k.Algorithm,
+			N:   enc.EncodeToString(pub.N.Bytes()),
+			E:   enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: k.Algorithm,
+			Crv: "Ed25519",
+			X:   enc.EncodeToString(pub),
+		}, true
+	}
+	// symmetric secret is never published
+	return JWK{}, false
+}
+
+// PublicKey of a JWK published by an issuer, *rsa.PublicKey or ed25519.PublicKey
+func (k JWK) PublicKey() (interface{}, error) {
+	enc := base64.RawURLEncoding
+	switch {
+	case k.Kty == "RSA":
+		n, err := enc.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk %q modulus: %w", k.Kid, err)
+		}
+		e, err := enc.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk %q exponent: %w", k.Kid, err)
+		}
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid jwk %q rsa key", k.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := enc.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid jwk %q ed25519 key", k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported jwk %q type %s", k.Kid, k.Kty)
+}
+
+// kid from public key thumbprint
+func keyID(pub interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+func newSigningKey(alg, kid string, priv interface{}) (*SigningKey, error) {
+	var pub interface{}
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		if alg != AlgorithmRS256 {
+			return nil, fmt.Errorf("RSA key cannot sign %s tokens", alg)
+		}
+		pub = &k.PublicKey
+	case ed25519.PrivateKey:
+		if alg != AlgorithmEdDSA {
+			return nil, fmt.Errorf("Ed25519 key cannot sign %s tokens", alg)
+		}
+		pub = k.Public()
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", priv)
+	}
+	if kid == "" {
+		id, err := keyID(pub)
+		if err != nil {
+			return nil, err
+		}
+		kid = id
+	}
+	return &SigningKey{ID: kid, Algorithm: alg, signKey: priv, verifyKey: pub}, nil
+}
+
+func generateSigningKey(alg string) (*SigningKey, error) {
+	var priv interface{}
+	var err error
+	switch alg {
+	case AlgorithmRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("cannot generate %s key", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(alg, "", priv)
+}
+
+func loadSigningKey(alg string, cfg *configs.JWTKey) (*SigningKey, error) {
+	data, err := ioutil.ReadFile(cfg.PrivateKeyPem)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", cfg.PrivateKeyPem)
+	}
+	var priv interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", cfg.PrivateKeyPem, err)
+	}
+	return newSigningKey(alg, cfg.ID, priv)
+}
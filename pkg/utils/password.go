@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+)
+
+// Password hash algorithms
+const (
+	PasswordHashBcrypt   = "bcrypt"
+	PasswordHashArgon2id = "argon2id"
+)
+
+// Default argon2id params, RFC 9106 second recommended option
+const (
+	DefaultArgon2Time    uint32 = 3
+	DefaultArgon2Memory  uint32 = 64 * 1024
+	DefaultArgon2Threads uint8  = 4
+
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// ErrPasswordMismatch is returned by CompareHash for a wrong password
+var ErrPasswordMismatch = bcrypt.ErrMismatchedHashAndPassword
+
+// PasswordHasher hashes passwords w the configured algorithm,
+// hashes of every supported algorithm are verified so stored hashes can be upgraded on login
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Compare(hash, password string) error
+	// NeedsRehash reports whether hash was made w another algorithm or params than configured
+	NeedsRehash(hash string) bool
+}
+
+type passwordHasher struct {
+	algorithm     string
+	bcryptCost    int
+	argon2Time    uint32
+	argon2Memory  uint32
+	argon2Threads uint8
+}
+
+var _ PasswordHasher = (*passwordHasher)(nil)
+
+// NewPasswordHasher of config, bcrypt w default cost if nil
+func NewPasswordHasher(config *configs.PasswordHashing) (PasswordHasher, error) {
+	h := &passwordHasher{
+		algorithm:     PasswordHashBcrypt,
+		bcryptCost:    bcrypt.DefaultCost,
+		argon2Time:    DefaultArgon2Time,
+		argon2Memory:  DefaultArgon2Memory,
+		argon2Threads: DefaultArgon2Threads,
+	}
+	if config == nil {
+		return h, nil
+	}
+	if config.Algorithm != "" {
+		h.algorithm = config.Algorithm
+	}
+	if config.BcryptCost != 0 {
+		h.bcryptCost = config.BcryptCost
+	}
+	if config.Argon2Time != 0 {
+		h.argon2Time = config.Argon2Time
+	}
+	if config.Argon2Memory != 0 {
+		h.argon2Memory = config.Argon2Memory
+	}
+	if config.Argon2Threads != 0 {
+		h.argon2Threads = config.Argon2Threads
+	}
+	switch h.algorithm {
+	case PasswordHashBcrypt:
+		if h.bcryptCost < bcrypt.MinCost || h.bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost %d out of [%d, %d]", h.bcryptCost, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case PasswordHashArgon2id:
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", h.algorithm)
+	}
+	return h, nil
+}
+
+func (h *passwordHasher) Hash(password string) (string, error) {
+	if h.algorithm == PasswordHashArgon2id {
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.argon2Time, h.argon2Memory, h.argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.argon2Memory, h.argon2Time, h.argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *passwordHasher) Compare(hash, password string) error {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	}
+	p, err := parseArgon2Hash(hash)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	if subtle.ConstantTimeCompare(key, p.key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h *passwordHasher) NeedsRehash(hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		if h.algorithm != PasswordHashArgon2id {
+			return true
+		}
+		p, err := parseArgon2Hash(hash)
+		return err != nil || p.time != h.argon2Time || p.memory != h.argon2Memory || p.threads != h.argon2Threads
+	}
+	if h.algorithm != PasswordHashBcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.bcryptCost
+}
+
+// argon2id hash in PHC string format
+type argon2Hash struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2Hash(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	p := &argon2Hash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2id params: %w", err)
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return nil, fmt.Errorf("invalid argon2id key")
+	}
+	return p, nil
+}
+
+// hasher of GenHash, CompareHash & NeedsRehash
+var defaultPasswordHasher, _ = NewPasswordHasher(nil)
+
+// SetPasswordHasher replaces hasher of GenHash, CompareHash & NeedsRehash, called once on startup
+func SetPasswordHasher(h PasswordHasher) {
+	defaultPasswordHasher = h
+}
+
+func GenHash(password string) (string, error) {
+	return defaultPasswordHasher.Hash(password)
+}
+
+func CompareHash(hashedPassword, password string) error {
+	return defaultPasswordHasher.Compare(hashedPassword, password)
+}
+
+// NeedsRehash reports whether a stored hash should be upgraded to the configured algorithm & params
+func NeedsRehash(hashedPassword string) bool {
+	return defaultPasswordHasher.NeedsRehash(hashedPassword)
+}
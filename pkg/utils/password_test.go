@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	// small argon2id params keep tests fast
+	argon2id := &configs.PasswordHashing{Algorithm: PasswordHashArgon2id, Argon2Time: 1, Argon2Memory: 1024, Argon2Threads: 1}
+	tests := []struct {
+		name       string
+		config     *configs.PasswordHashing
+		wantPrefix string
+	}{
+		{name: "Default", wantPrefix: "$2a$10$"},
+		{name: "BcryptCost", config: &configs.PasswordHashing{Algorithm: PasswordHashBcrypt, BcryptCost: 4}, wantPrefix: "$2a$04$"},
+		{name: "Argon2id", config: argon2id, wantPrefix: "$argon2id$v=19$m=1024,t=1,p=1$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewPasswordHasher(tt.config)
+			require.NoError(t, err)
+			hash, err := h.Hash("password")
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(hash, tt.wantPrefix), hash)
+			require.NoError(t, h.Compare(hash, "password"))
+			require.Equal(t, ErrPasswordMismatch, h.Compare(hash, "wrong"))
+			require.False(t, h.NeedsRehash(hash))
+			// salted
+			other, err := h.Hash("password")
+			require.NoError(t, err)
+			require.NotEqual(t, hash, other)
+		})
+	}
+
+	// hashes of other algorithms & params are verified & need rehash
+	bcryptHasher, err := NewPasswordHasher(&configs.PasswordHashing{BcryptCost: 4})
+	require.NoError(t, err)
+	argon2Hasher, err := NewPasswordHasher(argon2id)
+	require.NoError(t, err)
+	stronger, err := NewPasswordHasher(&configs.PasswordHashing{Algorithm: PasswordHashArgon2id, Argon2Time: 2, Argon2Memory: 1024, Argon2Threads: 1})
+	require.NoError(t, err)
+	bcryptHash, err := bcryptHasher.Hash("password")
+	require.NoError(t, err)
+	argon2Hash, err := argon2Hasher.Hash("password")
+	require.NoError(t, err)
+	require.NoError(t, argon2Hasher.Compare(bcryptHash, "password"))
+	require.NoError(t, bcryptHasher.Compare(argon2Hash, "password"))
+	require.True(t, argon2Hasher.NeedsRehash(bcryptHash))
+	require.True(t, bcryptHasher.NeedsRehash(argon2Hash))
+	require.True(t, stronger.NeedsRehash(argon2Hash))
+	require.NoError(t, stronger.Compare(argon2Hash, "password"))
+
+	// invalid hashes & configs
+	require.Error(t, argon2Hasher.Compare("$argon2id$v=19$m=1024$salt$key", "password"))
+	require.True(t, argon2Hasher.NeedsRehash("$argon2id$invalid"))
+	_, err = NewPasswordHasher(&configs.PasswordHashing{Algorithm: "md5"})
+	require.Error(t, err)
+	_, err = NewPasswordHasher(&configs.PasswordHashing{BcryptCost: 64})
+	require.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// proxies trusted by default: grpc-gateway runs in-process & dials the grpc server over loopback
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// ParseTrustedProxies parses CIDRs or IPs of trusted proxies, defaults to loopback
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	if len(proxies) == 0 {
+		proxies = defaultTrustedProxies
+	}
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ForwardedClientIP returns IP of a caller connected from remoteAddr (host or host:port),
+// x-forwarded-for values are used only when sent by a trusted proxy,
+// their last entry being the address the proxy received the request from
+func ForwardedClientIP(remoteAddr string, forwardedFor []string, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		// in-process or unix socket callers
+		return ""
+	}
+	if !isTrustedProxy(ip, trusted) || len(forwardedFor) == 0 {
+		return host
+	}
+	hops := strings.Split(forwardedFor[len(forwardedFor)-1], ",")
+	if last := strings.TrimSpace(hops[len(hops)-1]); net.ParseIP(last) != nil {
+		return last
+	}
+	return host
+}
+
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
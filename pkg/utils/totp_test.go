@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHOTP_RFC6238(t *testing.T) {
+	// test vectors of RFC 6238 appendix B, SHA1
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "94287082"},
+		{unix: 1111111109, want: "07081804"},
+		{unix: 1111111111, want: "14050471"},
+		{unix: 1234567890, want: "89005924"},
+		{unix: 2000000000, want: "69279037"},
+		{unix: 20000000000, want: "65353130"},
+	}
+	for _, tt := range tests {
+		step := TOTPStep(time.Unix(tt.unix, 0))
+		require.Equal(t, tt.want, hotp(key, uint64(step), 8), tt.unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenTOTPSecret()
+	require.NoError(t, err)
+	now := time.Unix(1234567890, 0)
+	step := TOTPStep(now)
+	code, err := TOTPCode(secret, step)
+	require.NoError(t, err)
+
+	// accepted within skew
+	for _, d := range []time.Duration{0, -TOTPPeriod, TOTPPeriod} {
+		got, ok := ValidateTOTP(secret, code, now.Add(d))
+		require.True(t, ok, d)
+		require.Equal(t, step, got)
+	}
+	// rejected out of skew or malformed
+	_, ok := ValidateTOTP(secret, code, now.Add(2*TOTPPeriod))
+	require.False(t, ok)
+	_, ok = ValidateTOTP(secret, "12345", now)
+	require.False(t, ok)
+	_, ok = ValidateTOTP(secret, "abcdef", now)
+	require.False(t, ok)
+	_, ok = ValidateTOTP("not base32!", code, now)
+	require.False(t, ok)
+
+	// known secret, RFC 6238 key "12345678901234567890" truncated to 6 digits
+	got, err := TOTPCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", TOTPStep(time.Unix(59, 0)))
+	require.NoError(t, err)
+	require.Equal(t, "287082", got)
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(TOTPURI("MoneyForward", "abc@gmail.com", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", u.Scheme)
+	require.Equal(t, "totp", u.Host)
+	require.Equal(t, "/MoneyForward:abc@gmail.com", u.Path)
+	require.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	require.Equal(t, "MoneyForward", u.Query().Get("issuer"))
+	require.Equal(t, "6", u.Query().Get("digits"))
+	require.Equal(t, "30", u.Query().Get("period"))
+}
@@ -0,0 +1,331 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/gorm"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// API key scopes, other scopes are full method names
+const (
+	// List* methods
+	APIKeyScopeRead = "read"
+	// all methods allowed to API keys
+	APIKeyScopeWrite = "write"
+)
+
+const (
+	// metadata key of API keys, forwarded by the gateway from X-Api-Key header
+	apiKeyHeader = "x-api-key"
+	// keys are apiKeyPrefix followed by randomTokenBytes base64url encoded
+	apiKeyPrefix = "mfk_"
+	// chars of the key stored in clear to identify it
+	apiKeyDisplayLen = 12
+	// last use of a key is recorded at most once per interval
+	apiKeyTouchInterval = time.Minute
+)
+
+const userServicePrefix = "/user.UserService/"
+
+// methods never allowed to API keys, managing keys, credentials & the user itself requires a login
+var apiKeyDeniedMethods = map[string]bool{
+	userServicePrefix + "Update":         true,
+	userServicePrefix + "Delete":         true,
+	userServicePrefix + "UnlockUser":     true,
+	userServicePrefix + "CreateAPIKey":   true,
+	userServicePrefix + "UpdateAPIKey":   true,
+	userServicePrefix + "RevokeAPIKey":   true,
+	userServicePrefix + "ChangePassword": true,
+	userServicePrefix + "EnrollTOTP":     true,
+	userServicePrefix + "ConfirmTOTP":    true,
+	userServicePrefix + "Logout":         true,
+	userServicePrefix + "RevokeSession":  true,
+}
+
+// method names of UserService
+func userServiceMethods() map[string]bool {
+	methods := pb.File_user_service_proto.Services().ByName("UserService").Methods()
+	names := make(map[string]bool, methods.Len())
+	for i := 0; i < methods.Len(); i++ {
+		names[userServicePrefix+string(methods.Get(i).Name())] = true
+	}
+	return names
+}
+
+// validate & dedupe scopes of a key
+func normalizeAPIKeyScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errorSrv.ErrInvalidAPIKeyScope
+	}
+	methods := userServiceMethods()
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		switch {
+		case scope == APIKeyScopeRead, scope == APIKeyScopeWrite:
+		case methods[scope] && !apiKeyDeniedMethods[scope]:
+		default:
+			return nil, errorSrv.ErrInvalidAPIKeyScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, nil
+}
+
+// apiKeyAllows reports whether a key w scopes may call method
+func apiKeyAllows(scopes []string, method string) bool {
+	if apiKeyDeniedMethods[method] {
+		return false
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case APIKeyScopeWrite:
+			return true
+		case APIKeyScopeRead:
+			if strings.HasPrefix(method, userServicePrefix+"List") {
+				return true
+			}
+		case method:
+			return true
+		}
+	}
+	return false
+}
+
+// API keys looked up per request, read from the primary so revocations apply immediately
+type dbAPIKeyStore struct {
+	dal    *postgres.DataAccessLayer
+	logger log.Factory
+	// clock, replaced in tests
+	now func() time.Time
+}
+
+var _ APIKeyStore = (*dbAPIKeyStore)(nil)
+
+func NewAPIKeyStore(dal *postgres.DataAccessLayer) APIKeyStore {
+	return &dbAPIKeyStore{
+		dal:    dal,
+		logger: log.With(zap.String("srv", "api-key")),
+		now:    time.Now,
+	}
+}
+
+func (s *dbAPIKeyStore) AuthenticateAPIKey(ctx context.Context, key string) (*Claims, []string, error) {
+	db := s.dal.GetDatabase()
+	if db == nil {
+		return nil, nil, errorSrv.ErrDBUnavailable
+	}
+	db = db.WithContext(ctx)
+	now := s.now()
+	var apiKey model.APIKey
+	if e := db.Where("key_hash = ?", utils.HashToken(key)).First(&apiKey).Error; e == gorm.ErrRecordNotFound {
+		return nil, nil, errorSrv.ErrAPIKeyInvalid
+	} else if e != nil {
+		s.logger.For(ctx).Error("Error find api key", zap.Error(e))
+		return nil, nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	if !apiKey.Active(now) {
+		return nil, nil, errorSrv.ErrAPIKeyInvalid
+	}
+	var user model.User
+	if e := db.Select("id", "email").Where("id = ?", apiKey.UserID).First(&user).Error; e == gorm.ErrRecordNotFound {
+		return nil, nil, errorSrv.ErrAPIKeyInvalid
+	} else if e != nil {
+		s.logger.For(ctx).Error("Error find user", zap.Error(e))
+		return nil, nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	// best effort, a failed update doesn't reject the request
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		if e := db.Model(&model.APIKey{}).Where("id = ?", apiKey.ID).UpdateColumn("last_used_at", now).Error; e != nil {
+			s.logger.For(ctx).Error("Error update api key last use", zap.Error(e))
+		}
+	}
+	return &Claims{ID: user.ID, Email: user.Email}, apiKey.ScopeList(), nil
+}
+
+// find key of user by id
+func (u *userServiceImpl) findAPIKey(ctx context.Context, db *gorm.DB, userID, id int64) (*model.APIKey, error) {
+	var apiKey model.APIKey
+	if e := db.Where("id = ? AND user_id = ?", id, userID).First(&apiKey).Error; e == gorm.ErrRecordNotFound {
+		return nil, errorSrv.ErrAPIKeyNotFound
+	} else if e != nil {
+		u.logger.For(ctx).Error("Error find api key", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return &apiKey, nil
+}
+
+// create API key of user, the key is returned once
+func (u *userServiceImpl) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	name := strings.TrimSpace(req.GetName())
+	if name == "" {
+		return nil, errorSrv.ErrMissingAPIKeyName
+	}
+	scopes, e := normalizeAPIKeyScopes(req.GetScopes())
+	if e != nil {
+		return nil, e
+	}
+	apiKey := &model.APIKey{
+		UserID: req.GetUserId(),
+		Name:   name,
+	}
+	apiKey.SetScopes(scopes)
+	if req.GetExpiresAt() != nil {
+		expiresAt := req.GetExpiresAt().AsTime()
+		if !expiresAt.After(u.now()) {
+			return nil, errorSrv.ErrInvalidAPIKeyExpiry
+		}
+		apiKey.ExpiresAt = &expiresAt
+	}
+	token, e := utils.GenToken(randomTokenBytes)
+	if e != nil {
+		u.logger.For(ctx).Error("Error gen api key", zap.Error(e))
+		return nil, errorSrv.ErrTokenGenerated
+	}
+	key := apiKeyPrefix + token
+	apiKey.Prefix = key[:apiKeyDisplayLen]
+	apiKey.KeyHash = utils.HashToken(key)
+
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		if _, e := u.findUserByID(ctx, tx, req.GetUserId()); e != nil {
+			return e
+		}
+		if e := tx.Create(apiKey).Error; e != nil {
+			u.logger.For(ctx).Error("Error create api key", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.logger.For(ctx).Info("API key created", zap.Int64("userID", apiKey.UserID), zap.Int64("id", apiKey.ID), zap.Strings("scopes", scopes))
+	md := metadata.Pairs("X-Http-Code", "201")
+	grpc.SetHeader(ctx, md)
+	return &pb.CreateAPIKeyResponse{
+		ApiKey: apiKey.Transform2GRPC(),
+		Key:    key,
+	}, nil
+}
+
+// list API keys of user, revoked ones included
+func (u *userServiceImpl) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	var apiKeys []*model.APIKey
+	if e := u.reader(ctx).Where(&model.APIKey{UserID: req.GetUserId()}).Order("id").Find(&apiKeys).Error; e != nil {
+		u.logger.For(ctx).Error("Error find api keys", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	rsp := &pb.ListAPIKeysResponse{
+		ApiKeys: make([]*pb.APIKey, len(apiKeys)),
+	}
+	for i, k := range apiKeys {
+		rsp.ApiKeys[i] = k.Transform2GRPC()
+	}
+	return rsp, nil
+}
+
+// set scopes and/or expiry of an API key
+func (u *userServiceImpl) UpdateAPIKey(ctx context.Context, req *pb.UpdateAPIKeyRequest) (*pb.UpdateAPIKeyResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		paths = []string{"scopes", "expires_at"}
+	}
+	rsp := &pb.UpdateAPIKeyResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		apiKey, e := u.findAPIKey(ctx, tx, req.GetUserId(), req.GetId())
+		if e != nil {
+			return e
+		}
+		// revoked keys are kept for listing only
+		if apiKey.RevokedAt != nil {
+			return errorSrv.ErrAPIKeyNotFound
+		}
+		for _, path := range paths {
+			switch strings.ToLower(path) {
+			case "scopes":
+				scopes, e := normalizeAPIKeyScopes(req.GetScopes())
+				if e != nil {
+					return e
+				}
+				apiKey.SetScopes(scopes)
+			case "expires_at", "expiresat":
+				apiKey.ExpiresAt = nil
+				if req.GetExpiresAt() != nil {
+					expiresAt := req.GetExpiresAt().AsTime()
+					if !expiresAt.After(u.now()) {
+						return errorSrv.ErrInvalidAPIKeyExpiry
+					}
+					apiKey.ExpiresAt = &expiresAt
+				}
+			default:
+				return errors.BadRequest("invalid field specified", map[string]string{
+					"update_mask": fmt.Sprintf("The API key message type does not have an updatable field called %q", path),
+				})
+			}
+		}
+		if e := tx.Save(apiKey).Error; e != nil {
+			u.logger.For(ctx).Error("Error update api key", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		rsp.ApiKey = apiKey.Transform2GRPC()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// revoke an API key, revoking a revoked key is a no-op
+func (u *userServiceImpl) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		apiKey, e := u.findAPIKey(ctx, tx, req.GetUserId(), req.GetId())
+		if e != nil {
+			return e
+		}
+		if apiKey.RevokedAt != nil {
+			return nil
+		}
+		if e := tx.Model(apiKey).UpdateColumn("revoked_at", u.now()).Error; e != nil {
+			u.logger.For(ctx).Error("Error revoke api key", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.logger.For(ctx).Info("API key revoked", zap.Int64("userID", req.GetUserId()), zap.Int64("id", req.GetId()))
+	return &pb.RevokeAPIKeyResponse{}, nil
+}
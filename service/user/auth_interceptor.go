@@ -2,32 +2,137 @@ package user
 
 import (
 	"context"
+	"errors"
 	"strings"
 
 	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/fx"
 	interceptor "github.com/1412335/moneyforward-go-coding-challenge/pkg/interceptor/server"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
 	"go.uber.org/zap"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
-// Auth interceptor with JWT
+// Auth interceptor with JWT, API key or client certificate identity (mutual TLS)
 type AuthServerInterceptor struct {
 	jwtManager          *TokenService
 	authRequiredMethods map[string]bool
+	identities          []*configs.ServiceIdentity
+	// emails of admin users
+	admins map[string]bool
+	// methods rejected for users w unverified email
+	verifiedMethods map[string]bool
+	verifications   EmailVerificationStore
+	// methods & withdrawals requiring a 2FA code
+	stepUpMethods  map[string]bool
+	stepUpWithdraw float64
+	stepUp         StepUpVerifier
+	// currency of stepUpWithdraw, amounts are compared as is w/o currencies
+	stepUpCurrency string
+	currencies     AccountCurrencyStore
+	rates          fx.RateProvider
+	// personal API keys sent in x-api-key metadata
+	apiKeys APIKeyStore
 }
 
 var _ interceptor.ServerInterceptor = (*AuthServerInterceptor)(nil)
 
-func NewAuthServerInterceptor(jwtManager *TokenService, authRequiredMethods map[string]bool) interceptor.ServerInterceptor {
-	return &AuthServerInterceptor{
+// EmailVerificationStore reports whether users verified their email
+type EmailVerificationStore interface {
+	EmailVerified(ctx context.Context, userID int64) (bool, error)
+}
+
+// StepUpVerifier verifies 2FA codes sent w sensitive requests
+type StepUpVerifier interface {
+	VerifyStepUp(ctx context.Context, userID int64, code string) error
+}
+
+// AccountCurrencyStore reports currencies of accounts & schedules of users
+type AccountCurrencyStore interface {
+	AccountCurrency(ctx context.Context, userID, accountID int64) (string, error)
+	ScheduleCurrency(ctx context.Context, userID, scheduleID int64) (string, error)
+}
+
+// APIKeyStore authenticates API keys, returning claims of their user & their scopes
+type APIKeyStore interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (*Claims, []string, error)
+}
+
+type claimsKey struct{}
+
+// request context w claims of the authenticated caller
+func withClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// claims of the caller authenticated by AuthServerInterceptor, nil for methods w/o auth or service identities
+func claimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsKey{}).(*Claims)
+	return claims
+}
+
+// metadata key of 2FA code sent w sensitive requests
+const totpCodeKey = "x-totp-code"
+
+type AuthOption func(*AuthServerInterceptor)
+
+// WithVerifiedEmailRequired rejects methods for users who didn't verify their email
+func WithVerifiedEmailRequired(methods map[string]bool, store EmailVerificationStore) AuthOption {
+	return func(a *AuthServerInterceptor) {
+		a.verifiedMethods = methods
+		a.verifications = store
+	}
+}
+
+// WithStepUp requires a 2FA code for methods & withdrawals of at least withdrawAmount, 0 disables it
+func WithStepUp(methods map[string]bool, withdrawAmount float64, verifier StepUpVerifier) AuthOption {
+	return func(a *AuthServerInterceptor) {
+		a.stepUpMethods = methods
+		a.stepUpWithdraw = withdrawAmount
+		a.stepUp = verifier
+	}
+}
+
+// WithStepUpCurrency sets the currency of the step-up withdrawal amount,
+// amounts of accounts in other currencies are converted at rates
+func WithStepUpCurrency(currency string, currencies AccountCurrencyStore, rates fx.RateProvider) AuthOption {
+	return func(a *AuthServerInterceptor) {
+		a.stepUpCurrency = currency
+		a.currencies = currencies
+		a.rates = rates
+	}
+}
+
+// WithAPIKeys authenticates requests w an API key instead of a JWT, restricted to methods in its scopes
+func WithAPIKeys(store APIKeyStore) AuthOption {
+	return func(a *AuthServerInterceptor) {
+		a.apiKeys = store
+	}
+}
+
+func NewAuthServerInterceptor(jwtManager *TokenService, authRequiredMethods map[string]bool, identities []*configs.ServiceIdentity, admins []string, opts ...AuthOption) interceptor.ServerInterceptor {
+	adminSet := make(map[string]bool, len(admins))
+	for _, email := range admins {
+		adminSet[strings.ToLower(email)] = true
+	}
+	a := &AuthServerInterceptor{
 		jwtManager:          jwtManager,
 		authRequiredMethods: authRequiredMethods,
+		identities:          identities,
+		admins:              adminSet,
 	}
+	for _, o := range opts {
+		o(a)
+	}
+	return a
 }
 
 func (a *AuthServerInterceptor) Log() log.Factory {
@@ -42,69 +147,296 @@ func (a *AuthServerInterceptor) Stream() grpc.StreamServerInterceptor {
 }
 
 // check accessiable method with user role got from header authorization
-func (a *AuthServerInterceptor) authorize(ctx context.Context, method string, req interface{}) error {
+func (a *AuthServerInterceptor) authorize(ctx context.Context, method string, req interface{}) (context.Context, error) {
 	// check accessiable method with user role got from header authorization
 	authReq, ok := a.authRequiredMethods[method]
 	if !authReq || !ok {
-		return nil
+		return ctx, nil
+	}
+	// requests carry credentials (passwords, TOTP codes & secrets), only the method is logged
+	a.Log().For(ctx).Info("authorize", zap.String("method", method))
+
+	// service identity of verified client certificate authorized w/o JWT
+	if id := utils.PeerIdentity(ctx, a.identities); utils.IdentityAllows(id, method) {
+		a.Log().For(ctx).Info("authorize", zap.String("identity", id.Name))
+		return ctx, nil
 	}
-	a.Log().For(ctx).Info("authorize", zap.Any("req", req))
 
 	// fetch authorization header
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return status.Errorf(codes.DataLoss, "failed to get metadata")
+		return nil, status.Errorf(codes.DataLoss, "failed to get metadata")
 	}
-	accessToken := md.Get("authorization")
-	if len(accessToken) == 0 {
-		return status.Errorf(codes.InvalidArgument, "missing 'authorization' header")
-	}
-	if strings.Trim(accessToken[0], " ") == "" {
-		return status.Errorf(codes.InvalidArgument, "empty 'authorization' header")
-	}
-	a.Log().For(ctx).Info("authorize", zap.String("token", accessToken[0]))
-
-	// verify token
-	userClaims, err := a.jwtManager.Verify(accessToken[0])
+	userClaims, err := a.authenticate(ctx, md, method)
 	if err != nil {
-		return status.Errorf(codes.Unauthenticated, "verify token failed: %v", err)
+		return nil, err
 	}
 	ErrAccessDined := status.Errorf(codes.Unauthenticated, "access denied")
 	switch method {
-	case "/user.UserService/UpdateUser":
+	case "/user.UserService/Update":
 		if userClaims.ID != req.(*pb.UpdateUserRequest).GetUser().GetId() {
-			return ErrAccessDined
+			return nil, ErrAccessDined
 		}
-	case "/user.UserService/DeleteUser":
+	case "/user.UserService/Delete":
 		if userClaims.ID != req.(*pb.DeleteUserRequest).GetId() {
-			return ErrAccessDined
+			return nil, ErrAccessDined
 		}
 	case "/user.UserService/CreateAccount":
 		if userClaims.ID != req.(*pb.CreateAccountRequest).GetUserId() {
-			return ErrAccessDined
+			return nil, ErrAccessDined
 		}
 	case "/user.UserService/ListAccounts":
 		if userClaims.ID != req.(*pb.ListAccountsRequest).GetUserId().Value {
-			return ErrAccessDined
+			return nil, ErrAccessDined
 		}
 	case "/user.UserService/CreateTransaction":
 		if userClaims.ID != req.(*pb.CreateTransactionRequest).GetUserId() {
-			return ErrAccessDined
+			return nil, ErrAccessDined
 		}
 	case "/user.UserService/ListTransactions":
 		if userClaims.ID != req.(*pb.ListTransactionsRequest).GetUserId() {
-			return ErrAccessDined
+			return nil, ErrAccessDined
 		}
 	case "/user.UserService/UpdateTransaction":
 		if userClaims.ID != req.(*pb.UpdateTransactionRequest).GetUserId() {
-			return ErrAccessDined
+			return nil, ErrAccessDined
 		}
 	case "/user.UserService/DeleteTransaction":
 		if userClaims.ID != req.(*pb.DeleteTransactionRequest).GetUserId() {
-			return ErrAccessDined
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/ListLoginHistory":
+		if userClaims.ID != req.(*pb.ListLoginHistoryRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/ChangePassword":
+		if userClaims.ID != req.(*pb.ChangePasswordRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/ResendEmailVerification":
+		if userClaims.ID != req.(*pb.ResendEmailVerificationRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/EnrollTOTP":
+		if userClaims.ID != req.(*pb.EnrollTOTPRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/ConfirmTOTP":
+		if userClaims.ID != req.(*pb.ConfirmTOTPRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/CreateAPIKey":
+		if userClaims.ID != req.(*pb.CreateAPIKeyRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/ListAPIKeys":
+		if userClaims.ID != req.(*pb.ListAPIKeysRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/UpdateAPIKey":
+		if userClaims.ID != req.(*pb.UpdateAPIKeyRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/RevokeAPIKey":
+		if userClaims.ID != req.(*pb.RevokeAPIKeyRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/Logout":
+		if userClaims.ID != req.(*pb.LogoutRequest).GetId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/ListSessions":
+		if userClaims.ID != req.(*pb.ListSessionsRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/RevokeSession":
+		if userClaims.ID != req.(*pb.RevokeSessionRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/AuthorizeHold":
+		if userClaims.ID != req.(*pb.AuthorizeHoldRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/CaptureHold":
+		if userClaims.ID != req.(*pb.CaptureHoldRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/VoidHold":
+		if userClaims.ID != req.(*pb.VoidHoldRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/CreateSchedule":
+		if userClaims.ID != req.(*pb.CreateScheduleRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/ListSchedules":
+		if userClaims.ID != req.(*pb.ListSchedulesRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/GetSchedule":
+		if userClaims.ID != req.(*pb.GetScheduleRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/UpdateSchedule":
+		if userClaims.ID != req.(*pb.UpdateScheduleRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/DeleteSchedule":
+		if userClaims.ID != req.(*pb.DeleteScheduleRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/CreateTransfer":
+		if userClaims.ID != req.(*pb.CreateTransferRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/GetTransactionLimits":
+		if userClaims.ID != req.(*pb.GetTransactionLimitsRequest).GetUserId() {
+			return nil, ErrAccessDined
+		}
+	case "/user.UserService/UnlockUser", "/user.UserService/SetTransactionLimits", "/user.UserService/SetOverdraftLimit",
+		"/user.UserService/CreateRatePlan", "/user.UserService/SetAccountRatePlan":
+		if !a.admins[strings.ToLower(userClaims.Email)] {
+			return nil, ErrAccessDined
+		}
+	}
+	if a.verifiedMethods[method] && a.verifications != nil {
+		verified, err := a.verifications.EmailVerified(ctx, userClaims.ID)
+		if err != nil {
+			a.Log().For(ctx).Error("lookup email verification failed", zap.Int64("id", userClaims.ID), zap.Error(err))
+			return nil, err
+		}
+		if !verified {
+			return nil, errorSrv.ErrEmailNotVerified
+		}
+	}
+	if a.stepUp != nil {
+		required, err := a.stepUpRequired(ctx, method, req)
+		if err != nil {
+			a.Log().For(ctx).Error("step-up amount check failed", zap.Int64("id", userClaims.ID), zap.Error(err))
+			return nil, err
+		}
+		if required {
+			var code string
+			if v := md.Get(totpCodeKey); len(v) > 0 {
+				code = v[0]
+			}
+			if err := a.stepUp.VerifyStepUp(ctx, userClaims.ID, code); err != nil {
+				a.Log().For(ctx).Error("step-up 2fa failed", zap.Int64("id", userClaims.ID), zap.Error(err))
+				return nil, err
+			}
+		}
+	}
+	return withClaims(ctx, userClaims), nil
+}
+
+// claims of the caller from API key or authorization header
+func (a *AuthServerInterceptor) authenticate(ctx context.Context, md metadata.MD, method string) (*Claims, error) {
+	if key := md.Get(apiKeyHeader); len(key) > 0 && a.apiKeys != nil {
+		claims, scopes, err := a.apiKeys.AuthenticateAPIKey(ctx, strings.TrimSpace(key[0]))
+		if err != nil {
+			a.Log().For(ctx).Error("authenticate api key failed", zap.Error(err))
+			return nil, err
+		}
+		if !apiKeyAllows(scopes, method) {
+			a.Log().For(ctx).Error("method out of api key scopes", zap.Int64("id", claims.ID), zap.Strings("scopes", scopes))
+			return nil, errorSrv.ErrAPIKeyScope
+		}
+		a.Log().For(ctx).Info("authorize", zap.Int64("apiKeyUser", claims.ID))
+		return claims, nil
+	}
+
+	accessToken := md.Get("authorization")
+	if len(accessToken) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "missing 'authorization' header")
+	}
+	if strings.Trim(accessToken[0], " ") == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "empty 'authorization' header")
+	}
+
+	// verify token, "Bearer " scheme is optional
+	claims, err := a.jwtManager.Verify(ctx, strings.TrimPrefix(accessToken[0], "Bearer "))
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "verify token failed: %v", err)
+	}
+	return claims, nil
+}
+
+// sensitive requests requiring a 2FA code
+func (a *AuthServerInterceptor) stepUpRequired(ctx context.Context, method string, req interface{}) (bool, error) {
+	if a.stepUpMethods[method] {
+		return true, nil
+	}
+	if a.stepUpWithdraw <= 0 {
+		return false, nil
+	}
+	account := func(userID, accountID int64) func() (string, error) {
+		return func() (string, error) { return a.currencies.AccountCurrency(ctx, userID, accountID) }
+	}
+	// holds reserve funds of withdrawals, transfers withdraw from the source, schedules post withdrawals later
+	switch r := req.(type) {
+	case *pb.CreateTransactionRequest:
+		if r.GetTransactionType() != pb.TransactionType_WITHDRAW {
+			return false, nil
+		}
+		return a.reachesStepUp(ctx, r.GetAmount(), account(r.GetUserId(), r.GetAccountId()))
+	case *pb.AuthorizeHoldRequest:
+		return a.reachesStepUp(ctx, r.GetAmount(), account(r.GetUserId(), r.GetAccountId()))
+	case *pb.CreateTransferRequest:
+		return a.reachesStepUp(ctx, r.GetAmount(), account(r.GetUserId(), r.GetFromAccountId()))
+	case *pb.UpdateTransactionRequest:
+		// type of the updated transaction isn't known here, raised amounts of any type step up
+		if !updatesAmount(r.GetUpdateMask()) {
+			return false, nil
+		}
+		return a.reachesStepUp(ctx, r.GetTransaction().GetAmount(), account(r.GetUserId(), r.GetAccountId()))
+	case *pb.CreateScheduleRequest:
+		if r.GetTransactionType() != pb.TransactionType_WITHDRAW {
+			return false, nil
+		}
+		return a.reachesStepUp(ctx, r.GetAmount(), account(r.GetUserId(), r.GetAccountId()))
+	case *pb.UpdateScheduleRequest:
+		// likewise type of the updated schedule isn't known here
+		if !updatesAmount(r.GetUpdateMask()) {
+			return false, nil
+		}
+		return a.reachesStepUp(ctx, r.GetSchedule().GetAmount(), func() (string, error) {
+			return a.currencies.ScheduleCurrency(ctx, r.GetUserId(), r.GetSchedule().GetId())
+		})
+	}
+	return false, nil
+}
+
+// amount reaches the step-up withdrawal amount, once converted from the currency of its account
+func (a *AuthServerInterceptor) reachesStepUp(ctx context.Context, amount float64, currency func() (string, error)) (bool, error) {
+	if a.currencies == nil || amount < 0 {
+		return amount >= a.stepUpWithdraw, nil
+	}
+	from, err := currency()
+	// nothing is withdrawn, the service rejects the request
+	if errors.Is(err, errorSrv.ErrAccountNotFound) || errors.Is(err, errorSrv.ErrScheduleNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	converted, err := convertAmount(ctx, a.rates, amount, from, a.stepUpCurrency)
+	if err != nil {
+		a.Log().For(ctx).Error("Error convert step-up amount", zap.String("from", from), zap.String("to", a.stepUpCurrency), zap.Error(err))
+		return false, errorSrv.ErrExchangeRateNotFound
+	}
+	return converted.Float64() >= a.stepUpWithdraw, nil
+}
+
+// amount is updated w/o a mask or w amount in it
+func updatesAmount(mask *fieldmaskpb.FieldMask) bool {
+	if len(mask.GetPaths()) == 0 {
+		return true
+	}
+	for _, path := range mask.GetPaths() {
+		if path == "amount" {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
 // unary request to grpc server
@@ -118,7 +450,7 @@ func (a *AuthServerInterceptor) UnaryInterceptor(ctx context.Context, req interf
 	a.Log().For(ctx).Info("unary req", zap.String("method", info.FullMethod))
 
 	// authorize request
-	err = a.authorize(ctx, info.FullMethod, req)
+	ctx, err = a.authorize(ctx, info.FullMethod, req)
 	if err != nil {
 		return nil, err
 	}
@@ -146,7 +478,7 @@ func (a *AuthServerInterceptor) StreamInterceptor(srv interface{}, ss grpc.Serve
 	a.Log().For(ss.Context()).Info("stream req", zap.String("method", info.FullMethod), zap.Any("serverStream", info.IsServerStream))
 
 	// authorize request
-	err = a.authorize(ss.Context(), info.FullMethod, nil)
+	_, err = a.authorize(ss.Context(), info.FullMethod, nil)
 	if err != nil {
 		return err
 	}
@@ -2,30 +2,137 @@ package client
 
 import (
 	"context"
+	stderrors "errors"
+	"io"
+	"sync"
 	"time"
 
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
 	grpcClient "github.com/1412335/moneyforward-go-coding-challenge/pkg/client"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
 	interceptor "github.com/1412335/moneyforward-go-coding-challenge/pkg/interceptor/client"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/dgrijalva/jwt-go"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// token is refreshed when it expires within this window
+const DefaultRefreshBefore = 1 * time.Minute
+
+// ErrTwoFactorRequired is returned by Login for users w 2FA enabled, login completes w LoginTOTP
+var ErrTwoFactorRequired = stderrors.New("2FA code required, call LoginTOTP")
+
+// metadata keys of 2FA code sent w sensitive requests & of API keys
+const (
+	totpCodeKey  = "x-totp-code"
+	apiKeyHeader = "x-api-key"
+)
+
+// WithTOTPCode attaches a 2FA code to sensitive requests made w ctx, such as Delete or large withdrawals
+func WithTOTPCode(ctx context.Context, code string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, totpCodeKey, code)
+}
+
+// Client is a typed SDK of UserService.
+// Login & Create store the issued token which is then attached to every request
+// & refreshed transparently before it expires, users w 2FA complete Login w LoginTOTP.
+// Machine-to-machine callers authenticate w SetAPIKey instead.
+// Errors returned by the service are decoded into *errors.Error.
 type Client interface {
-	Login(email, password string) (string, error)
+	// auth
+	Login(ctx context.Context, email, password string) (*user.User, error)
+	Logout(ctx context.Context) error
+	LogoutAll(ctx context.Context) error
+	Validate(ctx context.Context, token string) (*user.User, error)
+	RefreshToken(ctx context.Context) error
+	Token() string
+	SetToken(token string)
+	ListLoginHistory(ctx context.Context, userID int64, limit int32) ([]*user.LoginEvent, error)
+	ChangePassword(ctx context.Context, currentPassword, newPassword string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ConfirmPasswordReset(ctx context.Context, token, newPassword string) error
+	VerifyEmail(ctx context.Context, token string) (*user.User, error)
+	ResendEmailVerification(ctx context.Context) error
+	LoginTOTP(ctx context.Context, code string) (*user.User, error)
+	EnrollTOTP(ctx context.Context) (secret, uri string, err error)
+	ConfirmTOTP(ctx context.Context, code string) (recoveryCodes []string, err error)
+	SetAPIKey(key string)
+	CreateAPIKey(ctx context.Context, name string, scopes []string, expiresAt time.Time) (apiKey *user.APIKey, key string, err error)
+	ListAPIKeys(ctx context.Context) ([]*user.APIKey, error)
+	UpdateAPIKey(ctx context.Context, id int64, scopes []string, expiresAt time.Time, paths ...string) (*user.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) error
+	ListSessions(ctx context.Context) ([]*user.Session, error)
+	RevokeSession(ctx context.Context, id int64) error
+	// admin
+	UnlockUser(ctx context.Context, email, ip string) error
+	SetTransactionLimits(ctx context.Context, req *user.SetTransactionLimitsRequest) (*user.SetTransactionLimitsResponse, error)
+	SetOverdraftLimit(ctx context.Context, req *user.SetOverdraftLimitRequest) (*user.Account, error)
+	CreateRatePlan(ctx context.Context, req *user.CreateRatePlanRequest) (*user.RatePlan, error)
+	SetAccountRatePlan(ctx context.Context, req *user.SetAccountRatePlanRequest) (*user.Account, error)
+
+	// users
+	Create(ctx context.Context, email, password string) (*user.User, error)
+	Update(ctx context.Context, u *user.User, paths ...string) (*user.User, error)
+	Delete(ctx context.Context, id int64) error
+	List(ctx context.Context, req *user.ListUsersRequest) ([]*user.User, error)
+	ListStream(ctx context.Context, req *user.ListUsersRequest) (*UserIterator, error)
+
+	// accounts
+	CreateAccount(ctx context.Context, req *user.CreateAccountRequest) (*user.Account, error)
+	ListAccounts(ctx context.Context, req *user.ListAccountsRequest) ([]*user.Account, error)
+	GetTransactionLimits(ctx context.Context, req *user.GetTransactionLimitsRequest) (*user.GetTransactionLimitsResponse, error)
+	ListRatePlans(ctx context.Context) ([]*user.RatePlan, error)
+
+	// transactions
+	CreateTransaction(ctx context.Context, req *user.CreateTransactionRequest) (*user.Transaction, error)
+	ListTransactions(ctx context.Context, req *user.ListTransactionsRequest) ([]*user.ListTransactionsResponse_Result, error)
+	UpdateTransaction(ctx context.Context, req *user.UpdateTransactionRequest) (*user.Transaction, error)
+	DeleteTransaction(ctx context.Context, req *user.DeleteTransactionRequest) ([]int64, error)
+	CreateTransfer(ctx context.Context, req *user.CreateTransferRequest) (withdrawal, deposit *user.Transaction, err error)
+
+	// holds
+	AuthorizeHold(ctx context.Context, req *user.AuthorizeHoldRequest) (*user.Transaction, error)
+	CaptureHold(ctx context.Context, req *user.CaptureHoldRequest) (*user.Transaction, error)
+	VoidHold(ctx context.Context, req *user.VoidHoldRequest) (*user.Transaction, error)
+
+	// scheduled transactions
+	CreateSchedule(ctx context.Context, req *user.CreateScheduleRequest) (*user.Schedule, error)
+	ListSchedules(ctx context.Context, req *user.ListSchedulesRequest) ([]*user.Schedule, error)
+	GetSchedule(ctx context.Context, req *user.GetScheduleRequest) (*user.Schedule, error)
+	UpdateSchedule(ctx context.Context, req *user.UpdateScheduleRequest) (*user.Schedule, error)
+	DeleteSchedule(ctx context.Context, req *user.DeleteScheduleRequest) error
+
 	Close() error
 }
 
+// claims read from the stored token, signature is verified by the server
+type tokenClaims struct {
+	jwt.StandardClaims
+	ID int64 `json:"id"`
+}
+
 type clientImpl struct {
-	ctx           context.Context
 	logger        log.Factory
 	client        *grpcClient.Client
 	userSrvClient user.UserServiceClient
+
+	// stored token
+	mu            sync.Mutex
+	token         string
+	claims        *tokenClaims
+	refreshBefore time.Duration
+	// challenge of pending 2FA login
+	challenge string
+	// API key sent instead of token
+	apiKey string
 }
 
+var _ Client = (*clientImpl)(nil)
+
 func New(cfgs *configs.ClientConfig, opt ...grpcClient.Option) (Client, error) {
 	opt = append(opt,
 		grpcClient.WithInterceptors(interceptor.NewSimpleClientInterceptor()),
@@ -35,12 +142,11 @@ func New(cfgs *configs.ClientConfig, opt ...grpcClient.Option) (Client, error) {
 		return nil, err
 	}
 
-	ctx := context.Background()
 	return &clientImpl{
-		ctx:           ctx,
 		logger:        client.GetLogger(),
 		client:        client,
 		userSrvClient: user.NewUserServiceClient(client.ClientConn),
+		refreshBefore: DefaultRefreshBefore,
 	}, nil
 }
 
@@ -48,35 +154,768 @@ func (c *clientImpl) Close() error {
 	return c.client.Close()
 }
 
-func (c *clientImpl) setHeader(m map[string]string) context.Context {
-	md := metadata.New(m)
-	ctx := metadata.NewOutgoingContext(c.ctx, md)
-	return ctx
+func (c *clientImpl) setHeader(ctx context.Context, m map[string]string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, flatten(m)...)
+}
+
+func flatten(m map[string]string) []string {
+	kv := make([]string, 0, 2*len(m))
+	for k, v := range m {
+		kv = append(kv, k, v)
+	}
+	return kv
+}
+
+// Token returns the stored token
+func (c *clientImpl) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// SetToken stores token attached to next requests, empty token clears it
+func (c *clientImpl) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setToken(token)
+}
+
+func (c *clientImpl) setToken(token string) {
+	c.token = token
+	c.claims = nil
+	if token == "" {
+		return
+	}
+	claims := &tokenClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		c.logger.Bg().Error("parse token failed", zap.Error(err))
+		return
+	}
+	c.claims = claims
+}
+
+// id of logged in user read from the stored token
+func (c *clientImpl) userID() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.claims == nil {
+		return 0
+	}
+	return c.claims.ID
+}
+
+// SetAPIKey authenticates next requests w an API key instead of the stored token, empty key clears it
+func (c *clientImpl) SetAPIKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiKey = key
+}
+
+// auth attaches the API key or the stored token to ctx, refreshing it first if it is about to expire
+func (c *clientImpl) auth(ctx context.Context) (context.Context, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.apiKey != "" {
+		return c.setHeader(ctx, map[string]string{apiKeyHeader: c.apiKey}), nil
+	}
+	if c.token == "" {
+		return ctx, nil
+	}
+	if c.claims != nil && c.claims.ExpiresAt > 0 &&
+		time.Until(time.Unix(c.claims.ExpiresAt, 0)) < c.refreshBefore {
+		if err := c.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c.setHeader(ctx, map[string]string{"authorization": "Bearer " + c.token}), nil
+}
+
+// refresh stored token, caller holds the lock
+func (c *clientImpl) refresh(ctx context.Context) error {
+	reply, err := c.userSrvClient.RefreshToken(ctx, &user.RefreshTokenRequest{
+		Token: c.token,
+	})
+	if err != nil {
+		c.logger.For(ctx).Error("refresh token failed", zap.Error(err))
+		return errors.Decode(err)
+	}
+	c.setToken(reply.GetToken())
+	return nil
+}
+
+// RefreshToken exchanges the stored token for a new one
+func (c *clientImpl) RefreshToken(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refresh(ctx)
 }
 
-// login & get token
-func (c *clientImpl) Login(email, password string) (string, error) {
-	ctx := c.setHeader(map[string]string{"custom-req-header": "login"})
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+// login & store token
+func (c *clientImpl) Login(ctx context.Context, email, password string) (*user.User, error) {
+	ctx = c.setHeader(ctx, map[string]string{"custom-req-header": "login"})
 	// prepare request
 	msg := &user.LoginRequest{
 		Email:    email,
 		Password: password,
 	}
-	// fetch response headers
-	var header metadata.MD
 	// call service
-	reply, err := c.userSrvClient.Login(ctx, msg, grpc.Header(&header))
+	reply, err := c.userSrvClient.Login(ctx, msg)
 	if err != nil {
 		c.logger.For(ctx).Error("login failed", zap.Error(err))
-		return "", err
+		return nil, errors.Decode(err)
+	}
+	if reply.GetTwoFactorRequired() {
+		c.mu.Lock()
+		c.challenge = reply.GetChallengeToken()
+		c.mu.Unlock()
+		return reply.GetUser(), ErrTwoFactorRequired
+	}
+	c.SetToken(reply.GetToken())
+	return reply.GetUser(), nil
+}
+
+// complete login requiring 2FA w a TOTP or recovery code & store token
+func (c *clientImpl) LoginTOTP(ctx context.Context, code string) (*user.User, error) {
+	c.mu.Lock()
+	challenge := c.challenge
+	c.mu.Unlock()
+	reply, err := c.userSrvClient.LoginTOTP(ctx, &user.LoginTOTPRequest{
+		ChallengeToken: challenge,
+		Code:           code,
+	})
+	if err != nil {
+		c.logger.For(ctx).Error("login totp failed", zap.Error(err))
+		return nil, errors.Decode(err)
+	}
+	c.mu.Lock()
+	c.challenge = ""
+	c.setToken(reply.GetToken())
+	c.mu.Unlock()
+	return reply.GetUser(), nil
+}
+
+// logout, revoking session of stored token, & clear it
+func (c *clientImpl) Logout(ctx context.Context) error {
+	return c.logout(ctx, false)
+}
+
+// logout everywhere, revoking all sessions of the user, & clear stored token
+func (c *clientImpl) LogoutAll(ctx context.Context) error {
+	return c.logout(ctx, true)
+}
+
+func (c *clientImpl) logout(ctx context.Context, all bool) error {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := c.userSrvClient.Logout(ctx, &user.LogoutRequest{Id: id, All: all}); err != nil {
+		c.logger.For(ctx).Error("logout failed", zap.Error(err))
+		return errors.Decode(err)
+	}
+	c.SetToken("")
+	return nil
+}
+
+// validate token & get its user
+func (c *clientImpl) Validate(ctx context.Context, token string) (*user.User, error) {
+	reply, err := c.userSrvClient.Validate(ctx, &user.ValidateRequest{Token: token})
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetUser(), nil
+}
+
+// create user & store its token
+func (c *clientImpl) Create(ctx context.Context, email, password string) (*user.User, error) {
+	reply, err := c.userSrvClient.Create(ctx, &user.CreateUserRequest{
+		Email:    email,
+		Password: password,
+	})
+	if err != nil {
+		c.logger.For(ctx).Error("create user failed", zap.Error(err))
+		return nil, errors.Decode(err)
+	}
+	c.SetToken(reply.GetToken())
+	return reply.GetUser(), nil
+}
+
+// update user fields given by paths, all fields if no path is given
+func (c *clientImpl) Update(ctx context.Context, u *user.User, paths ...string) (*user.User, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := &user.UpdateUserRequest{
+		User: u,
+	}
+	if len(paths) > 0 {
+		req.UpdateMask = &fieldmaskpb.FieldMask{Paths: paths}
+	}
+	reply, err := c.userSrvClient.Update(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetUser(), nil
+}
+
+func (c *clientImpl) Delete(ctx context.Context, id int64) error {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := c.userSrvClient.Delete(ctx, &user.DeleteUserRequest{Id: id}); err != nil {
+		return errors.Decode(err)
+	}
+	return nil
+}
+
+func (c *clientImpl) List(ctx context.Context, req *user.ListUsersRequest) ([]*user.User, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.List(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetUsers(), nil
+}
+
+// list users w server streaming, the stream is closed when ctx is done
+func (c *clientImpl) ListStream(ctx context.Context, req *user.ListUsersRequest) (*UserIterator, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.userSrvClient.ListStream(ctx, req)
+	if err != nil {
+		cancel()
+		return nil, errors.Decode(err)
+	}
+	return &UserIterator{
+		stream: stream,
+		cancel: cancel,
+	}, nil
+}
+
+func (c *clientImpl) CreateAccount(ctx context.Context, req *user.CreateAccountRequest) (*user.Account, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.CreateAccount(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetAccount(), nil
+}
+
+func (c *clientImpl) ListAccounts(ctx context.Context, req *user.ListAccountsRequest) ([]*user.Account, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.ListAccounts(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetAccounts(), nil
+}
+
+// limits of an account & their usage today & this month
+func (c *clientImpl) GetTransactionLimits(ctx context.Context, req *user.GetTransactionLimitsRequest) (*user.GetTransactionLimitsResponse, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.GetTransactionLimits(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply, nil
+}
+
+func (c *clientImpl) CreateTransaction(ctx context.Context, req *user.CreateTransactionRequest) (*user.Transaction, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.CreateTransaction(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetTransaction(), nil
+}
+
+func (c *clientImpl) ListTransactions(ctx context.Context, req *user.ListTransactionsRequest) ([]*user.ListTransactionsResponse_Result, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.ListTransactions(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetTransactions(), nil
+}
+
+func (c *clientImpl) UpdateTransaction(ctx context.Context, req *user.UpdateTransactionRequest) (*user.Transaction, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.UpdateTransaction(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetTransaction(), nil
+}
+
+// delete transactions & return deleted ids
+func (c *clientImpl) DeleteTransaction(ctx context.Context, req *user.DeleteTransactionRequest) ([]int64, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.DeleteTransaction(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetIds(), nil
+}
+
+// transfer between accounts converting currencies, returns both legs
+func (c *clientImpl) CreateTransfer(ctx context.Context, req *user.CreateTransferRequest) (*user.Transaction, *user.Transaction, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	reply, err := c.userSrvClient.CreateTransfer(ctx, req)
+	if err != nil {
+		return nil, nil, errors.Decode(err)
+	}
+	return reply.GetWithdrawal(), reply.GetDeposit(), nil
+}
+
+// reserve funds of a withdrawal, returns the pending hold
+func (c *clientImpl) AuthorizeHold(ctx context.Context, req *user.AuthorizeHoldRequest) (*user.Transaction, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.AuthorizeHold(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetTransaction(), nil
+}
+
+// post a pending hold, in full if amount is 0
+func (c *clientImpl) CaptureHold(ctx context.Context, req *user.CaptureHoldRequest) (*user.Transaction, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.CaptureHold(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetTransaction(), nil
+}
+
+// release a pending hold
+func (c *clientImpl) VoidHold(ctx context.Context, req *user.VoidHoldRequest) (*user.Transaction, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
 	}
-	// get response headers
-	token := header.Get("token")
-	if len(token) > 0 {
-		c.logger.For(ctx).Info("response headers", zap.Strings("token", token))
+	reply, err := c.userSrvClient.VoidHold(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetTransaction(), nil
+}
+
+// schedule a transaction on an account, posted at times of a cron expression
+func (c *clientImpl) CreateSchedule(ctx context.Context, req *user.CreateScheduleRequest) (*user.Schedule, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.CreateSchedule(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetSchedule(), nil
+}
+
+// list schedules of user, or of an account
+func (c *clientImpl) ListSchedules(ctx context.Context, req *user.ListSchedulesRequest) ([]*user.Schedule, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.ListSchedules(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetSchedules(), nil
+}
+
+// get schedule w its next run & last result
+func (c *clientImpl) GetSchedule(ctx context.Context, req *user.GetScheduleRequest) (*user.Schedule, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.GetSchedule(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetSchedule(), nil
+}
+
+// update fields of schedule in update mask, all if unset
+func (c *clientImpl) UpdateSchedule(ctx context.Context, req *user.UpdateScheduleRequest) (*user.Schedule, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.UpdateSchedule(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetSchedule(), nil
+}
+
+// delete schedule, posted transactions are kept
+func (c *clientImpl) DeleteSchedule(ctx context.Context, req *user.DeleteScheduleRequest) error {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := c.userSrvClient.DeleteSchedule(ctx, req); err != nil {
+		return errors.Decode(err)
+	}
+	return nil
+}
+
+// create a rate plan of interest & monthly fee, admin only
+func (c *clientImpl) CreateRatePlan(ctx context.Context, req *user.CreateRatePlanRequest) (*user.RatePlan, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.CreateRatePlan(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetRatePlan(), nil
+}
+
+// list rate plans available to accounts
+func (c *clientImpl) ListRatePlans(ctx context.Context) ([]*user.RatePlan, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.ListRatePlans(ctx, &user.ListRatePlansRequest{})
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetRatePlans(), nil
+}
+
+// attach a rate plan to an account, 0 detaches it, admin only
+func (c *clientImpl) SetAccountRatePlan(ctx context.Context, req *user.SetAccountRatePlanRequest) (*user.Account, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.SetAccountRatePlan(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetAccount(), nil
+}
+
+// list recent login attempts of user
+func (c *clientImpl) ListLoginHistory(ctx context.Context, userID int64, limit int32) ([]*user.LoginEvent, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.ListLoginHistory(ctx, &user.ListLoginHistoryRequest{UserId: userID, Limit: limit})
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetEvents(), nil
+}
+
+// change password of logged in user & store the token replacing revoked ones
+func (c *clientImpl) ChangePassword(ctx context.Context, currentPassword, newPassword string) error {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return err
+	}
+	reply, err := c.userSrvClient.ChangePassword(ctx, &user.ChangePasswordRequest{
+		UserId:          id,
+		CurrentPassword: currentPassword,
+		NewPassword:     newPassword,
+	})
+	if err != nil {
+		c.logger.For(ctx).Error("change password failed", zap.Error(err))
+		return errors.Decode(err)
+	}
+	c.SetToken(reply.GetToken())
+	return nil
+}
+
+// send a password reset token to email
+func (c *clientImpl) RequestPasswordReset(ctx context.Context, email string) error {
+	if _, err := c.userSrvClient.RequestPasswordReset(ctx, &user.RequestPasswordResetRequest{Email: email}); err != nil {
+		return errors.Decode(err)
+	}
+	return nil
+}
+
+// set a new password w a reset token
+func (c *clientImpl) ConfirmPasswordReset(ctx context.Context, token, newPassword string) error {
+	if _, err := c.userSrvClient.ConfirmPasswordReset(ctx, &user.ConfirmPasswordResetRequest{Token: token, NewPassword: newPassword}); err != nil {
+		return errors.Decode(err)
+	}
+	return nil
+}
+
+// verify email w token sent on signup or email change
+func (c *clientImpl) VerifyEmail(ctx context.Context, token string) (*user.User, error) {
+	reply, err := c.userSrvClient.VerifyEmail(ctx, &user.VerifyEmailRequest{Token: token})
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetUser(), nil
+}
+
+// send a new verification token to email of logged in user
+func (c *clientImpl) ResendEmailVerification(ctx context.Context) error {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := c.userSrvClient.ResendEmailVerification(ctx, &user.ResendEmailVerificationRequest{UserId: id}); err != nil {
+		return errors.Decode(err)
+	}
+	return nil
+}
+
+// start 2FA enrollment of logged in user, secret is added to an authenticator app w uri
+func (c *clientImpl) EnrollTOTP(ctx context.Context) (string, string, error) {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	reply, err := c.userSrvClient.EnrollTOTP(ctx, &user.EnrollTOTPRequest{UserId: id})
+	if err != nil {
+		return "", "", errors.Decode(err)
+	}
+	return reply.GetSecret(), reply.GetUri(), nil
+}
+
+// enable 2FA of logged in user w a code of the enrolled secret, recovery codes are returned once
+func (c *clientImpl) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.ConfirmTOTP(ctx, &user.ConfirmTOTPRequest{UserId: id, Code: code})
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetRecoveryCodes(), nil
+}
+
+// create API key of logged in user, never expiring if expiresAt is zero. The key is returned once
+func (c *clientImpl) CreateAPIKey(ctx context.Context, name string, scopes []string, expiresAt time.Time) (*user.APIKey, string, error) {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	req := &user.CreateAPIKeyRequest{UserId: id, Name: name, Scopes: scopes}
+	if !expiresAt.IsZero() {
+		req.ExpiresAt = timestamppb.New(expiresAt)
+	}
+	reply, err := c.userSrvClient.CreateAPIKey(ctx, req)
+	if err != nil {
+		return nil, "", errors.Decode(err)
+	}
+	return reply.GetApiKey(), reply.GetKey(), nil
+}
+
+// list API keys of logged in user
+func (c *clientImpl) ListAPIKeys(ctx context.Context) ([]*user.APIKey, error) {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.ListAPIKeys(ctx, &user.ListAPIKeysRequest{UserId: id})
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetApiKeys(), nil
+}
+
+// update scopes and/or expiry of API key given by paths, both if no path is given. Zero expiresAt clears expiry
+func (c *clientImpl) UpdateAPIKey(ctx context.Context, keyID int64, scopes []string, expiresAt time.Time, paths ...string) (*user.APIKey, error) {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := &user.UpdateAPIKeyRequest{UserId: id, Id: keyID, Scopes: scopes}
+	if !expiresAt.IsZero() {
+		req.ExpiresAt = timestamppb.New(expiresAt)
+	}
+	if len(paths) > 0 {
+		req.UpdateMask = &fieldmaskpb.FieldMask{Paths: paths}
+	}
+	reply, err := c.userSrvClient.UpdateAPIKey(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetApiKey(), nil
+}
+
+// revoke API key of logged in user
+func (c *clientImpl) RevokeAPIKey(ctx context.Context, keyID int64) error {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := c.userSrvClient.RevokeAPIKey(ctx, &user.RevokeAPIKeyRequest{UserId: id, Id: keyID}); err != nil {
+		return errors.Decode(err)
+	}
+	return nil
+}
+
+// active sessions of the user, last seen first
+func (c *clientImpl) ListSessions(ctx context.Context) ([]*user.Session, error) {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.ListSessions(ctx, &user.ListSessionsRequest{UserId: id})
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetSessions(), nil
+}
+
+// revoke a session of the user, its tokens are rejected from now on
+func (c *clientImpl) RevokeSession(ctx context.Context, sessionID int64) error {
+	id := c.userID()
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := c.userSrvClient.RevokeSession(ctx, &user.RevokeSessionRequest{UserId: id, Id: sessionID}); err != nil {
+		return errors.Decode(err)
+	}
+	return nil
+}
+
+// unlock email and/or IP locked out by failed logins
+func (c *clientImpl) UnlockUser(ctx context.Context, email, ip string) error {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := c.userSrvClient.UnlockUser(ctx, &user.UnlockUserRequest{Email: email, Ip: ip}); err != nil {
+		return errors.Decode(err)
+	}
+	return nil
+}
+
+// set tier of a user and/or limits of one of its accounts
+func (c *clientImpl) SetTransactionLimits(ctx context.Context, req *user.SetTransactionLimitsRequest) (*user.SetTransactionLimitsResponse, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.SetTransactionLimits(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply, nil
+}
+
+// set overdraft limit of an account
+func (c *clientImpl) SetOverdraftLimit(ctx context.Context, req *user.SetOverdraftLimitRequest) (*user.Account, error) {
+	ctx, err := c.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.userSrvClient.SetOverdraftLimit(ctx, req)
+	if err != nil {
+		return nil, errors.Decode(err)
+	}
+	return reply.GetAccount(), nil
+}
+
+// UserIterator iterates over users received from ListStream
+//
+//	for it.Next() {
+//		u := it.User()
+//	}
+//	if err := it.Err(); err != nil {...}
+type UserIterator struct {
+	stream user.UserService_ListStreamClient
+	cancel context.CancelFunc
+	user   *user.User
+	err    error
+}
+
+// Next receives next user, false when the stream ends or fails
+func (it *UserIterator) Next() bool {
+	if it.err != nil || it.stream == nil {
+		return false
+	}
+	u, err := it.stream.Recv()
+	if err != nil {
+		if err != io.EOF {
+			it.err = errors.Decode(err)
+		}
+		it.Close()
+		return false
+	}
+	it.user = u
+	return true
+}
+
+// User returns current user
+func (it *UserIterator) User() *user.User {
+	return it.user
+}
+
+// Err returns error stopping the iteration, nil at the end of the stream
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+// Close stops receiving, safe to call multiple times
+func (it *UserIterator) Close() {
+	if it.stream != nil {
+		it.cancel()
+		it.stream = nil
 	}
-	// return
-	return reply.GetToken(), nil
 }
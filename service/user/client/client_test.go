@@ -0,0 +1,817 @@
+package client
+
+import (
+	"context"
+	stderrors "errors"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	grpcClient "github.com/1412335/moneyforward-go-coding-challenge/pkg/client"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/fx"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	userSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// in-process user service served over bufconn
+func newTestClient(t *testing.T, opts ...userSrv.ServiceOption) *clientImpl {
+	return newTestClientVerified(t, nil, opts...)
+}
+
+// in-process user service rejecting verifiedMethods for users w unverified email
+func newTestClientVerified(t *testing.T, verifiedMethods map[string]bool, opts ...userSrv.ServiceOption) *clientImpl {
+	return newTestClientAuth(t, func(dal *postgres.DataAccessLayer) []userSrv.AuthOption {
+		return []userSrv.AuthOption{userSrv.WithVerifiedEmailRequired(verifiedMethods, userSrv.NewEmailVerificationStore(dal))}
+	}, opts...)
+}
+
+// in-process user service w auth options built from its db
+func newTestClientAuth(t *testing.T, authOpts func(*postgres.DataAccessLayer) []userSrv.AuthOption, opts ...userSrv.ServiceOption) *clientImpl {
+	ctx := context.Background()
+	dal, err := postgres.NewDataAccessLayer(ctx, &configs.Database{
+		// in-memory sqlite db per test
+		Driver:         configs.DriverSQLite,
+		Path:           "file:" + t.Name() + "?mode=memory&cache=shared",
+		MaxIdleConns:   10,
+		MaxOpenConns:   100,
+		ConnectTimeout: 1 * time.Hour,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dal.GetDatabase().AutoMigrate(
+		&model.User{},
+		&model.Account{},
+		&model.Transaction{},
+		&model.LoginEvent{},
+		&model.PasswordReset{},
+		&model.EmailVerification{},
+		&model.RecoveryCode{},
+		&model.APIKey{},
+		&model.Session{},
+		&model.PasswordHistory{},
+		&model.AccountLimit{},
+		&model.Schedule{},
+		&model.RatePlan{},
+	))
+	t.Cleanup(func() { dal.Disconnect() })
+
+	tokenSrv := userSrv.NewTokenService(&configs.JWT{
+		SecretKey: "lu",
+		Duration:  10 * time.Minute,
+		Issuer:    "lu",
+	}, userSrv.WithTokenVersions(userSrv.NewTokenVersionStore(dal)), userSrv.WithSessions(userSrv.NewSessionStore(dal)))
+	auth := userSrv.NewAuthServerInterceptor(tokenSrv, map[string]bool{
+		"/user.UserService/List":                    true,
+		"/user.UserService/ListStream":              true,
+		"/user.UserService/CreateAccount":           true,
+		"/user.UserService/ListAccounts":            true,
+		"/user.UserService/CreateTransaction":       true,
+		"/user.UserService/ListTransactions":        true,
+		"/user.UserService/UpdateTransaction":       true,
+		"/user.UserService/DeleteTransaction":       true,
+		"/user.UserService/UnlockUser":              true,
+		"/user.UserService/ListLoginHistory":        true,
+		"/user.UserService/ChangePassword":          true,
+		"/user.UserService/ResendEmailVerification": true,
+		"/user.UserService/Delete":                  true,
+		"/user.UserService/EnrollTOTP":              true,
+		"/user.UserService/ConfirmTOTP":             true,
+		"/user.UserService/CreateAPIKey":            true,
+		"/user.UserService/ListAPIKeys":             true,
+		"/user.UserService/UpdateAPIKey":            true,
+		"/user.UserService/RevokeAPIKey":            true,
+		"/user.UserService/Logout":                  true,
+		"/user.UserService/ListSessions":            true,
+		"/user.UserService/RevokeSession":           true,
+		"/user.UserService/GetTransactionLimits":    true,
+		"/user.UserService/SetTransactionLimits":    true,
+		"/user.UserService/SetOverdraftLimit":       true,
+		"/user.UserService/AuthorizeHold":           true,
+		"/user.UserService/CaptureHold":             true,
+		"/user.UserService/VoidHold":                true,
+		"/user.UserService/CreateSchedule":          true,
+		"/user.UserService/ListSchedules":           true,
+		"/user.UserService/GetSchedule":             true,
+		"/user.UserService/UpdateSchedule":          true,
+		"/user.UserService/DeleteSchedule":          true,
+		"/user.UserService/CreateRatePlan":          true,
+		"/user.UserService/ListRatePlans":           true,
+		"/user.UserService/SetAccountRatePlan":      true,
+		"/user.UserService/CreateTransfer":          true,
+	}, nil, []string{"admin@gmail.com"}, append(authOpts(dal), userSrv.WithAPIKeys(userSrv.NewAPIKeyStore(dal)))...)
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(auth.Unary()),
+		grpc.ChainStreamInterceptor(auth.Stream()),
+	)
+	pb.RegisterUserServiceServer(srv, userSrv.NewUserService(dal, tokenSrv, opts...))
+
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	c, err := New(
+		&configs.ClientConfig{
+			ServiceName: "user-client-test",
+			GRPC:        &configs.GRPC{Host: "bufnet"},
+		},
+		grpcClient.WithDialOptions(grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		})),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c.(*clientImpl)
+}
+
+func TestClient(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	// create user stores token
+	u, err := c.Create(ctx, "sdk@gmail.com", "stringstring")
+	require.NoError(t, err)
+	require.NotEmpty(t, c.Token())
+
+	// requests w/o token are rejected
+	token := c.Token()
+	c.SetToken("")
+	_, err = c.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(u.GetId())})
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// login stores token
+	_, err = c.Login(ctx, "sdk@gmail.com", "stringstring")
+	require.NoError(t, err)
+	require.NotEmpty(t, c.Token())
+	got, err := c.Validate(ctx, token)
+	require.NoError(t, err)
+	require.Equal(t, u.GetId(), got.GetId())
+
+	// accounts
+	acc, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{
+		UserId:  u.GetId(),
+		Name:    "sdk",
+		Bank:    pb.Bank_ACB,
+		Balance: 100,
+	})
+	require.NoError(t, err)
+	accs, err := c.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(u.GetId())})
+	require.NoError(t, err)
+	require.Len(t, accs, 1)
+	require.Equal(t, acc.GetId(), accs[0].GetId())
+
+	// transactions
+	trans, err := c.CreateTransaction(ctx, &pb.CreateTransactionRequest{
+		UserId:          u.GetId(),
+		AccountId:       acc.GetId(),
+		Amount:          10,
+		TransactionType: pb.TransactionType_DEPOSIT,
+	})
+	require.NoError(t, err)
+	updated, err := c.UpdateTransaction(ctx, &pb.UpdateTransactionRequest{
+		UserId:      u.GetId(),
+		AccountId:   acc.GetId(),
+		Transaction: &pb.Transaction{Id: trans.GetId(), Amount: 20},
+	})
+	require.NoError(t, err)
+	require.Equal(t, float64(20), updated.GetAmount())
+	results, err := c.ListTransactions(ctx, &pb.ListTransactionsRequest{UserId: u.GetId()})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	ids, err := c.DeleteTransaction(ctx, &pb.DeleteTransactionRequest{
+		UserId:    u.GetId(),
+		AccountId: wrapperspb.Int64(acc.GetId()),
+		Id:        wrapperspb.Int64(trans.GetId()),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int64{trans.GetId()}, ids)
+
+	// stream users
+	it, err := c.ListStream(ctx, &pb.ListUsersRequest{Id: wrapperspb.Int64(u.GetId())})
+	require.NoError(t, err)
+	var streamed []*pb.User
+	for it.Next() {
+		streamed = append(streamed, it.User())
+	}
+	require.NoError(t, it.Err())
+	require.Len(t, streamed, 1)
+	require.Equal(t, u.GetEmail(), streamed[0].GetEmail())
+
+	// logout clears token
+	require.NoError(t, c.Logout(ctx))
+	require.Empty(t, c.Token())
+}
+
+func TestClient_Errors(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	_, err := c.Create(ctx, "dup@gmail.com", "stringstring")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		call   func() error
+		target error
+		check  func(t *testing.T, e *errors.Error)
+	}{
+		{
+			name: "InvalidEmail",
+			call: func() error {
+				_, err := c.Create(ctx, "abc", "stringstring")
+				return err
+			},
+			target: errorSrv.ErrInvalidEmail,
+			check: func(t *testing.T, e *errors.Error) {
+				require.Equal(t, codes.InvalidArgument, e.Code)
+				require.Contains(t, e.FieldViolations, "email")
+			},
+		},
+		{
+			name: "DuplicateEmail",
+			call: func() error {
+				_, err := c.Create(ctx, "dup@gmail.com", "stringstring")
+				return err
+			},
+			target: errorSrv.ErrDuplicateEmail,
+			check: func(t *testing.T, e *errors.Error) {
+				require.Equal(t, codes.AlreadyExists, e.Code)
+				require.Contains(t, e.FieldViolations, "email")
+			},
+		},
+		{
+			name: "UserNotFound",
+			call: func() error {
+				// token of a deleted user
+				u, err := c.Create(ctx, "deleted@gmail.com", "stringstring")
+				require.NoError(t, err)
+				require.NoError(t, c.Delete(ctx, u.GetId()))
+				_, err = c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: u.GetId(), Name: "deleted", Bank: pb.Bank_ACB})
+				return err
+			},
+			target: errorSrv.ErrUserNotFound,
+			check: func(t *testing.T, e *errors.Error) {
+				require.Equal(t, codes.NotFound, e.Code)
+				require.Len(t, e.Violations, 1)
+				require.Equal(t, "user", e.Violations[0].Subject)
+			},
+		},
+		{
+			// unknown email fails like a wrong password
+			name: "UnknownEmail",
+			call: func() error {
+				_, err := c.Login(ctx, "notfound@gmail.com", "stringstring")
+				return err
+			},
+			target: errorSrv.ErrIncorrectPassword,
+			check: func(t *testing.T, e *errors.Error) {
+				require.Equal(t, codes.Unauthenticated, e.Code)
+				require.Equal(t, "password", e.Domain)
+			},
+		},
+		{
+			name: "IncorrectPassword",
+			call: func() error {
+				_, err := c.Login(ctx, "dup@gmail.com", "wrongpassword")
+				return err
+			},
+			target: errorSrv.ErrIncorrectPassword,
+			check: func(t *testing.T, e *errors.Error) {
+				require.Equal(t, codes.Unauthenticated, e.Code)
+				require.Equal(t, "password", e.Domain)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			require.Error(t, err)
+			require.True(t, stderrors.Is(err, tt.target))
+			var e *errors.Error
+			require.True(t, stderrors.As(err, &e))
+			tt.check(t, e)
+		})
+	}
+}
+
+func TestClient_RefreshToken(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	u, err := c.Create(ctx, "refresh@gmail.com", "stringstring")
+	require.NoError(t, err)
+	token := c.Token()
+
+	// token expiring within the refresh window is refreshed before the request
+	c.refreshBefore = time.Hour
+	// tokens issued within the same second are identical
+	time.Sleep(1100 * time.Millisecond)
+	_, err = c.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(u.GetId())})
+	require.NoError(t, err)
+	require.NotEqual(t, token, c.Token())
+
+	// refreshed token is valid
+	got, err := c.Validate(ctx, c.Token())
+	require.NoError(t, err)
+	require.Equal(t, u.GetId(), got.GetId())
+}
+
+func TestClient_LoginProtection(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	victim, err := c.Create(ctx, "victim@gmail.com", "stringstring")
+	require.NoError(t, err)
+
+	// free attempts then delayed, unknown email behaves the same
+	for _, email := range []string{"victim@gmail.com", "unknown@gmail.com"} {
+		for i := 0; i < userSrv.DefaultLoginFreeAttempts+1; i++ {
+			_, err = c.Login(ctx, email, "wrongpassword")
+			require.True(t, stderrors.Is(err, errorSrv.ErrIncorrectPassword), email)
+		}
+		_, err = c.Login(ctx, email, "stringstring")
+		require.Equal(t, codes.ResourceExhausted, status.Code(err), email)
+		var e *errors.Error
+		require.True(t, stderrors.As(err, &e))
+		require.Greater(t, int64(e.RetryDelay), int64(0))
+	}
+
+	// only admins unlock
+	_, err = c.Create(ctx, "notadmin@gmail.com", "stringstring")
+	require.NoError(t, err)
+	err = c.UnlockUser(ctx, "victim@gmail.com", "")
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = c.Create(ctx, "admin@gmail.com", "stringstring")
+	require.NoError(t, err)
+	require.NoError(t, c.UnlockUser(ctx, "victim@gmail.com", ""))
+
+	// history of own logins only
+	_, err = c.Login(ctx, "victim@gmail.com", "stringstring")
+	require.NoError(t, err)
+	events, err := c.ListLoginHistory(ctx, victim.GetId(), 0)
+	require.NoError(t, err)
+	require.Len(t, events, userSrv.DefaultLoginFreeAttempts+2)
+	require.True(t, events[0].GetSuccess())
+	for _, e := range events[1:] {
+		require.False(t, e.GetSuccess())
+		require.Equal(t, "invalid_credentials", e.GetReason())
+	}
+	_, err = c.ListLoginHistory(ctx, victim.GetId()+1, 0)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+// notifier keeping last tokens sent per email
+type testNotifier struct {
+	resets        map[string]string
+	verifications map[string]string
+}
+
+func newTestNotifier() *testNotifier {
+	return &testNotifier{
+		resets:        make(map[string]string),
+		verifications: make(map[string]string),
+	}
+}
+
+func (n *testNotifier) SendPasswordReset(ctx context.Context, email, token string, expiresAt time.Time) error {
+	n.resets[email] = token
+	return nil
+}
+
+func (n *testNotifier) SendEmailVerification(ctx context.Context, email, token string, expiresAt time.Time) error {
+	n.verifications[email] = token
+	return nil
+}
+
+func TestClient_Password(t *testing.T) {
+	notifier := newTestNotifier()
+	c := newTestClient(t, userSrv.WithNotifier(notifier))
+	ctx := context.Background()
+	u, err := c.Create(ctx, "pwd@gmail.com", "stringstring")
+	require.NoError(t, err)
+	token := c.Token()
+
+	// updating email keeps password
+	_, err = c.Update(ctx, &pb.User{Id: u.GetId(), Email: "pwd2@gmail.com"}, "Email")
+	require.NoError(t, err)
+	_, err = c.Login(ctx, "pwd2@gmail.com", "stringstring")
+	require.NoError(t, err)
+	_, err = c.Update(ctx, &pb.User{Id: u.GetId(), Password: "newpassword"}, "Password")
+	require.True(t, stderrors.Is(err, errorSrv.ErrPasswordUpdate))
+
+	// change password requires current one & revokes issued tokens
+	err = c.ChangePassword(ctx, "wrongpassword", "newpassword")
+	require.True(t, stderrors.Is(err, errorSrv.ErrIncorrectPassword))
+	require.NoError(t, c.ChangePassword(ctx, "stringstring", "newpassword"))
+	_, err = c.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(u.GetId())})
+	require.NoError(t, err)
+	c.SetToken(token)
+	_, err = c.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(u.GetId())})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// reset w token delivered by notifier
+	require.NoError(t, c.RequestPasswordReset(ctx, "pwd2@gmail.com"))
+	require.NotEmpty(t, notifier.resets["pwd2@gmail.com"])
+	require.NoError(t, c.ConfirmPasswordReset(ctx, notifier.resets["pwd2@gmail.com"], "resetpassword"))
+	err = c.ConfirmPasswordReset(ctx, notifier.resets["pwd2@gmail.com"], "resetpassword")
+	require.True(t, stderrors.Is(err, errorSrv.ErrResetTokenInvalid))
+	_, err = c.Login(ctx, "pwd2@gmail.com", "newpassword")
+	require.True(t, stderrors.Is(err, errorSrv.ErrIncorrectPassword))
+	_, err = c.Login(ctx, "pwd2@gmail.com", "resetpassword")
+	require.NoError(t, err)
+}
+
+func TestClient_EmailVerification(t *testing.T) {
+	notifier := newTestNotifier()
+	c := newTestClientVerified(t, map[string]bool{
+		"/user.UserService/CreateTransaction": true,
+		"/user.UserService/AuthorizeHold":     true,
+		"/user.UserService/CreateSchedule":    true,
+	}, userSrv.WithNotifier(notifier))
+	ctx := context.Background()
+	u, err := c.Create(ctx, "verify@gmail.com", "stringstring")
+	require.NoError(t, err)
+	require.False(t, u.GetEmailVerified())
+	acc, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: u.GetId(), Name: "verify", Bank: pb.Bank_ACB})
+	require.NoError(t, err)
+
+	// unverified users are restricted
+	req := &pb.CreateTransactionRequest{
+		UserId:          u.GetId(),
+		AccountId:       acc.GetId(),
+		Amount:          10,
+		TransactionType: pb.TransactionType_DEPOSIT,
+	}
+	_, err = c.CreateTransaction(ctx, req)
+	require.True(t, stderrors.Is(err, errorSrv.ErrEmailNotVerified))
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = c.AuthorizeHold(ctx, &pb.AuthorizeHoldRequest{UserId: u.GetId(), AccountId: acc.GetId(), Amount: 10})
+	require.True(t, stderrors.Is(err, errorSrv.ErrEmailNotVerified))
+	_, err = c.CreateSchedule(ctx, &pb.CreateScheduleRequest{UserId: u.GetId(), AccountId: acc.GetId(), TransactionType: pb.TransactionType_WITHDRAW, Amount: 10, Cron: "@monthly"})
+	require.True(t, stderrors.Is(err, errorSrv.ErrEmailNotVerified))
+
+	// resend & verify
+	require.NoError(t, c.ResendEmailVerification(ctx))
+	got, err := c.VerifyEmail(ctx, notifier.verifications["verify@gmail.com"])
+	require.NoError(t, err)
+	require.True(t, got.GetEmailVerified())
+	_, err = c.CreateTransaction(ctx, req)
+	require.NoError(t, err)
+}
+
+func TestClient_TOTP(t *testing.T) {
+	c := newTestClientAuth(t, func(dal *postgres.DataAccessLayer) []userSrv.AuthOption {
+		return []userSrv.AuthOption{userSrv.WithStepUp(map[string]bool{"/user.UserService/Delete": true}, 1000,
+			userSrv.NewTwoFactor(dal, userSrv.NewLoginGuard(nil)))}
+	})
+	ctx := context.Background()
+	u, err := c.Create(ctx, "totp@gmail.com", "stringstring")
+	require.NoError(t, err)
+	acc, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: u.GetId(), Name: "totp", Bank: pb.Bank_ACB})
+	require.NoError(t, err)
+	_, err = c.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: u.GetId(), AccountId: acc.GetId(), Amount: 5000, TransactionType: pb.TransactionType_DEPOSIT})
+	require.NoError(t, err)
+
+	// users w/o 2FA aren't asked for a code
+	withdraw := func(ctx context.Context, amount float64) error {
+		_, err := c.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: u.GetId(), AccountId: acc.GetId(), Amount: amount, TransactionType: pb.TransactionType_WITHDRAW})
+		return err
+	}
+	require.NoError(t, withdraw(ctx, 1000))
+
+	// enroll & confirm
+	secret, uri, err := c.EnrollTOTP(ctx)
+	require.NoError(t, err)
+	require.Contains(t, uri, secret)
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+	require.NoError(t, err)
+	recoveryCodes, err := c.ConfirmTOTP(ctx, code)
+	require.NoError(t, err)
+	require.NotEmpty(t, recoveryCodes)
+
+	// two-step login
+	got, err := c.Login(ctx, "totp@gmail.com", "stringstring")
+	require.True(t, stderrors.Is(err, ErrTwoFactorRequired))
+	require.True(t, got.GetTotpEnabled())
+	_, err = c.LoginTOTP(ctx, "000000")
+	require.True(t, stderrors.Is(err, errorSrv.ErrTOTPInvalidCode))
+	_, err = c.LoginTOTP(ctx, recoveryCodes[0])
+	require.NoError(t, err)
+
+	// step-up: small withdrawals pass, large ones & delete require a code
+	require.NoError(t, withdraw(ctx, 10))
+	require.True(t, stderrors.Is(withdraw(ctx, 1000), errorSrv.ErrTOTPRequired))
+	require.True(t, stderrors.Is(withdraw(WithTOTPCode(ctx, recoveryCodes[0]), 1000), errorSrv.ErrTOTPInvalidCode))
+	require.NoError(t, withdraw(WithTOTPCode(ctx, recoveryCodes[1]), 1000))
+	// holds reserve funds of withdrawals
+	_, err = c.AuthorizeHold(ctx, &pb.AuthorizeHoldRequest{UserId: u.GetId(), AccountId: acc.GetId(), Amount: 1000})
+	require.True(t, stderrors.Is(err, errorSrv.ErrTOTPRequired))
+	// small withdrawals can't be raised w/o a code
+	small, err := c.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: u.GetId(), AccountId: acc.GetId(), Amount: 999, TransactionType: pb.TransactionType_WITHDRAW})
+	require.NoError(t, err)
+	raise := &pb.UpdateTransactionRequest{UserId: u.GetId(), AccountId: acc.GetId(), Transaction: &pb.Transaction{Id: small.GetId(), Amount: 3000}}
+	_, err = c.UpdateTransaction(ctx, raise)
+	require.True(t, stderrors.Is(err, errorSrv.ErrTOTPRequired))
+	// nor scheduled
+	_, err = c.CreateSchedule(ctx, &pb.CreateScheduleRequest{UserId: u.GetId(), AccountId: acc.GetId(), TransactionType: pb.TransactionType_WITHDRAW, Amount: 1000, Cron: "@monthly"})
+	require.True(t, stderrors.Is(err, errorSrv.ErrTOTPRequired))
+	sched, err := c.CreateSchedule(ctx, &pb.CreateScheduleRequest{UserId: u.GetId(), AccountId: acc.GetId(), TransactionType: pb.TransactionType_WITHDRAW, Amount: 999, Cron: "@monthly"})
+	require.NoError(t, err)
+	_, err = c.UpdateSchedule(ctx, &pb.UpdateScheduleRequest{UserId: u.GetId(), Schedule: &pb.Schedule{Id: sched.GetId(), Amount: 3000}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"amount"}}})
+	require.True(t, stderrors.Is(err, errorSrv.ErrTOTPRequired))
+	err = c.Delete(ctx, u.GetId())
+	require.True(t, stderrors.Is(err, errorSrv.ErrTOTPRequired))
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	err = c.Delete(WithTOTPCode(ctx, recoveryCodes[1]), u.GetId())
+	require.True(t, stderrors.Is(err, errorSrv.ErrTOTPInvalidCode))
+}
+
+func TestClient_Sessions(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	_, err := c.Create(ctx, "sessions@gmail.com", "stringstring")
+	require.NoError(t, err)
+	signup := c.Token()
+	_, err = c.Login(ctx, "sessions@gmail.com", "stringstring")
+	require.NoError(t, err)
+	login := c.Token()
+
+	// sessions of signup & login, request token's is current
+	sessions, err := c.ListSessions(ctx)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	require.True(t, sessions[0].GetCurrent())
+	require.False(t, sessions[1].GetCurrent())
+	require.NotEmpty(t, sessions[0].GetUserAgent())
+
+	// revoked session's token rejected
+	require.NoError(t, c.RevokeSession(ctx, sessions[1].GetId()))
+	require.True(t, stderrors.Is(c.RevokeSession(ctx, sessions[1].GetId()+100), errorSrv.ErrSessionNotFound))
+	c.SetToken(signup)
+	_, err = c.ListSessions(ctx)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// logout everywhere
+	c.SetToken(login)
+	_, err = c.Login(ctx, "sessions@gmail.com", "stringstring")
+	require.NoError(t, err)
+	other := c.Token()
+	require.NoError(t, c.LogoutAll(ctx))
+	require.Empty(t, c.Token())
+	for _, token := range []string{login, other} {
+		c.SetToken(token)
+		_, err = c.ListSessions(ctx)
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+}
+
+func TestClient_APIKey(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	u, err := c.Create(ctx, "apikey@gmail.com", "stringstring")
+	require.NoError(t, err)
+	acc, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: u.GetId(), Name: "apikey", Bank: pb.Bank_ACB})
+	require.NoError(t, err)
+	readOnly, readKey, err := c.CreateAPIKey(ctx, "reports", []string{userSrv.APIKeyScopeRead}, time.Time{})
+	require.NoError(t, err)
+	_, writeKey, err := c.CreateAPIKey(ctx, "batch", []string{userSrv.APIKeyScopeRead, "/user.UserService/CreateTransaction"}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	keys, err := c.ListAPIKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	// read-only key calls List* methods only
+	c.SetAPIKey(readKey)
+	_, err = c.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(u.GetId())})
+	require.NoError(t, err)
+	req := &pb.CreateTransactionRequest{UserId: u.GetId(), AccountId: acc.GetId(), Amount: 10, TransactionType: pb.TransactionType_DEPOSIT}
+	_, err = c.CreateTransaction(ctx, req)
+	require.True(t, stderrors.Is(err, errorSrv.ErrAPIKeyScope))
+
+	// key w method scope, keys can't create keys
+	c.SetAPIKey(writeKey)
+	_, err = c.CreateTransaction(ctx, req)
+	require.NoError(t, err)
+	_, _, err = c.CreateAPIKey(ctx, "other", []string{userSrv.APIKeyScopeWrite}, time.Time{})
+	require.True(t, stderrors.Is(err, errorSrv.ErrAPIKeyScope))
+	// other users' resources are still denied
+	_, err = c.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(u.GetId() + 1)})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// revoked & unknown keys rejected
+	c.SetAPIKey("")
+	require.NoError(t, c.RevokeAPIKey(ctx, readOnly.GetId()))
+	for _, key := range []string{readKey, "mfk_unknown"} {
+		c.SetAPIKey(key)
+		_, err = c.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(u.GetId())})
+		require.True(t, stderrors.Is(err, errorSrv.ErrAPIKeyInvalid))
+	}
+}
+
+func TestClient_TransactionLimits(t *testing.T) {
+	policy, err := userSrv.NewTransactionLimitPolicy(&configs.TransactionLimits{
+		Tiers: map[string]*configs.TransactionLimit{"standard": {MaxWithdrawal: 500}},
+	})
+	require.NoError(t, err)
+	c := newTestClient(t, userSrv.WithTransactionLimits(policy))
+	ctx := context.Background()
+	owner, err := c.Create(ctx, "limits@gmail.com", "stringstring")
+	require.NoError(t, err)
+	ownerToken := c.Token()
+	acc, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: owner.GetId(), Bank: pb.Bank_VCB, Balance: 1000})
+	require.NoError(t, err)
+	withdraw := &pb.CreateTransactionRequest{UserId: owner.GetId(), AccountId: acc.GetId(), TransactionType: pb.TransactionType_WITHDRAW, Amount: 600}
+
+	// limit hit & amount left decoded
+	_, err = c.CreateTransaction(ctx, withdraw)
+	require.True(t, stderrors.Is(err, errorSrv.ErrTransactionLimitExceeded("max_withdrawal", 0)))
+	var e *errors.Error
+	require.True(t, stderrors.As(err, &e))
+	require.Equal(t, []errors.Violation{{Type: "TRANSACTION_LIMIT", Subject: "max_withdrawal", Description: "500"}}, e.Violations)
+
+	limitsReq := &pb.GetTransactionLimitsRequest{UserId: owner.GetId(), AccountId: acc.GetId()}
+	limits, err := c.GetTransactionLimits(ctx, limitsReq)
+	require.NoError(t, err)
+	require.EqualValues(t, 500, limits.GetLimits().GetMaxWithdrawal())
+
+	// only admins set limits
+	setReq := &pb.SetTransactionLimitsRequest{UserId: owner.GetId(), AccountId: acc.GetId(), Limits: &pb.TransactionLimits{MaxWithdrawal: 1000}}
+	_, err = c.SetTransactionLimits(ctx, setReq)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = c.Create(ctx, "admin@gmail.com", "stringstring")
+	require.NoError(t, err)
+	_, err = c.SetTransactionLimits(ctx, setReq)
+	require.NoError(t, err)
+	// limits of own accounts only
+	_, err = c.GetTransactionLimits(ctx, limitsReq)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	c.SetToken(ownerToken)
+	_, err = c.CreateTransaction(ctx, withdraw)
+	require.NoError(t, err)
+}
+
+func TestClient_Overdraft(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	owner, err := c.Create(ctx, "overdraft@gmail.com", "stringstring")
+	require.NoError(t, err)
+	ownerToken := c.Token()
+	acc, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: owner.GetId(), Bank: pb.Bank_VCB, Balance: 100})
+	require.NoError(t, err)
+	withdraw := &pb.CreateTransactionRequest{UserId: owner.GetId(), AccountId: acc.GetId(), TransactionType: pb.TransactionType_WITHDRAW, Amount: 300}
+	_, err = c.CreateTransaction(ctx, withdraw)
+	require.True(t, stderrors.Is(err, errorSrv.ErrInvalidWithdrawTransactionAmount))
+
+	// only admins set overdraft limits
+	setReq := &pb.SetOverdraftLimitRequest{UserId: owner.GetId(), AccountId: acc.GetId(), OverdraftLimit: 500}
+	_, err = c.SetOverdraftLimit(ctx, setReq)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = c.Create(ctx, "admin@gmail.com", "stringstring")
+	require.NoError(t, err)
+	got, err := c.SetOverdraftLimit(ctx, setReq)
+	require.NoError(t, err)
+	require.EqualValues(t, 500, got.GetOverdraftLimit())
+
+	c.SetToken(ownerToken)
+	_, err = c.CreateTransaction(ctx, withdraw)
+	require.NoError(t, err)
+	accs, err := c.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(owner.GetId())})
+	require.NoError(t, err)
+	require.Len(t, accs, 1)
+	require.EqualValues(t, -200, accs[0].GetBalance())
+	require.EqualValues(t, 200, accs[0].GetOverdraftUsed())
+}
+
+func TestClient_Holds(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	owner, err := c.Create(ctx, "holds@gmail.com", "stringstring")
+	require.NoError(t, err)
+	acc, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: owner.GetId(), Bank: pb.Bank_VCB, Balance: 100})
+	require.NoError(t, err)
+
+	hold, err := c.AuthorizeHold(ctx, &pb.AuthorizeHoldRequest{UserId: owner.GetId(), AccountId: acc.GetId(), Amount: 80})
+	require.NoError(t, err)
+	require.Equal(t, pb.TransactionStatus_PENDING, hold.GetStatus())
+	_, err = c.AuthorizeHold(ctx, &pb.AuthorizeHoldRequest{UserId: owner.GetId(), AccountId: acc.GetId(), Amount: 80})
+	require.True(t, stderrors.Is(err, errorSrv.ErrInvalidWithdrawTransactionAmount))
+	posted, err := c.CaptureHold(ctx, &pb.CaptureHoldRequest{UserId: owner.GetId(), AccountId: acc.GetId(), Id: hold.GetId(), Amount: 50})
+	require.NoError(t, err)
+	require.Equal(t, pb.TransactionStatus_POSTED, posted.GetStatus())
+	_, err = c.VoidHold(ctx, &pb.VoidHoldRequest{UserId: owner.GetId(), AccountId: acc.GetId(), Id: hold.GetId()})
+	require.True(t, stderrors.Is(err, errorSrv.ErrHoldNotPending))
+	accs, err := c.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(owner.GetId())})
+	require.NoError(t, err)
+	require.EqualValues(t, 50, accs[0].GetLedgerBalance())
+	require.EqualValues(t, 50, accs[0].GetAvailableBalance())
+
+	// holds of own accounts only
+	_, err = c.Create(ctx, "other@gmail.com", "stringstring")
+	require.NoError(t, err)
+	_, err = c.AuthorizeHold(ctx, &pb.AuthorizeHoldRequest{UserId: owner.GetId(), AccountId: acc.GetId(), Amount: 10})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestClient_Schedules(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	owner, err := c.Create(ctx, "schedules@gmail.com", "stringstring")
+	require.NoError(t, err)
+	acc, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: owner.GetId(), Bank: pb.Bank_VCB, Balance: 100})
+	require.NoError(t, err)
+
+	_, err = c.CreateSchedule(ctx, &pb.CreateScheduleRequest{UserId: owner.GetId(), AccountId: acc.GetId(), TransactionType: pb.TransactionType_DEPOSIT, Amount: 10, Cron: "every day"})
+	require.True(t, stderrors.Is(err, errorSrv.ErrInvalidScheduleCron))
+	sched, err := c.CreateSchedule(ctx, &pb.CreateScheduleRequest{UserId: owner.GetId(), AccountId: acc.GetId(), TransactionType: pb.TransactionType_DEPOSIT, Amount: 10, Cron: "@monthly", TimeZone: "Asia/Tokyo"})
+	require.NoError(t, err)
+	require.NotNil(t, sched.GetNextRunAt())
+	updated, err := c.UpdateSchedule(ctx, &pb.UpdateScheduleRequest{UserId: owner.GetId(), Schedule: &pb.Schedule{Id: sched.GetId(), Amount: 20}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"amount"}}})
+	require.NoError(t, err)
+	require.EqualValues(t, 20, updated.GetAmount())
+	got, err := c.GetSchedule(ctx, &pb.GetScheduleRequest{UserId: owner.GetId(), Id: sched.GetId()})
+	require.NoError(t, err)
+	require.EqualValues(t, 20, got.GetAmount())
+	list, err := c.ListSchedules(ctx, &pb.ListSchedulesRequest{UserId: owner.GetId(), AccountId: wrapperspb.Int64(acc.GetId())})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.NoError(t, c.DeleteSchedule(ctx, &pb.DeleteScheduleRequest{UserId: owner.GetId(), Id: sched.GetId()}))
+	err = c.DeleteSchedule(ctx, &pb.DeleteScheduleRequest{UserId: owner.GetId(), Id: sched.GetId()})
+	require.True(t, stderrors.Is(err, errorSrv.ErrScheduleNotFound))
+
+	// schedules of own accounts only
+	_, err = c.Create(ctx, "other@gmail.com", "stringstring")
+	require.NoError(t, err)
+	_, err = c.ListSchedules(ctx, &pb.ListSchedulesRequest{UserId: owner.GetId()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestClient_RatePlans(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	owner, err := c.Create(ctx, "interest@gmail.com", "stringstring")
+	require.NoError(t, err)
+	acc, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: owner.GetId(), Bank: pb.Bank_VCB, Balance: 100})
+	require.NoError(t, err)
+
+	// only admins create & attach rate plans
+	createReq := &pb.CreateRatePlanRequest{Name: "savings", AnnualInterestRate: "0.045", MonthlyFee: 1}
+	_, err = c.CreateRatePlan(ctx, createReq)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = c.Create(ctx, "admin@gmail.com", "stringstring")
+	require.NoError(t, err)
+	_, err = c.CreateRatePlan(ctx, &pb.CreateRatePlanRequest{Name: "savings", AnnualInterestRate: "4.5%"})
+	require.True(t, stderrors.Is(err, errorSrv.ErrInvalidInterestRate))
+	plan, err := c.CreateRatePlan(ctx, createReq)
+	require.NoError(t, err)
+	require.Equal(t, "0.045", plan.GetAnnualInterestRate())
+	plans, err := c.ListRatePlans(ctx)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	got, err := c.SetAccountRatePlan(ctx, &pb.SetAccountRatePlanRequest{UserId: owner.GetId(), AccountId: acc.GetId(), RatePlanId: plan.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, plan.GetId(), got.GetRatePlanId())
+	_, err = c.SetAccountRatePlan(ctx, &pb.SetAccountRatePlanRequest{UserId: owner.GetId(), AccountId: acc.GetId(), RatePlanId: plan.GetId() + 1})
+	require.True(t, stderrors.Is(err, errorSrv.ErrRatePlanNotFound))
+}
+
+func TestClient_Transfers(t *testing.T) {
+	rates, err := fx.NewStatic("USD", map[string]string{"VND": "23000"})
+	require.NoError(t, err)
+	c := newTestClient(t, userSrv.WithRateProvider(rates))
+	ctx := context.Background()
+	payee, err := c.Create(ctx, "payee@gmail.com", "stringstring")
+	require.NoError(t, err)
+	to, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: payee.GetId(), Bank: pb.Bank_VCB})
+	require.NoError(t, err)
+	require.Equal(t, "VND", to.GetCurrency())
+	owner, err := c.Create(ctx, "payer@gmail.com", "stringstring")
+	require.NoError(t, err)
+	from, err := c.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: owner.GetId(), Bank: pb.Bank_ACB, Balance: 100, Currency: "USD"})
+	require.NoError(t, err)
+
+	// transfers from accounts of the caller only
+	_, _, err = c.CreateTransfer(ctx, &pb.CreateTransferRequest{UserId: payee.GetId(), FromAccountId: to.GetId(), ToAccountId: from.GetId(), Amount: 1})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, _, err = c.CreateTransfer(ctx, &pb.CreateTransferRequest{UserId: owner.GetId(), FromAccountId: from.GetId(), ToAccountId: to.GetId(), Amount: 1, Currency: "VND"})
+	require.True(t, stderrors.Is(err, errorSrv.ErrCurrencyMismatch))
+	withdrawal, deposit, err := c.CreateTransfer(ctx, &pb.CreateTransferRequest{UserId: owner.GetId(), FromAccountId: from.GetId(), ToAccountId: to.GetId(), Amount: 12.5})
+	require.NoError(t, err)
+	require.Equal(t, "23000", withdrawal.GetFxRate())
+	require.Equal(t, float64(287500), withdrawal.GetCounterAmount())
+	require.Equal(t, "VND", withdrawal.GetCounterCurrency())
+	require.Equal(t, deposit.GetId(), withdrawal.GetCounterTransactionId())
+	require.Equal(t, float64(287500), deposit.GetAmount())
+	require.Equal(t, "VND", deposit.GetCurrency())
+	_, err = c.UpdateTransaction(ctx, &pb.UpdateTransactionRequest{UserId: owner.GetId(), AccountId: from.GetId(), Transaction: &pb.Transaction{Id: withdrawal.GetId(), Amount: 1}})
+	require.True(t, stderrors.Is(err, errorSrv.ErrTransferTransaction))
+}
@@ -0,0 +1,37 @@
+package user
+
+import (
+	"context"
+	"net"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// clientIP returns IP of the caller, x-forwarded-for is used only when sent by a trusted proxy
+func clientIP(ctx context.Context, trusted []*net.IPNet) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	var forwardedFor []string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		forwardedFor = md.Get("x-forwarded-for")
+	}
+	return utils.ForwardedClientIP(p.Addr.String(), forwardedFor, trusted)
+}
+
+// userAgent of the caller, forwarded by grpc-gateway as grpcgateway-user-agent
+func userAgent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, key := range []string{"grpcgateway-user-agent", "user-agent"} {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package user
+
+import (
+	"strings"
+	"sync"
+)
+
+// commonPasswordList bundles frequently leaked passwords, lowercase & space separated,
+// checked offline by PasswordPolicy w RejectCommon
+const commonPasswordList = `
+123456 password 12345678 qwerty 123456789 12345 1234 111111 1234567 dragon 123123 baseball abc123
+football monkey letmein 696969 shadow master 666666 qwertyuiop 123321 mustang 1234567890 michael
+654321 superman 1qaz2wsx 7777777 121212 000000 qazwsx 123qwe killer trustno1 jordan jennifer zxcvbnm
+asdfgh hunter buster soccer harley batman andrew tigger sunshine iloveyou 2000 charlie robert thomas
+hockey ranger daniel starwars klaster 112233 george computer michelle jessica pepper 1111 zxcvbn
+555555 11111111 131313 freedom 777777 pass maggie 159753 aaaaaa ginger princess joshua cheese amanda
+summer love ashley nicole chelsea biteme matthew access yankees 987654321 dallas austin thunder
+taylor matrix minecraft william corvette hello martin heather secret merlin diamond 1234qwer gfhjkm
+hammer silver 222222 88888888 anthony justin test bailey q1w2e3r4t5 patrick internet scooter orange
+11111 golfer cookie richard samantha bigdog guitar jackson whatever mickey chicken sparky snoopy
+maverick phoenix camaro peanut morgan welcome falcon cowboy ferrari samsung andrea smokey steelers
+joseph mercedes dakota arsenal eagles melissa boomer booboo spider nascar monster tigers yellow
+xxxxxx 123123123 gateway marina diablo bulldog qwer1234 compaq purple banana junior hannah 123654
+porsche lakers iceman money cowboys 987654 london tennis 999999 ncc1701 coffee scooby 0000 miller
+boston q1w2e3r4 brandon yamaha chester mother forever johnny edward 333333 oliver redsox player
+nikita knight fender barney midnight please brandy chicago badboy slayer rangers charles angel
+flower rabbit wizard jasper enter rachel chris steven winner adidas victoria natasha 1q2w3e4r
+jasmine winter prince marine ghbdtn fishing cocacola casper james 232323 raiders 888888 marlboro
+gandalf asdfasdf crystal 87654321 12344321 golden 8675309 microsoft apples mercury stella mike
+cameron jackie orange1 password1 password12 password123 password1234 passw0rd p@ssw0rd p@ssword
+pa55word pa55w0rd qwerty123 qwerty12 qwerty1234 qwertyui qwertyu 1q2w3e4r5t 1q2w3e4r5t6y 1qazxsw2
+zaq12wsx zaq1zaq1 zaq1xsw2 1qaz2wsx3edc abcd1234 abc12345 abcdefg abcdefgh abcdef123 a1b2c3d4
+aa123456 aaaaaaaa asdfghjkl asdfghjk asdf1234 zxcvbnm1 iloveyou1 iloveyou2 sunshine1 princess1
+football1 baseball1 superman1 welcome1 welcome123 letmein1 letmein123 monkey123 dragon123 shadow123
+master123 admin123 admin1234 administrator changeme changeme123 default defaultpassword guest123
+root1234 rootroot toor1234 test1234 test12345 testtest testing123 trustno1! whatever1 computer1
+internet1 michael1 jennifer1 jordan23 blink182 liverpool chelsea1 manchester arsenal1 barcelona
+realmadrid juventus startrek starwars1 pokemon naruto spiderman ironman batman123 superstar 1234abcd
+11223344 12341234 123412341234 12121212 123454321 1111111111 0123456789 00000000 987654321a
+147258369 123456789a 123456789q 123456a 123456q q123456 a123456 qweasdzxc qweasd123 qazwsxedc
+1q1q1q1q !qaz2wsx iloveu lovely loveme lover babygirl sweety hellokitty hello123 letmein! secret123
+mypassword mypass123 yourpassword nopassword passpass pass1234 pass12345 password! password1!
+qwerty! summer2020 summer2021 summer2022 summer2023 summer2024 winter2020 winter2021 winter2022
+winter2023 winter2024 spring2024 autumn2024 january2024 welcome2024 password2020 password2021
+password2022 password2023 password2024 password2025 company123 football123 baseball123 soccer123
+hockey123 basketball volleyball motorola nintendo playstation xbox360 warcraft starcraft
+counterstrike matrix123 trinity zeppelin metallica nirvana beatles eminem rockstar heavymetal
+mustang1 corvette1 ferrari1 porsche911 harley1 yamaha1 kawasaki michelle1 jessica1 ashley1 amanda1
+daniel1 charlie1 thomas1 robert1 william1 anthony1 joshua1 matthew1 andrew1 qwertyuiop1 asdfghjkl1
+zxcvbnm123 1qaz!qaz q1w2e3r4t5y6 monkey1 dragon1 shadow1 master1 killer1 hunter1 hunter2 buster1
+tigger1 ginger1 pepper1 cookie1 flower1 angel1
+`
+
+var (
+	commonPasswords     map[string]bool
+	commonPasswordsOnce sync.Once
+)
+
+// isCommonPassword reports whether password is in the bundled list, case insensitive
+func isCommonPassword(password string) bool {
+	commonPasswordsOnce.Do(func() {
+		words := strings.Fields(commonPasswordList)
+		commonPasswords = make(map[string]bool, len(words))
+		for _, w := range words {
+			commonPasswords[w] = true
+		}
+	})
+	return commonPasswords[strings.ToLower(password)]
+}
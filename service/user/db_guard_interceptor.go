@@ -0,0 +1,63 @@
+package user
+
+import (
+	"context"
+	"strings"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	interceptor "github.com/1412335/moneyforward-go-coding-challenge/pkg/interceptor/server"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"go.uber.org/zap"
+
+	"google.golang.org/grpc"
+)
+
+// DB guard interceptor rejects service requests while db is not connected (degraded mode)
+type DBGuardServerInterceptor struct {
+	dal *postgres.DataAccessLayer
+}
+
+var _ interceptor.ServerInterceptor = (*DBGuardServerInterceptor)(nil)
+
+func NewDBGuardServerInterceptor(dal *postgres.DataAccessLayer) interceptor.ServerInterceptor {
+	return &DBGuardServerInterceptor{
+		dal: dal,
+	}
+}
+
+func (g *DBGuardServerInterceptor) Log() log.Factory {
+	return interceptor.DefaultLogger.With(zap.String("interceptor-name", "db-guard"))
+}
+
+func (g *DBGuardServerInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return g.UnaryInterceptor
+}
+func (g *DBGuardServerInterceptor) Stream() grpc.StreamServerInterceptor {
+	return g.StreamInterceptor
+}
+
+// health check & reflection are served in degraded mode
+func (g *DBGuardServerInterceptor) check(ctx context.Context, method string) error {
+	if !strings.HasPrefix(method, "/user.") || g.dal.GetDatabase() != nil {
+		return nil
+	}
+	g.Log().For(ctx).Error("db not connected", zap.String("method", method))
+	return errorSrv.ErrDBUnavailable
+}
+
+// unary request to grpc server
+func (g *DBGuardServerInterceptor) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	if err := g.check(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// stream request interceptor
+func (g *DBGuardServerInterceptor) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	if err := g.check(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
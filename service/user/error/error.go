@@ -1,15 +1,45 @@
 package error
 
-import "github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+import (
+	"strconv"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+)
 
 var (
 	ErrMissingEmail   = errors.BadRequest("Email is required", map[string]string{"email": "Missing email"})
 	ErrInvalidEmail   = errors.BadRequest("Invalid email", map[string]string{"email": "The email provided is invalid"})
-	ErrDuplicateEmail = errors.BadRequest("Duplicate email", map[string]string{"email": "A user with this email address already exists"})
+	ErrDuplicateEmail = errors.AlreadyExists("Duplicate email", map[string]string{"email": "A user with this email address already exists"})
 
 	ErrInvalidPassword   = errors.BadRequest("Invalid password", map[string]string{"password": "Password must be at least 8 characters long"})
 	ErrIncorrectPassword = errors.Unauthenticated("Email or password is incorrect", "password", "Email or password is incorrect")
 	ErrHashPassword      = errors.InternalServerError("Hash password failed", "hash password failed")
+	ErrPasswordUpdate    = errors.BadRequest("Cannot update password", map[string]string{"password": "Use change password or password reset to update password"})
+	ErrResetTokenInvalid = errors.BadRequest("Invalid reset token", map[string]string{"token": "Reset token is invalid, expired or already used"})
+
+	ErrVerificationTokenInvalid = errors.BadRequest("Invalid verification token", map[string]string{"token": "Verification token is invalid, expired or already used"})
+	ErrEmailNotVerified         = errors.FailedPrecondition("Email not verified", "EMAIL_VERIFICATION", map[string]string{"email": "Verify your email to call this method"})
+
+	ErrTOTPAlreadyEnabled = errors.FailedPrecondition("2FA already enabled", "TOTP", map[string]string{"totp": "2FA is already enabled"})
+	ErrTOTPNotEnrolled    = errors.FailedPrecondition("2FA not enrolled", "TOTP", map[string]string{"totp": "Enroll & confirm 2FA to call this method"})
+	ErrTOTPRequired       = errors.Unauthenticated("2FA code required", "totp", "Send a 2FA code in x-totp-code metadata")
+	ErrTOTPInvalidCode    = errors.Unauthenticated("Invalid 2FA code", "totp", "2FA code is invalid or already used")
+
+	ErrMissingAPIKeyName   = errors.BadRequest("Missing API key name", map[string]string{"name": "Missing API key name"})
+	ErrInvalidAPIKeyScope  = errors.BadRequest("Invalid API key scope", map[string]string{"scopes": "Scopes must be read, write or names of methods allowed to API keys"})
+	ErrInvalidAPIKeyExpiry = errors.BadRequest("Invalid API key expiry", map[string]string{"expires_at": "Expiry must be in the future"})
+	ErrAPIKeyNotFound      = errors.NotFound("Not found API key", map[string]string{"api_key": "API key not found"})
+	ErrAPIKeyInvalid       = errors.Unauthenticated("Invalid API key", "x-api-key", "API key is invalid, expired or revoked")
+	ErrAPIKeyScope         = errors.Unauthenticated("Method not allowed to API key", "x-api-key", "Method is out of the API key scopes")
+
+	ErrPasswordReused = errors.BadRequest("Password reused", map[string]string{"password": "Password must differ from the previous ones"})
+
+	ErrSessionNotFound = errors.NotFound("Not found session", map[string]string{"session": "Session not found"})
+	ErrSessionRevoked  = errors.Unauthenticated("Session revoked", "token", "Session of the token is revoked or expired, log in again")
+
+	ErrInvalidTier             = errors.BadRequest("Invalid tier", map[string]string{"tier": "Tier must be one of the configured tiers"})
+	ErrInvalidTransactionLimit = errors.BadRequest("Invalid transaction limits", map[string]string{"limits": "Limits must be greater than or equal zero"})
 
 	ErrMissingUserID        = errors.BadRequest("Missing user id", map[string]string{"id": "Missing user id"})
 	ErrMissingAccountID     = errors.BadRequest("Missing account id", map[string]string{"id": "Missing account id"})
@@ -17,9 +47,37 @@ var (
 
 	ErrInvalidAccountBalance            = errors.BadRequest("Invalid account balance (>=0)", map[string]string{"balance": "greater than zero"})
 	ErrInvalidTransactionAmountGT0      = errors.BadRequest("Invalid transaction amount (>0)", map[string]string{"amount": "greater than zero"})
-	ErrInvalidWithdrawTransactionAmount = errors.BadRequest("Invalid withdraw transaction amount (<= account balance)", map[string]string{"amount": "less than or equal account balance"})
+	ErrInvalidWithdrawTransactionAmount = errors.BadRequest("Invalid withdraw transaction amount (<= account balance + overdraft limit)", map[string]string{"amount": "less than or equal account balance plus overdraft limit"})
+	ErrInvalidOverdraftLimit            = errors.BadRequest("Invalid overdraft limit (>=0)", map[string]string{"overdraft_limit": "greater than or equal zero"})
+	ErrInvalidTransactionType           = errors.BadRequest("Invalid transaction type", map[string]string{"transaction_type": "Transaction type must be WITHDRAW or DEPOSIT"})
+	ErrSystemTransaction                = errors.FailedPrecondition("System transaction can't be changed", "SYSTEM_TRANSACTION", map[string]string{"trans": "Transaction is posted by the service"})
+	ErrInvalidCaptureAmount             = errors.BadRequest("Invalid capture amount (<= hold amount)", map[string]string{"amount": "less than or equal hold amount"})
+	ErrTransactionNotPosted             = errors.FailedPrecondition("Transaction not posted", "TRANSACTION_STATUS", map[string]string{"trans": "Pending holds are captured or voided, voided ones can't be changed"})
+	ErrHoldNotPending                   = errors.FailedPrecondition("Hold not pending", "TRANSACTION_STATUS", map[string]string{"trans": "Hold is already captured or voided"})
+	ErrHoldExpired                      = errors.FailedPrecondition("Hold expired", "TRANSACTION_STATUS", map[string]string{"trans": "Hold expired, funds are released"})
+
+	ErrMissingScheduleID    = errors.BadRequest("Missing schedule id", map[string]string{"id": "Missing schedule id"})
+	ErrInvalidScheduleCron  = errors.BadRequest("Invalid schedule", map[string]string{"cron": "Cron must be a 5 fields expression or a descriptor (@daily, @monthly...)"})
+	ErrInvalidTimeZone      = errors.BadRequest("Invalid time zone", map[string]string{"time_zone": "Time zone must be an IANA name, ex: Asia/Tokyo"})
+	ErrInvalidScheduleRange = errors.BadRequest("Invalid schedule dates", map[string]string{"end_at": "End must be after start & next run"})
+	ErrScheduleNotFound     = errors.NotFound("Not found schedule", map[string]string{"schedule": "Schedule not found"})
+
+	ErrMissingRatePlanName   = errors.BadRequest("Missing rate plan name", map[string]string{"name": "Missing rate plan name"})
+	ErrInvalidInterestRate   = errors.BadRequest("Invalid interest rate", map[string]string{"annual_interest_rate": "Rate must be a non negative decimal fraction, ex: 0.045 for 4.5%"})
+	ErrInvalidMonthlyFee     = errors.BadRequest("Invalid monthly fee (>=0)", map[string]string{"monthly_fee": "greater than or equal zero"})
+	ErrDuplicateRatePlanName = errors.AlreadyExists("Duplicate rate plan name", map[string]string{"name": "A rate plan with this name already exists"})
+	ErrRatePlanNotFound      = errors.NotFound("Not found rate plan", map[string]string{"rate_plan": "Rate plan not found"})
+	ErrAccrualDayNotClosed   = errors.BadRequest("Accrual day not closed", map[string]string{"day": "Day must be closed, yesterday or before in the interest time zone"})
+
+	ErrInvalidCurrency        = errors.BadRequest("Invalid currency", map[string]string{"currency": "Currency must be an ISO 4217 code, ex: USD"})
+	ErrCurrencyMismatch       = errors.FailedPrecondition("Currency mismatch", "CURRENCY", map[string]string{"currency": "Currency must be the account currency"})
+	ErrInvalidAmountPrecision = errors.BadRequest("Invalid amount precision", map[string]string{"amount": "Amount must be in minor units of the account currency, ex: cents of USD, whole VND"})
+	ErrSameTransferAccount    = errors.BadRequest("Invalid transfer accounts", map[string]string{"to_account_id": "Must differ from from_account_id"})
+	ErrExchangeRateNotFound   = errors.FailedPrecondition("Exchange rate not found", "EXCHANGE_RATE", map[string]string{"currency": "No exchange rate between the account currencies"})
+	ErrTransferTransaction    = errors.FailedPrecondition("Transfer transaction can't be changed", "TRANSFER_TRANSACTION", map[string]string{"trans": "Transaction is a leg of a transfer"})
 
-	ErrConnectDB = errors.InternalServerError("Connect db failed", "Connecting to database failed")
+	ErrConnectDB     = errors.InternalServerError("Connect db failed", "Connecting to database failed")
+	ErrDBUnavailable = errors.Unavailable("Service unavailable, database is not connected", errors.UnavailableRetryDelay)
 
 	ErrUserNotFound        = errors.NotFound("Not found user", map[string]string{"user": "User not found"})
 	ErrAccountNotFound     = errors.NotFound("Not found user account", map[string]string{"account": "Account not found"})
@@ -29,3 +87,18 @@ var (
 	ErrTokenGenerated = errors.InternalServerError("Token gen failed", "Generate token failed")
 	ErrTokenInvalid   = errors.Unauthenticated("Invalid token", "token", "Token invalid")
 )
+
+// ErrWeakPassword rejects a new password breaking the password policy for reason
+func ErrWeakPassword(reason string) error {
+	return errors.BadRequest("Invalid password", map[string]string{"password": reason})
+}
+
+// ErrLoginThrottled rejects login attempts of an email or IP w too many failures until retryDelay
+func ErrLoginThrottled(retryDelay time.Duration) error {
+	return errors.ResourceExhausted("Too many failed login attempts, try again later", retryDelay)
+}
+
+// ErrTransactionLimitExceeded rejects a transaction over limit, w amount or number of transactions left
+func ErrTransactionLimitExceeded(limit string, left float64) error {
+	return errors.FailedPrecondition("Transaction limit exceeded", "TRANSACTION_LIMIT", map[string]string{limit: strconv.FormatFloat(left, 'f', -1, 64)})
+}
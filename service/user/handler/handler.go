@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"crypto/tls"
+	"expvar"
 	"mime"
 	"net"
 	"net/http"
@@ -27,6 +28,7 @@ import (
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/ratelimit"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
 
 	// Static files
@@ -36,16 +38,52 @@ import (
 	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
 )
 
+// KeySet provides public keys verifying issued tokens
+type KeySet interface {
+	JWKS() *utils.JWKS
+}
+
+type Option func(*Handler)
+
+// WithKeySet serves keys at /.well-known/jwks.json
+func WithKeySet(keys KeySet) Option {
+	return func(h *Handler) {
+		h.keys = keys
+	}
+}
+
 type Handler struct {
 	logger log.Factory
 	config *configs.ServiceConfig
+	keys   KeySet
+	// login federation, enabled w config.OIDC
+	oidc       *oidcProvider
+	oidcLinker OIDCLinker
+	// rate limits, enabled w WithRateLimiter
+	limiter        *ratelimit.Limiter
+	users          UserIdentifier
+	trustedProxies []*net.IPNet
 }
 
-func NewHandler(config *configs.ServiceConfig) *Handler {
-	return &Handler{
+func NewHandler(config *configs.ServiceConfig, opts ...Option) *Handler {
+	h := &Handler{
 		logger: log.With(zap.String("gateway", "gin")),
 		config: config,
 	}
+	for _, o := range opts {
+		o(h)
+	}
+	if config.OIDC != nil && h.oidcLinker != nil {
+		h.oidc = newOIDCProvider(config.OIDC)
+	}
+	// proxies trusted to forward client IP, fall back to loopback
+	trustedProxies, err := utils.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		h.logger.Bg().Error("Parse trusted proxies", zap.Error(err))
+		trustedProxies, _ = utils.ParseTrustedProxies(nil)
+	}
+	h.trustedProxies = trustedProxies
+	return h
 }
 
 // isPermanentHTTPHeader checks whether hdr belongs to the list of
@@ -183,17 +221,39 @@ func (h *Handler) initRouter(handler http.Handler) *gin.Engine {
 
 	r := gin.Default()
 	r.Use(secureFunc)
+	if h.limiter != nil {
+		r.Use(h.rateLimit)
+	}
 
 	if err := serveOpenAPI(r); err != nil {
 		h.logger.Bg().Error("Serve OpenAPI", zap.Error(err))
 	}
 
+	// public keys verifying tokens
+	if h.keys != nil {
+		r.GET("/.well-known/jwks.json", h.jwks)
+	}
+
+	// login w OIDC provider
+	if h.oidc != nil {
+		r.GET("/auth/oidc/login", h.oidcLogin)
+		r.GET("/auth/oidc/callback", h.oidcCallback)
+	}
+
 	api := r.Group("/api/v1")
 	api.Any("/*any", gin.WrapH(handler))
 
 	return r
 }
 
+// debug endpoints served on the internal listener only
+func (h *Handler) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	// expvar metrics, ex: db transaction retries
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
 // serveOpenAPI serves an OpenAPI UI on /openapi-ui/
 // Adapted from https://github.com/philips/grpc-gateway-example/blob/a269bcb5931ca92be0ceae6130ac27ae89582ecc/cmd/serve.go#L63
 func serveOpenAPI(r *gin.Engine) error {
@@ -211,23 +271,40 @@ func serveOpenAPI(r *gin.Engine) error {
 	return nil
 }
 
+// serve JWKS, verifiers refresh it at least once per key rotation interval
+func (h *Handler) jwks(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
+
 func (h *Handler) loadClientTLSCredentials() (credentials.TransportCredentials, error) {
-	config, err := utils.LoadClientTLSConfig(h.config.TLSCert.CACert)
+	cert := h.config.TLSCert
+	var config *tls.Config
+	var err error
+	if cert.MutualTLS {
+		// present gateway client certificate
+		config, err = utils.LoadMutualClientTLSConfig(cert.CACert, cert.ClientCertPem, cert.ClientKeyPem)
+	} else {
+		config, err = utils.LoadClientTLSConfig(cert.CACert)
+	}
 	if err != nil {
 		return nil, err
 	}
-	// config.ServerName = h.addr
-	config.InsecureSkipVerify = true
+	// verify grpc server certificate against its service name
+	config.ServerName = cert.ServerName
+	if config.ServerName == "" {
+		config.ServerName = h.config.GRPC.Host
+	}
 	// Create the credentials and return it
 	return credentials.NewTLS(config), nil
 }
 
-func (h *Handler) loadServerTLSCredentials() (*tls.Config, error) {
-	config, err := utils.LoadServerTLSConfig(h.config.TLSCert.CertPem, h.config.TLSCert.KeyPem)
-	if err != nil {
-		return nil, err
-	}
-	return config, nil
+func (h *Handler) loadServerTLSCredentials() (*utils.CertWatcher, error) {
+	// reload certificates on change w/o restarting gateway
+	return utils.NewCertWatcher(h.config.TLSCert.CertPem, h.config.TLSCert.KeyPem, "",
+		utils.WithReloadInterval(h.config.TLSCert.ReloadInterval),
+		utils.WithExpiryWarning(h.config.TLSCert.ExpiryWarning),
+	)
 }
 
 // run grpc-gateway
@@ -307,12 +384,29 @@ func (h *Handler) Run() error {
 
 	// insecure
 	if h.config.EnableTLS && h.config.TLSCert != nil {
-		tlsConfig, err := h.loadServerTLSCredentials()
+		watcher, err := h.loadServerTLSCredentials()
 		if err != nil {
 			h.logger.For(ctx).Error("Load http server TLS credentials", zap.Error(err))
 			return err
 		}
-		srv.TLSConfig = tlsConfig
+		watcher.Start()
+		defer watcher.Stop()
+		srv.TLSConfig = watcher.TLSConfig()
+	}
+
+	// debug endpoints on the internal listener
+	var debugSrv *http.Server
+	if cfg := h.config.Debug; cfg != nil && cfg.Addr != "" {
+		debugSrv = &http.Server{
+			Addr:    cfg.Addr,
+			Handler: h.debugHandler(),
+		}
+		go func() {
+			h.logger.For(ctx).Info("Serving debug endpoints on", zap.String("addr", "http://"+cfg.Addr+"/debug/vars"))
+			if err := debugSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				h.logger.For(ctx).Error("Serve debug endpoints", zap.Error(err))
+			}
+		}()
 	}
 
 	// graceful shutdown
@@ -322,6 +416,9 @@ func (h *Handler) Run() error {
 		sig := <-signals
 		h.logger.For(ctx).Info("Received signal", zap.Any("signal", sig))
 		shutdown, can := context.WithTimeout(ctx, 10*time.Second)
+		if debugSrv != nil {
+			debugSrv.Shutdown(shutdown)
+		}
 		srv.Shutdown(shutdown)
 		defer can()
 	}()
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_JWKS(t *testing.T) {
+	m, err := utils.NewJWTManager(&configs.JWT{Algorithm: utils.AlgorithmEdDSA})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantCode int
+	}{
+		{name: "KeySet", opts: []Option{WithKeySet(m)}, wantCode: http.StatusOK},
+		{name: "NoKeySet", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(&configs.ServiceConfig{}, tt.opts...)
+			r := h.initRouter(http.NotFoundHandler())
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+			require.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var set utils.JWKS
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &set))
+			require.Equal(t, m.JWKS(), &set)
+			require.Equal(t, "OKP", set.Keys[0].Kty)
+		})
+	}
+}
+
+func TestHandler_incomingHeaderMatcher(t *testing.T) {
+	h := NewHandler(&configs.ServiceConfig{})
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{header: "x-api-key", want: "X-Api-Key", ok: true},
+		{header: "X-Totp-Code", want: "X-Totp-Code", ok: true},
+		{header: "User-Agent", want: "grpcgateway-User-Agent", ok: true},
+		{header: "Grpc-Timeout", want: "X-Grpc-Timeout", ok: true},
+		{header: "Content-Length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, ok := h.incomingHeaderMatcher(tt.header)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHandler_debugVars(t *testing.T) {
+	h := NewHandler(&configs.ServiceConfig{})
+	// not served by the public gateway
+	w := httptest.NewRecorder()
+	h.initRouter(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	h.debugHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var vars map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &vars))
+	require.Contains(t, vars, "memstats")
+}
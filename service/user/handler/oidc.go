@@ -0,0 +1,415 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"go.uber.org/zap"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+)
+
+// OIDCLinker links or creates the user of an email verified by the OIDC provider & issues its token
+type OIDCLinker interface {
+	LoginOIDC(ctx context.Context, email, ip, userAgent string) (*pb.LoginResponse, error)
+}
+
+// WithOIDC serves login w the provider of config.OIDC at /auth/oidc/login & /auth/oidc/callback
+func WithOIDC(linker OIDCLinker) Option {
+	return func(h *Handler) {
+		h.oidcLinker = linker
+	}
+}
+
+const (
+	oidcCookie     = "oidc_login"
+	oidcCookiePath = "/auth/oidc"
+	// login must complete within
+	oidcLoginTTL = 10 * time.Minute
+	// unknown kids refetch provider keys at most once per interval
+	oidcKeysRefreshInterval = time.Minute
+	// allowed clock difference w the provider
+	oidcClockSkew      = time.Minute
+	defaultOIDCTimeout = 10 * time.Second
+	// random bytes of state, nonce & PKCE verifier
+	oidcRandomBytes = 32
+	// max size of provider responses
+	oidcMaxResponseSize = 1 << 20
+)
+
+var (
+	errOIDCState    = errors.Unauthenticated("Invalid OIDC login state", "state", "Login expired or was started in another browser, try again")
+	errOIDCDenied   = errors.Unauthenticated("OIDC login denied", "error", "Provider denied the login")
+	errOIDCToken    = errors.Unauthenticated("Invalid OIDC ID token", "id_token", "ID token of the provider is invalid")
+	errOIDCEmail    = errors.FailedPrecondition("OIDC email not verified", "EMAIL_VERIFICATION", map[string]string{"email": "Provider account has no verified email"})
+	errOIDCProvider = errors.Unavailable("OIDC provider unavailable", errors.UnavailableRetryDelay)
+)
+
+// provider endpoints of the discovery document
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// audience claim, a string or an array
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(b []byte) error {
+	var aud string
+	if err := json.Unmarshal(b, &aud); err == nil {
+		*a = oidcAudience{aud}
+		return nil
+	}
+	var auds []string
+	if err := json.Unmarshal(b, &auds); err != nil {
+		return err
+	}
+	*a = auds
+	return nil
+}
+
+func (a oidcAudience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+type oidcClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        oidcAudience `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	ExpiresAt       int64        `json:"exp"`
+	IssuedAt        int64        `json:"iat"`
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   bool         `json:"email_verified"`
+}
+
+// claims are validated against the provider by verifyIDToken
+func (c *oidcClaims) Valid() error {
+	return nil
+}
+
+// login state kept in a cookie between login & callback
+type oidcLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// oidcProvider runs the authorization code flow w PKCE against an OpenID Connect provider,
+// discovery document & keys are fetched on first login so the gateway starts while the provider is down
+type oidcProvider struct {
+	config configs.OIDC
+	client *http.Client
+	logger log.Factory
+	// clock, replaced in tests
+	now func() time.Time
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+func newOIDCProvider(config *configs.OIDC) *oidcProvider {
+	cfg := *config
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email"}
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultOIDCTimeout
+	}
+	return &oidcProvider{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: log.With(zap.String("gateway", "oidc")),
+		now:    time.Now,
+	}
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return p.do(req, v)
+}
+
+func (p *oidcProvider) do(req *http.Request, v interface{}) error {
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(rsp.Body, oidcMaxResponseSize))
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL, rsp.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// discovery document, cached once fetched
+func (p *oidcProvider) metadata(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	d := &oidcDiscovery{}
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q doesn't match %q", d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("incomplete discovery document of %q", d.Issuer)
+	}
+	p.discovery = d
+	return d, nil
+}
+
+// verify key of kid, keys are refetched when kid is unknown
+func (p *oidcProvider) key(ctx context.Context, d *oidcDiscovery, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	now := p.now()
+	if now.Sub(p.keysFetchedAt) < oidcKeysRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	set := &utils.JWKS{}
+	if err := p.getJSON(ctx, d.JWKSURI, set); err != nil {
+		return nil, err
+	}
+	p.keysFetchedAt = now
+	p.keys = make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.PublicKey()
+		if err != nil {
+			// keys of unsupported types are skipped
+			p.logger.Bg().Error("Parse provider key", zap.Error(err))
+			continue
+		}
+		p.keys[k.Kid] = key
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// PKCE S256 challenge of verifier
+func oidcChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *oidcProvider) authURL(d *oidcDiscovery, login *oidcLogin) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {oidcChallenge(login.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// exchange authorization code for an ID token
+func (p *oidcProvider) exchange(ctx context.Context, d *oidcDiscovery, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// public clients authenticate w PKCE only
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	var rsp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &rsp); err != nil {
+		return "", err
+	}
+	if rsp.IDToken == "" {
+		return "", fmt.Errorf("token response w/o id_token")
+	}
+	return rsp.IDToken, nil
+}
+
+// verify signature & claims of ID token issued for nonce
+func (p *oidcProvider) verifyIDToken(ctx context.Context, d *oidcDiscovery, idToken, nonce string) (*oidcClaims, error) {
+	parser := &jwt.Parser{
+		ValidMethods:         []string{utils.AlgorithmRS256, utils.AlgorithmEdDSA},
+		SkipClaimsValidation: true,
+	}
+	claims := &oidcClaims{}
+	if _, err := parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, d, kid)
+	}); err != nil {
+		return nil, err
+	}
+	now := p.now()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.config.Issuer:
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, fmt.Errorf("unexpected audience %v", claims.Audience)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, fmt.Errorf("unexpected authorized party %q", claims.AuthorizedParty)
+	case !now.Before(time.Unix(claims.ExpiresAt, 0).Add(oidcClockSkew)):
+		return nil, fmt.Errorf("id token expired")
+	case now.Add(oidcClockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("id token issued in the future")
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("unexpected nonce")
+	}
+	return claims, nil
+}
+
+func (h *Handler) oidcCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookie, value, maxAge, oidcCookiePath, "", h.config.EnableTLS, true)
+}
+
+// write error as grpc-gateway does
+func (h *Handler) oidcError(c *gin.Context, err error) {
+	errors.CustomHTTPError(c.Request.Context(), nil, &runtime.JSONPb{OrigName: true}, c.Writer, c.Request, err)
+	c.Abort()
+}
+
+// redirect to the provider w a new login state
+func (h *Handler) oidcLogin(c *gin.Context) {
+	ctx := c.Request.Context()
+	d, err := h.oidc.metadata(ctx)
+	if err != nil {
+		h.logger.For(ctx).Error("Fetch OIDC discovery", zap.Error(err))
+		h.oidcError(c, errOIDCProvider)
+		return
+	}
+	login := &oidcLogin{}
+	for _, v := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		if *v, err = utils.GenToken(oidcRandomBytes); err != nil {
+			h.logger.For(ctx).Error("Gen OIDC login state", zap.Error(err))
+			h.oidcError(c, err)
+			return
+		}
+	}
+	state, err := json.Marshal(login)
+	if err != nil {
+		h.oidcError(c, err)
+		return
+	}
+	h.oidcCookie(c, base64.RawURLEncoding.EncodeToString(state), int(oidcLoginTTL.Seconds()))
+	c.Redirect(http.StatusFound, h.oidc.authURL(d, login))
+}
+
+// exchange code returned by the provider, link or create user of its verified email & return our token
+func (h *Handler) oidcCallback(c *gin.Context) {
+	ctx := c.Request.Context()
+	// login state is single use
+	cookie, _ := c.Cookie(oidcCookie)
+	h.oidcCookie(c, "", -1)
+
+	login := &oidcLogin{}
+	if raw, err := base64.RawURLEncoding.DecodeString(cookie); err != nil || json.Unmarshal(raw, login) != nil ||
+		login.State == "" || subtle.ConstantTimeCompare([]byte(login.State), []byte(c.Query("state"))) != 1 {
+		h.oidcError(c, errOIDCState)
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		h.logger.For(ctx).Error("OIDC login denied", zap.String("error", e), zap.String("description", c.Query("error_description")))
+		h.oidcError(c, errOIDCDenied)
+		return
+	}
+	d, err := h.oidc.metadata(ctx)
+	if err != nil {
+		h.logger.For(ctx).Error("Fetch OIDC discovery", zap.Error(err))
+		h.oidcError(c, errOIDCProvider)
+		return
+	}
+	idToken, err := h.oidc.exchange(ctx, d, c.Query("code"), login.Verifier)
+	if err != nil {
+		h.logger.For(ctx).Error("Exchange OIDC code", zap.Error(err))
+		h.oidcError(c, errOIDCDenied)
+		return
+	}
+	claims, err := h.oidc.verifyIDToken(ctx, d, idToken, login.Nonce)
+	if err != nil {
+		h.logger.For(ctx).Error("Verify OIDC id token", zap.Error(err))
+		h.oidcError(c, errOIDCToken)
+		return
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		h.oidcError(c, errOIDCEmail)
+		return
+	}
+	rsp, err := h.oidcLinker.LoginOIDC(ctx, claims.Email, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		h.logger.For(ctx).Error("OIDC login", zap.String("email", claims.Email), zap.Error(err))
+		h.oidcError(c, err)
+		return
+	}
+	h.logger.For(ctx).Info("OIDC login", zap.String("email", claims.Email), zap.String("sub", claims.Subject))
+	m := &runtime.JSONPb{OrigName: true}
+	body, err := m.Marshal(rsp)
+	if err != nil {
+		h.oidcError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, m.ContentType(), body)
+}
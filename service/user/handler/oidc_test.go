@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+)
+
+const (
+	testOIDCClientID    = "gateway"
+	testOIDCRedirectURL = "http://gateway.test/auth/oidc/callback"
+)
+
+// stand-in OpenID Connect provider issuing ID tokens of claims
+type testOIDCProvider struct {
+	*httptest.Server
+	keys      *utils.JWTManager
+	claims    jwt.MapClaims
+	challenge string
+	nonce     string
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	keys, err := utils.NewJWTManager(&configs.JWT{Algorithm: utils.AlgorithmRS256})
+	require.NoError(t, err)
+	p := &testOIDCProvider{keys: keys}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(p.keys.JWKS())
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != testOIDCClientID || q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+		p.challenge, p.nonce = q.Get("code_challenge"), q.Get("nonce")
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {"code"}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "code" || oidcChallenge(r.PostFormValue("code_verifier")) != p.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":            p.URL,
+			"sub":            "sub",
+			"aud":            []string{testOIDCClientID},
+			"azp":            testOIDCClientID,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          p.nonce,
+			"email":          "oidc@gmail.com",
+			"email_verified": true,
+		}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		idToken, err := p.keys.Generate(claims)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+type testOIDCLinker struct {
+	email string
+}
+
+func (l *testOIDCLinker) LoginOIDC(ctx context.Context, email, ip, userAgent string) (*pb.LoginResponse, error) {
+	l.email = email
+	return &pb.LoginResponse{User: &pb.User{Email: email}, Token: "token"}, nil
+}
+
+func TestHandler_OIDC(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		// tamper login state of the callback
+		login    func(l *oidcLogin, q url.Values)
+		noCookie bool
+		wantCode int
+	}{
+		{name: "Success", wantCode: http.StatusOK},
+		{name: "AudienceString", claims: jwt.MapClaims{"aud": testOIDCClientID}, wantCode: http.StatusOK},
+		{name: "MissingCookie", noCookie: true, wantCode: http.StatusUnauthorized},
+		{name: "StateMismatch", login: func(l *oidcLogin, q url.Values) { q.Set("state", "forged") }, wantCode: http.StatusUnauthorized},
+		{name: "Denied", login: func(l *oidcLogin, q url.Values) { q.Set("error", "access_denied") }, wantCode: http.StatusUnauthorized},
+		{name: "PKCEMismatch", login: func(l *oidcLogin, q url.Values) { l.Verifier = "forged" }, wantCode: http.StatusUnauthorized},
+		{name: "NonceMismatch", login: func(l *oidcLogin, q url.Values) { l.Nonce = "forged" }, wantCode: http.StatusUnauthorized},
+		{name: "Expired", claims: jwt.MapClaims{"exp": time.Now().Add(-2 * time.Minute).Unix()}, wantCode: http.StatusUnauthorized},
+		{name: "WrongAudience", claims: jwt.MapClaims{"aud": "other"}, wantCode: http.StatusUnauthorized},
+		{name: "WrongIssuer", claims: jwt.MapClaims{"iss": "https://evil.test"}, wantCode: http.StatusUnauthorized},
+		{name: "EmailNotVerified", claims: jwt.MapClaims{"email_verified": false}, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestOIDCProvider(t)
+			provider.claims = tt.claims
+			linker := &testOIDCLinker{}
+			h := NewHandler(&configs.ServiceConfig{OIDC: &configs.OIDC{
+				Issuer:      provider.URL,
+				ClientID:    testOIDCClientID,
+				RedirectURL: testOIDCRedirectURL,
+			}}, WithOIDC(linker))
+			r := h.initRouter(http.NotFoundHandler())
+
+			// login redirects to provider
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+			require.Equal(t, http.StatusFound, w.Code)
+			cookies := w.Result().Cookies()
+			require.Len(t, cookies, 1)
+			require.Equal(t, oidcCookie, cookies[0].Name)
+			require.True(t, cookies[0].HttpOnly)
+
+			// provider redirects back w code
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+			rsp, err := client.Get(w.Header().Get("Location"))
+			require.NoError(t, err)
+			rsp.Body.Close()
+			require.Equal(t, http.StatusFound, rsp.StatusCode)
+			callback, err := url.Parse(rsp.Header.Get("Location"))
+			require.NoError(t, err)
+			require.Equal(t, "/auth/oidc/callback", callback.Path)
+
+			q := callback.Query()
+			if tt.login != nil {
+				raw, err := base64.RawURLEncoding.DecodeString(cookies[0].Value)
+				require.NoError(t, err)
+				login := &oidcLogin{}
+				require.NoError(t, json.Unmarshal(raw, login))
+				tt.login(login, q)
+				raw, err = json.Marshal(login)
+				require.NoError(t, err)
+				cookies[0].Value = base64.RawURLEncoding.EncodeToString(raw)
+			}
+			req := httptest.NewRequest(http.MethodGet, callback.Path+"?"+q.Encode(), nil)
+			if !tt.noCookie {
+				req.AddCookie(cookies[0])
+			}
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			require.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantCode != http.StatusOK {
+				require.Empty(t, linker.email)
+				return
+			}
+			require.Equal(t, "oidc@gmail.com", linker.email)
+			var got map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			require.Equal(t, "token", got["token"])
+		})
+	}
+}
+
+func TestHandler_OIDCDisabled(t *testing.T) {
+	// linker w/o config
+	h := NewHandler(&configs.ServiceConfig{}, WithOIDC(&testOIDCLinker{}))
+	r := h.initRouter(http.NotFoundHandler())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
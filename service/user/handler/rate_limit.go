@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/ratelimit"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+)
+
+// UserIdentifier resolves user id of a validly signed access token
+type UserIdentifier interface {
+	UserID(accessToken string) (int64, error)
+}
+
+// WithRateLimiter limits requests w rules matching "<HTTP method> <path>", nil disables it
+func WithRateLimiter(limiter *ratelimit.Limiter, users UserIdentifier) Option {
+	return func(h *Handler) {
+		h.limiter = limiter
+		h.users = users
+	}
+}
+
+// caller of API key, access token & client IP, an invalid token identifies no user
+func (h *Handler) rateLimitIdentity(c *gin.Context) ratelimit.Identity {
+	id := ratelimit.Identity{
+		APIKey: strings.TrimSpace(c.GetHeader("X-Api-Key")),
+		IP:     utils.ForwardedClientIP(c.Request.RemoteAddr, c.Request.Header.Values("X-Forwarded-For"), h.trustedProxies),
+	}
+	if token := strings.TrimSpace(c.GetHeader("Authorization")); token != "" && h.users != nil {
+		if userID, err := h.users.UserID(strings.TrimPrefix(token, "Bearer ")); err == nil {
+			id.UserID = userID
+		}
+	}
+	return id
+}
+
+// reject requests over limits w 429 & Retry-After before proxying them
+func (h *Handler) rateLimit(c *gin.Context) {
+	method := c.Request.Method + " " + c.Request.URL.Path
+	if err := h.limiter.Allow(c.Request.Context(), method, h.rateLimitIdentity(c)); err != nil {
+		errors.CustomHTTPError(c.Request.Context(), nil, &runtime.JSONPb{OrigName: true}, c.Writer, c.Request, err)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/ratelimit"
+)
+
+// users of tokens named after them
+type testUserIdentifier map[string]int64
+
+func (u testUserIdentifier) UserID(accessToken string) (int64, error) {
+	if id, ok := u[accessToken]; ok {
+		return id, nil
+	}
+	return 0, errors.New("invalid token")
+}
+
+func TestHandler_RateLimit(t *testing.T) {
+	limiter, err := ratelimit.New("gateway", []*configs.RateLimitRule{
+		{Methods: []string{"GET /api/v1/users/*"}, Per: ratelimit.PerUser, Rate: 1},
+		{Methods: []string{"POST /api/v1/users/login"}, Per: ratelimit.PerIP, Rate: 0.5},
+	}, ratelimit.NewMemoryStore())
+	require.NoError(t, err)
+	h := NewHandler(&configs.ServiceConfig{}, WithRateLimiter(limiter, testUserIdentifier{"alice": 1, "bob": 2}))
+	r := h.initRouter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     map[string]string
+		remoteAddr string
+		wantCode   int
+		retryAfter string
+	}{
+		{name: "User", method: http.MethodGet, path: "/api/v1/users/1", header: map[string]string{"Authorization": "Bearer alice"}, wantCode: http.StatusOK},
+		{name: "UserLimited", method: http.MethodGet, path: "/api/v1/users/1", header: map[string]string{"Authorization": "Bearer alice"}, wantCode: http.StatusTooManyRequests, retryAfter: "1"},
+		{name: "OtherUser", method: http.MethodGet, path: "/api/v1/users/2", header: map[string]string{"Authorization": "Bearer bob"}, wantCode: http.StatusOK},
+		{name: "InvalidTokenNotLimitedPerUser", method: http.MethodGet, path: "/api/v1/users/1", header: map[string]string{"Authorization": "Bearer forged"}, wantCode: http.StatusOK},
+		{name: "IP", method: http.MethodPost, path: "/api/v1/users/login", remoteAddr: "127.0.0.1:5000", header: map[string]string{"X-Forwarded-For": "9.9.9.9"}, wantCode: http.StatusOK},
+		{name: "IPLimited", method: http.MethodPost, path: "/api/v1/users/login", remoteAddr: "127.0.0.1:5000", header: map[string]string{"X-Forwarded-For": "9.9.9.9"}, wantCode: http.StatusTooManyRequests, retryAfter: "2"},
+		{name: "OtherForwardedIP", method: http.MethodPost, path: "/api/v1/users/login", remoteAddr: "127.0.0.1:5000", header: map[string]string{"X-Forwarded-For": "8.8.8.8"}, wantCode: http.StatusOK},
+		// untrusted peers can't spoof their IP
+		{name: "UntrustedForwarded", method: http.MethodPost, path: "/api/v1/users/login", remoteAddr: "1.2.3.4:5000", header: map[string]string{"X-Forwarded-For": "7.7.7.7"}, wantCode: http.StatusOK},
+		{name: "UntrustedForwardedLimited", method: http.MethodPost, path: "/api/v1/users/login", remoteAddr: "1.2.3.4:5000", header: map[string]string{"X-Forwarded-For": "6.6.6.6"}, wantCode: http.StatusTooManyRequests, retryAfter: "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			require.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			require.Equal(t, tt.retryAfter, w.Header().Get("Retry-After"))
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/gorm"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// Default hold settings
+const (
+	DefaultHoldTTL           = 7 * 24 * time.Hour
+	DefaultHoldCheckInterval = time.Minute
+)
+
+// HoldExpiry voids pending holds past their expiry, releasing their funds
+type HoldExpiry struct {
+	dal      *postgres.DataAccessLayer
+	logger   log.Factory
+	interval time.Duration
+	// clock, replaced in tests
+	now func() time.Time
+}
+
+// NewHoldExpiry of config, w default interval if nil
+func NewHoldExpiry(dal *postgres.DataAccessLayer, config *configs.Holds) *HoldExpiry {
+	h := &HoldExpiry{
+		dal:      dal,
+		logger:   log.With(zap.String("srv", "hold-expiry")),
+		interval: DefaultHoldCheckInterval,
+		now:      time.Now,
+	}
+	if config != nil && config.CheckInterval > 0 {
+		h.interval = config.CheckInterval
+	}
+	return h
+}
+
+// Run voids expired holds every check interval until ctx is done
+func (h *HoldExpiry) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		if n, err := h.Expire(ctx); err != nil {
+			h.logger.For(ctx).Error("Error expire holds", zap.Int("voided", n), zap.Error(err))
+		} else if n > 0 {
+			h.logger.For(ctx).Info("Holds expired", zap.Int("voided", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Expire voids pending holds past their expiry, returns the number of holds voided
+func (h *HoldExpiry) Expire(ctx context.Context) (int, error) {
+	db := h.dal.GetDatabase()
+	if db == nil {
+		return 0, postgres.ErrNotConnected
+	}
+	now := h.now()
+
+	var ids []int64
+	if e := db.WithContext(ctx).Model(&model.Transaction{}).
+		Where("status = ? AND expires_at <= ?", pb.TransactionStatus_PENDING.String(), now).Pluck("id", &ids).Error; e != nil {
+		return 0, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	var (
+		voided   int
+		firstErr error
+	)
+	for _, id := range ids {
+		// set by the committed attempt only, transactions are retried
+		released := false
+		err := h.dal.Transaction(ctx, func(tx *gorm.DB) error {
+			released = false
+			var trans model.Transaction
+			if e := tx.Where("id = ?", id).First(&trans).Error; e == gorm.ErrRecordNotFound {
+				return nil
+			} else if e != nil {
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			// captured or voided meanwhile
+			if !trans.Pending() || trans.ExpiresAt == nil || now.Before(*trans.ExpiresAt) {
+				return nil
+			}
+			var acc model.Account
+			if e := tx.Where("id = ?", trans.AccountID).First(&acc).Error; e != nil {
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			if err := voidHold(tx, &acc, &trans); err != nil {
+				return err
+			}
+			released = true
+			return nil
+		}, postgres.WithIsolation(sql.LevelSerializable))
+		if released && err == nil {
+			voided++
+		}
+		if err != nil {
+			h.logger.For(ctx).Error("Error expire hold", zap.Int64("id", id), zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return voided, firstErr
+}
+
+// release funds of a pending hold of acc, within tx
+func voidHold(tx *gorm.DB, acc *model.Account, trans *model.Transaction) error {
+	acc.Held = math.Max(0, model.SumAmounts(acc.Held, -trans.Amount))
+	trans.Status = pb.TransactionStatus_VOIDED.String()
+	if e := tx.Save(trans).Error; e != nil {
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	if e := tx.Save(acc).Error; e != nil {
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return nil
+}
+
+// find account of user & its pending hold id, within tx
+func (u *userServiceImpl) findHold(ctx context.Context, tx *gorm.DB, userID, accountID, id int64) (*model.Account, *model.Transaction, error) {
+	var acc model.Account
+	if e := tx.Where(&model.Account{ID: accountID, UserID: userID}).Preload("Transactions", func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ?", id)
+	}).First(&acc).Error; e == gorm.ErrRecordNotFound {
+		return nil, nil, errorSrv.ErrAccountNotFound
+	} else if e != nil {
+		u.logger.For(ctx).Error("Error find account", zap.Error(e))
+		return nil, nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	if len(acc.Transactions) == 0 {
+		return nil, nil, errorSrv.ErrTransactionNotFound
+	}
+	trans := acc.Transactions[0]
+	if !trans.Pending() {
+		return nil, nil, errorSrv.ErrHoldNotPending
+	}
+	return &acc, trans, nil
+}
+
+// AuthorizeHold reserves funds of a withdrawal in the available balance, checked against limits as a withdrawal
+func (u *userServiceImpl) AuthorizeHold(ctx context.Context, req *pb.AuthorizeHoldRequest) (*pb.AuthorizeHoldResponse, error) {
+	// validate request
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if req.GetAccountId() == 0 {
+		return nil, errorSrv.ErrMissingAccountID
+	}
+	if req.GetAmount() <= 0 {
+		return nil, errorSrv.ErrInvalidTransactionAmountGT0
+	}
+
+	rsp := &pb.AuthorizeHoldResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var acc model.Account
+		if e := tx.Where(&model.Account{ID: req.GetAccountId(), UserID: req.GetUserId()}).First(&acc).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrAccountNotFound
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find account", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		if err := checkCurrency(&acc, req.GetCurrency()); err != nil {
+			return err
+		}
+		if err := checkAmount(&acc, req.GetAmount()); err != nil {
+			return err
+		}
+
+		now := u.now().Round(time.Millisecond)
+		if err := u.checkTransactionLimits(ctx, tx, &acc, req.GetAmount(), now, 0); err != nil {
+			return err
+		}
+		// check available balance, down to its overdraft limit
+		acc.Held = model.SumAmounts(acc.Held, req.GetAmount())
+		if acc.ExceedsOverdraft() {
+			return errorSrv.ErrInvalidWithdrawTransactionAmount
+		}
+
+		expiresAt := now.Add(u.holdTTL)
+		trans := &model.Transaction{
+			AccountID:       acc.ID,
+			Amount:          req.GetAmount(),
+			TransactionType: pb.TransactionType_WITHDRAW.String(),
+			Status:          pb.TransactionStatus_PENDING.String(),
+			ExpiresAt:       &expiresAt,
+			Currency:        acc.Currency,
+			CreatedAt:       now,
+		}
+		if err := trans.Validate(); err != nil {
+			u.logger.For(ctx).Error("Error validate hold", zap.Error(err))
+			return err
+		}
+		if e := tx.Create(trans).Error; e != nil {
+			u.logger.For(ctx).Error("Error create hold", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		if e := tx.Save(&acc).Error; e != nil {
+			u.logger.For(ctx).Error("Error update account held amount", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		rsp.Transaction = trans.Transform2GRPC()
+		return nil
+	}, postgres.WithIsolation(sql.LevelSerializable))
+	if err != nil {
+		return nil, err
+	}
+
+	// set header in your handler
+	md := metadata.Pairs("X-Http-Code", "201")
+	grpc.SetHeader(ctx, md)
+	return rsp, nil
+}
+
+// CaptureHold posts a pending hold w up to its amount, the rest is released
+func (u *userServiceImpl) CaptureHold(ctx context.Context, req *pb.CaptureHoldRequest) (*pb.CaptureHoldResponse, error) {
+	// validate request
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if req.GetAccountId() == 0 {
+		return nil, errorSrv.ErrMissingAccountID
+	}
+	if req.GetId() == 0 {
+		return nil, errorSrv.ErrMissingTransactionID
+	}
+	if req.GetAmount() < 0 {
+		return nil, errorSrv.ErrInvalidCaptureAmount
+	}
+
+	rsp := &pb.CaptureHoldResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		acc, trans, err := u.findHold(ctx, tx, req.GetUserId(), req.GetAccountId(), req.GetId())
+		if err != nil {
+			return err
+		}
+		// released by the expiry worker
+		if trans.ExpiresAt != nil && !u.now().Before(*trans.ExpiresAt) {
+			return errorSrv.ErrHoldExpired
+		}
+		amount := req.GetAmount()
+		if amount == 0 {
+			amount = trans.Amount
+		}
+		if amount > trans.Amount {
+			return errorSrv.ErrInvalidCaptureAmount
+		}
+		if err := checkAmount(acc, amount); err != nil {
+			return err
+		}
+
+		// funds were reserved, the available balance only grows by the released amount
+		acc.Held = math.Max(0, model.SumAmounts(acc.Held, -trans.Amount))
+		acc.Balance = model.SumAmounts(acc.Balance, -amount)
+		trans.Amount = amount
+		trans.Status = pb.TransactionStatus_POSTED.String()
+		trans.ExpiresAt = nil
+		if e := tx.Save(trans).Error; e != nil {
+			u.logger.For(ctx).Error("Error capture hold", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		if e := tx.Save(acc).Error; e != nil {
+			u.logger.For(ctx).Error("Error update account balance", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		rsp.Transaction = trans.Transform2GRPC()
+		return nil
+	}, postgres.WithIsolation(sql.LevelSerializable))
+	if err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// VoidHold releases a pending hold
+func (u *userServiceImpl) VoidHold(ctx context.Context, req *pb.VoidHoldRequest) (*pb.VoidHoldResponse, error) {
+	// validate request
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if req.GetAccountId() == 0 {
+		return nil, errorSrv.ErrMissingAccountID
+	}
+	if req.GetId() == 0 {
+		return nil, errorSrv.ErrMissingTransactionID
+	}
+
+	rsp := &pb.VoidHoldResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		acc, trans, err := u.findHold(ctx, tx, req.GetUserId(), req.GetAccountId(), req.GetId())
+		if err != nil {
+			return err
+		}
+		if err := voidHold(tx, acc, trans); err != nil {
+			u.logger.For(ctx).Error("Error void hold", zap.Error(err))
+			return err
+		}
+		rsp.Transaction = trans.Transform2GRPC()
+		return nil
+	}, postgres.WithIsolation(sql.LevelSerializable))
+	if err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
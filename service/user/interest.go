@@ -0,0 +1,379 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/gorm"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/money"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// DefaultInterestCheckInterval of the last closed day for accrual
+const DefaultInterestCheckInterval = time.Hour
+
+// days of a year of actual/365 accruals
+const interestDayCount = 365
+
+// layout of Account.AccrualDate, ordered as strings
+const accrualDateLayout = "2006-01-02"
+
+// InterestAccrual accrues interest of rate plans daily on positive balances,
+// posting it w the monthly fee of the plan at month end as system transactions
+type InterestAccrual struct {
+	dal      *postgres.DataAccessLayer
+	logger   log.Factory
+	interval time.Duration
+	// time zone of accrual days & month ends
+	location *time.Location
+	// clock, replaced in tests
+	now func() time.Time
+}
+
+// NewInterestAccrual of config, w default interval & UTC days if nil
+func NewInterestAccrual(dal *postgres.DataAccessLayer, config *configs.Interest) (*InterestAccrual, error) {
+	a := &InterestAccrual{
+		dal:      dal,
+		logger:   log.With(zap.String("srv", "interest-accrual")),
+		interval: DefaultInterestCheckInterval,
+		location: time.UTC,
+		now:      time.Now,
+	}
+	if config == nil {
+		return a, nil
+	}
+	if config.CheckInterval > 0 {
+		a.interval = config.CheckInterval
+	}
+	if config.Location != "" {
+		loc, err := time.LoadLocation(config.Location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interest location: %w", err)
+		}
+		a.location = loc
+	}
+	return a, nil
+}
+
+// LastClosedDay is yesterday in the accrual time zone, the day accrued by Run
+func (a *InterestAccrual) LastClosedDay() string {
+	return lastClosedDay(a.now(), a.location)
+}
+
+func lastClosedDay(now time.Time, loc *time.Location) string {
+	return now.In(loc).AddDate(0, 0, -1).Format(accrualDateLayout)
+}
+
+// Run accrues the last closed day every check interval until ctx is done
+func (a *InterestAccrual) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		if n, err := a.Accrue(ctx, a.LastClosedDay()); err != nil {
+			a.logger.For(ctx).Error("Error accrue interest", zap.Int("accrued", n), zap.Error(err))
+		} else if n > 0 {
+			a.logger.For(ctx).Info("Interest accrued", zap.Int("accrued", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckDay is a closed day (2006-01-02) to accrue through
+func (a *InterestAccrual) CheckDay(day string) error {
+	if _, err := time.Parse(accrualDateLayout, day); err != nil {
+		return fmt.Errorf("invalid accrual day %q, want 2006-01-02: %w", day, err)
+	}
+	// days accrued can't be accrued again w later balances
+	if day > a.LastClosedDay() {
+		return errorSrv.ErrAccrualDayNotClosed
+	}
+	return nil
+}
+
+// Accrue days through day (2006-01-02) on accounts w a rate plan, returns the number of accounts accrued.
+// Days missed since the last accrual are caught up, days accrued already are skipped,
+// even by concurrent service instances or jobs.
+func (a *InterestAccrual) Accrue(ctx context.Context, day string) (int, error) {
+	if err := a.CheckDay(day); err != nil {
+		return 0, err
+	}
+	end, _ := time.Parse(accrualDateLayout, day)
+	db := a.dal.GetDatabase()
+	if db == nil {
+		return 0, postgres.ErrNotConnected
+	}
+
+	var ids []int64
+	if e := db.WithContext(ctx).Model(&model.Account{}).Where("rate_plan_id IS NOT NULL AND accrual_date < ?", day).Pluck("id", &ids).Error; e != nil {
+		return 0, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	var (
+		count    int
+		firstErr error
+	)
+	for _, id := range ids {
+		// set by the committed attempt only, transactions are retried
+		accrued := false
+		err := a.dal.Transaction(ctx, func(tx *gorm.DB) error {
+			accrued = false
+			var acc model.Account
+			if e := tx.Where("id = ?", id).First(&acc).Error; e == gorm.ErrRecordNotFound {
+				return nil
+			} else if e != nil {
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			// detached or accrued by another instance meanwhile
+			if acc.RatePlanID == nil || acc.AccrualDate >= day {
+				return nil
+			}
+			var plan model.RatePlan
+			if e := tx.Where("id = ?", *acc.RatePlanID).First(&plan).Error; e != nil {
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			rate, err := money.ParseRate(plan.AnnualInterestRate)
+			if err != nil {
+				return fmt.Errorf("rate plan %d: %w", plan.ID, err)
+			}
+
+			from := end
+			if acc.AccrualDate != "" {
+				last, err := time.Parse(accrualDateLayout, acc.AccrualDate)
+				if err != nil {
+					return fmt.Errorf("account %d accrual date: %w", acc.ID, err)
+				}
+				from = last.AddDate(0, 0, 1)
+			}
+			for d := from; !d.After(end); d = d.AddDate(0, 0, 1) {
+				if err := a.accrueDay(tx, &acc, &plan, rate, d); err != nil {
+					return err
+				}
+			}
+			acc.AccrualDate = day
+			if e := tx.Save(&acc).Error; e != nil {
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			accrued = true
+			return nil
+		}, postgres.WithIsolation(sql.LevelSerializable))
+		if accrued && err == nil {
+			count++
+		}
+		if err != nil {
+			a.logger.For(ctx).Error("Error accrue interest", zap.Int64("account_id", id), zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return count, firstErr
+}
+
+// accrue interest of day d on acc, posting interest & fee of the plan if d is a month end, within tx
+func (a *InterestAccrual) accrueDay(tx *gorm.DB, acc *model.Account, plan *model.RatePlan, rate money.Rate, d time.Time) error {
+	balance, err := a.closingBalance(tx, acc, d)
+	if err != nil {
+		return err
+	}
+	if balance > 0 {
+		acc.AccruedInterest += balance.Mul(rate, 1, interestDayCount)
+	}
+	if d.AddDate(0, 0, 1).Day() != 1 {
+		return nil
+	}
+	// posted at the end of the month end
+	at := time.Date(d.Year(), d.Month(), d.Day(), 23, 59, 59, 0, a.location).UTC()
+	if err := postInterest(tx, acc, at); err != nil {
+		return err
+	}
+	if plan.MonthlyFee <= 0 {
+		return nil
+	}
+	fee := money.FromFloat(plan.MonthlyFee).Round(money.MinorUnit(acc.Currency))
+	if fee <= 0 {
+		return nil
+	}
+	if e := tx.Create(&model.Transaction{
+		AccountID:       acc.ID,
+		Amount:          fee.Float64(),
+		TransactionType: pb.TransactionType_FEE.String(),
+		Currency:        acc.Currency,
+		CreatedAt:       at,
+	}).Error; e != nil {
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	acc.Balance = (money.FromFloat(acc.Balance) - fee).Float64()
+	return nil
+}
+
+// balance of acc at the end of day d, the current one w/o posted transactions since then, within tx.
+// Caught up days accrue the same whenever they are accrued.
+func (a *InterestAccrual) closingBalance(tx *gorm.DB, acc *model.Account, d time.Time) (money.Amount, error) {
+	end := time.Date(d.Year(), d.Month(), d.Day()+1, 0, 0, 0, 0, a.location)
+	var since float64
+	// deleted transactions stay in the balance
+	if e := tx.Unscoped().Model(&model.Transaction{}).
+		Select("COALESCE(SUM(CASE WHEN transaction_type IN ? THEN amount ELSE -amount END), 0)", creditTransactionTypes).
+		Where("account_id = ? AND status = ? AND created_at >= ?", acc.ID, pb.TransactionStatus_POSTED.String(), end).
+		Scan(&since).Error; e != nil {
+		return 0, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return money.FromFloat(acc.Balance) - money.FromFloat(since), nil
+}
+
+// types of transactions adding to the balance, others are taken from it
+var creditTransactionTypes = []string{pb.TransactionType_DEPOSIT.String(), pb.TransactionType_INTEREST.String()}
+
+// post interest accrued on acc rounded to the minor unit of its currency, the rest is carried over, within tx.
+// Account is saved by the caller.
+func postInterest(tx *gorm.DB, acc *model.Account, at time.Time) error {
+	interest := acc.AccruedInterest.Round(money.MinorUnit(acc.Currency))
+	if interest <= 0 {
+		return nil
+	}
+	if e := tx.Create(&model.Transaction{
+		AccountID:       acc.ID,
+		Amount:          interest.Float64(),
+		TransactionType: pb.TransactionType_INTEREST.String(),
+		Currency:        acc.Currency,
+		CreatedAt:       at,
+	}).Error; e != nil {
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	acc.Balance = (money.FromFloat(acc.Balance) + interest).Float64()
+	acc.AccruedInterest -= interest
+	return nil
+}
+
+// CreateRatePlan of interest & monthly fee, attached to accounts by SetAccountRatePlan
+func (u *userServiceImpl) CreateRatePlan(ctx context.Context, req *pb.CreateRatePlanRequest) (*pb.CreateRatePlanResponse, error) {
+	// validate request
+	name := strings.TrimSpace(req.GetName())
+	if name == "" {
+		return nil, errorSrv.ErrMissingRatePlanName
+	}
+	rate := strings.TrimSpace(req.GetAnnualInterestRate())
+	if rate == "" {
+		rate = "0"
+	}
+	if _, err := money.ParseRate(rate); err != nil {
+		return nil, errorSrv.ErrInvalidInterestRate
+	}
+	if req.GetMonthlyFee() < 0 {
+		return nil, errorSrv.ErrInvalidMonthlyFee
+	}
+
+	plan := &model.RatePlan{
+		Name:               name,
+		AnnualInterestRate: rate,
+		MonthlyFee:         req.GetMonthlyFee(),
+	}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var count int64
+		if e := tx.Model(&model.RatePlan{}).Where("name = ?", name).Count(&count).Error; e != nil {
+			u.logger.For(ctx).Error("Error find rate plan", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		if count > 0 {
+			return errorSrv.ErrDuplicateRatePlanName
+		}
+		if e := tx.Create(plan).Error; e != nil {
+			u.logger.For(ctx).Error("Error create rate plan", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// set header in your handler
+	md := metadata.Pairs("X-Http-Code", "201")
+	grpc.SetHeader(ctx, md)
+	return &pb.CreateRatePlanResponse{RatePlan: plan.Transform2GRPC()}, nil
+}
+
+// ListRatePlans available to accounts
+func (u *userServiceImpl) ListRatePlans(ctx context.Context, req *pb.ListRatePlansRequest) (*pb.ListRatePlansResponse, error) {
+	var plans []model.RatePlan
+	if e := u.reader(ctx).Order("id").Find(&plans).Error; e != nil {
+		u.logger.For(ctx).Error("Error list rate plans", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	rsp := &pb.ListRatePlansResponse{RatePlans: make([]*pb.RatePlan, len(plans))}
+	for i := range plans {
+		rsp.RatePlans[i] = plans[i].Transform2GRPC()
+	}
+	return rsp, nil
+}
+
+// SetAccountRatePlan attaches a rate plan to an account, accrued from the current day.
+// Detaching it posts the interest accrued so far.
+func (u *userServiceImpl) SetAccountRatePlan(ctx context.Context, req *pb.SetAccountRatePlanRequest) (*pb.SetAccountRatePlanResponse, error) {
+	// validate request
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if req.GetAccountId() == 0 {
+		return nil, errorSrv.ErrMissingAccountID
+	}
+
+	rsp := &pb.SetAccountRatePlanResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var acc model.Account
+		if e := tx.Where(&model.Account{ID: req.GetAccountId(), UserID: req.GetUserId()}).First(&acc).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrAccountNotFound
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find account", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+
+		now := u.now().Round(time.Millisecond)
+		if id := req.GetRatePlanId(); id == 0 {
+			if err := postInterest(tx, &acc, now); err != nil {
+				u.logger.For(ctx).Error("Error post interest", zap.Error(err))
+				return err
+			}
+			acc.RatePlanID = nil
+		} else if acc.RatePlanID == nil || *acc.RatePlanID != id {
+			var plan model.RatePlan
+			if e := tx.Select("id").Where("id = ?", id).First(&plan).Error; e == gorm.ErrRecordNotFound {
+				return errorSrv.ErrRatePlanNotFound
+			} else if e != nil {
+				u.logger.For(ctx).Error("Error find rate plan", zap.Error(e))
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			// closed days are not accrued by the new plan
+			if closed := lastClosedDay(now, u.interestLocation); acc.AccrualDate < closed {
+				acc.AccrualDate = closed
+			}
+			acc.RatePlanID = &id
+		}
+		if e := tx.Save(&acc).Error; e != nil {
+			u.logger.For(ctx).Error("Error update account rate plan", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		rsp.Account = acc.Transform2GRPC()
+		return nil
+	}, postgres.WithIsolation(sql.LevelSerializable))
+	if err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
@@ -0,0 +1,213 @@
+package user
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"go.uber.org/zap"
+)
+
+// Default login protection policy
+const (
+	DefaultLoginFreeAttempts    = 3
+	DefaultLoginBaseDelay       = time.Second
+	DefaultLoginMaxDelay        = 30 * time.Second
+	DefaultLoginMaxAttempts     = 10
+	DefaultLoginMaxIPAttempts   = 50
+	DefaultLoginLockoutDuration = 15 * time.Minute
+	DefaultLoginWindow          = 15 * time.Minute
+)
+
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginGuard tracks failed logins per email & per IP, delays attempts
+// progressively & locks them out after too many failures.
+// Unknown emails are tracked like existing ones so responses don't reveal which emails exist.
+// Attempts are kept in memory, per service instance.
+type LoginGuard struct {
+	policy configs.LoginProtection
+	logger log.Factory
+	// clock, replaced in tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	attempts  map[string]*loginAttempts
+	nextPrune time.Time
+}
+
+func NewLoginGuard(cfg *configs.LoginProtection) *LoginGuard {
+	policy := configs.LoginProtection{}
+	if cfg != nil {
+		policy = *cfg
+	}
+	if policy.FreeAttempts <= 0 {
+		policy.FreeAttempts = DefaultLoginFreeAttempts
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = DefaultLoginBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultLoginMaxDelay
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultLoginMaxAttempts
+	}
+	if policy.MaxIPAttempts <= 0 {
+		policy.MaxIPAttempts = DefaultLoginMaxIPAttempts
+	}
+	if policy.LockoutDuration <= 0 {
+		policy.LockoutDuration = DefaultLoginLockoutDuration
+	}
+	if policy.Window <= 0 {
+		policy.Window = DefaultLoginWindow
+	}
+	return &LoginGuard{
+		policy:   policy,
+		logger:   log.With(zap.String("srv", "login-guard")),
+		now:      time.Now,
+		attempts: make(map[string]*loginAttempts),
+	}
+}
+
+func loginEmailKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func loginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// keys tracked for an attempt, IP is unknown for in-process calls
+func loginKeys(email, ip string) []string {
+	keys := []string{loginEmailKey(email)}
+	if ip != "" {
+		keys = append(keys, loginIPKey(ip))
+	}
+	return keys
+}
+
+func (g *LoginGuard) maxAttempts(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return g.policy.MaxIPAttempts
+	}
+	return g.policy.MaxAttempts
+}
+
+// failed attempts w/o delay, scaled for IPs shared by many users (NAT, proxies)
+func (g *LoginGuard) freeAttempts(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return g.policy.FreeAttempts * g.policy.MaxIPAttempts / g.policy.MaxAttempts
+	}
+	return g.policy.FreeAttempts
+}
+
+// delay before next attempt of key after n failures
+func (g *LoginGuard) delay(key string, failures int) time.Duration {
+	free := g.freeAttempts(key)
+	if failures <= free {
+		return 0
+	}
+	d := g.policy.BaseDelay
+	for i := free + 1; i < failures && d < g.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > g.policy.MaxDelay {
+		d = g.policy.MaxDelay
+	}
+	return d
+}
+
+func (g *LoginGuard) expired(a *loginAttempts, now time.Time) bool {
+	return !now.Before(a.lastFailure.Add(g.policy.Window)) && !now.Before(a.lockedUntil)
+}
+
+// Check returns how long attempts of email from ip are rejected, 0 if allowed
+func (g *LoginGuard) Check(email, ip string) (retryAfter time.Duration, locked bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	for _, key := range loginKeys(email, ip) {
+		a, ok := g.attempts[key]
+		if !ok {
+			continue
+		}
+		if g.expired(a, now) {
+			delete(g.attempts, key)
+			continue
+		}
+		var wait time.Duration
+		if now.Before(a.lockedUntil) {
+			wait = a.lockedUntil.Sub(now)
+			locked = true
+		} else if until := a.lastFailure.Add(g.delay(key, a.failures)); now.Before(until) {
+			wait = until.Sub(now)
+		}
+		if wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	return retryAfter, locked
+}
+
+// Fail records a failed attempt, returns whether email or ip got locked out
+func (g *LoginGuard) Fail(email, ip string) (locked bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	g.prune(now)
+	for _, key := range loginKeys(email, ip) {
+		a, ok := g.attempts[key]
+		if !ok || g.expired(a, now) {
+			a = &loginAttempts{}
+			g.attempts[key] = a
+		}
+		a.failures++
+		a.lastFailure = now
+		if a.failures >= g.maxAttempts(key) && !now.Before(a.lockedUntil) {
+			a.lockedUntil = now.Add(g.policy.LockoutDuration)
+			locked = true
+			g.logger.Bg().Error("login locked out", zap.String("key", key), zap.Int("failures", a.failures), zap.Time("until", a.lockedUntil))
+		}
+	}
+	return locked
+}
+
+// Succeed clears failures of email, failures of ip are kept
+// so one valid account doesn't reset attempts on others
+func (g *LoginGuard) Succeed(email string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.attempts, loginEmailKey(email))
+}
+
+// Unlock clears failures & lockout of email and/or ip
+func (g *LoginGuard) Unlock(email, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if email != "" {
+		delete(g.attempts, loginEmailKey(email))
+	}
+	if ip != "" {
+		delete(g.attempts, loginIPKey(ip))
+	}
+}
+
+// drop expired attempts once per window
+func (g *LoginGuard) prune(now time.Time) {
+	if now.Before(g.nextPrune) {
+		return
+	}
+	for key, a := range g.attempts {
+		if g.expired(a, now) {
+			delete(g.attempts, key)
+		}
+	}
+	g.nextPrune = now.Add(g.policy.Window)
+}
@@ -0,0 +1,129 @@
+package user
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func newTestLoginGuard() (*LoginGuard, *time.Time) {
+	g := NewLoginGuard(&configs.LoginProtection{
+		FreeAttempts:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        4 * time.Second,
+		MaxAttempts:     6,
+		MaxIPAttempts:   8,
+		LockoutDuration: time.Minute,
+		Window:          10 * time.Minute,
+	})
+	now := time.Now()
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestLoginGuard(t *testing.T) {
+	g, now := newTestLoginGuard()
+	email, ip := "abc@gmail.com", "1.2.3.4"
+
+	// progressive delays after free attempts, capped to max delay
+	delays := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+	for _, want := range delays {
+		wait, locked := g.Check(email, ip)
+		require.Zero(t, wait)
+		require.False(t, locked)
+		require.False(t, g.Fail(email, ip))
+		wait, _ = g.Check(email, ip)
+		require.Equal(t, want, wait)
+		*now = now.Add(want)
+	}
+
+	// lockout after max attempts, email key is case insensitive
+	require.True(t, g.Fail("ABC@gmail.com", ip))
+	wait, locked := g.Check(email, "")
+	require.True(t, locked)
+	require.Equal(t, time.Minute, wait)
+	// other emails from same ip are delayed but not locked
+	wait, locked = g.Check("other@gmail.com", ip)
+	require.False(t, locked)
+	require.Equal(t, 4*time.Second, wait)
+
+	// lockout expires
+	*now = now.Add(time.Minute)
+	wait, _ = g.Check(email, ip)
+	require.Zero(t, wait)
+
+	// admin unlock
+	require.True(t, g.Fail(email, ip))
+	g.Unlock(email, "")
+	wait, locked = g.Check(email, "")
+	require.Zero(t, wait)
+	require.False(t, locked)
+	wait, _ = g.Check("", ip)
+	require.NotZero(t, wait)
+
+	// ip lockout after max ip attempts
+	require.True(t, g.Fail("other@gmail.com", ip))
+	_, locked = g.Check("new@gmail.com", ip)
+	require.True(t, locked)
+	g.Unlock("", ip)
+	_, locked = g.Check("new@gmail.com", ip)
+	require.False(t, locked)
+
+	// success clears email failures only
+	g.Fail(email, ip)
+	g.Fail(email, ip)
+	g.Fail(email, ip)
+	g.Succeed(email)
+	wait, _ = g.Check(email, "")
+	require.Zero(t, wait)
+	wait, _ = g.Check("", ip)
+	require.NotZero(t, wait)
+
+	// failures forgotten after window
+	*now = now.Add(10 * time.Minute)
+	wait, _ = g.Check(email, ip)
+	require.Zero(t, wait)
+	g.Fail(email, ip)
+	require.Len(t, g.attempts, 2)
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := utils.ParseTrustedProxies(nil)
+	require.NoError(t, err)
+	_, err = utils.ParseTrustedProxies([]string{"not-an-ip"})
+	require.Error(t, err)
+
+	tests := []struct {
+		name string
+		peer string
+		md   metadata.MD
+		want string
+	}{
+		{name: "Direct", peer: "1.2.3.4:5000", want: "1.2.3.4"},
+		{name: "UntrustedForwarded", peer: "1.2.3.4:5000", md: metadata.Pairs("x-forwarded-for", "9.9.9.9"), want: "1.2.3.4"},
+		{name: "TrustedForwarded", peer: "127.0.0.1:5000", md: metadata.Pairs("x-forwarded-for", "6.6.6.6, 9.9.9.9"), want: "9.9.9.9"},
+		{name: "TrustedNotForwarded", peer: "127.0.0.1:5000", want: "127.0.0.1"},
+		{name: "NoPeer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.peer != "" {
+				addr, err := net.ResolveTCPAddr("tcp", tt.peer)
+				require.NoError(t, err)
+				ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+			}
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+			require.Equal(t, tt.want, clientIP(ctx, trusted))
+		})
+	}
+}
@@ -6,30 +6,100 @@ import (
 
 	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/money"
 	"github.com/microcosm-cc/bluemonday"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gopkg.in/validator.v2"
 	"gorm.io/gorm"
 )
 
+// DefaultCurrency of accounts created w/o one
+const DefaultCurrency = "VND"
+
+// OnMinorUnit reports whether amount is in minor units of currency (the default one if empty), as balances are
+func OnMinorUnit(currency string, amount float64) bool {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	a := money.FromFloat(amount)
+	return a == a.Round(money.MinorUnit(currency))
+}
+
+// SumAmounts in exact micro units, as float sums of balances drift (ex: 3.3 - 1.1 - 2.2)
+func SumAmounts(amounts ...float64) float64 {
+	var sum money.Amount
+	for _, a := range amounts {
+		sum += money.FromFloat(a)
+	}
+	return sum.Float64()
+}
+
 type Account struct {
-	ID           int64          `json:"id"`
-	UserID       int64          `json:"user_id" validate:"nonzero"`
-	Name         string         `json:"name" validate:"max=100"`
-	Bank         string         `json:"bank" validate:"nonzero"`
-	Balance      float64        `json:"balance"`
+	ID      int64   `json:"id"`
+	UserID  int64   `json:"user_id" validate:"nonzero"`
+	Name    string  `json:"name" validate:"max=100"`
+	Bank    string  `json:"bank" validate:"nonzero"`
+	Balance float64 `json:"balance"`
+	// ISO 4217 code of balance & transactions
+	Currency string `json:"currency" gorm:"not null;default:'VND'"`
+	// balance may go down to -OverdraftLimit
+	OverdraftLimit float64 `json:"overdraft_limit" gorm:"not null;default:0"`
+	// amount of pending holds, not in the balance yet
+	Held float64 `json:"held" gorm:"not null;default:0"`
+	// day of the last overdraft fee posted, 2006-01-02 in the fee time zone
+	OverdraftFeeDate string `json:"-" gorm:"not null;default:''"`
+	// plan of interest & fees, none if nil
+	RatePlanID *int64 `json:"rate_plan_id" gorm:"index"`
+	// interest accrued since the last posting, in micro units
+	AccruedInterest money.Amount `json:"-" gorm:"not null;default:0"`
+	// last day accrued, 2006-01-02 in the interest time zone
+	AccrualDate  string         `json:"-" gorm:"not null;default:''"`
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	Transactions []*Transaction `json:"transactions"`
 }
 
+// OverdraftUsed is the overdrawn amount of a negative balance
+func (a *Account) OverdraftUsed() float64 {
+	if a.Balance < 0 {
+		return -a.Balance
+	}
+	return 0
+}
+
+// AvailableBalance is the balance less pending holds
+func (a *Account) AvailableBalance() float64 {
+	return a.Balance - a.Held
+}
+
+// ExceedsOverdraft reports whether the available balance is below the overdraft limit,
+// compared in minor units of the currency as float balances drift (ex: 3.3 - 1.1 - 2.2)
+func (a *Account) ExceedsOverdraft() bool {
+	currency := a.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	unit := money.MinorUnit(currency)
+	available := (money.FromFloat(a.Balance) - money.FromFloat(a.Held)).Round(unit)
+	return available < -money.FromFloat(a.OverdraftLimit).Round(unit)
+}
+
 func (a *Account) Transform2GRPC() *pb.Account {
 	acc := &pb.Account{
-		Id:        a.ID,
-		Name:      a.Name,
-		UserId:    a.UserID,
-		Balance:   a.Balance,
-		CreatedAt: timestamppb.New(a.CreatedAt),
+		Id:               a.ID,
+		Name:             a.Name,
+		UserId:           a.UserID,
+		Balance:          a.Balance,
+		LedgerBalance:    a.Balance,
+		AvailableBalance: a.AvailableBalance(),
+		OverdraftLimit:   a.OverdraftLimit,
+		OverdraftUsed:    a.OverdraftUsed(),
+		AccruedInterest:  a.AccruedInterest.Round(money.Cent).Float64(),
+		Currency:         a.Currency,
+		CreatedAt:        timestamppb.New(a.CreatedAt),
+	}
+	if a.RatePlanID != nil {
+		acc.RatePlanId = *a.RatePlanID
 	}
 	//
 	if bank, ok := pb.Bank_value[a.Bank]; ok {
@@ -80,6 +150,9 @@ func (a *Account) BeforeCreate(tx *gorm.DB) error {
 	if a.Name == "" {
 		a.Name = fmt.Sprintf("%d.%s", a.UserID, a.Bank)
 	}
+	if a.Currency == "" {
+		a.Currency = DefaultCurrency
+	}
 	return nil
 }
 
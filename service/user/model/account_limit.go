@@ -0,0 +1,46 @@
+package model
+
+import (
+	"time"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+)
+
+// TransactionLimits of an account, 0 is unlimited
+type TransactionLimits struct {
+	MaxWithdrawal     float64 `json:"max_withdrawal"`
+	DailyWithdrawal   float64 `json:"daily_withdrawal"`
+	MonthlyWithdrawal float64 `json:"monthly_withdrawal"`
+	DailyTransactions int     `json:"daily_transactions"`
+}
+
+// Unlimited reports whether no limit is set
+func (l TransactionLimits) Unlimited() bool {
+	return l == TransactionLimits{}
+}
+
+func (l TransactionLimits) Transform2GRPC() *pb.TransactionLimits {
+	return &pb.TransactionLimits{
+		MaxWithdrawal:     l.MaxWithdrawal,
+		DailyWithdrawal:   l.DailyWithdrawal,
+		MonthlyWithdrawal: l.MonthlyWithdrawal,
+		DailyTransactions: int32(l.DailyTransactions),
+	}
+}
+
+func TransactionLimitsFromGRPC(l *pb.TransactionLimits) TransactionLimits {
+	return TransactionLimits{
+		MaxWithdrawal:     l.GetMaxWithdrawal(),
+		DailyWithdrawal:   l.GetDailyWithdrawal(),
+		MonthlyWithdrawal: l.GetMonthlyWithdrawal(),
+		DailyTransactions: int(l.GetDailyTransactions()),
+	}
+}
+
+// AccountLimit overrides transaction limits of the account user tier
+type AccountLimit struct {
+	AccountID         int64 `json:"account_id" gorm:"primaryKey;autoIncrement:false"`
+	TransactionLimits `gorm:"embedded"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// APIKey is a personal key authenticating machine-to-machine requests of a user, only its hash is stored
+type APIKey struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id" gorm:"index"`
+	Name   string `json:"name"`
+	// first chars of the key, identifying it in lists
+	Prefix  string `json:"prefix"`
+	KeyHash string `json:"-" gorm:"uniqueIndex"`
+	// comma separated scopes
+	Scopes     string     `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (k *APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return nil
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+func (k *APIKey) SetScopes(scopes []string) {
+	k.Scopes = strings.Join(scopes, ",")
+}
+
+// Active reports whether the key authenticates requests at now
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+func (k *APIKey) Transform2GRPC() *pb.APIKey {
+	key := &pb.APIKey{
+		Id:        k.ID,
+		UserId:    k.UserID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    k.ScopeList(),
+		Revoked:   k.RevokedAt != nil,
+		CreatedAt: timestamppb.New(k.CreatedAt),
+	}
+	if k.ExpiresAt != nil {
+		key.ExpiresAt = timestamppb.New(*k.ExpiresAt)
+	}
+	if k.LastUsedAt != nil {
+		key.LastUsedAt = timestamppb.New(*k.LastUsedAt)
+	}
+	return key
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// EmailVerification is a single use token verifying an email of a user, only its hash is stored
+type EmailVerification struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id" gorm:"index"`
+	// email verified by the token, stale once user changed email
+	Email     string     `json:"email"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Login failure reasons
+const (
+	LoginReasonInvalidCredentials = "invalid_credentials"
+	LoginReasonInvalidTOTP        = "invalid_totp"
+	LoginReasonLocked             = "locked"
+)
+
+// LoginEvent records a login attempt of an existing user
+type LoginEvent struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id" gorm:"index"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (e *LoginEvent) Transform2GRPC() *pb.LoginEvent {
+	return &pb.LoginEvent{
+		Id:        e.ID,
+		Success:   e.Success,
+		Reason:    e.Reason,
+		Ip:        e.IP,
+		UserAgent: e.UserAgent,
+		CreatedAt: timestamppb.New(e.CreatedAt),
+	}
+}
@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// PasswordHistory keeps hashes of previous passwords of a user, new passwords must differ from them
+type PasswordHistory struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id" gorm:"index"`
+	Hash      string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// PasswordReset is a single use password reset token, only its hash is stored
+type PasswordReset struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RatePlan of interest accrued daily & fees posted monthly on the accounts it is attached to
+type RatePlan struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name" gorm:"uniqueIndex;not null"`
+	// exact decimal fraction, parsed by money.ParseRate
+	AnnualInterestRate string    `json:"annual_interest_rate" gorm:"not null;default:'0'"`
+	MonthlyFee         float64   `json:"monthly_fee" gorm:"not null;default:0"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+func (p *RatePlan) Transform2GRPC() *pb.RatePlan {
+	return &pb.RatePlan{
+		Id:                 p.ID,
+		Name:               p.Name,
+		AnnualInterestRate: p.AnnualInterestRate,
+		MonthlyFee:         p.MonthlyFee,
+		CreatedAt:          timestamppb.New(p.CreatedAt),
+		UpdatedAt:          timestamppb.New(p.UpdatedAt),
+	}
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// RecoveryCode is a single use 2FA code replacing a lost authenticator, only its hash is stored
+type RecoveryCode struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id" gorm:"index"`
+	CodeHash  string     `json:"-" gorm:"index"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Schedule is a standing order posting a transaction on an account at times of its cron expression
+type Schedule struct {
+	ID              int64   `json:"id"`
+	UserID          int64   `json:"user_id" gorm:"index"`
+	AccountID       int64   `json:"account_id"`
+	Amount          float64 `json:"amount"`
+	TransactionType string  `json:"transaction_type"`
+	Cron            string  `json:"cron"`
+	// IANA time zone of the cron expression
+	TimeZone string     `json:"time_zone"`
+	StartAt  time.Time  `json:"start_at"`
+	EndAt    *time.Time `json:"end_at"`
+	// nil once ended
+	NextRunAt         *time.Time `json:"next_run_at" gorm:"index"`
+	LastRunAt         *time.Time `json:"last_run_at"`
+	LastTransactionID int64      `json:"last_transaction_id"`
+	LastError         string     `json:"last_error"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+func (s *Schedule) Transform2GRPC() *pb.Schedule {
+	schedule := &pb.Schedule{
+		Id:                s.ID,
+		UserId:            s.UserID,
+		AccountId:         s.AccountID,
+		Amount:            s.Amount,
+		Cron:              s.Cron,
+		TimeZone:          s.TimeZone,
+		StartAt:           timestamppb.New(s.StartAt),
+		LastTransactionId: s.LastTransactionID,
+		LastError:         s.LastError,
+		CreatedAt:         timestamppb.New(s.CreatedAt),
+		UpdatedAt:         timestamppb.New(s.UpdatedAt),
+	}
+	if t, ok := pb.TransactionType_value[s.TransactionType]; ok {
+		schedule.TransactionType = pb.TransactionType(t)
+	}
+	if s.EndAt != nil {
+		schedule.EndAt = timestamppb.New(*s.EndAt)
+	}
+	if s.NextRunAt != nil {
+		schedule.NextRunAt = timestamppb.New(*s.NextRunAt)
+	}
+	if s.LastRunAt != nil {
+		schedule.LastRunAt = timestamppb.New(*s.LastRunAt)
+	}
+	return schedule
+}
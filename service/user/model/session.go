@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Session is a login of a user, tokens carry its id & are rejected once it's revoked
+type Session struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id" gorm:"index"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	// expiry of the last token issued, extended by refresh
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Active reports whether tokens of the session are accepted at now
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+func (s *Session) Transform2GRPC() *pb.Session {
+	return &pb.Session{
+		Id:         s.ID,
+		Ip:         s.IP,
+		UserAgent:  s.UserAgent,
+		CreatedAt:  timestamppb.New(s.CreatedAt),
+		LastSeenAt: timestamppb.New(s.LastSeenAt),
+		ExpiresAt:  timestamppb.New(s.ExpiresAt),
+	}
+}
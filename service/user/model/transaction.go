@@ -11,25 +11,75 @@ import (
 )
 
 type Transaction struct {
-	ID              int64     `json:"id"`
-	AccountID       int64     `json:"account_id" validate:"nonzero"`
-	Amount          float64   `json:"amount" validate:"min=1"`
-	TransactionType string    `json:"transaction_type"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	ID              int64   `json:"id"`
+	AccountID       int64   `json:"account_id" validate:"nonzero"`
+	Amount          float64 `json:"amount"`
+	TransactionType string  `json:"transaction_type"`
+	// POSTED unless a hold
+	Status string `json:"status" gorm:"not null;default:'POSTED';index"`
+	// expiry of a pending hold
+	ExpiresAt *time.Time `json:"expires_at"`
+	// currency of the account
+	Currency string `json:"currency" gorm:"not null;default:'VND'"`
+	// transfer leg: rate of 1 withdrawn in the deposited currency,
+	// amount & currency of the other leg & its id, nil if not a transfer
+	FXRate               string    `json:"fx_rate" gorm:"not null;default:''"`
+	CounterAmount        float64   `json:"counter_amount" gorm:"not null;default:0"`
+	CounterCurrency      string    `json:"counter_currency" gorm:"not null;default:''"`
+	CounterTransactionID *int64    `json:"counter_transaction_id" gorm:"index"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+	// deleted transactions are hidden, still counted by limits
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// Transfer reports whether the transaction is a leg of a transfer
+func (t *Transaction) Transfer() bool {
+	return t.CounterTransactionID != nil
+}
+
+// Pending reports whether the transaction is a hold not captured nor voided yet
+func (t *Transaction) Pending() bool {
+	return t.Status == pb.TransactionStatus_PENDING.String()
+}
+
+// SystemTransactionTypes are posted by the service only, ex: fees & interest
+var SystemTransactionTypes = []string{pb.TransactionType_FEE.String(), pb.TransactionType_INTEREST.String()}
+
+// System reports whether the transaction was posted by the service
+func (t *Transaction) System() bool {
+	for _, typ := range SystemTransactionTypes {
+		if t.TransactionType == typ {
+			return true
+		}
+	}
+	return false
 }
 
 func (t *Transaction) Transform2GRPC() *pb.Transaction {
 	trans := &pb.Transaction{
-		Id:        t.ID,
-		AccountId: t.AccountID,
-		Amount:    t.Amount,
-		CreatedAt: timestamppb.New(t.CreatedAt),
+		Id:              t.ID,
+		AccountId:       t.AccountID,
+		Amount:          t.Amount,
+		Currency:        t.Currency,
+		FxRate:          t.FXRate,
+		CounterAmount:   t.CounterAmount,
+		CounterCurrency: t.CounterCurrency,
+		CreatedAt:       timestamppb.New(t.CreatedAt),
+	}
+	if t.CounterTransactionID != nil {
+		trans.CounterTransactionId = *t.CounterTransactionID
 	}
 	//
 	if t, ok := pb.TransactionType_value[t.TransactionType]; ok {
 		trans.TransactionType = pb.TransactionType(t)
 	}
+	if s, ok := pb.TransactionStatus_value[t.Status]; ok {
+		trans.Status = pb.TransactionStatus(s)
+	}
+	if t.ExpiresAt != nil {
+		trans.ExpiresAt = timestamppb.New(*t.ExpiresAt)
+	}
 	return trans
 }
 
@@ -65,10 +115,20 @@ func (t *Transaction) Validate() error {
 		}
 		return errors.BadRequest("validate failed", fields)
 	}
+	// ex: 0.5 USD, converted amounts of transfers may be below 1
+	if t.Amount <= 0 || !OnMinorUnit(t.Currency, t.Amount) {
+		return errors.BadRequest("validate failed", map[string]string{"Amount": "greater than zero, in minor units of the currency"})
+	}
 	return nil
 }
 
 func (t *Transaction) BeforeCreate(tx *gorm.DB) error {
+	if t.Status == "" {
+		t.Status = pb.TransactionStatus_POSTED.String()
+	}
+	if t.Currency == "" {
+		t.Currency = DefaultCurrency
+	}
 	return nil
 }
 
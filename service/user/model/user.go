@@ -14,27 +14,61 @@ import (
 )
 
 type User struct {
-	ID        int64     `json:"id"`
-	Email     string    `gorm:"uniqueIndex" validate:"nonzero"`
-	Password  string    `json:"-"`
+	ID       int64  `json:"id"`
+	Email    string `gorm:"uniqueIndex" validate:"nonzero"`
+	Password string `json:"-"`
+	// verified w token sent on signup or email change
+	EmailVerified   bool       `json:"email_verified" gorm:"not null;default:false"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// TOTP 2FA: secret set on enrollment, enabled once confirmed w a code
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"totp_enabled" gorm:"not null;default:false"`
+	// time step of last accepted code, codes are single use
+	TOTPLastStep int64 `json:"-" gorm:"not null;default:0"`
+	// bumped on password change, revokes tokens issued before
+	TokenVersion      int64     `json:"-" gorm:"not null;default:0"`
+	PasswordChangedAt time.Time `json:"-"`
+	// tier of transaction limits, the default tier if empty
+	Tier      string    `json:"tier"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Accounts  []Account `json:"accounts"`
+
+	// plain password set by SetPassword, hashed on next save
+	passwordChanged bool
 }
 
 func (u *User) Transform2GRPC() *pb.User {
 	user := &pb.User{
-		Id:        u.ID,
-		Email:     u.Email,
-		CreatedAt: timestamppb.New(u.CreatedAt),
-		UpdatedAt: timestamppb.New(u.CreatedAt),
+		Id:            u.ID,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		TotpEnabled:   u.TOTPEnabled,
+		CreatedAt:     timestamppb.New(u.CreatedAt),
+		UpdatedAt:     timestamppb.New(u.CreatedAt),
 	}
 	return user
 }
 
+// UpdateFromGRPC updates profile fields, password is changed only by SetPassword
 func (u *User) UpdateFromGRPC(user *pb.User) {
-	u.Email = user.GetEmail()
-	u.Password = user.GetPassword()
+	u.SetEmail(user.GetEmail())
+}
+
+// SetEmail changes email, which must then be verified again
+func (u *User) SetEmail(email string) {
+	if strings.EqualFold(u.Email, email) {
+		return
+	}
+	u.Email = email
+	u.EmailVerified = false
+	u.EmailVerifiedAt = nil
+}
+
+// SetPassword sets a new plain password, hashed & revoking issued tokens on next save
+func (u *User) SetPassword(password string) {
+	u.Password = password
+	u.passwordChanged = true
 }
 
 func (u *User) cache() error {
@@ -53,6 +87,7 @@ func (u *User) hashPassword() error {
 		return errorSrv.ErrHashPassword
 	}
 	u.Password = hashedPassword
+	u.passwordChanged = false
 	return nil
 }
 
@@ -94,8 +129,19 @@ func (u *User) AfterCreate(tx *gorm.DB) error {
 }
 
 func (u *User) BeforeUpdate(tx *gorm.DB) error {
-	if err := u.hashPassword(); err != nil {
-		return err
+	// password is already hashed unless changed
+	if u.passwordChanged {
+		if err := u.hashPassword(); err != nil {
+			return err
+		}
+		u.TokenVersion++
+		u.PasswordChangedAt = time.Now()
+		// tokens are revoked, so are their sessions
+		if err := tx.Session(&gorm.Session{NewDB: true}).Model(&Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", u.ID).
+			UpdateColumn("revoked_at", u.PasswordChangedAt).Error; err != nil {
+			return err
+		}
 	}
 	u.Email = strings.ToLower(u.Email)
 	return nil
@@ -0,0 +1,40 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"go.uber.org/zap"
+)
+
+// Notifier delivers messages to users, ex: by email
+type Notifier interface {
+	// SendPasswordReset delivers a password reset token valid until expiresAt
+	SendPasswordReset(ctx context.Context, email, token string, expiresAt time.Time) error
+	// SendEmailVerification delivers a token verifying email valid until expiresAt
+	SendEmailVerification(ctx context.Context, email, token string, expiresAt time.Time) error
+}
+
+// LogNotifier writes messages to the log instead of delivering them, for development only
+type LogNotifier struct {
+	logger log.Factory
+}
+
+var _ Notifier = (*LogNotifier)(nil)
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{
+		logger: log.With(zap.String("srv", "notifier")),
+	}
+}
+
+func (n *LogNotifier) SendPasswordReset(ctx context.Context, email, token string, expiresAt time.Time) error {
+	n.logger.For(ctx).Info("password reset", zap.String("email", email), zap.String("token", token), zap.Time("expiresAt", expiresAt))
+	return nil
+}
+
+func (n *LogNotifier) SendEmailVerification(ctx context.Context, email, token string, expiresAt time.Time) error {
+	n.logger.For(ctx).Info("email verification", zap.String("email", email), zap.String("token", token), zap.Time("expiresAt", expiresAt))
+	return nil
+}
@@ -0,0 +1,81 @@
+package user
+
+import (
+	"context"
+	"strings"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// OIDCLinker logs in users federated by an OIDC provider of the gateway
+type OIDCLinker interface {
+	LoginOIDC(ctx context.Context, email, ip, userAgent string) (*pb.LoginResponse, error)
+}
+
+// LoginOIDC logs in the user of an email verified by the OIDC provider, creating it if unknown.
+// An unverified user w the email gets a random password: it may have been registered by someone
+// not owning the email, whose tokens are revoked
+func (u *userServiceImpl) LoginOIDC(ctx context.Context, email, ip, userAgent string) (*pb.LoginResponse, error) {
+	if !isValidEmail(email) {
+		return nil, errorSrv.ErrInvalidEmail
+	}
+	email = strings.ToLower(email)
+
+	var user model.User
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		e := tx.Where(&model.User{Email: email}).First(&user).Error
+		if e != nil && e != gorm.ErrRecordNotFound {
+			u.logger.For(ctx).Error("Error find user", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		if e == nil && user.EmailVerified {
+			return nil
+		}
+		// federated users log in w the provider, password is set by password reset
+		password, e2 := utils.GenToken(randomTokenBytes)
+		if e2 != nil {
+			u.logger.For(ctx).Error("Error gen password", zap.Error(e2))
+			return errorSrv.ErrHashPassword
+		}
+		now := u.now()
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+		if e == gorm.ErrRecordNotFound {
+			user.Email = email
+			user.Password = password
+			if e := user.Validate(); e != nil {
+				return e
+			}
+			if e := tx.Create(&user).Error; e != nil && dal.IsUniqueViolation(e) {
+				return errorSrv.ErrDuplicateEmail
+			} else if e != nil {
+				u.logger.For(ctx).Error("Error create user", zap.Error(e))
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			u.logger.For(ctx).Info("OIDC user created", zap.Int64("userID", user.ID))
+			return nil
+		}
+		user.SetPassword(password)
+		if e := tx.Save(&user).Error; e != nil {
+			u.logger.For(ctx).Error("Error link user", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		u.logger.For(ctx).Info("OIDC user linked", zap.Int64("userID", user.ID))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		return u.twoFactorChallenge(ctx, &user)
+	}
+	return u.loginSucceeded(ctx, &user, ip, userAgent)
+}
@@ -0,0 +1,205 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/fx"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/money"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// DefaultOverdraftCheckInterval of overdrawn accounts for the fee of the day
+const DefaultOverdraftCheckInterval = time.Hour
+
+// layout of Account.OverdraftFeeDate, ordered as strings
+const overdraftFeeDateLayout = "2006-01-02"
+
+// OverdraftFees posts a fee once a day as a FEE transaction on accounts w a negative balance
+type OverdraftFees struct {
+	dal    *postgres.DataAccessLayer
+	logger log.Factory
+	fee    float64
+	// currency of fee, converted at rates for accounts in other currencies
+	currency string
+	rates    fx.RateProvider
+	interval time.Duration
+	// time zone of fee days
+	location *time.Location
+	// clock, replaced in tests
+	now func() time.Time
+}
+
+// NewOverdraftFees of config, nil if no fee is configured.
+// Accounts in other currencies than the fee one are charged only w rates.
+func NewOverdraftFees(dal *postgres.DataAccessLayer, config *configs.Overdraft, rates fx.RateProvider) (*OverdraftFees, error) {
+	if config == nil || config.DailyFee == 0 {
+		return nil, nil
+	}
+	if config.DailyFee < 0 {
+		return nil, fmt.Errorf("invalid overdraft daily fee %v", config.DailyFee)
+	}
+	o := &OverdraftFees{
+		dal:      dal,
+		logger:   log.With(zap.String("srv", "overdraft-fees")),
+		fee:      config.DailyFee,
+		currency: model.DefaultCurrency,
+		rates:    rates,
+		interval: DefaultOverdraftCheckInterval,
+		location: time.UTC,
+		now:      time.Now,
+	}
+	if config.CheckInterval > 0 {
+		o.interval = config.CheckInterval
+	}
+	if config.Location != "" {
+		loc, err := time.LoadLocation(config.Location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid overdraft location: %w", err)
+		}
+		o.location = loc
+	}
+	if config.Currency != "" {
+		c, err := money.ParseCurrency(config.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid overdraft currency: %w", err)
+		}
+		o.currency = c
+	}
+	return o, nil
+}
+
+// Run posts fees every check interval until ctx is done
+func (o *OverdraftFees) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		if n, err := o.Charge(ctx); err != nil {
+			o.logger.For(ctx).Error("Error charge overdraft fees", zap.Int("charged", n), zap.Error(err))
+		} else if n > 0 {
+			o.logger.For(ctx).Info("Overdraft fees charged", zap.Int("charged", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Charge the fee of the day on overdrawn accounts not charged yet, returns the number of fees posted.
+// Accounts are charged once a day, even by concurrent service instances.
+func (o *OverdraftFees) Charge(ctx context.Context) (int, error) {
+	db := o.dal.GetDatabase()
+	if db == nil {
+		return 0, postgres.ErrNotConnected
+	}
+	now := o.now().Round(time.Millisecond)
+	day := now.In(o.location).Format(overdraftFeeDateLayout)
+
+	var ids []int64
+	if e := db.WithContext(ctx).Model(&model.Account{}).Where("balance < 0 AND overdraft_fee_date < ?", day).Pluck("id", &ids).Error; e != nil {
+		return 0, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	var (
+		charged  int
+		firstErr error
+	)
+	for _, id := range ids {
+		// set by the committed attempt only, transactions are retried
+		posted := false
+		err := o.dal.Transaction(ctx, func(tx *gorm.DB) error {
+			posted = false
+			var acc model.Account
+			if e := tx.Where("id = ?", id).First(&acc).Error; e == gorm.ErrRecordNotFound {
+				return nil
+			} else if e != nil {
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			// repaid or charged by another instance meanwhile
+			if acc.Balance >= 0 || acc.OverdraftFeeDate >= day {
+				return nil
+			}
+			amount, err := convertAmount(ctx, o.rates, o.fee, o.currency, acc.Currency)
+			if err != nil {
+				return fmt.Errorf("convert fee to %s: %w", acc.Currency, err)
+			}
+			// in minor units of the account, as balances are
+			charge := amount.Round(money.MinorUnit(acc.Currency)).Float64()
+			fee := &model.Transaction{
+				AccountID:       acc.ID,
+				Amount:          charge,
+				TransactionType: pb.TransactionType_FEE.String(),
+				Currency:        acc.Currency,
+				CreatedAt:       now,
+			}
+			if e := tx.Create(fee).Error; e != nil {
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			acc.Balance = model.SumAmounts(acc.Balance, -charge)
+			acc.OverdraftFeeDate = day
+			if e := tx.Save(&acc).Error; e != nil {
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			posted = true
+			return nil
+		}, postgres.WithIsolation(sql.LevelSerializable))
+		if posted && err == nil {
+			charged++
+		}
+		if err != nil {
+			o.logger.For(ctx).Error("Error charge overdraft fee", zap.Int64("account_id", id), zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return charged, firstErr
+}
+
+// SetOverdraftLimit of an account, lowering it below the overdraft used only blocks further withdrawals
+func (u *userServiceImpl) SetOverdraftLimit(ctx context.Context, req *pb.SetOverdraftLimitRequest) (*pb.SetOverdraftLimitResponse, error) {
+	// validate request
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if req.GetAccountId() == 0 {
+		return nil, errorSrv.ErrMissingAccountID
+	}
+	if req.GetOverdraftLimit() < 0 {
+		return nil, errorSrv.ErrInvalidOverdraftLimit
+	}
+
+	rsp := &pb.SetOverdraftLimitResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var acc model.Account
+		if e := tx.Where(&model.Account{ID: req.GetAccountId(), UserID: req.GetUserId()}).First(&acc).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrAccountNotFound
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find account", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		acc.OverdraftLimit = req.GetOverdraftLimit()
+		if e := tx.Save(&acc).Error; e != nil {
+			u.logger.For(ctx).Error("Error update account overdraft limit", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		rsp.Account = acc.Transform2GRPC()
+		return nil
+	}, postgres.WithIsolation(sql.LevelSerializable))
+	if err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
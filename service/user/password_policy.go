@@ -0,0 +1,152 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"unicode"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// DefaultPasswordMinLength of passwords w/o configured policy
+const DefaultPasswordMinLength = 8
+
+// PasswordPolicy validates new passwords of signup, password change & reset
+type PasswordPolicy struct {
+	minLength      int
+	minCharClasses int
+	rejectCommon   bool
+	historySize    int
+}
+
+// NewPasswordPolicy of config, min length only if nil
+func NewPasswordPolicy(config *configs.PasswordPolicy) *PasswordPolicy {
+	p := &PasswordPolicy{minLength: DefaultPasswordMinLength}
+	if config == nil {
+		return p
+	}
+	if config.MinLength > 0 {
+		p.minLength = config.MinLength
+	}
+	p.minCharClasses = config.MinCharClasses
+	p.rejectCommon = config.RejectCommon
+	p.historySize = config.HistorySize
+	return p
+}
+
+// Validate password against length, char classes & common passwords rules
+func (p *PasswordPolicy) Validate(password string) error {
+	if len([]rune(password)) < p.minLength {
+		return errorSrv.ErrWeakPassword(fmt.Sprintf("Password must be at least %d characters long", p.minLength))
+	}
+	if p.minCharClasses > 0 && passwordCharClasses(password) < p.minCharClasses {
+		return errorSrv.ErrWeakPassword(fmt.Sprintf("Password must contain %d of lowercase, uppercase, digits & symbols", p.minCharClasses))
+	}
+	if p.rejectCommon && isCommonPassword(password) {
+		return errorSrv.ErrWeakPassword("Password is too common")
+	}
+	return nil
+}
+
+// number of classes among lowercase, uppercase, digits & symbols in password
+func passwordCharClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// set new password of user unless reused, revoking issued tokens
+func (u *userServiceImpl) changePassword(ctx context.Context, tx *gorm.DB, user *model.User, password string) error {
+	if e := u.checkPasswordHistory(ctx, tx, user, password); e != nil {
+		return e
+	}
+	previous := user.Password
+	user.SetPassword(password)
+	if e := tx.Save(user).Error; e != nil {
+		u.logger.For(ctx).Error("Error update password", zap.Error(e))
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return u.recordPasswordHistory(ctx, tx, user.ID, previous)
+}
+
+// reject reuse of the last history size passwords of user, current one included
+func (u *userServiceImpl) checkPasswordHistory(ctx context.Context, tx *gorm.DB, user *model.User, password string) error {
+	if u.passwordPolicy.historySize <= 0 {
+		return nil
+	}
+	if utils.CompareHash(user.Password, password) == nil {
+		return errorSrv.ErrPasswordReused
+	}
+	if u.passwordPolicy.historySize == 1 {
+		return nil
+	}
+	var history []*model.PasswordHistory
+	if e := tx.Where(&model.PasswordHistory{UserID: user.ID}).Order("id DESC").Limit(u.passwordPolicy.historySize - 1).Find(&history).Error; e != nil {
+		u.logger.For(ctx).Error("Error find password history", zap.Error(e))
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	for _, h := range history {
+		if utils.CompareHash(h.Hash, password) == nil {
+			return errorSrv.ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+// keep hash of the password user had before a change, pruned to the history size w the current one
+func (u *userServiceImpl) recordPasswordHistory(ctx context.Context, tx *gorm.DB, userID int64, hash string) error {
+	if u.passwordPolicy.historySize <= 1 {
+		return nil
+	}
+	if e := tx.Create(&model.PasswordHistory{UserID: userID, Hash: hash}).Error; e != nil {
+		u.logger.For(ctx).Error("Error record password history", zap.Error(e))
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	keep := tx.Model(&model.PasswordHistory{}).Select("id").Where("user_id = ?", userID).Order("id DESC").Limit(u.passwordPolicy.historySize - 1)
+	if e := tx.Where("user_id = ? AND id NOT IN (?)", userID, keep).Delete(&model.PasswordHistory{}).Error; e != nil {
+		u.logger.For(ctx).Error("Error prune password history", zap.Error(e))
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return nil
+}
+
+// upgrade hash of a verified password to the configured algorithm, best effort.
+// Run out of the login transaction, a failed statement would abort it on postgres.
+func (u *userServiceImpl) rehashPassword(ctx context.Context, user *model.User, password string) {
+	if !utils.NeedsRehash(user.Password) {
+		return
+	}
+	hash, e := utils.GenHash(password)
+	if e != nil {
+		u.logger.For(ctx).Error("Error rehash password", zap.Error(e))
+		return
+	}
+	db := u.dal.GetDatabase()
+	if db == nil {
+		return
+	}
+	// same password, issued tokens stay valid; skipped if changed meanwhile
+	if e := db.WithContext(ctx).Model(&model.User{}).Where("id = ? AND password = ?", user.ID, user.Password).UpdateColumn("password", hash).Error; e != nil {
+		u.logger.For(ctx).Error("Error update password hash", zap.Error(e))
+		return
+	}
+	u.logger.For(ctx).Info("Password rehashed", zap.Int64("userID", user.ID))
+}
@@ -0,0 +1,79 @@
+package user
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	interceptor "github.com/1412335/moneyforward-go-coding-challenge/pkg/interceptor/server"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/ratelimit"
+	"go.uber.org/zap"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Rate limit interceptor rejects requests over token bucket limits of their method & caller,
+// checked before auth so abusive callers are rejected cheaply
+type RateLimitServerInterceptor struct {
+	limiter *ratelimit.Limiter
+	// user id of access tokens, w/o revocation checks
+	tokens         *TokenService
+	trustedProxies []*net.IPNet
+}
+
+var _ interceptor.ServerInterceptor = (*RateLimitServerInterceptor)(nil)
+
+func NewRateLimitServerInterceptor(limiter *ratelimit.Limiter, tokens *TokenService, trustedProxies []*net.IPNet) interceptor.ServerInterceptor {
+	return &RateLimitServerInterceptor{
+		limiter:        limiter,
+		tokens:         tokens,
+		trustedProxies: trustedProxies,
+	}
+}
+
+func (r *RateLimitServerInterceptor) Log() log.Factory {
+	return interceptor.DefaultLogger.With(zap.String("interceptor-name", "rate-limit"))
+}
+
+func (r *RateLimitServerInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return r.UnaryInterceptor
+}
+func (r *RateLimitServerInterceptor) Stream() grpc.StreamServerInterceptor {
+	return r.StreamInterceptor
+}
+
+// caller of API key, access token & client IP, an invalid token identifies no user
+func (r *RateLimitServerInterceptor) identity(ctx context.Context) ratelimit.Identity {
+	id := ratelimit.Identity{IP: clientIP(ctx, r.trustedProxies)}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return id
+	}
+	if key := md.Get(apiKeyHeader); len(key) > 0 {
+		id.APIKey = strings.TrimSpace(key[0])
+	}
+	if token := md.Get("authorization"); len(token) > 0 {
+		if userID, err := r.tokens.UserID(strings.TrimPrefix(strings.TrimSpace(token[0]), "Bearer ")); err == nil {
+			id.UserID = userID
+		}
+	}
+	return id
+}
+
+// unary request to grpc server
+func (r *RateLimitServerInterceptor) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	if err := r.limiter.Allow(ctx, info.FullMethod, r.identity(ctx)); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// stream request interceptor
+func (r *RateLimitServerInterceptor) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	if err := r.limiter.Allow(ss.Context(), info.FullMethod, r.identity(ss.Context())); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,96 @@
+package user
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/ratelimit"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+func TestRateLimitServerInterceptor(t *testing.T) {
+	tokenSrv := NewTokenService(&configs.JWT{SecretKey: "lu", Duration: 10 * time.Minute, Issuer: "lu"})
+	token, err := tokenSrv.Generate(&model.User{ID: 1, Email: "abc@gmail.com"}, 0)
+	require.NoError(t, err)
+	otherToken, err := tokenSrv.Generate(&model.User{ID: 2, Email: "def@gmail.com"}, 0)
+	require.NoError(t, err)
+	trusted, err := utils.ParseTrustedProxies(nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		rule *configs.RateLimitRule
+		// peer & metadata of the limited caller, then of a caller w its own bucket
+		peer, otherPeer string
+		md, otherMD     metadata.MD
+	}{
+		{
+			name:      "PerUser",
+			rule:      &configs.RateLimitRule{Methods: []string{"/user.UserService/*"}, Per: ratelimit.PerUser, Rate: 1},
+			peer:      "1.2.3.4:5000",
+			otherPeer: "1.2.3.4:5000",
+			md:        metadata.Pairs("authorization", "Bearer "+token),
+			otherMD:   metadata.Pairs("authorization", otherToken),
+		},
+		{
+			name:      "PerAPIKey",
+			rule:      &configs.RateLimitRule{Methods: []string{"/user.UserService/*"}, Per: ratelimit.PerAPIKey, Rate: 1},
+			peer:      "1.2.3.4:5000",
+			otherPeer: "1.2.3.4:5000",
+			md:        metadata.Pairs("x-api-key", "mfk_key"),
+			otherMD:   metadata.Pairs("x-api-key", "mfk_other"),
+		},
+		{
+			name:      "PerForwardedIP",
+			rule:      &configs.RateLimitRule{Methods: []string{"/user.UserService/Get"}, Per: ratelimit.PerIP, Rate: 1},
+			peer:      "127.0.0.1:5000",
+			otherPeer: "127.0.0.1:5000",
+			md:        metadata.Pairs("x-forwarded-for", "9.9.9.9"),
+			otherMD:   metadata.Pairs("x-forwarded-for", "8.8.8.8"),
+		},
+		{
+			// forged tokens identify no user, callers are still limited per IP
+			name:      "ForgedToken",
+			rule:      &configs.RateLimitRule{Methods: []string{"*"}, Per: ratelimit.PerIP, Rate: 1},
+			peer:      "1.2.3.4:5000",
+			otherPeer: "5.6.7.8:5000",
+			md:        metadata.Pairs("authorization", "Bearer forged"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := ratelimit.New("grpc", []*configs.RateLimitRule{tt.rule}, ratelimit.NewMemoryStore())
+			require.NoError(t, err)
+			r := NewRateLimitServerInterceptor(limiter, tokenSrv, trusted)
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+			info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"}
+			newCtx := func(p string, md metadata.MD) context.Context {
+				addr, err := net.ResolveTCPAddr("tcp", p)
+				require.NoError(t, err)
+				ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+				if md == nil {
+					md = metadata.MD{}
+				}
+				return metadata.NewIncomingContext(ctx, md)
+			}
+
+			_, err = r.UnaryInterceptor(newCtx(tt.peer, tt.md), nil, info, handler)
+			require.NoError(t, err)
+			_, err = r.UnaryInterceptor(newCtx(tt.peer, tt.md), nil, info, handler)
+			require.Equal(t, codes.ResourceExhausted, status.Code(err))
+			_, err = r.UnaryInterceptor(newCtx(tt.otherPeer, tt.otherMD), nil, info, handler)
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// DefaultSchedulerCheckInterval of due schedules
+const DefaultSchedulerCheckInterval = time.Minute
+
+// advisory lock of the scheduler, held by one replica at a time
+const schedulerLockKey int64 = 0x7363686564756c65
+
+// SchedulePoster posts transactions of due schedules, returns the number of transactions posted
+type SchedulePoster interface {
+	PostDueSchedules(ctx context.Context) (int, error)
+}
+
+// Scheduler posts due scheduled transactions every check interval, on the replica taking its lock
+type Scheduler struct {
+	dal      *postgres.DataAccessLayer
+	poster   SchedulePoster
+	logger   log.Factory
+	interval time.Duration
+}
+
+// NewScheduler of config, w default interval if nil
+func NewScheduler(dal *postgres.DataAccessLayer, poster SchedulePoster, config *configs.Scheduler) *Scheduler {
+	s := &Scheduler{
+		dal:      dal,
+		poster:   poster,
+		logger:   log.With(zap.String("srv", "scheduler")),
+		interval: DefaultSchedulerCheckInterval,
+	}
+	if config != nil && config.CheckInterval > 0 {
+		s.interval = config.CheckInterval
+	}
+	return s
+}
+
+// Run posts due schedules every check interval until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.post(ctx); err != nil {
+			s.logger.For(ctx).Error("Error post schedules", zap.Int("posted", n), zap.Error(err))
+		} else if n > 0 {
+			s.logger.For(ctx).Info("Schedules posted", zap.Int("posted", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// post due schedules unless another replica holds the lock
+func (s *Scheduler) post(ctx context.Context) (int, error) {
+	unlock, ok, err := s.dal.TryLock(ctx, schedulerLockKey)
+	if err != nil || !ok {
+		return 0, err
+	}
+	defer unlock()
+	return s.poster.PostDueSchedules(ctx)
+}
+
+// parse cron expression of a schedule & its time zone
+func parseSchedule(spec, timeZone string) (cron.Schedule, *time.Location, error) {
+	loc := time.UTC
+	if timeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timeZone); err != nil {
+			return nil, nil, errorSrv.ErrInvalidTimeZone
+		}
+	}
+	// time zone is a field of its own
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		return nil, nil, errorSrv.ErrInvalidScheduleCron
+	}
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, nil, errorSrv.ErrInvalidScheduleCron
+	}
+	return sched, loc, nil
+}
+
+// first run of a schedule at or after start & after now, nil if past end
+func nextRun(sched cron.Schedule, loc *time.Location, start, now time.Time, end *time.Time) *time.Time {
+	// next times are strictly after, in whole seconds
+	from := start.Add(-time.Second)
+	if from.Before(now) {
+		from = now
+	}
+	next := sched.Next(from.In(loc))
+	if next.IsZero() || (end != nil && next.After(*end)) {
+		return nil
+	}
+	next = next.UTC()
+	return &next
+}
+
+// rejections of a scheduled transaction are recorded on its schedule, other errors are retried
+func rejectedTransaction(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.NotFound:
+		return true
+	}
+	return false
+}
+
+// PostDueSchedules posts transactions of due schedules as CreateTransaction does, once per schedule run.
+// Runs missed while no scheduler was running are skipped.
+func (u *userServiceImpl) PostDueSchedules(ctx context.Context) (int, error) {
+	db := u.dal.GetDatabase()
+	if db == nil {
+		return 0, postgres.ErrNotConnected
+	}
+	now := u.now().Round(time.Millisecond)
+
+	var ids []int64
+	if e := db.WithContext(ctx).Model(&model.Schedule{}).Where("next_run_at <= ?", now).Order("next_run_at").Pluck("id", &ids).Error; e != nil {
+		return 0, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	var (
+		count    int
+		firstErr error
+	)
+	for _, id := range ids {
+		// set by the committed attempt only, transactions are retried
+		posted := false
+		err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+			posted = false
+			var s model.Schedule
+			if e := tx.Where("id = ?", id).First(&s).Error; e == gorm.ErrRecordNotFound {
+				return nil
+			} else if e != nil {
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			// posted by another run meanwhile
+			if s.NextRunAt == nil || s.NextRunAt.After(now) {
+				return nil
+			}
+			sched, loc, err := parseSchedule(s.Cron, s.TimeZone)
+			if err != nil {
+				// stored schedules are valid, stop it rather than retrying forever
+				s.NextRunAt = nil
+				s.LastError = status.Convert(err).Message()
+				return tx.Save(&s).Error
+			}
+
+			// owner may have lost what the schedule was created w, ex: verified email after a change
+			var trans *model.Transaction
+			err = u.checkScheduleOwner(ctx, tx, &s)
+			if err == nil {
+				trans, err = u.createTransaction(ctx, tx, &pb.CreateTransactionRequest{
+					UserId:          s.UserID,
+					AccountId:       s.AccountID,
+					Amount:          s.Amount,
+					TransactionType: pb.TransactionType(pb.TransactionType_value[s.TransactionType]),
+				})
+			}
+			switch {
+			case err == nil:
+				s.LastTransactionID = trans.ID
+				s.LastError = ""
+				posted = true
+			case rejectedTransaction(err):
+				// ex: insufficient balance, the run is skipped
+				u.logger.For(ctx).Info("Scheduled transaction rejected", zap.Int64("schedule", s.ID), zap.Error(err))
+				s.LastError = status.Convert(err).Message()
+			default:
+				return err
+			}
+			s.LastRunAt = &now
+			s.NextRunAt = nextRun(sched, loc, s.StartAt, now, s.EndAt)
+			if e := tx.Save(&s).Error; e != nil {
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			return nil
+		}, postgres.WithIsolation(sql.LevelSerializable))
+		if posted && err == nil {
+			count++
+		}
+		if err != nil {
+			u.logger.For(ctx).Error("Error post schedule", zap.Int64("id", id), zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return count, firstErr
+}
+
+// owner of schedule s may still post its transactions, within tx
+func (u *userServiceImpl) checkScheduleOwner(ctx context.Context, tx *gorm.DB, s *model.Schedule) error {
+	if !u.scheduleVerifiedEmail {
+		return nil
+	}
+	var user model.User
+	if e := tx.Select("id", "email_verified").Where("id = ?", s.UserID).First(&user).Error; e == gorm.ErrRecordNotFound {
+		return errorSrv.ErrUserNotFound
+	} else if e != nil {
+		u.logger.For(ctx).Error("Error find user", zap.Error(e))
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	if !user.EmailVerified {
+		return errorSrv.ErrEmailNotVerified
+	}
+	return nil
+}
+
+// check fields of a schedule & set its next run
+func (u *userServiceImpl) planSchedule(s *model.Schedule) error {
+	if s.Amount <= 0 {
+		return errorSrv.ErrInvalidTransactionAmountGT0
+	}
+	sched, loc, err := parseSchedule(s.Cron, s.TimeZone)
+	if err != nil {
+		return err
+	}
+	if s.EndAt != nil && !s.EndAt.After(s.StartAt) {
+		return errorSrv.ErrInvalidScheduleRange
+	}
+	if s.NextRunAt = nextRun(sched, loc, s.StartAt, u.now(), s.EndAt); s.NextRunAt == nil {
+		return errorSrv.ErrInvalidScheduleRange
+	}
+	return nil
+}
+
+// CreateSchedule of a transaction on an account of the user
+func (u *userServiceImpl) CreateSchedule(ctx context.Context, req *pb.CreateScheduleRequest) (*pb.CreateScheduleResponse, error) {
+	// validate request
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if err := validateTransaction(req.GetAccountId(), req.GetAmount(), req.GetTransactionType()); err != nil {
+		return nil, err
+	}
+	s := &model.Schedule{
+		UserID:          req.GetUserId(),
+		AccountID:       req.GetAccountId(),
+		Amount:          req.GetAmount(),
+		TransactionType: req.GetTransactionType().String(),
+		Cron:            strings.TrimSpace(req.GetCron()),
+		TimeZone:        req.GetTimeZone(),
+		StartAt:         u.now().Round(time.Millisecond).UTC(),
+	}
+	if req.GetStartAt() != nil {
+		s.StartAt = req.GetStartAt().AsTime().Round(time.Millisecond)
+	}
+	if req.GetEndAt() != nil {
+		end := req.GetEndAt().AsTime().Round(time.Millisecond)
+		s.EndAt = &end
+	}
+	if err := u.planSchedule(s); err != nil {
+		return nil, err
+	}
+
+	rsp := &pb.CreateScheduleResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var acc model.Account
+		if e := tx.Select("id", "currency").Where(&model.Account{ID: req.GetAccountId(), UserID: req.GetUserId()}).First(&acc).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrAccountNotFound
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find account", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		// rejected now rather than at each run
+		if err := checkAmount(&acc, s.Amount); err != nil {
+			return err
+		}
+		if e := tx.Create(s).Error; e != nil {
+			u.logger.For(ctx).Error("Error create schedule", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		rsp.Schedule = s.Transform2GRPC()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// set header in your handler
+	md := metadata.Pairs("X-Http-Code", "201")
+	grpc.SetHeader(ctx, md)
+	return rsp, nil
+}
+
+// ListSchedules of the user, or of one of its accounts
+func (u *userServiceImpl) ListSchedules(ctx context.Context, req *pb.ListSchedulesRequest) (*pb.ListSchedulesResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	q := u.reader(ctx).Where("user_id = ?", req.GetUserId())
+	if req.GetAccountId() != nil {
+		q = q.Where("account_id = ?", req.GetAccountId().Value)
+	}
+	var schedules []model.Schedule
+	if e := q.Order("id").Find(&schedules).Error; e != nil {
+		u.logger.For(ctx).Error("Error list schedules", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	rsp := &pb.ListSchedulesResponse{Schedules: make([]*pb.Schedule, len(schedules))}
+	for i := range schedules {
+		rsp.Schedules[i] = schedules[i].Transform2GRPC()
+	}
+	return rsp, nil
+}
+
+// find schedule id of user
+func (u *userServiceImpl) findSchedule(ctx context.Context, db *gorm.DB, userID, id int64) (*model.Schedule, error) {
+	var s model.Schedule
+	if e := db.Where("id = ? AND user_id = ?", id, userID).First(&s).Error; e == gorm.ErrRecordNotFound {
+		return nil, errorSrv.ErrScheduleNotFound
+	} else if e != nil {
+		u.logger.For(ctx).Error("Error find schedule", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return &s, nil
+}
+
+// GetSchedule of the user
+func (u *userServiceImpl) GetSchedule(ctx context.Context, req *pb.GetScheduleRequest) (*pb.GetScheduleResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if req.GetId() == 0 {
+		return nil, errorSrv.ErrMissingScheduleID
+	}
+	s, err := u.findSchedule(ctx, u.reader(ctx), req.GetUserId(), req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetScheduleResponse{Schedule: s.Transform2GRPC()}, nil
+}
+
+// UpdateSchedule amount or timing, its next run is planned again
+func (u *userServiceImpl) UpdateSchedule(ctx context.Context, req *pb.UpdateScheduleRequest) (*pb.UpdateScheduleResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if req.GetSchedule().GetId() == 0 {
+		return nil, errorSrv.ErrMissingScheduleID
+	}
+	paths := req.GetUpdateMask().GetPaths()
+	// If there is no update mask do a regular update
+	if len(paths) == 0 {
+		paths = []string{"amount", "cron", "time_zone", "start_at", "end_at"}
+	}
+
+	rsp := &pb.UpdateScheduleResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		s, err := u.findSchedule(ctx, tx, req.GetUserId(), req.GetSchedule().GetId())
+		if err != nil {
+			return err
+		}
+		update := req.GetSchedule()
+		for _, path := range paths {
+			switch strings.ToLower(path) {
+			case "amount":
+				var acc model.Account
+				if e := tx.Select("id", "currency").Where("id = ?", s.AccountID).First(&acc).Error; e == gorm.ErrRecordNotFound {
+					return errorSrv.ErrAccountNotFound
+				} else if e != nil {
+					u.logger.For(ctx).Error("Error find account", zap.Error(e))
+					return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+				}
+				if err := checkAmount(&acc, update.GetAmount()); err != nil {
+					return err
+				}
+				s.Amount = update.GetAmount()
+			case "cron":
+				s.Cron = strings.TrimSpace(update.GetCron())
+			case "time_zone":
+				s.TimeZone = update.GetTimeZone()
+			case "start_at":
+				// start is required, kept if unset
+				if update.GetStartAt() != nil {
+					s.StartAt = update.GetStartAt().AsTime().Round(time.Millisecond)
+				}
+			case "end_at":
+				s.EndAt = nil
+				if update.GetEndAt() != nil {
+					end := update.GetEndAt().AsTime().Round(time.Millisecond)
+					s.EndAt = &end
+				}
+			default:
+				return errors.BadRequest("invalid field specified", map[string]string{
+					"update_mask": fmt.Sprintf("The schedule message type does not have an updatable field called %q", path),
+				})
+			}
+		}
+		if err := u.planSchedule(s); err != nil {
+			return err
+		}
+		if e := tx.Save(s).Error; e != nil {
+			u.logger.For(ctx).Error("Error update schedule", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		rsp.Schedule = s.Transform2GRPC()
+		return nil
+	}, postgres.WithIsolation(sql.LevelSerializable))
+	if err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// DeleteSchedule of the user, transactions posted are kept
+func (u *userServiceImpl) DeleteSchedule(ctx context.Context, req *pb.DeleteScheduleRequest) (*pb.DeleteScheduleResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if req.GetId() == 0 {
+		return nil, errorSrv.ErrMissingScheduleID
+	}
+	db := u.dal.GetDatabase()
+	if db == nil {
+		return nil, errorSrv.ErrDBUnavailable
+	}
+	res := db.WithContext(ctx).Where("id = ? AND user_id = ?", req.GetId(), req.GetUserId()).Delete(&model.Schedule{})
+	if res.Error != nil {
+		u.logger.For(ctx).Error("Error delete schedule", zap.Error(res.Error))
+		return nil, dal.DatabaseError(res.Error, errorSrv.ErrConnectDB)
+	}
+	if res.RowsAffected == 0 {
+		return nil, errorSrv.ErrScheduleNotFound
+	}
+	return &pb.DeleteScheduleResponse{Id: req.GetId()}, nil
+}
@@ -2,73 +2,300 @@ package user
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
 
 	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/fx"
+	interceptor "github.com/1412335/moneyforward-go-coding-challenge/pkg/interceptor/server"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/money"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/ratelimit"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/server"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
 	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type Server struct {
-	server   *server.Server
-	tokenSrv *TokenService
-	dal      *postgres.DataAccessLayer
+	server     *server.Server
+	tokenSrv   *TokenService
+	dal        *postgres.DataAccessLayer
+	health     *health.Server
+	srvOptions []ServiceOption
+	api        pb.UserServiceServer
+	// buckets of rate limits, nil if disabled
+	rateStore      ratelimit.Store
+	gatewayLimiter *ratelimit.Limiter
+	// stop background db reconnect, health check, overdraft fees, hold expiry, scheduler & interest accrual
+	cancel context.CancelFunc
 }
 
-func NewServer(srvConfig *configs.ServiceConfig, opt ...server.Option) *Server {
-	// init postgres
-	dal, err := postgres.NewDataAccessLayer(context.Background(), srvConfig.Database)
-	if err != nil || dal.GetDatabase() == nil {
-		log.Error("init db failed", zap.Error(err))
-		return nil
-	}
-	// migrate db
-	if err := dal.GetDatabase().AutoMigrate(
-		&model.User{},
-		&model.Account{},
-		&model.Transaction{},
-	); err != nil {
-		log.Error("migrate db failed", zap.Error(err))
-		return nil
-	}
+func NewServer(srvConfig *configs.ServiceConfig, opt ...server.Option) (*Server, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 
 	// create server
+	dal := postgres.New(srvConfig.Database)
 	srv := &Server{
-		tokenSrv: NewTokenService(srvConfig.JWT),
+		tokenSrv: NewTokenService(srvConfig.JWT, WithTokenVersions(NewTokenVersionStore(dal)), WithSessions(NewSessionStore(dal))),
 		dal:      dal,
+		health:   health.NewServer(),
+		cancel:   cancel,
+	}
+
+	// algorithm of new password hashes, shared by all users of utils.GenHash
+	hasher, err := utils.NewPasswordHasher(srvConfig.PasswordHashing)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	utils.SetPasswordHasher(hasher)
+
+	// proxies trusted to forward client IP
+	trustedProxies, err := utils.ParseTrustedProxies(srvConfig.TrustedProxies)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// grpc methods & gateway requests limits share a store
+	var rateLimitInterceptor interceptor.ServerInterceptor
+	if cfg := srvConfig.RateLimit; cfg != nil {
+		if srv.rateStore, err = ratelimit.NewStore(cfg, srvConfig.Redis); err != nil {
+			cancel()
+			return nil, err
+		}
+		limiter, err := ratelimit.New("grpc", cfg.Rules, srv.rateStore)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if srv.gatewayLimiter, err = ratelimit.New("gateway", cfg.GatewayRules, srv.rateStore); err != nil {
+			cancel()
+			return nil, err
+		}
+		rateLimitInterceptor = NewRateLimitServerInterceptor(limiter, srv.tokenSrv, trustedProxies)
+	}
+	// limits of transactions per user tier
+	transactionLimits, err := NewTransactionLimitPolicy(srvConfig.TransactionLimits)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// exchange rates of transfers, same currency only w/o it
+	rates, err := fx.New(srvConfig.FX)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// daily fee of overdrawn accounts
+	overdraftFees, err := NewOverdraftFees(dal, srvConfig.Overdraft, rates)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// interest & monthly fees of rate plans
+	interestAccrual, err := NewInterestAccrual(dal, srvConfig.Interest)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// failed logins & 2FA codes share a guard
+	loginGuard := NewLoginGuard(srvConfig.LoginProtection)
+	twoFactor := NewTwoFactor(dal, loginGuard)
+	srv.srvOptions = []ServiceOption{
+		WithLoginGuard(loginGuard),
+		WithTwoFactor(twoFactor),
+		WithTrustedProxies(trustedProxies),
+		WithNotifier(NewLogNotifier()),
+		WithPasswordResetTTL(srvConfig.PasswordResetTTL),
+		WithPasswordPolicy(NewPasswordPolicy(srvConfig.PasswordPolicy)),
+		WithTransactionLimits(transactionLimits),
+		WithInterestLocation(interestAccrual.location),
+		WithRateProvider(rates),
+	}
+	if cfg := srvConfig.Holds; cfg != nil {
+		srv.srvOptions = append(srv.srvOptions, WithHoldTTL(cfg.TTL))
+	}
+	authOptions := []AuthOption{WithAPIKeys(NewAPIKeyStore(dal))}
+	if cfg := srvConfig.EmailVerification; cfg != nil {
+		srv.srvOptions = append(srv.srvOptions,
+			WithEmailVerificationTTL(cfg.TokenTTL),
+			WithScheduleVerifiedEmail(cfg.RequiredMethods["/user.UserService/CreateSchedule"] || cfg.RequiredMethods["/user.UserService/CreateTransaction"]),
+		)
+		authOptions = append(authOptions, WithVerifiedEmailRequired(cfg.RequiredMethods, NewEmailVerificationStore(dal)))
+	}
+	if cfg := srvConfig.TwoFactor; cfg != nil {
+		srv.srvOptions = append(srv.srvOptions, WithTOTPIssuer(cfg.Issuer), WithTOTPChallengeTTL(cfg.ChallengeTTL))
+		stepUpCurrency := model.DefaultCurrency
+		if cfg.StepUpWithdrawCurrency != "" {
+			if stepUpCurrency, err = money.ParseCurrency(cfg.StepUpWithdrawCurrency); err != nil {
+				cancel()
+				return nil, fmt.Errorf("invalid step-up withdraw currency: %w", err)
+			}
+		}
+		authOptions = append(authOptions,
+			WithStepUp(cfg.StepUpMethods, cfg.StepUpWithdrawAmount, twoFactor),
+			WithStepUpCurrency(stepUpCurrency, NewAccountCurrencyStore(dal), rates),
+		)
+	}
+
+	// init postgres w retries
+	if err := srv.connectDB(ctx); err != nil {
+		if !srvConfig.Database.AllowDegraded {
+			cancel()
+			return nil, err
+		}
+		// degraded mode: report NOT_SERVING & keep reconnecting
+		log.Error("init db failed, serving in degraded mode", zap.Error(err))
+		srv.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		go srv.reconnectDB(ctx)
+	} else {
+		go srv.watchDB(ctx)
+	}
+	// fees & interest are posted & holds expired once connected, by any instance
+	if overdraftFees != nil {
+		go overdraftFees.Run(ctx)
 	}
+	go NewHoldExpiry(dal, srvConfig.Holds).Run(ctx)
+	go interestAccrual.Run(ctx)
 
 	// auth server interceptor
-	authInterceptor := NewAuthServerInterceptor(srv.tokenSrv, srvConfig.AuthRequiredMethods)
+	authInterceptor := NewAuthServerInterceptor(srv.tokenSrv, srvConfig.AuthRequiredMethods, srvConfig.ServiceIdentities, srvConfig.Admins, authOptions...)
 
-	// append server options with logger + auth token interceptor
+	// append server options with logger + rate limit + db guard + auth token interceptor
+	if rateLimitInterceptor != nil {
+		opt = append(opt, server.WithInterceptors(rateLimitInterceptor))
+	}
 	opt = append(opt,
 		server.WithInterceptors(
 			// interceptor.NewSimpleServerInterceptor(),
+			NewDBGuardServerInterceptor(srv.dal),
 			authInterceptor,
 		),
 	)
 
 	// grpc server
 	s := server.NewServer(srvConfig, opt...)
+	if s == nil {
+		cancel()
+		return nil, errors.New("init grpc server failed")
+	}
 
 	srv.server = s
-	return srv
+	srv.api = NewUserService(srv.dal, srv.tokenSrv, srv.srvOptions...)
+	go NewScheduler(dal, srv.api.(SchedulePoster), srvConfig.Scheduler).Run(ctx)
+	return srv, nil
+}
+
+// TokenService issues & verifies tokens of users
+func (s *Server) TokenService() *TokenService {
+	return s.tokenSrv
+}
+
+// OIDCLinker logs in users federated by the gateway
+func (s *Server) OIDCLinker() OIDCLinker {
+	return s.api.(OIDCLinker)
+}
+
+// GatewayRateLimiter limits gateway requests, nil if disabled
+func (s *Server) GatewayRateLimiter() *ratelimit.Limiter {
+	return s.gatewayLimiter
+}
+
+// connect & migrate db, set serving status on success
+func (s *Server) connectDB(ctx context.Context) error {
+	db, err := s.dal.ConnectWithRetry(ctx)
+	if err != nil {
+		return err
+	}
+	// migrate db
+	if err := db.AutoMigrate(
+		&model.User{},
+		&model.Account{},
+		&model.Transaction{},
+		&model.LoginEvent{},
+		&model.PasswordReset{},
+		&model.EmailVerification{},
+		&model.RecoveryCode{},
+		&model.APIKey{},
+		&model.Session{},
+		&model.PasswordHistory{},
+		&model.AccountLimit{},
+		&model.Schedule{},
+		&model.RatePlan{},
+	); err != nil {
+		log.Error("migrate db failed", zap.Error(err))
+		return err
+	}
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	return nil
+}
+
+// keep reconnecting db in degraded mode until connected or stopped
+func (s *Server) reconnectDB(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(postgres.DefaultConnectMaxBackoff):
+		}
+		if err := s.connectDB(ctx); err != nil {
+			log.Error("reconnect db failed", zap.Error(err))
+			continue
+		}
+		log.Info("db connected, leaving degraded mode")
+		s.watchDB(ctx)
+		return
+	}
+}
+
+// report NOT_SERVING while db is unreachable,
+// the connection pool re-establishes connections by itself
+func (s *Server) watchDB(ctx context.Context) {
+	interval := s.dal.HealthCheckInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := s.dal.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			log.Error("ping db failed", zap.Error(err))
+			s.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		} else {
+			s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+		}
+	}
 }
 
 func (s *Server) Run() error {
 	return s.server.Run(func(srv *grpc.Server) error {
-		// implement service
-		api := NewUserService(s.dal, s.tokenSrv)
-
 		// register impl service
-		pb.RegisterUserServiceServer(srv, api)
+		pb.RegisterUserServiceServer(srv, s.api)
+
+		// health check
+		healthpb.RegisterHealthServer(srv, s.health)
 		return nil
 	}, func() {
+		// stop background jobs
+		s.cancel()
+		s.health.Shutdown()
+		// close redis connections of rate limits
+		if c, ok := s.rateStore.(io.Closer); ok {
+			c.Close()
+		}
 		// close db connection
 		defer s.dal.Disconnect()
 	})
@@ -0,0 +1,170 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// last seen time of sessions is updated at most once per interval
+const sessionTouchInterval = time.Minute
+
+// sessions looked up per request, read from the primary so revocations apply immediately
+type dbSessionStore struct {
+	dal    *postgres.DataAccessLayer
+	logger log.Factory
+	// clock, replaced in tests
+	now func() time.Time
+}
+
+var _ SessionStore = (*dbSessionStore)(nil)
+
+func NewSessionStore(dal *postgres.DataAccessLayer) SessionStore {
+	return &dbSessionStore{
+		dal:    dal,
+		logger: log.With(zap.String("srv", "session")),
+		now:    time.Now,
+	}
+}
+
+func (s *dbSessionStore) ActiveSession(ctx context.Context, userID, sessionID int64) error {
+	db := s.dal.GetDatabase()
+	if db == nil {
+		return errorSrv.ErrDBUnavailable
+	}
+	db = db.WithContext(ctx)
+	now := s.now()
+	var session model.Session
+	if e := db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; e == gorm.ErrRecordNotFound {
+		return errorSrv.ErrSessionRevoked
+	} else if e != nil {
+		s.logger.For(ctx).Error("Error find session", zap.Error(e))
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	if !session.Active(now) {
+		return errorSrv.ErrSessionRevoked
+	}
+	// best effort, a failed update doesn't reject the request
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		if e := db.Model(&model.Session{}).Where("id = ?", session.ID).UpdateColumn("last_seen_at", now).Error; e != nil {
+			s.logger.For(ctx).Error("Error update session last seen", zap.Error(e))
+		}
+	}
+	return nil
+}
+
+// start a session of user & issue its token
+func (u *userServiceImpl) startSession(ctx context.Context, db *gorm.DB, user *model.User, ip, ua string) (string, error) {
+	now := u.now()
+	session := &model.Session{
+		UserID:     user.ID,
+		IP:         ip,
+		UserAgent:  ua,
+		ExpiresAt:  now.Add(u.tokenSrv.TTL()),
+		LastSeenAt: now,
+	}
+	if e := db.Create(session).Error; e != nil {
+		u.logger.For(ctx).Error("Error create session", zap.Error(e))
+		return "", dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	token, e := u.tokenSrv.Generate(user, session.ID)
+	if e != nil {
+		u.logger.For(ctx).Error("Error gen token", zap.Error(e))
+		return "", errorSrv.ErrTokenGenerated
+	}
+	return token, nil
+}
+
+// extend session of a refreshed token & issue its new token
+func (u *userServiceImpl) extendSession(ctx context.Context, db *gorm.DB, user *model.User, sessionID int64, ip, ua string) (string, error) {
+	now := u.now()
+	res := db.Model(&model.Session{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, user.ID).
+		UpdateColumns(map[string]interface{}{
+			"expires_at":   now.Add(u.tokenSrv.TTL()),
+			"last_seen_at": now,
+			"ip":           ip,
+			"user_agent":   ua,
+		})
+	if res.Error != nil {
+		u.logger.For(ctx).Error("Error extend session", zap.Error(res.Error))
+		return "", dal.DatabaseError(res.Error, errorSrv.ErrConnectDB)
+	}
+	if res.RowsAffected == 0 {
+		return "", errorSrv.ErrSessionRevoked
+	}
+	token, e := u.tokenSrv.Generate(user, sessionID)
+	if e != nil {
+		u.logger.For(ctx).Error("Error gen token", zap.Error(e))
+		return "", errorSrv.ErrTokenGenerated
+	}
+	return token, nil
+}
+
+// revoke active sessions of user, all unless ids are given
+func (u *userServiceImpl) revokeSessions(ctx context.Context, db *gorm.DB, userID int64, ids ...int64) error {
+	db = db.Model(&model.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if len(ids) > 0 {
+		db = db.Where("id IN ?", ids)
+	}
+	if e := db.UpdateColumn("revoked_at", u.now()).Error; e != nil {
+		u.logger.For(ctx).Error("Error revoke sessions", zap.Error(e))
+		return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return nil
+}
+
+// active sessions of user, last seen first
+func (u *userServiceImpl) ListSessions(ctx context.Context, req *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	var sessions []*model.Session
+	if e := u.reader(ctx).Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", req.GetUserId(), u.now()).
+		Order("last_seen_at DESC, id DESC").Find(&sessions).Error; e != nil {
+		u.logger.For(ctx).Error("Error find sessions", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	var current int64
+	if claims := claimsFromContext(ctx); claims != nil {
+		current = claims.Session
+	}
+	rsp := &pb.ListSessionsResponse{
+		Sessions: make([]*pb.Session, len(sessions)),
+	}
+	for i, s := range sessions {
+		rsp.Sessions[i] = s.Transform2GRPC()
+		rsp.Sessions[i].Current = s.ID == current
+	}
+	return rsp, nil
+}
+
+// revoke a session of user, its tokens are rejected from now on
+func (u *userServiceImpl) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var session model.Session
+		if e := tx.Where("id = ? AND user_id = ?", req.GetId(), req.GetUserId()).First(&session).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrSessionNotFound
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find session", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		return u.revokeSessions(ctx, tx, req.GetUserId(), session.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.logger.For(ctx).Info("Session revoked", zap.Int64("userID", req.GetUserId()), zap.Int64("id", req.GetId()))
+	return &pb.RevokeSessionResponse{}, nil
+}
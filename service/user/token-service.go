@@ -1,11 +1,14 @@
 package user
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
 	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
 	"go.uber.org/zap"
 
@@ -16,31 +19,128 @@ type Claims struct {
 	jwt.StandardClaims
 	ID    int64  `json:"id"`
 	Email string `json:"email"`
+	// token version of user when issued
+	Version int64 `json:"ver,omitempty"`
+	// purpose of tokens other than access tokens
+	Purpose string `json:"pur,omitempty"`
+	// session of access tokens, rejected once revoked
+	Session int64 `json:"sid,omitempty"`
+}
+
+// purpose of tokens issued after password check of users w 2FA, exchanged by LoginTOTP
+const purposeTOTPChallenge = "totp_challenge"
+
+// TokenVersionStore looks up current token version of users,
+// tokens issued w an older version are revoked
+type TokenVersionStore interface {
+	TokenVersion(ctx context.Context, userID int64) (int64, error)
+}
+
+// SessionStore checks sessions of access tokens are active
+type SessionStore interface {
+	ActiveSession(ctx context.Context, userID, sessionID int64) error
 }
 
 type TokenService struct {
 	logger     log.Factory
 	jwtManager *utils.JWTManager
+	versions   TokenVersionStore
+	sessions   SessionStore
+}
+
+type TokenOption func(*TokenService)
+
+// WithTokenVersions rejects tokens revoked by a newer user token version
+func WithTokenVersions(store TokenVersionStore) TokenOption {
+	return func(t *TokenService) {
+		t.versions = store
+	}
+}
+
+func NewTokenService(config *configs.JWT, opts ...TokenOption) *TokenService {
+	logger := log.With(zap.String("srv", "token"))
+	jwtManager, err := utils.NewJWTManager(config)
+	if err != nil {
+		logger.Fatal("Failed to load jwt signing keys", zap.Error(err))
+		return nil
+	}
+	t := &TokenService{
+		logger:     logger,
+		jwtManager: jwtManager,
+	}
+	for _, o := range opts {
+		o(t)
+	}
+	return t
+}
+
+// WithSessions rejects tokens of revoked or expired sessions
+func WithSessions(store SessionStore) TokenOption {
+	return func(t *TokenService) {
+		t.sessions = store
+	}
+}
+
+// JWKS returns public keys verifying issued tokens
+func (t *TokenService) JWKS() *utils.JWKS {
+	return t.jwtManager.JWKS()
 }
 
-func NewTokenService(config *configs.JWT) *TokenService {
-	return &TokenService{
-		logger:     log.With(zap.String("srv", "token")),
-		jwtManager: utils.NewJWTManager(config),
+// TTL of access tokens
+func (t *TokenService) TTL() time.Duration {
+	return t.jwtManager.TokenDuration()
+}
+
+// Generate issues an access token of user session
+func (t *TokenService) Generate(user *model.User, sessionID int64) (string, error) {
+	claims := Claims{
+		StandardClaims: t.jwtManager.GetStandardClaims(),
+		ID:             user.ID,
+		Email:          user.Email,
+		Version:        user.TokenVersion,
+		Session:        sessionID,
 	}
+	return t.jwtManager.Generate(claims)
 }
 
-func (t *TokenService) Generate(user *model.User) (string, error) {
+// GenerateChallenge issues a token valid for ttl, exchanged for an access token by LoginTOTP
+func (t *TokenService) GenerateChallenge(user *model.User, ttl time.Duration) (string, error) {
 	claims := Claims{
 		StandardClaims: t.jwtManager.GetStandardClaims(),
 		ID:             user.ID,
 		Email:          user.Email,
+		Version:        user.TokenVersion,
+		Purpose:        purposeTOTPChallenge,
 	}
+	claims.ExpiresAt = time.Now().Add(ttl).Unix()
 	return t.jwtManager.Generate(claims)
 }
 
-func (t *TokenService) Verify(accessToken string) (*Claims, error) {
+// Verify parses access token & checks it's not revoked
+func (t *TokenService) Verify(ctx context.Context, accessToken string) (*Claims, error) {
+	return t.verify(ctx, accessToken, "")
+}
+
+// VerifyChallenge parses token issued by GenerateChallenge
+func (t *TokenService) VerifyChallenge(ctx context.Context, challengeToken string) (*Claims, error) {
+	return t.verify(ctx, challengeToken, purposeTOTPChallenge)
+}
+
+// UserID of a validly signed access token w/o revocation checks, identifying callers before auth
+func (t *TokenService) UserID(accessToken string) (int64, error) {
 	claims, err := t.jwtManager.Verify(accessToken, &Claims{})
+	if err != nil {
+		return 0, err
+	}
+	uc, ok := claims.(*Claims)
+	if !ok || uc.Purpose != "" {
+		return 0, fmt.Errorf("invalid access token")
+	}
+	return uc.ID, nil
+}
+
+func (t *TokenService) verify(ctx context.Context, token, purpose string) (*Claims, error) {
+	claims, err := t.jwtManager.Verify(token, &Claims{})
 	if err != nil {
 		t.logger.Bg().Error("verify token failed", zap.Error(err))
 		return nil, err
@@ -50,5 +150,29 @@ func (t *TokenService) Verify(accessToken string) (*Claims, error) {
 		t.logger.Bg().Error("invalid", zap.Any("claims", claims))
 		return nil, fmt.Errorf("invalid token claims")
 	}
+	// challenge tokens aren't access tokens & vice versa
+	if uc.Purpose != purpose {
+		return nil, fmt.Errorf("invalid token purpose %q", uc.Purpose)
+	}
+	if t.versions != nil {
+		version, err := t.versions.TokenVersion(ctx, uc.ID)
+		// deleted users are reported by methods looking them up
+		if err == errorSrv.ErrUserNotFound {
+			return uc, nil
+		}
+		if err != nil {
+			t.logger.For(ctx).Error("lookup token version failed", zap.Int64("id", uc.ID), zap.Error(err))
+			return nil, err
+		}
+		if uc.Version != version {
+			return nil, fmt.Errorf("token revoked")
+		}
+	}
+	// tokens issued before sessions have none
+	if t.sessions != nil && uc.Session != 0 {
+		if err := t.sessions.ActiveSession(ctx, uc.ID, uc.Session); err != nil {
+			return nil, err
+		}
+	}
 	return uc, nil
 }
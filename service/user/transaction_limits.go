@@ -0,0 +1,348 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/money"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// DefaultTransactionTier of users w/o tier, unlimited unless configured
+const DefaultTransactionTier = "standard"
+
+// Limits reported in ErrTransactionLimitExceeded
+const (
+	limitMaxWithdrawal     = "max_withdrawal"
+	limitDailyWithdrawal   = "daily_withdrawal"
+	limitMonthlyWithdrawal = "monthly_withdrawal"
+	limitDailyTransactions = "daily_transactions"
+)
+
+// TransactionLimitPolicy holds limits of user tiers, checked against transactions of all accounts of a user
+// (or of an account w its own limits) in the calendar day & month of its time zone
+type TransactionLimitPolicy struct {
+	defaultTier string
+	tiers       map[string]model.TransactionLimits
+	location    *time.Location
+	// currency of tier limits, own limits of accounts are in their currency
+	currency string
+}
+
+// NewTransactionLimitPolicy of config, unlimited if nil
+func NewTransactionLimitPolicy(config *configs.TransactionLimits) (*TransactionLimitPolicy, error) {
+	p := &TransactionLimitPolicy{
+		defaultTier: DefaultTransactionTier,
+		tiers:       map[string]model.TransactionLimits{DefaultTransactionTier: {}},
+		location:    time.UTC,
+		currency:    model.DefaultCurrency,
+	}
+	if config == nil {
+		return p, nil
+	}
+	if config.DefaultTier != "" {
+		p.defaultTier = strings.ToLower(config.DefaultTier)
+	}
+	// tiers names are case insensitive, as config keys
+	for name, l := range config.Tiers {
+		if l == nil {
+			l = &configs.TransactionLimit{}
+		}
+		limits := model.TransactionLimits{
+			MaxWithdrawal:     l.MaxWithdrawal,
+			DailyWithdrawal:   l.DailyWithdrawal,
+			MonthlyWithdrawal: l.MonthlyWithdrawal,
+			DailyTransactions: l.DailyTransactions,
+		}
+		if !validTransactionLimits(limits) {
+			return nil, fmt.Errorf("invalid transaction limits of tier %q", name)
+		}
+		p.tiers[strings.ToLower(name)] = limits
+	}
+	if _, ok := p.tiers[p.defaultTier]; !ok {
+		return nil, fmt.Errorf("default tier %q has no transaction limits", p.defaultTier)
+	}
+	if config.Location != "" {
+		loc, err := time.LoadLocation(config.Location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction limits location: %w", err)
+		}
+		p.location = loc
+	}
+	if config.Currency != "" {
+		c, err := money.ParseCurrency(config.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction limits currency: %w", err)
+		}
+		p.currency = c
+	}
+	return p, nil
+}
+
+func validTransactionLimits(l model.TransactionLimits) bool {
+	return l.MaxWithdrawal >= 0 && l.DailyWithdrawal >= 0 && l.MonthlyWithdrawal >= 0 && l.DailyTransactions >= 0
+}
+
+// tier of user, the default tier if unset or no longer configured
+func (p *TransactionLimitPolicy) tier(user *model.User) string {
+	if _, ok := p.tiers[user.Tier]; ok && user.Tier != "" {
+		return user.Tier
+	}
+	return p.defaultTier
+}
+
+// day & month windows containing at
+func (p *TransactionLimitPolicy) windows(at time.Time) (dayStart, dayEnd, monthStart, monthEnd time.Time) {
+	at = at.In(p.location)
+	dayStart = time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, p.location)
+	monthStart = time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, p.location)
+	return dayStart, dayStart.AddDate(0, 0, 1), monthStart, monthStart.AddDate(0, 1, 0)
+}
+
+// usage of limits by transactions of an account or a user
+type transactionLimitsUsage struct {
+	dailyWithdrawal   float64
+	monthlyWithdrawal float64
+	dailyTransactions int
+}
+
+func (l transactionLimitsUsage) Transform2GRPC() *pb.TransactionLimitsUsage {
+	return &pb.TransactionLimitsUsage{
+		DailyWithdrawal:   l.dailyWithdrawal,
+		MonthlyWithdrawal: l.monthlyWithdrawal,
+		DailyTransactions: int32(l.dailyTransactions),
+	}
+}
+
+// limits of account, its own or of its user tier
+func (u *userServiceImpl) accountLimits(ctx context.Context, db *gorm.DB, acc *model.Account) (limits model.TransactionLimits, tier string, own bool, err error) {
+	var user model.User
+	if e := db.Select("id", "tier").Where("id = ?", acc.UserID).First(&user).Error; e == gorm.ErrRecordNotFound {
+		return limits, "", false, errorSrv.ErrUserNotFound
+	} else if e != nil {
+		u.logger.For(ctx).Error("Error find user by id", zap.Error(e))
+		return limits, "", false, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	tier = u.transactionLimits.tier(&user)
+
+	var accLimit model.AccountLimit
+	if e := db.Where("account_id = ?", acc.ID).First(&accLimit).Error; e == gorm.ErrRecordNotFound {
+		return u.transactionLimits.tiers[tier], tier, false, nil
+	} else if e != nil {
+		u.logger.For(ctx).Error("Error find account limits", zap.Error(e))
+		return limits, "", false, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return accLimit.TransactionLimits, tier, true, nil
+}
+
+// currency of limits of acc, its own currency for its own limits
+func (u *userServiceImpl) limitsCurrency(acc *model.Account, own bool) string {
+	if own {
+		return acc.Currency
+	}
+	return u.transactionLimits.currency
+}
+
+// amount of currency in the limits currency, at the exchange rate of transfers
+func (u *userServiceImpl) limitsAmount(ctx context.Context, amount float64, currency, limitsCurrency string) (float64, error) {
+	converted, err := convertAmount(ctx, u.rates, amount, currency, limitsCurrency)
+	if err != nil {
+		u.logger.For(ctx).Error("Error convert to limits currency", zap.String("from", currency), zap.String("to", limitsCurrency), zap.Error(err))
+		return 0, errorSrv.ErrExchangeRateNotFound
+	}
+	return converted.Float64(), nil
+}
+
+// usage of limits by transactions in the day & month of at, w/o transaction excludeID, in the limits currency.
+// Own limits of acc are used by its transactions, tier limits by transactions of all accounts of its user.
+func (u *userServiceImpl) transactionLimitsUsage(ctx context.Context, db *gorm.DB, acc *model.Account, own bool, at time.Time, excludeID int64) (transactionLimitsUsage, error) {
+	var usage transactionLimitsUsage
+	limitsCurrency := u.limitsCurrency(acc, own)
+	dayStart, dayEnd, monthStart, monthEnd := u.transactionLimits.windows(at)
+	q := func() *gorm.DB {
+		// pending holds & deleted transactions count, voided ones don't
+		q := db.Unscoped().Model(&model.Transaction{}).Where("id <> ? AND status <> ?", excludeID, pb.TransactionStatus_VOIDED.String())
+		if own {
+			return q.Where("account_id = ?", acc.ID)
+		}
+		// tier limits can't be bypassed by splitting transactions across accounts
+		return q.Where("account_id IN (?)", db.Model(&model.Account{}).Select("id").Where("user_id = ?", acc.UserID))
+	}
+	withdraw := pb.TransactionType_WITHDRAW.String()
+	var count int64
+	// fees & interest posted by the service don't count
+	if e := q().Where("transaction_type NOT IN ? AND created_at >= ? AND created_at < ?", model.SystemTransactionTypes, dayStart, dayEnd).Count(&count).Error; e != nil {
+		u.logger.For(ctx).Error("Error count transactions", zap.Error(e))
+		return usage, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	usage.dailyTransactions = int(count)
+	// withdrawals of accounts in other currencies are summed per currency & converted
+	sum := func(start, end time.Time) (float64, error) {
+		var sums []struct {
+			Currency string
+			Amount   float64
+		}
+		if e := q().Where("transaction_type = ? AND created_at >= ? AND created_at < ?", withdraw, start, end).
+			Select("currency, SUM(amount) AS amount").Group("currency").Scan(&sums).Error; e != nil {
+			u.logger.For(ctx).Error("Error sum withdrawals", zap.Error(e))
+			return 0, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		var total float64
+		for _, s := range sums {
+			amount, err := u.limitsAmount(ctx, s.Amount, s.Currency, limitsCurrency)
+			if err != nil {
+				return 0, err
+			}
+			total += amount
+		}
+		return total, nil
+	}
+	var err error
+	if usage.dailyWithdrawal, err = sum(dayStart, dayEnd); err != nil {
+		return usage, err
+	}
+	if usage.monthlyWithdrawal, err = sum(monthStart, monthEnd); err != nil {
+		return usage, err
+	}
+	return usage, nil
+}
+
+// check a transaction of account at time at against its limits, in the transaction changing the balance.
+// withdrawal is the withdrawn amount (0 for deposits) in the account currency, a transaction excludeID being updated isn't counted again
+func (u *userServiceImpl) checkTransactionLimits(ctx context.Context, db *gorm.DB, acc *model.Account, withdrawal float64, at time.Time, excludeID int64) error {
+	limits, _, own, err := u.accountLimits(ctx, db, acc)
+	if err != nil || limits.Unlimited() {
+		return err
+	}
+	if withdrawal, err = u.limitsAmount(ctx, withdrawal, acc.Currency, u.limitsCurrency(acc, own)); err != nil {
+		return err
+	}
+	if limits.MaxWithdrawal > 0 && withdrawal > limits.MaxWithdrawal {
+		return errorSrv.ErrTransactionLimitExceeded(limitMaxWithdrawal, limits.MaxWithdrawal)
+	}
+	usage, err := u.transactionLimitsUsage(ctx, db, acc, own, at, excludeID)
+	if err != nil {
+		return err
+	}
+	// updates don't change the number of transactions
+	if limits.DailyTransactions > 0 && excludeID == 0 && usage.dailyTransactions >= limits.DailyTransactions {
+		return errorSrv.ErrTransactionLimitExceeded(limitDailyTransactions, 0)
+	}
+	if limits.DailyWithdrawal > 0 && usage.dailyWithdrawal+withdrawal > limits.DailyWithdrawal {
+		return errorSrv.ErrTransactionLimitExceeded(limitDailyWithdrawal, math.Max(0, limits.DailyWithdrawal-usage.dailyWithdrawal))
+	}
+	if limits.MonthlyWithdrawal > 0 && usage.monthlyWithdrawal+withdrawal > limits.MonthlyWithdrawal {
+		return errorSrv.ErrTransactionLimitExceeded(limitMonthlyWithdrawal, math.Max(0, limits.MonthlyWithdrawal-usage.monthlyWithdrawal))
+	}
+	return nil
+}
+
+// limits of an account & their usage in the current day & month
+func (u *userServiceImpl) GetTransactionLimits(ctx context.Context, req *pb.GetTransactionLimitsRequest) (*pb.GetTransactionLimitsResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if req.GetAccountId() == 0 {
+		return nil, errorSrv.ErrMissingAccountID
+	}
+	db := u.reader(ctx)
+	var acc model.Account
+	if e := db.Where(&model.Account{ID: req.GetAccountId(), UserID: req.GetUserId()}).First(&acc).Error; e == gorm.ErrRecordNotFound {
+		return nil, errorSrv.ErrAccountNotFound
+	} else if e != nil {
+		u.logger.For(ctx).Error("Error find account", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	limits, tier, own, err := u.accountLimits(ctx, db, &acc)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := u.transactionLimitsUsage(ctx, db, &acc, own, u.now(), 0)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetTransactionLimitsResponse{
+		Tier:          tier,
+		Limits:        limits.Transform2GRPC(),
+		AccountLimits: own,
+		Usage:         usage.Transform2GRPC(),
+		Currency:      u.limitsCurrency(&acc, own),
+	}, nil
+}
+
+// set tier of a user and/or limits of one of its accounts, admin only
+func (u *userServiceImpl) SetTransactionLimits(ctx context.Context, req *pb.SetTransactionLimitsRequest) (*pb.SetTransactionLimitsResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	tier := strings.ToLower(strings.TrimSpace(req.GetTier()))
+	if _, ok := u.transactionLimits.tiers[tier]; !ok && tier != "" {
+		return nil, errorSrv.ErrInvalidTier
+	}
+	limits := model.TransactionLimitsFromGRPC(req.GetLimits())
+	if !validTransactionLimits(limits) {
+		return nil, errorSrv.ErrInvalidTransactionLimit
+	}
+
+	rsp := &pb.SetTransactionLimitsResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		user, err := u.findUserByID(ctx, tx, req.GetUserId())
+		if err != nil {
+			return err
+		}
+		if tier != "" {
+			if e := tx.Model(user).UpdateColumn("tier", tier).Error; e != nil {
+				u.logger.For(ctx).Error("Error update user tier", zap.Error(e))
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			user.Tier = tier
+		}
+		rsp.Tier = u.transactionLimits.tier(user)
+		if req.GetAccountId() == 0 {
+			return nil
+		}
+
+		var acc model.Account
+		if e := tx.Where(&model.Account{ID: req.GetAccountId(), UserID: user.ID}).First(&acc).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrAccountNotFound
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find account", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		// w/o limits, the account gets back to its user tier
+		if req.GetLimits() == nil {
+			if e := tx.Where("account_id = ?", acc.ID).Delete(&model.AccountLimit{}).Error; e != nil {
+				u.logger.For(ctx).Error("Error delete account limits", zap.Error(e))
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			rsp.Limits = u.transactionLimits.tiers[rsp.Tier].Transform2GRPC()
+			return nil
+		}
+		accLimit := &model.AccountLimit{AccountID: acc.ID, TransactionLimits: limits}
+		if e := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "account_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"max_withdrawal", "daily_withdrawal", "monthly_withdrawal", "daily_transactions", "updated_at"}),
+		}).Create(accLimit).Error; e != nil {
+			u.logger.For(ctx).Error("Error save account limits", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		rsp.Limits = limits.Transform2GRPC()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.logger.For(ctx).Info("Transaction limits set", zap.Int64("userID", req.GetUserId()), zap.Int64("accountID", req.GetAccountId()), zap.String("tier", rsp.Tier))
+	return rsp, nil
+}
@@ -0,0 +1,177 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/gorm"
+
+	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/fx"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/money"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// CreateTransfer moves amount from an account of the user to any account, converted at the exchange rate
+// of their currencies. The withdrawal is checked against limits & overdraft of the source account.
+func (u *userServiceImpl) CreateTransfer(ctx context.Context, req *pb.CreateTransferRequest) (*pb.CreateTransferResponse, error) {
+	// validate request
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if req.GetFromAccountId() == 0 || req.GetToAccountId() == 0 {
+		return nil, errorSrv.ErrMissingAccountID
+	}
+	if req.GetFromAccountId() == req.GetToAccountId() {
+		return nil, errorSrv.ErrSameTransferAccount
+	}
+	if req.GetAmount() <= 0 {
+		return nil, errorSrv.ErrInvalidTransactionAmountGT0
+	}
+
+	rsp := &pb.CreateTransferResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var from, to model.Account
+		if e := tx.Where(&model.Account{ID: req.GetFromAccountId(), UserID: req.GetUserId()}).First(&from).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrAccountNotFound
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find account", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		if e := tx.Where("id = ?", req.GetToAccountId()).First(&to).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrAccountNotFound
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find account", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		if err := checkCurrency(&from, req.GetCurrency()); err != nil {
+			return err
+		}
+		if err := checkAmount(&from, req.GetAmount()); err != nil {
+			return err
+		}
+
+		// convert to the minor unit of the deposited currency
+		rate, err := u.exchangeRate(ctx, from.Currency, to.Currency)
+		if err != nil {
+			return err
+		}
+		converted := money.FromFloat(req.GetAmount()).Mul(rate, 1, 1).Round(money.MinorUnit(to.Currency))
+		if converted <= 0 {
+			return errorSrv.ErrInvalidTransactionAmountGT0
+		}
+
+		now := u.now().Round(time.Millisecond)
+		if err := u.checkTransactionLimits(ctx, tx, &from, req.GetAmount(), now, 0); err != nil {
+			return err
+		}
+		// check source balance, down to its overdraft limit
+		from.Balance = model.SumAmounts(from.Balance, -req.GetAmount())
+		if from.ExceedsOverdraft() {
+			return errorSrv.ErrInvalidWithdrawTransactionAmount
+		}
+		to.Balance = model.SumAmounts(to.Balance, converted.Float64())
+
+		// legs record the rate & the amount of each other
+		withdrawal := &model.Transaction{
+			AccountID:       from.ID,
+			Amount:          req.GetAmount(),
+			TransactionType: pb.TransactionType_WITHDRAW.String(),
+			Currency:        from.Currency,
+			FXRate:          rate.String(),
+			CounterAmount:   converted.Float64(),
+			CounterCurrency: to.Currency,
+			CreatedAt:       now,
+		}
+		deposit := &model.Transaction{
+			AccountID:       to.ID,
+			Amount:          converted.Float64(),
+			TransactionType: pb.TransactionType_DEPOSIT.String(),
+			Currency:        to.Currency,
+			FXRate:          rate.String(),
+			CounterAmount:   req.GetAmount(),
+			CounterCurrency: from.Currency,
+			CreatedAt:       now,
+		}
+		for _, trans := range []*model.Transaction{withdrawal, deposit} {
+			if err := trans.Validate(); err != nil {
+				u.logger.For(ctx).Error("Error validate trans", zap.Error(err))
+				return err
+			}
+			if e := tx.Create(trans).Error; e != nil {
+				u.logger.For(ctx).Error("Error create transaction", zap.Error(e))
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+		}
+		// link legs
+		withdrawal.CounterTransactionID = &deposit.ID
+		deposit.CounterTransactionID = &withdrawal.ID
+		for _, trans := range []*model.Transaction{withdrawal, deposit} {
+			if e := tx.Model(trans).Update("counter_transaction_id", trans.CounterTransactionID).Error; e != nil {
+				u.logger.For(ctx).Error("Error link transfer", zap.Error(e))
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+		}
+
+		// update balances
+		for _, acc := range []*model.Account{&from, &to} {
+			if e := tx.Save(acc).Error; e != nil {
+				u.logger.For(ctx).Error("Error update account balance", zap.Error(e))
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+		}
+		rsp.Withdrawal = withdrawal.Transform2GRPC()
+		rsp.Deposit = deposit.Transform2GRPC()
+		return nil
+	}, postgres.WithIsolation(sql.LevelSerializable))
+	if err != nil {
+		return nil, err
+	}
+
+	// set header in your handler
+	md := metadata.Pairs("X-Http-Code", "201")
+	grpc.SetHeader(ctx, md)
+	return rsp, nil
+}
+
+// rate of 1 from in to, as recorded on transfers
+func (u *userServiceImpl) exchangeRate(ctx context.Context, from, to string) (money.Rate, error) {
+	rate, err := exchangeRate(ctx, u.rates, from, to)
+	if err != nil {
+		u.logger.For(ctx).Error("Error get exchange rate", zap.String("from", from), zap.String("to", to), zap.Error(err))
+		return money.Rate{}, errorSrv.ErrExchangeRateNotFound
+	}
+	return rate, nil
+}
+
+// rate of 1 from in to of rates, same currency only if nil
+func exchangeRate(ctx context.Context, rates fx.RateProvider, from, to string) (money.Rate, error) {
+	if from == to {
+		return money.ParseRate("1")
+	}
+	if rates == nil {
+		return money.Rate{}, errorSrv.ErrExchangeRateNotFound
+	}
+	rate, err := rates.Rate(ctx, from, to)
+	if err != nil {
+		return money.Rate{}, err
+	}
+	// converted at the rounded rate recorded
+	return money.ParseRate(rate.String())
+}
+
+// amount of from converted in to at the exchange rate of transfers, in micro units
+func convertAmount(ctx context.Context, rates fx.RateProvider, amount float64, from, to string) (money.Amount, error) {
+	rate, err := exchangeRate(ctx, rates, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return money.FromFloat(amount).Mul(rate, 1, 1), nil
+}
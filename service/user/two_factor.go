@@ -0,0 +1,148 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// Default 2FA settings
+const (
+	DefaultTOTPIssuer       = "MoneyForward"
+	DefaultTOTPChallengeTTL = 5 * time.Minute
+)
+
+// recovery codes issued when 2FA is enabled, 80 bits each
+const (
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactor verifies TOTP & recovery codes of users w 2FA enabled
+type TwoFactor struct {
+	dal    *postgres.DataAccessLayer
+	logger log.Factory
+	// throttles step-up code guessing w a stolen token
+	guard *LoginGuard
+	// clock, replaced in tests
+	now func() time.Time
+}
+
+var _ StepUpVerifier = (*TwoFactor)(nil)
+
+func NewTwoFactor(dal *postgres.DataAccessLayer, guard *LoginGuard) *TwoFactor {
+	return &TwoFactor{
+		dal:    dal,
+		logger: log.With(zap.String("srv", "two-factor")),
+		guard:  guard,
+		now:    time.Now,
+	}
+}
+
+// recovery codes are case & separator insensitive
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// verify TOTP or unused recovery code of user & mark it used, within tx
+func (f *TwoFactor) verify(ctx context.Context, tx *gorm.DB, user *model.User, code string) error {
+	code = strings.TrimSpace(code)
+	if step, ok := utils.ValidateTOTP(user.TOTPSecret, code, f.now()); ok {
+		// each step is accepted once, a concurrent request w same code updates no row
+		res := tx.Model(&model.User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).UpdateColumn("totp_last_step", step)
+		if res.Error != nil {
+			f.logger.For(ctx).Error("Error use totp code", zap.Error(res.Error))
+			return dal.DatabaseError(res.Error, errorSrv.ErrConnectDB)
+		}
+		if res.RowsAffected == 0 {
+			return errorSrv.ErrTOTPInvalidCode
+		}
+		user.TOTPLastStep = step
+		return nil
+	}
+	res := tx.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(normalizeRecoveryCode(code))).
+		UpdateColumn("used_at", f.now())
+	if res.Error != nil {
+		f.logger.For(ctx).Error("Error use recovery code", zap.Error(res.Error))
+		return dal.DatabaseError(res.Error, errorSrv.ErrConnectDB)
+	}
+	if res.RowsAffected == 0 {
+		return errorSrv.ErrTOTPInvalidCode
+	}
+	f.logger.For(ctx).Info("Recovery code used", zap.Int64("id", user.ID))
+	return nil
+}
+
+// replace recovery codes of user, returned codes are shown once
+func (f *TwoFactor) newRecoveryCodes(ctx context.Context, tx *gorm.DB, userID int64) ([]string, error) {
+	if e := tx.Where(&model.RecoveryCode{UserID: userID}).Delete(&model.RecoveryCode{}).Error; e != nil {
+		f.logger.For(ctx).Error("Error delete recovery codes", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]*model.RecoveryCode, recoveryCodeCount)
+	b := make([]byte, recoveryCodeBytes)
+	for i := range codes {
+		if _, e := rand.Read(b); e != nil {
+			f.logger.For(ctx).Error("Error gen recovery code", zap.Error(e))
+			return nil, errorSrv.ErrTokenGenerated
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		// xxxx-xxxx-xxxx-xxxx
+		codes[i] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+		rows[i] = &model.RecoveryCode{
+			UserID:   userID,
+			CodeHash: utils.HashToken(normalizeRecoveryCode(code)),
+		}
+	}
+	if e := tx.Create(rows).Error; e != nil {
+		f.logger.For(ctx).Error("Error create recovery codes", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return codes, nil
+}
+
+// VerifyStepUp verifies 2FA code sent w a sensitive request of user,
+// users w/o 2FA enrolled aren't asked for a code
+func (f *TwoFactor) VerifyStepUp(ctx context.Context, userID int64, code string) error {
+	var email string
+	err := f.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var user model.User
+		if e := tx.Where("id = ?", userID).First(&user).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrUserNotFound
+		} else if e != nil {
+			f.logger.For(ctx).Error("Error find user", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		if !user.TOTPEnabled {
+			return nil
+		}
+		if code == "" {
+			return errorSrv.ErrTOTPRequired
+		}
+		email = user.Email
+		if wait, _ := f.guard.Check(email, ""); wait > 0 {
+			return errorSrv.ErrLoginThrottled(wait)
+		}
+		return f.verify(ctx, tx, &user, code)
+	})
+	if err == errorSrv.ErrTOTPInvalidCode {
+		f.guard.Fail(email, "")
+	}
+	return err
+}
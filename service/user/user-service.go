@@ -2,12 +2,14 @@ package user
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"net"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/fatih/structs"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
@@ -15,9 +17,12 @@ import (
 	"gorm.io/gorm"
 
 	pb "github.com/1412335/moneyforward-go-coding-challenge/pkg/api/user"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/fx"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/money"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
 	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
 	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
@@ -27,39 +32,225 @@ type userServiceImpl struct {
 	dal      *postgres.DataAccessLayer
 	logger   log.Factory
 	tokenSrv *TokenService
+	// login brute-force protection
+	loginGuard *LoginGuard
+	// proxies trusted to forward client IP
+	trustedProxies []*net.IPNet
+	// delivers password reset tokens
+	notifier             Notifier
+	passwordResetTTL     time.Duration
+	emailVerificationTTL time.Duration
+	// TOTP 2FA
+	twoFactor        *TwoFactor
+	totpIssuer       string
+	totpChallengeTTL time.Duration
+	// rules of new passwords
+	passwordPolicy *PasswordPolicy
+	// withdrawal & transaction limits of user tiers
+	transactionLimits *TransactionLimitPolicy
+	// validity of pending holds
+	holdTTL time.Duration
+	// scheduled transactions are posted for users w verified email only
+	scheduleVerifiedEmail bool
+	// time zone of interest accrual days
+	interestLocation *time.Location
+	// exchange rates of transfers between currencies, none if nil
+	rates fx.RateProvider
+	// clock, replaced in tests
+	now func() time.Time
 }
 
 var _ pb.UserServiceServer = (*userServiceImpl)(nil)
 
-func NewUserService(dal *postgres.DataAccessLayer, tokenSrv *TokenService) pb.UserServiceServer {
-	return &userServiceImpl{
-		dal:      dal,
-		logger:   log.With(zap.String("srv", "user")),
-		tokenSrv: tokenSrv,
+type ServiceOption func(*userServiceImpl)
+
+// WithLoginGuard overrides default login protection
+func WithLoginGuard(g *LoginGuard) ServiceOption {
+	return func(u *userServiceImpl) {
+		u.loginGuard = g
+	}
+}
+
+// WithTrustedProxies overrides proxies trusted to forward client IP
+func WithTrustedProxies(proxies []*net.IPNet) ServiceOption {
+	return func(u *userServiceImpl) {
+		u.trustedProxies = proxies
+	}
+}
+
+// WithNotifier overrides notifier delivering messages to users
+func WithNotifier(n Notifier) ServiceOption {
+	return func(u *userServiceImpl) {
+		u.notifier = n
+	}
+}
+
+// WithPasswordResetTTL overrides validity of password reset tokens
+func WithPasswordResetTTL(ttl time.Duration) ServiceOption {
+	return func(u *userServiceImpl) {
+		if ttl > 0 {
+			u.passwordResetTTL = ttl
+		}
+	}
+}
+
+// WithEmailVerificationTTL overrides validity of email verification tokens
+func WithEmailVerificationTTL(ttl time.Duration) ServiceOption {
+	return func(u *userServiceImpl) {
+		if ttl > 0 {
+			u.emailVerificationTTL = ttl
+		}
+	}
+}
+
+// WithScheduleVerifiedEmail skips runs of schedules of users who didn't verify their email,
+// as the methods creating them are rejected
+func WithScheduleVerifiedEmail(required bool) ServiceOption {
+	return func(u *userServiceImpl) {
+		u.scheduleVerifiedEmail = required
+	}
+}
+
+// WithTwoFactor overrides verifier of 2FA codes
+func WithTwoFactor(f *TwoFactor) ServiceOption {
+	return func(u *userServiceImpl) {
+		u.twoFactor = f
+	}
+}
+
+// WithTOTPIssuer overrides issuer shown by authenticator apps
+func WithTOTPIssuer(issuer string) ServiceOption {
+	return func(u *userServiceImpl) {
+		if issuer != "" {
+			u.totpIssuer = issuer
+		}
+	}
+}
+
+// WithTOTPChallengeTTL overrides validity of login challenge tokens of users w 2FA
+func WithTOTPChallengeTTL(ttl time.Duration) ServiceOption {
+	return func(u *userServiceImpl) {
+		if ttl > 0 {
+			u.totpChallengeTTL = ttl
+		}
+	}
+}
+
+// WithPasswordPolicy overrides rules of new passwords
+func WithPasswordPolicy(p *PasswordPolicy) ServiceOption {
+	return func(u *userServiceImpl) {
+		if p != nil {
+			u.passwordPolicy = p
+		}
+	}
+}
+
+// WithTransactionLimits overrides limits of user tiers, unlimited by default
+func WithTransactionLimits(p *TransactionLimitPolicy) ServiceOption {
+	return func(u *userServiceImpl) {
+		if p != nil {
+			u.transactionLimits = p
+		}
+	}
+}
+
+// WithHoldTTL overrides validity of pending holds
+func WithHoldTTL(ttl time.Duration) ServiceOption {
+	return func(u *userServiceImpl) {
+		if ttl > 0 {
+			u.holdTTL = ttl
+		}
+	}
+}
+
+// WithInterestLocation overrides the time zone of interest accrual days, UTC by default
+func WithInterestLocation(loc *time.Location) ServiceOption {
+	return func(u *userServiceImpl) {
+		if loc != nil {
+			u.interestLocation = loc
+		}
+	}
+}
+
+// WithRateProvider sets exchange rates of transfers, w/o it only same currency transfers are allowed
+func WithRateProvider(rates fx.RateProvider) ServiceOption {
+	return func(u *userServiceImpl) {
+		if rates != nil {
+			u.rates = rates
+		}
+	}
+}
+
+// Default validity of password reset & email verification tokens
+const (
+	DefaultPasswordResetTTL     = time.Hour
+	DefaultEmailVerificationTTL = 24 * time.Hour
+)
+
+func NewUserService(dal *postgres.DataAccessLayer, tokenSrv *TokenService, opts ...ServiceOption) pb.UserServiceServer {
+	// default loopback proxies always parse
+	trustedProxies, _ := utils.ParseTrustedProxies(nil)
+	u := &userServiceImpl{
+		dal:                  dal,
+		logger:               log.With(zap.String("srv", "user")),
+		tokenSrv:             tokenSrv,
+		loginGuard:           NewLoginGuard(nil),
+		trustedProxies:       trustedProxies,
+		notifier:             NewLogNotifier(),
+		passwordResetTTL:     DefaultPasswordResetTTL,
+		emailVerificationTTL: DefaultEmailVerificationTTL,
+		totpIssuer:           DefaultTOTPIssuer,
+		totpChallengeTTL:     DefaultTOTPChallengeTTL,
+		passwordPolicy:       NewPasswordPolicy(nil),
+		holdTTL:              DefaultHoldTTL,
+		interestLocation:     time.UTC,
+		now:                  time.Now,
+	}
+	// unlimited policy always builds
+	u.transactionLimits, _ = NewTransactionLimitPolicy(nil)
+	for _, o := range opts {
+		o(u)
+	}
+	if u.twoFactor == nil {
+		u.twoFactor = NewTwoFactor(dal, u.loginGuard)
+	}
+	return u
+}
+
+// metadata key forcing reads from the primary to read your own writes
+const readYourWritesKey = "x-read-your-writes"
+
+// reader returns db session for read-only queries, served by a replica
+// unless the request asks to read its own writes
+func (u *userServiceImpl) reader(ctx context.Context) *gorm.DB {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(readYourWritesKey); len(v) > 0 && v[0] != "" && v[0] != "false" {
+			ctx = postgres.WithPrimary(ctx)
+		}
 	}
+	return u.dal.Reader(ctx)
 }
 
 // get user by id from redis & db
 func (u *userServiceImpl) getUserByID(ctx context.Context, id int64) (*model.User, error) {
+	return u.findUserByID(ctx, u.reader(ctx), id)
+}
+
+// find user by id w given db session
+func (u *userServiceImpl) findUserByID(ctx context.Context, db *gorm.DB, id int64) (*model.User, error) {
 	user := &model.User{}
-	err := u.dal.GetDatabase().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		// find user by id
-		if e := tx.Where(&model.User{ID: id}).First(user).Error; e == gorm.ErrRecordNotFound {
-			return errorSrv.ErrUserNotFound
-		} else if e != nil {
-			u.logger.For(ctx).Error("Find user", zap.Error(e))
-			return errorSrv.ErrConnectDB
-		}
-		// // cache
-		// if e := user.cache(); e != nil {
-		// 	u.logger.For(ctx).Error("Cache user", zap.Error(e))
-		// }
-		return nil
-	})
-	if err != nil {
-		return nil, err
+	// find user by id
+	if e := db.Where(&model.User{ID: id}).First(user).Error; e == gorm.ErrRecordNotFound {
+		return nil, errorSrv.ErrUserNotFound
+	} else if e != nil {
+		u.logger.For(ctx).Error("Find user", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
 	}
-	return user, err
+	// // cache
+	// if e := user.cache(); e != nil {
+	// 	u.logger.For(ctx).Error("Cache user", zap.Error(e))
+	// }
+	return user, nil
 }
 
 // create user & token
@@ -68,8 +259,8 @@ func (u *userServiceImpl) Create(ctx context.Context, req *pb.CreateUserRequest)
 	if !isValidEmail(req.GetEmail()) {
 		return nil, errorSrv.ErrInvalidEmail
 	}
-	if !isValidPassword(req.GetPassword()) {
-		return nil, errorSrv.ErrInvalidPassword
+	if err := u.passwordPolicy.Validate(req.GetPassword()); err != nil {
+		return nil, err
 	}
 
 	user := &model.User{
@@ -83,21 +274,27 @@ func (u *userServiceImpl) Create(ctx context.Context, req *pb.CreateUserRequest)
 
 	// init response
 	rsp := &pb.CreateUserResponse{}
+	var verification *pendingVerification
 
 	// create
-	err := u.dal.GetDatabase().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.Create(user).Error; err != nil && strings.Contains(err.Error(), "idx_users_email") {
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil && dal.IsUniqueViolation(err) {
 			return errorSrv.ErrDuplicateEmail
 		} else if err != nil {
 			u.logger.For(ctx).Error("Error connecting from db", zap.Error(err))
-			return errorSrv.ErrConnectDB
+			return dal.DatabaseError(err, errorSrv.ErrConnectDB)
+		}
+
+		// email must be verified
+		var err error
+		if verification, err = u.issueEmailVerification(ctx, tx, user); err != nil {
+			return err
 		}
 
 		// create token
-		token, err := u.tokenSrv.Generate(user)
+		token, err := u.startSession(ctx, tx, user, clientIP(ctx, u.trustedProxies), userAgent(ctx))
 		if err != nil {
-			u.logger.For(ctx).Error("Error generate token", zap.Error(err))
-			return errorSrv.ErrTokenGenerated
+			return err
 		}
 
 		rsp.User = user.Transform2GRPC()
@@ -107,6 +304,7 @@ func (u *userServiceImpl) Create(ctx context.Context, req *pb.CreateUserRequest)
 	if err != nil {
 		return nil, err
 	}
+	u.sendEmailVerification(ctx, verification)
 	// set header in your handler
 	md := metadata.Pairs("X-Http-Code", "201")
 	grpc.SetHeader(ctx, md)
@@ -118,12 +316,12 @@ func (u *userServiceImpl) Delete(ctx context.Context, req *pb.DeleteUserRequest)
 	if req.GetId() == 0 {
 		return nil, errorSrv.ErrMissingUserID
 	}
-	err := u.dal.GetDatabase().Transaction(func(tx *gorm.DB) error {
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
 		if err := tx.Where(req.GetId()).Delete(&model.User{}).Error; err == gorm.ErrRecordNotFound {
 			return errorSrv.ErrUserNotFound
 		} else if err != nil {
 			u.logger.For(ctx).Error("Error connecting from db", zap.Error(err))
-			return errorSrv.ErrConnectDB
+			return dal.DatabaseError(err, errorSrv.ErrConnectDB)
 		}
 		return nil
 	})
@@ -141,9 +339,10 @@ func (u *userServiceImpl) Update(ctx context.Context, req *pb.UpdateUserRequest)
 		return nil, errorSrv.ErrMissingUserID
 	}
 	rsp := &pb.UpdateUserResponse{}
-	err := u.dal.GetDatabase().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	var verification *pendingVerification
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
 		// find user by id
-		user, e := u.getUserByID(tx.Statement.Context, req.GetUser().GetId())
+		user, e := u.findUserByID(ctx, tx, req.GetUser().GetId())
 		if e != nil {
 			u.logger.For(ctx).Error("Get user by ID", zap.Error(e))
 			return errors.InternalServerError("Get user failed", "Lookup user by ID w redis/db failed")
@@ -152,45 +351,48 @@ func (u *userServiceImpl) Update(ctx context.Context, req *pb.UpdateUserRequest)
 		u.logger.For(ctx).Info("mask", zap.Strings("path", req.GetUpdateMask().GetPaths()))
 		// If there is no update mask do a regular update
 		if req.GetUpdateMask() == nil || len(req.GetUpdateMask().GetPaths()) == 0 {
+			if req.GetUser().GetPassword() != "" {
+				return errorSrv.ErrPasswordUpdate
+			}
 			user.UpdateFromGRPC(req.GetUser())
 		} else {
-			st := structs.New(*user)
-			in := structs.New(req.GetUser())
 			for _, path := range req.GetUpdateMask().GetPaths() {
-				if path == "id" {
+				// only profile fields are updatable, status fields are set by their flows
+				switch strings.ToLower(path) {
+				case "id":
 					return errors.BadRequest("cannot update id", map[string]string{"update_mask": "cannot update id field"})
-				}
-				// This doesn't translate properly if a CustomName setting is used,
-				// but none of the fields except ID has that set, so NO WORRIES.
-				fname := path
-				field, ok := st.FieldOk(fname)
-				if !ok {
+				case "password":
+					// password requires the current one or a reset token
+					return errorSrv.ErrPasswordUpdate
+				case "email":
+					user.SetEmail(req.GetUser().GetEmail())
+				default:
 					return errors.BadRequest("invalid field specified", map[string]string{
-						"update_mask": fmt.Sprintf("The user message type does not have a field called %q", path),
+						"update_mask": fmt.Sprintf("The user message type does not have an updatable field called %q", path),
 					})
 				}
-				// set update value
-				if e := field.Set(in.Field(fname).Value()); e != nil {
-					return e
-				}
 			}
 		}
 		// check fields valid
 		if !isValidEmail(user.Email) {
 			return errorSrv.ErrInvalidEmail
 		}
-		if !isValidPassword(user.Password) {
-			return errorSrv.ErrInvalidPassword
-		}
 		if err := user.Validate(); err != nil {
 			u.logger.For(ctx).Error("Error validate user", zap.Error(err))
 			return err
 		}
 		// update user in db
-		if e := tx.Save(user).Error; e != nil && strings.Contains(e.Error(), "idx_users_email") {
+		if e := tx.Save(user).Error; e != nil && dal.IsUniqueViolation(e) {
 			return errorSrv.ErrDuplicateEmail
 		} else if e != nil {
-			return errorSrv.ErrConnectDB
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		// changed email must be verified again
+		verification = nil
+		if !user.EmailVerified {
+			if verification, e = u.issueEmailVerification(ctx, tx, user); e != nil {
+				return e
+			}
 		}
 		// response
 		rsp.User = user.Transform2GRPC()
@@ -199,6 +401,7 @@ func (u *userServiceImpl) Update(ctx context.Context, req *pb.UpdateUserRequest)
 	if err != nil {
 		return nil, err
 	}
+	u.sendEmailVerification(ctx, verification)
 	return rsp, err
 }
 
@@ -206,17 +409,17 @@ func (u *userServiceImpl) Update(ctx context.Context, req *pb.UpdateUserRequest)
 func (u *userServiceImpl) getUsers(ctx context.Context, req *pb.ListUsersRequest) ([]*pb.User, error) {
 	var users []model.User
 	// build sql statement
-	psql := u.dal.GetDatabase().WithContext(ctx)
+	psql := u.reader(ctx)
 	if req.GetId() != nil {
-		psql = psql.Where("id = ?", req.GetId())
+		psql = psql.Where("id = ?", req.GetId().GetValue())
 	}
 	if req.GetEmail() != nil {
-		psql = psql.Where("email LIKE '%?%'", req.GetEmail().Value)
+		psql = psql.Where("email LIKE ?", "%"+req.GetEmail().GetValue()+"%")
 	}
 	// exec
 	if err := psql.Order("created_at desc").Find(&users).Error; err != nil {
 		u.logger.For(ctx).Error("Error find users", zap.Error(err))
-		return nil, errorSrv.ErrConnectDB
+		return nil, dal.DatabaseError(err, errorSrv.ErrConnectDB)
 	}
 	// check empty from db
 	if len(users) == 0 {
@@ -269,40 +472,287 @@ func (u *userServiceImpl) Login(ctx context.Context, req *pb.LoginRequest) (*pb.
 	if len(req.GetPassword()) == 0 {
 		return nil, errorSrv.ErrInvalidPassword
 	}
-	// response
-	rsp := &pb.LoginResponse{}
-	err := u.dal.GetDatabase().Transaction(func(tx *gorm.DB) error {
-		var user model.User
+	email := strings.ToLower(req.GetEmail())
+	ip, ua := clientIP(ctx, u.trustedProxies), userAgent(ctx)
+
+	// reject throttled email or IP before checking password
+	if wait, locked := u.loginGuard.Check(email, ip); wait > 0 {
+		u.logger.For(ctx).Error("Login throttled", zap.String("email", email), zap.String("ip", ip), zap.Bool("locked", locked), zap.Duration("retryAfter", wait))
+		if locked {
+			u.recordLogin(ctx, u.userIDByEmail(ctx, email), ip, ua, model.LoginReasonLocked)
+		}
+		return nil, errorSrv.ErrLoginThrottled(wait)
+	}
+
+	var user model.User
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
 		// find user by email
-		if e := tx.Where(&model.User{Email: strings.ToLower(req.GetEmail())}).First(&user).Error; e == gorm.ErrRecordNotFound {
-			return errorSrv.ErrUserNotFound
+		if e := tx.Where(&model.User{Email: email}).First(&user).Error; e == gorm.ErrRecordNotFound {
+			// unknown email fails like a wrong password, in about the same time
+			_ = utils.CompareHash(dummyPasswordHash(), req.GetPassword())
+			return errorSrv.ErrIncorrectPassword
 		} else if e != nil {
 			u.logger.For(ctx).Error("Error find user", zap.Error(e))
-			return errorSrv.ErrConnectDB
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
 		}
 		// verify password
 		if e := utils.CompareHash(user.Password, req.GetPassword()); e != nil {
 			return errorSrv.ErrIncorrectPassword
 		}
-		// gen new token
-		token, e := u.tokenSrv.Generate(&user)
+		return nil
+	})
+	if err == errorSrv.ErrIncorrectPassword {
+		if u.loginGuard.Fail(email, ip) {
+			u.logger.For(ctx).Error("Login locked out", zap.String("email", email), zap.String("ip", ip))
+		}
+		u.recordLogin(ctx, user.ID, ip, ua, model.LoginReasonInvalidCredentials)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.rehashPassword(ctx, &user, req.GetPassword())
+
+	// 2FA: exchange challenge for a token w a code, failures are kept until then
+	if user.TOTPEnabled {
+		return u.twoFactorChallenge(ctx, &user)
+	}
+	return u.loginSucceeded(ctx, &user, ip, ua)
+}
+
+// challenge of user w 2FA, exchanged for a token by LoginTOTP
+func (u *userServiceImpl) twoFactorChallenge(ctx context.Context, user *model.User) (*pb.LoginResponse, error) {
+	challenge, e := u.tokenSrv.GenerateChallenge(user, u.totpChallengeTTL)
+	if e != nil {
+		u.logger.For(ctx).Error("Error gen challenge token", zap.Error(e))
+		return nil, errorSrv.ErrTokenGenerated
+	}
+	return &pb.LoginResponse{
+		User:              user.Transform2GRPC(),
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+	}, nil
+}
+
+// issue token of logged in user
+func (u *userServiceImpl) loginSucceeded(ctx context.Context, user *model.User, ip, ua string) (*pb.LoginResponse, error) {
+	u.loginGuard.Succeed(user.Email)
+
+	// gen new token
+	token, e := u.startSession(ctx, u.dal.GetDatabase().WithContext(ctx), user, ip, ua)
+	if e != nil {
+		return nil, e
+	}
+	u.recordLogin(ctx, user.ID, ip, ua, "")
+	// // cache user
+	// if e := user.cache(); e != nil {
+	// 	u.logger.For(ctx).Error("Cache user", zap.Error(e))
+	// }
+	return &pb.LoginResponse{
+		User:  user.Transform2GRPC(),
+		Token: token,
+	}, nil
+}
+
+// second login step of users w 2FA: challenge token of Login & TOTP or recovery code
+func (u *userServiceImpl) LoginTOTP(ctx context.Context, req *pb.LoginTOTPRequest) (*pb.LoginResponse, error) {
+	if len(req.GetChallengeToken()) == 0 {
+		return nil, errorSrv.ErrMissingToken
+	}
+	claims, e := u.tokenSrv.VerifyChallenge(ctx, req.GetChallengeToken())
+	if e != nil {
+		u.logger.For(ctx).Error("verify challenge token failed", zap.Error(e))
+		return nil, errorSrv.ErrTokenInvalid
+	}
+	email := strings.ToLower(claims.Email)
+	ip, ua := clientIP(ctx, u.trustedProxies), userAgent(ctx)
+
+	// code guessing is throttled like passwords
+	if wait, locked := u.loginGuard.Check(email, ip); wait > 0 {
+		if locked {
+			u.recordLogin(ctx, claims.ID, ip, ua, model.LoginReasonLocked)
+		}
+		return nil, errorSrv.ErrLoginThrottled(wait)
+	}
+
+	var user *model.User
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var e error
+		if user, e = u.findUserByID(ctx, tx, claims.ID); e != nil {
+			return e
+		}
+		if !user.TOTPEnabled {
+			return errorSrv.ErrTOTPNotEnrolled
+		}
+		return u.twoFactor.verify(ctx, tx, user, req.GetCode())
+	})
+	if err == errorSrv.ErrTOTPInvalidCode {
+		if u.loginGuard.Fail(email, ip) {
+			u.logger.For(ctx).Error("Login locked out", zap.String("email", email), zap.String("ip", ip))
+		}
+		u.recordLogin(ctx, claims.ID, ip, ua, model.LoginReasonInvalidTOTP)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u.loginSucceeded(ctx, user, ip, ua)
+}
+
+// start TOTP enrollment w a new secret, replacing a pending one
+func (u *userServiceImpl) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	secret, e := utils.GenTOTPSecret()
+	if e != nil {
+		u.logger.For(ctx).Error("Error gen totp secret", zap.Error(e))
+		return nil, errorSrv.ErrTokenGenerated
+	}
+	rsp := &pb.EnrollTOTPResponse{Secret: secret}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		user, e := u.findUserByID(ctx, tx, req.GetUserId())
 		if e != nil {
-			u.logger.For(ctx).Error("Error gen token", zap.Error(e))
-			return errorSrv.ErrTokenGenerated
+			return e
 		}
-		// // cache user
-		// if e := user.cache(); e != nil {
-		// 	u.logger.For(ctx).Error("Cache user", zap.Error(e))
-		// }
-		//
-		rsp.User = user.Transform2GRPC()
-		rsp.Token = token
+		if user.TOTPEnabled {
+			return errorSrv.ErrTOTPAlreadyEnabled
+		}
+		user.TOTPSecret = secret
+		user.TOTPLastStep = 0
+		if e := tx.Save(user).Error; e != nil {
+			u.logger.For(ctx).Error("Error save totp secret", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		rsp.Uri = utils.TOTPURI(u.totpIssuer, user.Email, secret)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	return rsp, err
+	return rsp, nil
+}
+
+// enable 2FA w a code of the enrolled secret, returns recovery codes
+func (u *userServiceImpl) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	rsp := &pb.ConfirmTOTPResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		user, e := u.findUserByID(ctx, tx, req.GetUserId())
+		if e != nil {
+			return e
+		}
+		if user.TOTPEnabled {
+			return errorSrv.ErrTOTPAlreadyEnabled
+		}
+		if user.TOTPSecret == "" {
+			return errorSrv.ErrTOTPNotEnrolled
+		}
+		if e := u.twoFactor.verify(ctx, tx, user, req.GetCode()); e != nil {
+			return e
+		}
+		user.TOTPEnabled = true
+		if e := tx.Save(user).Error; e != nil {
+			u.logger.For(ctx).Error("Error enable totp", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		codes, e := u.twoFactor.newRecoveryCodes(ctx, tx, user.ID)
+		if e != nil {
+			return e
+		}
+		rsp.RecoveryCodes = codes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// hash compared against for unknown emails
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = utils.GenHash("dummy password")
+	})
+	return dummyHash
+}
+
+// id of user w email, 0 if not found
+func (u *userServiceImpl) userIDByEmail(ctx context.Context, email string) int64 {
+	var user model.User
+	if e := u.reader(ctx).Select("id").Where(&model.User{Email: email}).First(&user).Error; e != nil && e != gorm.ErrRecordNotFound {
+		u.logger.For(ctx).Error("Error find user", zap.Error(e))
+	}
+	return user.ID
+}
+
+// record login attempt of existing user, failed w reason or succeeded if empty
+func (u *userServiceImpl) recordLogin(ctx context.Context, userID int64, ip, ua, reason string) {
+	if userID == 0 {
+		return
+	}
+	event := &model.LoginEvent{
+		UserID:    userID,
+		Success:   reason == "",
+		Reason:    reason,
+		IP:        ip,
+		UserAgent: ua,
+	}
+	// history is best effort, login result doesn't depend on it
+	if e := u.dal.GetDatabase().WithContext(ctx).Create(event).Error; e != nil {
+		u.logger.For(ctx).Error("Error record login", zap.Error(e))
+	}
+}
+
+// unlock email and/or IP locked out by failed logins, admin only
+func (u *userServiceImpl) UnlockUser(ctx context.Context, req *pb.UnlockUserRequest) (*pb.UnlockUserResponse, error) {
+	if req.GetEmail() == "" && req.GetIp() == "" {
+		return nil, errorSrv.ErrMissingEmail
+	}
+	if req.GetEmail() != "" && !isValidEmail(req.GetEmail()) {
+		return nil, errorSrv.ErrInvalidEmail
+	}
+	u.loginGuard.Unlock(strings.ToLower(req.GetEmail()), req.GetIp())
+	u.logger.For(ctx).Info("Login unlocked", zap.String("email", req.GetEmail()), zap.String("ip", req.GetIp()))
+	return &pb.UnlockUserResponse{}, nil
+}
+
+// default & max login events listed
+const (
+	defaultLoginHistoryLimit = 50
+	maxLoginHistoryLimit     = 500
+)
+
+// list recent login attempts of user
+func (u *userServiceImpl) ListLoginHistory(ctx context.Context, req *pb.ListLoginHistoryRequest) (*pb.ListLoginHistoryResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = defaultLoginHistoryLimit
+	} else if limit > maxLoginHistoryLimit {
+		limit = maxLoginHistoryLimit
+	}
+	var events []*model.LoginEvent
+	if e := u.reader(ctx).Where(&model.LoginEvent{UserID: req.GetUserId()}).Order("created_at desc, id desc").Limit(limit).Find(&events).Error; e != nil {
+		u.logger.For(ctx).Error("Error find login events", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	rsp := &pb.ListLoginHistoryResponse{
+		Events: make([]*pb.LoginEvent, len(events)),
+	}
+	for i, e := range events {
+		rsp.Events[i] = e.Transform2GRPC()
+	}
+	return rsp, nil
 }
 
 // logout: clear redis cache
@@ -310,27 +760,329 @@ func (u *userServiceImpl) Logout(ctx context.Context, req *pb.LogoutRequest) (*p
 	if req.GetId() == 0 {
 		return nil, errorSrv.ErrMissingUserID
 	}
-	return nil, nil
+	if req.GetAll() {
+		// bumped token version revokes tokens issued before sessions too
+		err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+			if e := tx.Model(&model.User{}).Where("id = ?", req.GetId()).
+				UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error; e != nil {
+				u.logger.For(ctx).Error("Error revoke tokens", zap.Error(e))
+				return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+			}
+			return u.revokeSessions(ctx, tx, req.GetId())
+		})
+		if err != nil {
+			return nil, err
+		}
+		u.logger.For(ctx).Info("Logged out everywhere", zap.Int64("userID", req.GetId()))
+		return &pb.LogoutResponse{}, nil
+	}
+	// revoke session of the request token
+	if claims := claimsFromContext(ctx); claims != nil && claims.Session != 0 {
+		if e := u.revokeSessions(ctx, u.dal.GetDatabase().WithContext(ctx), req.GetId(), claims.Session); e != nil {
+			return nil, e
+		}
+	}
+	return &pb.LogoutResponse{}, nil
 }
 
-// validate token: update isActive=true & return user
+// validate token & return its user
 func (u *userServiceImpl) Validate(ctx context.Context, req *pb.ValidateRequest) (*pb.ValidateResponse, error) {
 	if len(req.GetToken()) == 0 {
 		return nil, errorSrv.ErrMissingToken
 	}
-	rsp := &pb.ValidateResponse{}
-	err := u.dal.GetDatabase().Transaction(func(tx *gorm.DB) error {
-		// verrify token
-		claims, e := u.tokenSrv.Verify(req.Token)
+	// verrify token
+	claims, e := u.tokenSrv.Verify(ctx, req.Token)
+	if e != nil {
+		u.logger.For(ctx).Error("verify token failed", zap.Error(e))
+		return nil, errorSrv.ErrTokenInvalid
+	}
+	// get cache user
+	user, e := u.getUserByID(ctx, claims.ID)
+	if e != nil {
+		u.logger.For(ctx).Error("Get user by ID", zap.Error(e))
+		return nil, errors.InternalServerError("Get user failed", "Lookup user by id failed")
+	}
+	return &pb.ValidateResponse{
+		User: user.Transform2GRPC(),
+	}, nil
+}
+
+// refresh token: issue a new token for a valid one
+func (u *userServiceImpl) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	if len(req.GetToken()) == 0 {
+		return nil, errorSrv.ErrMissingToken
+	}
+	// verrify token
+	claims, e := u.tokenSrv.Verify(ctx, req.GetToken())
+	if e != nil {
+		u.logger.For(ctx).Error("verify token failed", zap.Error(e))
+		return nil, errorSrv.ErrTokenInvalid
+	}
+	// user may be deleted since token was issued
+	user, e := u.getUserByID(ctx, claims.ID)
+	if e != nil {
+		u.logger.For(ctx).Error("Get user by ID", zap.Error(e))
+		return nil, errorSrv.ErrTokenInvalid
+	}
+	// gen new token in the same session, tokens issued before sessions start one
+	db := u.dal.GetDatabase().WithContext(ctx)
+	ip, ua := clientIP(ctx, u.trustedProxies), userAgent(ctx)
+	var token string
+	if claims.Session != 0 {
+		token, e = u.extendSession(ctx, db, user, claims.Session, ip, ua)
+	} else {
+		token, e = u.startSession(ctx, db, user, ip, ua)
+	}
+	if e == errorSrv.ErrSessionRevoked {
+		return nil, errorSrv.ErrTokenInvalid
+	}
+	if e != nil {
+		return nil, e
+	}
+	return &pb.RefreshTokenResponse{
+		Token: token,
+	}, nil
+}
+
+// change password w the current one, revokes issued tokens & returns a new one
+func (u *userServiceImpl) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	if len(req.GetCurrentPassword()) == 0 {
+		return nil, errorSrv.ErrInvalidPassword
+	}
+	if err := u.passwordPolicy.Validate(req.GetNewPassword()); err != nil {
+		return nil, err
+	}
+	ip := clientIP(ctx, u.trustedProxies)
+
+	var email string
+	rsp := &pb.ChangePasswordResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		user, e := u.findUserByID(ctx, tx, req.GetUserId())
 		if e != nil {
-			u.logger.For(ctx).Error("verify token failed", zap.Error(e))
-			return errorSrv.ErrTokenInvalid
+			return e
 		}
-		// get cache user
-		user, e := u.getUserByID(ctx, claims.ID)
+		email = user.Email
+		// guessing current password w a stolen token is throttled like logins
+		if wait, _ := u.loginGuard.Check(email, ip); wait > 0 {
+			return errorSrv.ErrLoginThrottled(wait)
+		}
+		if e := utils.CompareHash(user.Password, req.GetCurrentPassword()); e != nil {
+			return errorSrv.ErrIncorrectPassword
+		}
+		if e := u.changePassword(ctx, tx, user, req.GetNewPassword()); e != nil {
+			return e
+		}
+		// sessions are revoked w the password, the returned token starts a new one
+		token, e := u.startSession(ctx, tx, user, ip, userAgent(ctx))
 		if e != nil {
-			u.logger.For(ctx).Error("Get user by ID", zap.Error(e))
-			return errors.InternalServerError("Get user failed", "Lookup user by id failed")
+			return e
+		}
+		rsp.Token = token
+		return nil
+	})
+	if err == errorSrv.ErrIncorrectPassword {
+		u.loginGuard.Fail(email, ip)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// size of random password reset & email verification tokens
+const randomTokenBytes = 32
+
+// mark single use token row used, false if already used by a concurrent request
+func claimToken(tx *gorm.DB, token interface{}, id int64, now time.Time) (bool, error) {
+	res := tx.Model(token).Where("id = ? AND used_at IS NULL", id).Update("used_at", now)
+	return res.RowsAffected == 1, res.Error
+}
+
+// send a password reset token to the email if a user has it,
+// response doesn't reveal whether the email exists
+func (u *userServiceImpl) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetResponse, error) {
+	if !isValidEmail(req.GetEmail()) {
+		return nil, errorSrv.ErrInvalidEmail
+	}
+	email := strings.ToLower(req.GetEmail())
+
+	token, e := utils.GenToken(randomTokenBytes)
+	if e != nil {
+		u.logger.For(ctx).Error("Error gen reset token", zap.Error(e))
+		return nil, errorSrv.ErrTokenGenerated
+	}
+	expiresAt := u.now().Add(u.passwordResetTTL)
+
+	var user model.User
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		if e := tx.Where(&model.User{Email: email}).First(&user).Error; e == gorm.ErrRecordNotFound {
+			return nil
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find user", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		// new token replaces pending ones
+		if e := tx.Where(&model.PasswordReset{UserID: user.ID}).Delete(&model.PasswordReset{}).Error; e != nil {
+			u.logger.For(ctx).Error("Error delete password resets", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		reset := &model.PasswordReset{
+			UserID:    user.ID,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: expiresAt,
+		}
+		if e := tx.Create(reset).Error; e != nil {
+			u.logger.For(ctx).Error("Error create password reset", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		u.logger.For(ctx).Info("Password reset of unknown email", zap.String("email", email))
+		return &pb.RequestPasswordResetResponse{}, nil
+	}
+	// delivery failure isn't returned, it would reveal the email exists
+	if e := u.notifier.SendPasswordReset(ctx, user.Email, token, expiresAt); e != nil {
+		u.logger.For(ctx).Error("Error send password reset", zap.Int64("id", user.ID), zap.Error(e))
+	}
+	return &pb.RequestPasswordResetResponse{}, nil
+}
+
+// set a new password w a single use reset token, revokes issued tokens
+func (u *userServiceImpl) ConfirmPasswordReset(ctx context.Context, req *pb.ConfirmPasswordResetRequest) (*pb.ConfirmPasswordResetResponse, error) {
+	if len(req.GetToken()) == 0 {
+		return nil, errorSrv.ErrMissingToken
+	}
+	if err := u.passwordPolicy.Validate(req.GetNewPassword()); err != nil {
+		return nil, err
+	}
+
+	var email string
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var reset model.PasswordReset
+		if e := tx.Where(&model.PasswordReset{TokenHash: utils.HashToken(req.GetToken())}).First(&reset).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrResetTokenInvalid
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find password reset", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		now := u.now()
+		if reset.UsedAt != nil || !now.Before(reset.ExpiresAt) {
+			return errorSrv.ErrResetTokenInvalid
+		}
+		if ok, e := claimToken(tx, &model.PasswordReset{}, reset.ID, now); e != nil {
+			u.logger.For(ctx).Error("Error use password reset", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		} else if !ok {
+			return errorSrv.ErrResetTokenInvalid
+		}
+		user, e := u.findUserByID(ctx, tx, reset.UserID)
+		if e == errorSrv.ErrUserNotFound {
+			return errorSrv.ErrResetTokenInvalid
+		} else if e != nil {
+			return e
+		}
+		if e := u.changePassword(ctx, tx, user, req.GetNewPassword()); e != nil {
+			return e
+		}
+		email = user.Email
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// owner of the email proved it, lift its login lockout
+	u.loginGuard.Unlock(email, "")
+	return &pb.ConfirmPasswordResetResponse{}, nil
+}
+
+// verification token to deliver once its transaction committed
+type pendingVerification struct {
+	email     string
+	token     string
+	expiresAt time.Time
+}
+
+// issue token verifying current email of user, replacing pending ones
+func (u *userServiceImpl) issueEmailVerification(ctx context.Context, tx *gorm.DB, user *model.User) (*pendingVerification, error) {
+	token, e := utils.GenToken(randomTokenBytes)
+	if e != nil {
+		u.logger.For(ctx).Error("Error gen verification token", zap.Error(e))
+		return nil, errorSrv.ErrTokenGenerated
+	}
+	if e := tx.Where(&model.EmailVerification{UserID: user.ID}).Delete(&model.EmailVerification{}).Error; e != nil {
+		u.logger.For(ctx).Error("Error delete email verifications", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	v := &model.EmailVerification{
+		UserID:    user.ID,
+		Email:     strings.ToLower(user.Email),
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: u.now().Add(u.emailVerificationTTL),
+	}
+	if e := tx.Create(v).Error; e != nil {
+		u.logger.For(ctx).Error("Error create email verification", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return &pendingVerification{email: v.Email, token: token, expiresAt: v.ExpiresAt}, nil
+}
+
+// deliver verification token, failures are logged & left to ResendEmailVerification
+func (u *userServiceImpl) sendEmailVerification(ctx context.Context, v *pendingVerification) {
+	if v == nil {
+		return
+	}
+	if e := u.notifier.SendEmailVerification(ctx, v.email, v.token, v.expiresAt); e != nil {
+		u.logger.For(ctx).Error("Error send email verification", zap.String("email", v.email), zap.Error(e))
+	}
+}
+
+// verify email w token sent on signup or email change
+func (u *userServiceImpl) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
+	if len(req.GetToken()) == 0 {
+		return nil, errorSrv.ErrMissingToken
+	}
+	rsp := &pb.VerifyEmailResponse{}
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		var v model.EmailVerification
+		if e := tx.Where(&model.EmailVerification{TokenHash: utils.HashToken(req.GetToken())}).First(&v).Error; e == gorm.ErrRecordNotFound {
+			return errorSrv.ErrVerificationTokenInvalid
+		} else if e != nil {
+			u.logger.For(ctx).Error("Error find email verification", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		now := u.now()
+		if v.UsedAt != nil || !now.Before(v.ExpiresAt) {
+			return errorSrv.ErrVerificationTokenInvalid
+		}
+		if ok, e := claimToken(tx, &model.EmailVerification{}, v.ID, now); e != nil {
+			u.logger.For(ctx).Error("Error use email verification", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		} else if !ok {
+			return errorSrv.ErrVerificationTokenInvalid
+		}
+		user, e := u.findUserByID(ctx, tx, v.UserID)
+		if e == errorSrv.ErrUserNotFound {
+			return errorSrv.ErrVerificationTokenInvalid
+		} else if e != nil {
+			return e
+		}
+		// token of an email changed since
+		if !strings.EqualFold(user.Email, v.Email) {
+			return errorSrv.ErrVerificationTokenInvalid
+		}
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+		if e := tx.Save(user).Error; e != nil {
+			u.logger.For(ctx).Error("Error verify email", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
 		}
 		rsp.User = user.Transform2GRPC()
 		return nil
@@ -338,7 +1090,32 @@ func (u *userServiceImpl) Validate(ctx context.Context, req *pb.ValidateRequest)
 	if err != nil {
 		return nil, err
 	}
-	return rsp, err
+	return rsp, nil
+}
+
+// send a new verification token, no-op once email is verified
+func (u *userServiceImpl) ResendEmailVerification(ctx context.Context, req *pb.ResendEmailVerificationRequest) (*pb.ResendEmailVerificationResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, errorSrv.ErrMissingUserID
+	}
+	var verification *pendingVerification
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		user, e := u.findUserByID(ctx, tx, req.GetUserId())
+		if e != nil {
+			return e
+		}
+		if user.EmailVerified {
+			verification = nil
+			return nil
+		}
+		verification, e = u.issueEmailVerification(ctx, tx, user)
+		return e
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.sendEmailVerification(ctx, verification)
+	return &pb.ResendEmailVerificationResponse{}, nil
 }
 
 // CreateAccount
@@ -350,25 +1127,37 @@ func (u *userServiceImpl) CreateAccount(ctx context.Context, req *pb.CreateAccou
 	if req.GetBalance() < 0 {
 		return nil, errorSrv.ErrInvalidAccountBalance
 	}
+	currency := model.DefaultCurrency
+	if req.GetCurrency() != "" {
+		c, err := money.ParseCurrency(req.GetCurrency())
+		if err != nil {
+			return nil, errorSrv.ErrInvalidCurrency
+		}
+		currency = c
+	}
+	if !model.OnMinorUnit(currency, req.GetBalance()) {
+		return nil, errorSrv.ErrInvalidAmountPrecision
+	}
 
 	// response
 	rsp := &pb.CreateAccountResponse{}
-	err := u.dal.GetDatabase().Transaction(func(tx *gorm.DB) error {
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
 		var user model.User
 		// find user by id
 		if e := tx.Where(&model.User{ID: req.GetUserId()}).First(&user).Error; e == gorm.ErrRecordNotFound {
 			return errorSrv.ErrUserNotFound
 		} else if e != nil {
 			u.logger.For(ctx).Error("Error find user by id", zap.Error(e))
-			return errorSrv.ErrConnectDB
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
 		}
 
 		// create account
 		acc := &model.Account{
-			UserID:  user.ID,
-			Name:    req.GetName(),
-			Bank:    req.GetBank().String(),
-			Balance: req.GetBalance(),
+			UserID:   user.ID,
+			Name:     req.GetName(),
+			Bank:     req.GetBank().String(),
+			Balance:  req.GetBalance(),
+			Currency: currency,
 		}
 		if err := acc.Validate(); err != nil {
 			u.logger.For(ctx).Error("Error validate account", zap.Error(err))
@@ -376,7 +1165,7 @@ func (u *userServiceImpl) CreateAccount(ctx context.Context, req *pb.CreateAccou
 		}
 		if err := tx.Create(acc).Error; err != nil {
 			u.logger.For(ctx).Error("Error create account", zap.Error(err))
-			return errorSrv.ErrConnectDB
+			return dal.DatabaseError(err, errorSrv.ErrConnectDB)
 		}
 		//
 		rsp.Account = acc.Transform2GRPC()
@@ -400,11 +1189,11 @@ func (u *userServiceImpl) ListAccounts(ctx context.Context, req *pb.ListAccounts
 
 	var user model.User
 	// lookup user by id
-	if e := u.dal.GetDatabase().Where(&model.User{ID: req.GetUserId().Value}).Preload("Accounts").First(&user).Error; e == gorm.ErrRecordNotFound {
+	if e := u.reader(ctx).Where(&model.User{ID: req.GetUserId().Value}).Preload("Accounts").First(&user).Error; e == gorm.ErrRecordNotFound {
 		return nil, errorSrv.ErrUserNotFound
 	} else if e != nil {
 		u.logger.For(ctx).Error("Error find user by id", zap.Error(e))
-		return nil, errorSrv.ErrConnectDB
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
 	}
 	rsp := &pb.ListAccountsResponse{}
 	// fetch accounts belong to the user
@@ -421,66 +1210,21 @@ func (u *userServiceImpl) CreateTransaction(ctx context.Context, req *pb.CreateT
 	if req.GetUserId() == 0 {
 		return nil, errorSrv.ErrMissingUserID
 	}
-	if req.GetAccountId() == 0 {
-		return nil, errorSrv.ErrMissingAccountID
-	}
-	if req.GetAmount() <= 0 {
-		return nil, errorSrv.ErrInvalidTransactionAmountGT0
+	if err := validateTransaction(req.GetAccountId(), req.GetAmount(), req.GetTransactionType()); err != nil {
+		return nil, err
 	}
 
 	// response
 	rsp := &pb.CreateTransactionResponse{}
-	err := u.dal.GetDatabase().Transaction(func(tx *gorm.DB) error {
-		var acc model.Account
-		// find account by userId + accId
-		if e := tx.Where(&model.Account{ID: req.GetAccountId(), UserID: req.GetUserId()}).First(&acc).Error; e == gorm.ErrRecordNotFound {
-			return errorSrv.ErrAccountNotFound
-		} else if e != nil {
-			u.logger.For(ctx).Error("Error find user by id", zap.Error(e))
-			return errorSrv.ErrConnectDB
-		}
-
-		// // check account balance w withdraw transaction
-		// if req.GetTransactionType() == pb.TransactionType_WITHDRAW && acc.Balance < req.GetAmount() {
-		// 	return ErrInvalidTransactionAmount
-		// }
-		// check account balance
-		switch req.GetTransactionType() {
-		case pb.TransactionType_WITHDRAW:
-			acc.Balance -= req.GetAmount()
-			if acc.Balance < 0 {
-				return errorSrv.ErrInvalidWithdrawTransactionAmount
-			}
-		case pb.TransactionType_DEPOSIT:
-			acc.Balance += req.GetAmount()
-		}
-
-		// create transaction
-		trans := &model.Transaction{
-			AccountID:       acc.ID,
-			Amount:          req.GetAmount(),
-			TransactionType: req.GetTransactionType().String(),
-			CreatedAt:       time.Now().Round(time.Millisecond),
-		}
-		if err := trans.Validate(); err != nil {
-			u.logger.For(ctx).Error("Error validate trans", zap.Error(err))
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		trans, err := u.createTransaction(ctx, tx, req)
+		if err != nil {
 			return err
 		}
-		if err := tx.Create(trans).Error; err != nil {
-			u.logger.For(ctx).Error("Error create transaction", zap.Error(err))
-			return errorSrv.ErrConnectDB
-		}
-
-		// update account
-		if e := tx.Save(&acc).Error; e != nil {
-			u.logger.For(ctx).Error("Error update account balance", zap.Error(e))
-			return errorSrv.ErrConnectDB
-		}
-
 		// response
 		rsp.Transaction = trans.Transform2GRPC()
 		return nil
-	})
+	}, postgres.WithIsolation(sql.LevelSerializable))
 	if err != nil {
 		return nil, err
 	}
@@ -491,6 +1235,111 @@ func (u *userServiceImpl) CreateTransaction(ctx context.Context, req *pb.CreateT
 	return rsp, nil
 }
 
+// check account, amount & type of a new transaction
+func validateTransaction(accountID int64, amount float64, typ pb.TransactionType) error {
+	if accountID == 0 {
+		return errorSrv.ErrMissingAccountID
+	}
+	if amount <= 0 {
+		return errorSrv.ErrInvalidTransactionAmountGT0
+	}
+	// fees are posted by the service only
+	if typ != pb.TransactionType_WITHDRAW && typ != pb.TransactionType_DEPOSIT {
+		return errorSrv.ErrInvalidTransactionType
+	}
+	return nil
+}
+
+// create validated transaction req & update its account balance, within serializable tx
+func (u *userServiceImpl) createTransaction(ctx context.Context, tx *gorm.DB, req *pb.CreateTransactionRequest) (*model.Transaction, error) {
+	var acc model.Account
+	// find account by userId + accId
+	if e := tx.Where(&model.Account{ID: req.GetAccountId(), UserID: req.GetUserId()}).First(&acc).Error; e == gorm.ErrRecordNotFound {
+		return nil, errorSrv.ErrAccountNotFound
+	} else if e != nil {
+		u.logger.For(ctx).Error("Error find user by id", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	if err := checkCurrency(&acc, req.GetCurrency()); err != nil {
+		return nil, err
+	}
+	if err := checkAmount(&acc, req.GetAmount()); err != nil {
+		return nil, err
+	}
+
+	// check limits of the account atomically w its balance
+	now := u.now().Round(time.Millisecond)
+	var withdrawal float64
+	if req.GetTransactionType() == pb.TransactionType_WITHDRAW {
+		withdrawal = req.GetAmount()
+	}
+	if err := u.checkTransactionLimits(ctx, tx, &acc, withdrawal, now, 0); err != nil {
+		return nil, err
+	}
+
+	// // check account balance w withdraw transaction
+	// if req.GetTransactionType() == pb.TransactionType_WITHDRAW && acc.Balance < req.GetAmount() {
+	// 	return ErrInvalidTransactionAmount
+	// }
+	// check account balance, down to its overdraft limit
+	switch req.GetTransactionType() {
+	case pb.TransactionType_WITHDRAW:
+		acc.Balance = model.SumAmounts(acc.Balance, -req.GetAmount())
+		if acc.ExceedsOverdraft() {
+			return nil, errorSrv.ErrInvalidWithdrawTransactionAmount
+		}
+	case pb.TransactionType_DEPOSIT:
+		acc.Balance = model.SumAmounts(acc.Balance, req.GetAmount())
+	}
+
+	// create transaction
+	trans := &model.Transaction{
+		AccountID:       acc.ID,
+		Amount:          req.GetAmount(),
+		TransactionType: req.GetTransactionType().String(),
+		Currency:        acc.Currency,
+		CreatedAt:       now,
+	}
+	if err := trans.Validate(); err != nil {
+		u.logger.For(ctx).Error("Error validate trans", zap.Error(err))
+		return nil, err
+	}
+	if err := tx.Create(trans).Error; err != nil {
+		u.logger.For(ctx).Error("Error create transaction", zap.Error(err))
+		return nil, dal.DatabaseError(err, errorSrv.ErrConnectDB)
+	}
+
+	// update account
+	if e := tx.Save(&acc).Error; e != nil {
+		u.logger.For(ctx).Error("Error update account balance", zap.Error(e))
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
+	}
+	return trans, nil
+}
+
+// check currency of a request amount, if set, is the account currency
+func checkCurrency(acc *model.Account, code string) error {
+	if code == "" {
+		return nil
+	}
+	c, err := money.ParseCurrency(code)
+	if err != nil {
+		return errorSrv.ErrInvalidCurrency
+	}
+	if c != acc.Currency {
+		return errorSrv.ErrCurrencyMismatch
+	}
+	return nil
+}
+
+// check a request amount is in minor units of the account currency, as balances are
+func checkAmount(acc *model.Account, amount float64) error {
+	if !model.OnMinorUnit(acc.Currency, amount) {
+		return errorSrv.ErrInvalidAmountPrecision
+	}
+	return nil
+}
+
 // ListTransactions
 func (u *userServiceImpl) ListTransactions(ctx context.Context, req *pb.ListTransactionsRequest) (*pb.ListTransactionsResponse, error) {
 	// validate request
@@ -499,7 +1348,7 @@ func (u *userServiceImpl) ListTransactions(ctx context.Context, req *pb.ListTran
 	}
 
 	// build query
-	q := u.dal.GetDatabase().Where(&model.Account{UserID: req.GetUserId()})
+	q := u.reader(ctx).Where(&model.Account{UserID: req.GetUserId()})
 	if req.GetAccountId() != 0 {
 		q = q.Where("id = ?", req.GetAccountId())
 	}
@@ -508,7 +1357,7 @@ func (u *userServiceImpl) ListTransactions(ctx context.Context, req *pb.ListTran
 	var accs []model.Account
 	if e := q.Preload("Transactions").Find(&accs).Error; e != nil {
 		u.logger.For(ctx).Error("Error find user by id", zap.Error(e))
-		return nil, errorSrv.ErrConnectDB
+		return nil, dal.DatabaseError(e, errorSrv.ErrConnectDB)
 	}
 
 	if len(accs) == 0 {
@@ -524,6 +1373,7 @@ func (u *userServiceImpl) ListTransactions(ctx context.Context, req *pb.ListTran
 				Id:        trans.ID,
 				AccountId: trans.AccountID,
 				Amount:    trans.Amount,
+				Currency:  trans.Currency,
 				CreatedAt: timestamppb.New(trans.CreatedAt),
 			}
 			if b, ok := pb.Bank_value[acc.Bank]; ok {
@@ -532,6 +1382,9 @@ func (u *userServiceImpl) ListTransactions(ctx context.Context, req *pb.ListTran
 			if t, ok := pb.TransactionType_value[trans.TransactionType]; ok {
 				pbTrans.TransactionType = pb.TransactionType(t)
 			}
+			if s, ok := pb.TransactionStatus_value[trans.Status]; ok {
+				pbTrans.Status = pb.TransactionStatus(s)
+			}
 			rsp.Transactions = append(rsp.Transactions, pbTrans)
 		}
 	}
@@ -545,37 +1398,46 @@ func (u *userServiceImpl) DeleteTransaction(ctx context.Context, req *pb.DeleteT
 		return nil, errorSrv.ErrMissingUserID
 	}
 
-	// build query
-	q := u.dal.GetDatabase().Where(&model.Account{UserID: req.GetUserId()})
-	if req.GetAccountId() != nil {
-		q = q.Where("id = ?", req.GetAccountId().Value)
-	}
+	var ids []int64
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
+		// build query
+		q := tx.Where(&model.Account{UserID: req.GetUserId()})
+		if req.GetAccountId() != nil {
+			q = q.Where("id = ?", req.GetAccountId().Value)
+		}
 
-	// lookup account
-	var accs []model.Account
-	if e := q.Preload("Transactions", func(db *gorm.DB) *gorm.DB {
-		if req.GetId() != nil {
-			db = db.Where("id = ?", req.GetId().Value)
+		// lookup account
+		var accs []model.Account
+		if e := q.Preload("Transactions", func(db *gorm.DB) *gorm.DB {
+			// fees & interest posted by the service, pending holds & transfer legs are kept
+			db = db.Where("transaction_type NOT IN ? AND status <> ? AND counter_transaction_id IS NULL", model.SystemTransactionTypes, pb.TransactionStatus_PENDING.String())
+			if req.GetId() != nil {
+				db = db.Where("id = ?", req.GetId().Value)
+			}
+			return db
+		}).Find(&accs).Error; e != nil {
+			u.logger.For(ctx).Error("Error find user by id", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		if len(accs) == 0 {
+			return errorSrv.ErrTransactionNotFound
 		}
-		return db
-	}).Find(&accs).Error; e != nil {
-		u.logger.For(ctx).Error("Error find user by id", zap.Error(e))
-		return nil, errorSrv.ErrConnectDB
-	}
-	if len(accs) == 0 {
-		return nil, errorSrv.ErrTransactionNotFound
-	}
 
-	// lookup transaction
-	var ids []int64
-	for _, acc := range accs {
-		for _, trans := range acc.Transactions {
-			ids = append(ids, trans.ID)
+		// lookup transaction
+		ids = nil
+		for _, acc := range accs {
+			for _, trans := range acc.Transactions {
+				ids = append(ids, trans.ID)
+			}
 		}
-	}
-	if e := u.dal.GetDatabase().Where("id IN ?", ids).Delete(&model.Transaction{}).Error; e != nil {
-		u.logger.For(ctx).Error("Error delete transaction", zap.Error(e))
-		return nil, errorSrv.ErrConnectDB
+		if e := tx.Where("id IN ?", ids).Delete(&model.Transaction{}).Error; e != nil {
+			u.logger.For(ctx).Error("Error delete transaction", zap.Error(e))
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return &pb.DeleteTransactionResponse{Ids: ids}, nil
 }
@@ -591,9 +1453,10 @@ func (u *userServiceImpl) UpdateTransaction(ctx context.Context, req *pb.UpdateT
 	if req.GetTransaction().GetId() == 0 {
 		return nil, errorSrv.ErrMissingTransactionID
 	}
+	updateAmount := updatesAmount(req.GetUpdateMask())
 
 	rsp := &pb.UpdateTransactionResponse{}
-	err := u.dal.GetDatabase().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	err := u.dal.Transaction(ctx, func(tx *gorm.DB) error {
 		// find acc
 		var acc model.Account
 		if e := tx.Where(&model.Account{ID: req.GetAccountId(), UserID: req.GetUserId()}).Preload("Transactions", func(db *gorm.DB) *gorm.DB {
@@ -603,25 +1466,49 @@ func (u *userServiceImpl) UpdateTransaction(ctx context.Context, req *pb.UpdateT
 			return errorSrv.ErrAccountNotFound
 		} else if e != nil {
 			u.logger.For(ctx).Error("Error find account", zap.Error(e))
-			return errorSrv.ErrConnectDB
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
 		}
 
 		if len(acc.Transactions) == 0 {
 			return errorSrv.ErrTransactionNotFound
 		}
 		trans := acc.Transactions[0]
+		if trans.System() {
+			return errorSrv.ErrSystemTransaction
+		}
+		// both legs are posted at the recorded rate
+		if trans.Transfer() {
+			return errorSrv.ErrTransferTransaction
+		}
+		// holds change by capture or void only
+		if trans.Status != pb.TransactionStatus_POSTED.String() {
+			return errorSrv.ErrTransactionNotPosted
+		}
+		if updateAmount {
+			// ex: a negative withdrawal would credit the account
+			if req.GetTransaction().GetAmount() <= 0 {
+				return errorSrv.ErrInvalidTransactionAmountGT0
+			}
+			if err := checkCurrency(&acc, req.GetTransaction().GetCurrency()); err != nil {
+				return err
+			}
+			if err := checkAmount(&acc, req.GetTransaction().GetAmount()); err != nil {
+				return err
+			}
+		}
+		prevAmount := trans.Amount
 
 		// If there is no update mask do a regular update
 		if req.GetUpdateMask() == nil || len(req.GetUpdateMask().GetPaths()) == 0 {
 			switch trans.TransactionType {
 			case pb.TransactionType_WITHDRAW.String():
-				acc.Balance += trans.Amount - req.GetTransaction().GetAmount()
-				if acc.Balance < 0 {
+				acc.Balance = model.SumAmounts(acc.Balance, trans.Amount, -req.GetTransaction().GetAmount())
+				if acc.ExceedsOverdraft() {
 					return errorSrv.ErrInvalidWithdrawTransactionAmount
 				}
 			case pb.TransactionType_DEPOSIT.String():
-				acc.Balance = acc.Balance - trans.Amount + req.GetTransaction().GetAmount()
-				if acc.Balance < 0 {
+				acc.Balance = model.SumAmounts(acc.Balance, -trans.Amount, req.GetTransaction().GetAmount())
+				if acc.ExceedsOverdraft() {
 					return errorSrv.ErrInvalidTransactionAmountGT0
 				}
 			}
@@ -639,13 +1526,13 @@ func (u *userServiceImpl) UpdateTransaction(ctx context.Context, req *pb.UpdateT
 				if path == "amount" {
 					switch trans.TransactionType {
 					case pb.TransactionType_WITHDRAW.String():
-						acc.Balance += trans.Amount - req.GetTransaction().GetAmount()
-						if acc.Balance < 0 {
+						acc.Balance = model.SumAmounts(acc.Balance, trans.Amount, -req.GetTransaction().GetAmount())
+						if acc.ExceedsOverdraft() {
 							return errorSrv.ErrInvalidWithdrawTransactionAmount
 						}
 					case pb.TransactionType_DEPOSIT.String():
-						acc.Balance = acc.Balance - trans.Amount + req.GetTransaction().GetAmount()
-						if acc.Balance < 0 {
+						acc.Balance = model.SumAmounts(acc.Balance, -trans.Amount, req.GetTransaction().GetAmount())
+						if acc.ExceedsOverdraft() {
 							return errorSrv.ErrInvalidTransactionAmountGT0
 						}
 					}
@@ -653,20 +1540,26 @@ func (u *userServiceImpl) UpdateTransaction(ctx context.Context, req *pb.UpdateT
 				}
 			}
 		}
+		// raised withdrawals are checked against limits of the day & month they were made in
+		if trans.TransactionType == pb.TransactionType_WITHDRAW.String() && trans.Amount > prevAmount {
+			if err := u.checkTransactionLimits(ctx, tx, &acc, trans.Amount, trans.CreatedAt, trans.ID); err != nil {
+				return err
+			}
+		}
 		// update trans
 		if e := tx.Save(trans).Error; e != nil {
 			u.logger.For(ctx).Error("Error update trans", zap.Error(e))
-			return errorSrv.ErrConnectDB
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
 		}
 		// update acc balance
 		if e := tx.Save(&acc).Error; e != nil {
 			u.logger.For(ctx).Error("Error update account", zap.Error(e))
-			return errorSrv.ErrConnectDB
+			return dal.DatabaseError(e, errorSrv.ErrConnectDB)
 		}
 		// response
 		rsp.Transaction = trans.Transform2GRPC()
 		return nil
-	})
+	}, postgres.WithIsolation(sql.LevelSerializable))
 	if err != nil {
 		return nil, err
 	}
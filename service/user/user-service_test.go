@@ -3,6 +3,7 @@ package user
 import (
 	"context"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,14 +11,25 @@ import (
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/configs"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/errors"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/fx"
 	"github.com/1412335/moneyforward-go-coding-challenge/pkg/log"
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/utils"
 	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
 	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+// id never issued by a fresh test db
+const notFoundID int64 = 1 << 40
+
 func newUserServiceError(t *testing.T) {
 	// service configs
 	config := configs.ServiceConfig{
@@ -36,17 +48,24 @@ func newUserServiceError(t *testing.T) {
 	dal, err := postgres.NewDataAccessLayer(context.Background(), config.Database)
 	require.Error(t, err)
 	require.Nil(t, dal)
+
+	// failed connection attempt is not cached
+	dal = postgres.New(config.Database)
+	for i := 0; i < 2; i++ {
+		db, err := dal.Connect(context.Background())
+		require.Error(t, err)
+		require.Nil(t, db)
+	}
+	require.Nil(t, dal.GetDatabase())
 }
 
 func newUserService(t *testing.T) pb.UserServiceServer {
 	// service configs
 	config := configs.ServiceConfig{
 		Database: &configs.Database{
-			Host:           "localhost",
-			Port:           "5432",
-			User:           "root",
-			Password:       "root",
-			Scheme:         "users",
+			// in-memory sqlite db per test
+			Driver:         configs.DriverSQLite,
+			Path:           "file:" + t.Name() + "?mode=memory&cache=shared",
 			MaxIdleConns:   10,
 			MaxOpenConns:   100,
 			ConnectTimeout: 1 * time.Hour,
@@ -64,20 +83,26 @@ func newUserService(t *testing.T) pb.UserServiceServer {
 	require.NotNil(t, dal)
 	require.NotNil(t, dal.GetDatabase())
 
-	// truncate table
-	err = dal.GetDatabase().Exec("TRUNCATE TABLE users, accounts, transactions CASCADE").Error
-	require.NoError(t, err)
-
 	// migrate db
 	err = dal.GetDatabase().AutoMigrate(
 		&model.User{},
 		&model.Account{},
 		&model.Transaction{},
+		&model.LoginEvent{},
+		&model.PasswordReset{},
+		&model.EmailVerification{},
+		&model.RecoveryCode{},
+		&model.APIKey{},
+		&model.Session{},
+		&model.PasswordHistory{},
+		&model.AccountLimit{},
+		&model.Schedule{},
+		&model.RatePlan{},
 	)
 	require.NoError(t, err)
 
 	// token service
-	tokenSrv := NewTokenService(config.JWT)
+	tokenSrv := NewTokenService(config.JWT, WithTokenVersions(NewTokenVersionStore(dal)), WithSessions(NewSessionStore(dal)))
 	require.NotNil(t, tokenSrv)
 
 	// create server
@@ -238,41 +263,346 @@ func Test_userServiceImpl_Delete(t *testing.T) {
 }
 
 func Test_userServiceImpl_Update(t *testing.T) {
-	type fields struct {
-		dal      *postgres.DataAccessLayer
-		logger   log.Factory
-		tokenSrv *TokenService
+	s := newUserService(t)
+	require.NotNil(t, s)
+
+	// mockup
+	rspCreated, err := s.Create(context.TODO(), &pb.CreateUserRequest{
+		Email:    "abc@gmail.com",
+		Password: "abc123456",
+	})
+	require.NoError(t, err)
+	id := rspCreated.User.Id
+
+	tests := []struct {
+		name  string
+		req   *pb.UpdateUserRequest
+		email string
+		err   error
+	}{
+		{
+			name: "MissingUserID",
+			req:  &pb.UpdateUserRequest{User: &pb.User{Email: "abc@gmail.com"}},
+			err:  errorSrv.ErrMissingUserID,
+		},
+		{
+			name: "InvalidEmail",
+			req:  &pb.UpdateUserRequest{User: &pb.User{Id: id, Email: "abc"}},
+			err:  errorSrv.ErrInvalidEmail,
+		},
+		{
+			name: "ErrPasswordUpdate",
+			req:  &pb.UpdateUserRequest{User: &pb.User{Id: id, Email: "abc@gmail.com", Password: "new123456"}},
+			err:  errorSrv.ErrPasswordUpdate,
+		},
+		{
+			name: "ErrPasswordUpdateMask",
+			req: &pb.UpdateUserRequest{
+				User:       &pb.User{Id: id, Password: "new123456"},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"Password"}},
+			},
+			err: errorSrv.ErrPasswordUpdate,
+		},
+		{
+			name:  "Success",
+			req:   &pb.UpdateUserRequest{User: &pb.User{Id: id, Email: "def@gmail.com"}},
+			email: "def@gmail.com",
+		},
+		{
+			name: "SuccessMask",
+			req: &pb.UpdateUserRequest{
+				User:       &pb.User{Id: id, Email: "ghi@gmail.com"},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"Email"}},
+			},
+			email: "ghi@gmail.com",
+		},
 	}
-	type args struct {
-		ctx context.Context
-		req *pb.UpdateUserRequest
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp, err := s.Update(context.TODO(), tt.req)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				require.Nil(t, rsp)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.email, rsp.User.Email)
+			// password is not re-hashed by updates
+			_, err = s.Login(context.TODO(), &pb.LoginRequest{Email: tt.email, Password: "abc123456"})
+			require.NoError(t, err)
+		})
+	}
+}
+
+// notifier keeping last tokens sent per email
+type testNotifier struct {
+	resets        map[string]string
+	verifications map[string]string
+}
+
+func newTestNotifier() *testNotifier {
+	return &testNotifier{
+		resets:        make(map[string]string),
+		verifications: make(map[string]string),
 	}
+}
+
+func (n *testNotifier) SendPasswordReset(ctx context.Context, email, token string, expiresAt time.Time) error {
+	n.resets[email] = token
+	return nil
+}
+
+func (n *testNotifier) SendEmailVerification(ctx context.Context, email, token string, expiresAt time.Time) error {
+	n.verifications[email] = token
+	return nil
+}
+
+func Test_userServiceImpl_ChangePassword(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	rspCreated, err := s.Create(context.TODO(), &pb.CreateUserRequest{
+		Email:    "abc@gmail.com",
+		Password: "abc123456",
+	})
+	require.NoError(t, err)
+	id := rspCreated.User.Id
+
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    *pb.UpdateUserResponse
-		wantErr bool
+		name string
+		req  *pb.ChangePasswordRequest
+		err  error
 	}{
-		// TODO: Add test cases.
+		{
+			name: "MissingUserID",
+			req:  &pb.ChangePasswordRequest{CurrentPassword: "abc123456", NewPassword: "new123456"},
+			err:  errorSrv.ErrMissingUserID,
+		},
+		{
+			name: "InvalidNewPassword",
+			req:  &pb.ChangePasswordRequest{UserId: id, CurrentPassword: "abc123456", NewPassword: "new"},
+			err:  errorSrv.ErrInvalidPassword,
+		},
+		{
+			name: "UserNotFound",
+			req:  &pb.ChangePasswordRequest{UserId: notFoundID, CurrentPassword: "abc123456", NewPassword: "new123456"},
+			err:  errorSrv.ErrUserNotFound,
+		},
+		{
+			name: "ErrIncorrectPassword",
+			req:  &pb.ChangePasswordRequest{UserId: id, CurrentPassword: "wrong123456", NewPassword: "new123456"},
+			err:  errorSrv.ErrIncorrectPassword,
+		},
+		{
+			name: "Success",
+			req:  &pb.ChangePasswordRequest{UserId: id, CurrentPassword: "abc123456", NewPassword: "new123456"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			u := &userServiceImpl{
-				dal:      tt.fields.dal,
-				logger:   tt.fields.logger,
-				tokenSrv: tt.fields.tokenSrv,
+			rsp, err := s.ChangePassword(context.TODO(), tt.req)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				require.Nil(t, rsp)
+				return
 			}
-			got, err := u.Update(tt.args.ctx, tt.args.req)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("userServiceImpl.Update() error = %v, wantErr %v", err, tt.wantErr)
+			require.NoError(t, err)
+			// tokens issued before are revoked, new one is valid
+			_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: rspCreated.Token})
+			require.ErrorIs(t, err, errorSrv.ErrTokenInvalid)
+			_, err = s.RefreshToken(context.TODO(), &pb.RefreshTokenRequest{Token: rspCreated.Token})
+			require.ErrorIs(t, err, errorSrv.ErrTokenInvalid)
+			_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: rsp.Token})
+			require.NoError(t, err)
+			// login w new password only
+			_, err = s.Login(context.TODO(), &pb.LoginRequest{Email: "abc@gmail.com", Password: "abc123456"})
+			require.ErrorIs(t, err, errorSrv.ErrIncorrectPassword)
+			_, err = s.Login(context.TODO(), &pb.LoginRequest{Email: "abc@gmail.com", Password: "new123456"})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_userServiceImpl_PasswordReset(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	notifier := newTestNotifier()
+	u := s.(*userServiceImpl)
+	u.notifier = notifier
+	now := time.Now()
+	u.now = func() time.Time { return now }
+
+	rspCreated, err := s.Create(context.TODO(), &pb.CreateUserRequest{
+		Email:    "abc@gmail.com",
+		Password: "abc123456",
+	})
+	require.NoError(t, err)
+
+	// unknown email responds the same w/o notification
+	_, err = s.RequestPasswordReset(context.TODO(), &pb.RequestPasswordResetRequest{Email: "unknown@gmail.com"})
+	require.NoError(t, err)
+	require.Empty(t, notifier.resets)
+	_, err = s.RequestPasswordReset(context.TODO(), &pb.RequestPasswordResetRequest{Email: "abc"})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidEmail)
+
+	// expired token
+	_, err = s.RequestPasswordReset(context.TODO(), &pb.RequestPasswordResetRequest{Email: "ABC@gmail.com"})
+	require.NoError(t, err)
+	expired := notifier.resets["abc@gmail.com"]
+	require.NotEmpty(t, expired)
+	now = now.Add(DefaultPasswordResetTTL)
+	_, err = s.ConfirmPasswordReset(context.TODO(), &pb.ConfirmPasswordResetRequest{Token: expired, NewPassword: "new123456"})
+	require.ErrorIs(t, err, errorSrv.ErrResetTokenInvalid)
+
+	// new token replaces pending ones
+	_, err = s.RequestPasswordReset(context.TODO(), &pb.RequestPasswordResetRequest{Email: "abc@gmail.com"})
+	require.NoError(t, err)
+	token := notifier.resets["abc@gmail.com"]
+	require.NotEqual(t, expired, token)
+	now = now.Add(DefaultPasswordResetTTL / 2)
+
+	tests := []struct {
+		name string
+		req  *pb.ConfirmPasswordResetRequest
+		err  error
+	}{
+		{
+			name: "MissingToken",
+			req:  &pb.ConfirmPasswordResetRequest{NewPassword: "new123456"},
+			err:  errorSrv.ErrMissingToken,
+		},
+		{
+			name: "InvalidPassword",
+			req:  &pb.ConfirmPasswordResetRequest{Token: token, NewPassword: "new"},
+			err:  errorSrv.ErrInvalidPassword,
+		},
+		{
+			name: "InvalidToken",
+			req:  &pb.ConfirmPasswordResetRequest{Token: "invalid", NewPassword: "new123456"},
+			err:  errorSrv.ErrResetTokenInvalid,
+		},
+		{
+			name: "Success",
+			req:  &pb.ConfirmPasswordResetRequest{Token: token, NewPassword: "new123456"},
+		},
+		{
+			name: "AlreadyUsed",
+			req:  &pb.ConfirmPasswordResetRequest{Token: token, NewPassword: "other123456"},
+			err:  errorSrv.ErrResetTokenInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp, err := s.ConfirmPasswordReset(context.TODO(), tt.req)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				require.Nil(t, rsp)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("userServiceImpl.Update() = %v, want %v", got, tt.want)
+			require.NoError(t, err)
+		})
+	}
+
+	// password changed & tokens issued before revoked
+	_, err = s.Login(context.TODO(), &pb.LoginRequest{Email: "abc@gmail.com", Password: "new123456"})
+	require.NoError(t, err)
+	_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: rspCreated.Token})
+	require.ErrorIs(t, err, errorSrv.ErrTokenInvalid)
+}
+
+func Test_userServiceImpl_VerifyEmail(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	notifier := newTestNotifier()
+	u := s.(*userServiceImpl)
+	u.notifier = notifier
+	now := time.Now()
+	u.now = func() time.Time { return now }
+
+	// signup sends a verification token
+	rspCreated, err := s.Create(context.TODO(), &pb.CreateUserRequest{
+		Email:    "ABC@gmail.com",
+		Password: "abc123456",
+	})
+	require.NoError(t, err)
+	require.False(t, rspCreated.User.EmailVerified)
+	token := notifier.verifications["abc@gmail.com"]
+	require.NotEmpty(t, token)
+
+	// expired token
+	_, err = s.Create(context.TODO(), &pb.CreateUserRequest{
+		Email:    "expired@gmail.com",
+		Password: "abc123456",
+	})
+	require.NoError(t, err)
+	expired := notifier.verifications["expired@gmail.com"]
+	now = now.Add(DefaultEmailVerificationTTL)
+	_, err = s.VerifyEmail(context.TODO(), &pb.VerifyEmailRequest{Token: expired})
+	require.ErrorIs(t, err, errorSrv.ErrVerificationTokenInvalid)
+
+	// resend replaces expired token
+	_, err = s.ResendEmailVerification(context.TODO(), &pb.ResendEmailVerificationRequest{UserId: rspCreated.User.Id})
+	require.NoError(t, err)
+	_, err = s.VerifyEmail(context.TODO(), &pb.VerifyEmailRequest{Token: token})
+	require.ErrorIs(t, err, errorSrv.ErrVerificationTokenInvalid)
+	token = notifier.verifications["abc@gmail.com"]
+
+	tests := []struct {
+		name string
+		req  *pb.VerifyEmailRequest
+		err  error
+	}{
+		{
+			name: "MissingToken",
+			req:  &pb.VerifyEmailRequest{},
+			err:  errorSrv.ErrMissingToken,
+		},
+		{
+			name: "InvalidToken",
+			req:  &pb.VerifyEmailRequest{Token: "invalid"},
+			err:  errorSrv.ErrVerificationTokenInvalid,
+		},
+		{
+			name: "Success",
+			req:  &pb.VerifyEmailRequest{Token: token},
+		},
+		{
+			name: "AlreadyUsed",
+			req:  &pb.VerifyEmailRequest{Token: token},
+			err:  errorSrv.ErrVerificationTokenInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp, err := s.VerifyEmail(context.TODO(), tt.req)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				require.Nil(t, rsp)
+				return
 			}
+			require.NoError(t, err)
+			require.True(t, rsp.User.EmailVerified)
 		})
 	}
+
+	// verified email isn't sent again
+	delete(notifier.verifications, "abc@gmail.com")
+	_, err = s.ResendEmailVerification(context.TODO(), &pb.ResendEmailVerificationRequest{UserId: rspCreated.User.Id})
+	require.NoError(t, err)
+	require.Empty(t, notifier.verifications["abc@gmail.com"])
+
+	// changed email must be verified again, token of a previous email is stale
+	rspUpdated, err := s.Update(context.TODO(), &pb.UpdateUserRequest{User: &pb.User{Id: rspCreated.User.Id, Email: "def@gmail.com"}})
+	require.NoError(t, err)
+	require.False(t, rspUpdated.User.EmailVerified)
+	require.NotEmpty(t, notifier.verifications["def@gmail.com"])
+	rspUpdated, err = s.Update(context.TODO(), &pb.UpdateUserRequest{User: &pb.User{Id: rspCreated.User.Id, Email: "ghi@gmail.com"}})
+	require.NoError(t, err)
+	_, err = s.VerifyEmail(context.TODO(), &pb.VerifyEmailRequest{Token: notifier.verifications["def@gmail.com"]})
+	require.ErrorIs(t, err, errorSrv.ErrVerificationTokenInvalid)
+	rsp, err := s.VerifyEmail(context.TODO(), &pb.VerifyEmailRequest{Token: notifier.verifications["ghi@gmail.com"]})
+	require.NoError(t, err)
+	require.Equal(t, rspUpdated.User.Email, rsp.User.Email)
+	require.True(t, rsp.User.EmailVerified)
 }
 
 func Test_userServiceImpl_getUsers(t *testing.T) {
@@ -425,12 +755,13 @@ func Test_userServiceImpl_Login(t *testing.T) {
 			err: errorSrv.ErrInvalidPassword,
 		},
 		{
-			name: "ErrUserNotFound",
+			// unknown email fails like a wrong password
+			name: "ErrUnknownEmail",
 			req: &pb.LoginRequest{
 				Email:    "a@gmail.com",
 				Password: "abc123456",
 			},
-			err: errorSrv.ErrUserNotFound,
+			err: errorSrv.ErrIncorrectPassword,
 		},
 		{
 			name: "ErrIncorrectPassword",
@@ -465,67 +796,697 @@ func Test_userServiceImpl_Login(t *testing.T) {
 	}
 }
 
-func Test_userServiceImpl_Logout(t *testing.T) {
-	type fields struct {
-		dal      *postgres.DataAccessLayer
-		logger   log.Factory
-		tokenSrv *TokenService
-	}
-	type args struct {
-		ctx context.Context
-		req *pb.LogoutRequest
+func Test_userServiceImpl_TOTP(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	now := time.Now()
+	u.twoFactor.now = func() time.Time { return now }
+	code := func(secret string, step int64) string {
+		c, err := utils.TOTPCode(secret, step)
+		require.NoError(t, err)
+		return c
 	}
+
+	rspCreated, err := s.Create(context.TODO(), &pb.CreateUserRequest{
+		Email:    "abc@gmail.com",
+		Password: "abc123456",
+	})
+	require.NoError(t, err)
+	id := rspCreated.User.Id
+
+	// enroll & confirm w current code
+	_, err = s.ConfirmTOTP(context.TODO(), &pb.ConfirmTOTPRequest{UserId: id, Code: "123456"})
+	require.ErrorIs(t, err, errorSrv.ErrTOTPNotEnrolled)
+	enrolled, err := s.EnrollTOTP(context.TODO(), &pb.EnrollTOTPRequest{UserId: id})
+	require.NoError(t, err)
+	require.NotEmpty(t, enrolled.Secret)
+	require.Contains(t, enrolled.Uri, "secret="+enrolled.Secret)
+	step := utils.TOTPStep(now)
+	_, err = s.ConfirmTOTP(context.TODO(), &pb.ConfirmTOTPRequest{UserId: id, Code: code(enrolled.Secret, step+2)})
+	require.ErrorIs(t, err, errorSrv.ErrTOTPInvalidCode)
+	confirmed, err := s.ConfirmTOTP(context.TODO(), &pb.ConfirmTOTPRequest{UserId: id, Code: code(enrolled.Secret, step)})
+	require.NoError(t, err)
+	require.Len(t, confirmed.RecoveryCodes, recoveryCodeCount)
+	_, err = s.EnrollTOTP(context.TODO(), &pb.EnrollTOTPRequest{UserId: id})
+	require.ErrorIs(t, err, errorSrv.ErrTOTPAlreadyEnabled)
+
+	// login returns a challenge instead of a token
+	login := func() string {
+		rsp, err := s.Login(context.TODO(), &pb.LoginRequest{Email: "abc@gmail.com", Password: "abc123456"})
+		require.NoError(t, err)
+		require.True(t, rsp.TwoFactorRequired)
+		require.True(t, rsp.User.TotpEnabled)
+		require.Empty(t, rsp.Token)
+		// challenge is not an access token
+		_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: rsp.ChallengeToken})
+		require.ErrorIs(t, err, errorSrv.ErrTokenInvalid)
+		return rsp.ChallengeToken
+	}
+	challenge := login()
+	// access token is not a challenge
+	_, err = s.LoginTOTP(context.TODO(), &pb.LoginTOTPRequest{ChallengeToken: rspCreated.Token, Code: code(enrolled.Secret, step)})
+	require.ErrorIs(t, err, errorSrv.ErrTokenInvalid)
+
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    *pb.LogoutResponse
-		wantErr bool
+		name string
+		// clock advance before the request
+		advance time.Duration
+		code    string
+		err     error
 	}{
-		// TODO: Add test cases.
+		{name: "ReplayedCode", code: code(enrolled.Secret, step), err: errorSrv.ErrTOTPInvalidCode},
+		{name: "ExpiredCode", advance: 3 * utils.TOTPPeriod, code: code(enrolled.Secret, step+1), err: errorSrv.ErrTOTPInvalidCode},
+		{name: "NextCode", code: code(enrolled.Secret, step+3)},
+		{name: "RecoveryCode", code: strings.ToUpper(confirmed.RecoveryCodes[0])},
+		{name: "UsedRecoveryCode", code: confirmed.RecoveryCodes[0], err: errorSrv.ErrTOTPInvalidCode},
+		{name: "OtherRecoveryCode", code: strings.ReplaceAll(confirmed.RecoveryCodes[1], "-", "")},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			u := &userServiceImpl{
-				dal:      tt.fields.dal,
-				logger:   tt.fields.logger,
-				tokenSrv: tt.fields.tokenSrv,
-			}
-			got, err := u.Logout(tt.args.ctx, tt.args.req)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("userServiceImpl.Logout() error = %v, wantErr %v", err, tt.wantErr)
+			now = now.Add(tt.advance)
+			rsp, err := s.LoginTOTP(context.TODO(), &pb.LoginTOTPRequest{ChallengeToken: challenge, Code: tt.code})
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				require.Nil(t, rsp)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("userServiceImpl.Logout() = %v, want %v", got, tt.want)
-			}
+			require.NoError(t, err)
+			require.Equal(t, id, rsp.User.Id)
+			require.NotEmpty(t, rsp.Token)
+			_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: rsp.Token})
+			require.NoError(t, err)
 		})
 	}
+
+	// step-up w a code of current step, each code accepted once
+	step = utils.TOTPStep(now) + 1
+	now = now.Add(utils.TOTPPeriod)
+	require.ErrorIs(t, u.twoFactor.VerifyStepUp(context.TODO(), id, ""), errorSrv.ErrTOTPRequired)
+	require.NoError(t, u.twoFactor.VerifyStepUp(context.TODO(), id, code(enrolled.Secret, step)))
+	require.ErrorIs(t, u.twoFactor.VerifyStepUp(context.TODO(), id, code(enrolled.Secret, step)), errorSrv.ErrTOTPInvalidCode)
+
+	// users w/o 2FA aren't asked for a code
+	other, err := s.Create(context.TODO(), &pb.CreateUserRequest{Email: "other@gmail.com", Password: "abc123456"})
+	require.NoError(t, err)
+	require.NoError(t, u.twoFactor.VerifyStepUp(context.TODO(), other.User.Id, ""))
+	require.NoError(t, u.twoFactor.VerifyStepUp(context.TODO(), other.User.Id, "123456"))
+	require.ErrorIs(t, u.twoFactor.VerifyStepUp(context.TODO(), notFoundID, ""), errorSrv.ErrUserNotFound)
 }
 
-func Test_userServiceImpl_Validate(t *testing.T) {
-	type fields struct {
-		dal      *postgres.DataAccessLayer
-		logger   log.Factory
-		tokenSrv *TokenService
+func TestAuthServerInterceptor_authorizeOwner(t *testing.T) {
+	tokenSrv := NewTokenService(&configs.JWT{SecretKey: "lu", Duration: 10 * time.Minute, Issuer: "lu"})
+	token, err := tokenSrv.Generate(&model.User{ID: 1, Email: "abc@gmail.com"}, 0)
+	require.NoError(t, err)
+	a := NewAuthServerInterceptor(tokenSrv, map[string]bool{
+		"/user.UserService/Update": true,
+		"/user.UserService/Delete": true,
+	}, nil, nil).(*AuthServerInterceptor)
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.Pairs("authorization", "Bearer "+token))
+	tests := []struct {
+		name   string
+		method string
+		req    interface{}
+		code   codes.Code
+	}{
+		{"update", "/user.UserService/Update", &pb.UpdateUserRequest{User: &pb.User{Id: 1}}, codes.OK},
+		{"update other user", "/user.UserService/Update", &pb.UpdateUserRequest{User: &pb.User{Id: 2}}, codes.Unauthenticated},
+		{"delete", "/user.UserService/Delete", &pb.DeleteUserRequest{Id: 1}, codes.OK},
+		{"delete other user", "/user.UserService/Delete", &pb.DeleteUserRequest{Id: 2}, codes.Unauthenticated},
 	}
-	type args struct {
-		ctx context.Context
-		req *pb.ValidateRequest
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.authorize(ctx, tt.method, tt.req)
+			require.Equal(t, tt.code, status.Code(err))
+		})
 	}
+}
+
+func TestAuthServerInterceptor_stepUpRequired(t *testing.T) {
+	a := NewAuthServerInterceptor(nil, nil, nil, nil, WithStepUp(map[string]bool{"/user.UserService/Delete": true}, 1000, nil)).(*AuthServerInterceptor)
+	amountMask := &fieldmaskpb.FieldMask{Paths: []string{"amount"}}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    *pb.ValidateResponse
-		wantErr bool
+		name   string
+		method string
+		req    interface{}
+		want   bool
 	}{
-		// TODO: Add test cases.
+		{"method", "/user.UserService/Delete", &pb.DeleteUserRequest{}, true},
+		{"small withdrawal", "/user.UserService/CreateTransaction", &pb.CreateTransactionRequest{Amount: 999}, false},
+		{"withdrawal", "/user.UserService/CreateTransaction", &pb.CreateTransactionRequest{Amount: 1000}, true},
+		{"deposit", "/user.UserService/CreateTransaction", &pb.CreateTransactionRequest{Amount: 1000, TransactionType: pb.TransactionType_DEPOSIT}, false},
+		{"hold", "/user.UserService/AuthorizeHold", &pb.AuthorizeHoldRequest{Amount: 1000}, true},
+		{"transfer", "/user.UserService/CreateTransfer", &pb.CreateTransferRequest{Amount: 1000}, true},
+		{"raised amount", "/user.UserService/UpdateTransaction", &pb.UpdateTransactionRequest{Transaction: &pb.Transaction{Amount: 1000}}, true},
+		{"raised amount w mask", "/user.UserService/UpdateTransaction", &pb.UpdateTransactionRequest{Transaction: &pb.Transaction{Amount: 5000}, UpdateMask: amountMask}, true},
+		{"raised deposit", "/user.UserService/UpdateTransaction", &pb.UpdateTransactionRequest{Transaction: &pb.Transaction{Amount: 1000, TransactionType: pb.TransactionType_DEPOSIT}}, true},
+		{"small amount", "/user.UserService/UpdateTransaction", &pb.UpdateTransactionRequest{Transaction: &pb.Transaction{Amount: 999}, UpdateMask: amountMask}, false},
+		{"amount not in mask", "/user.UserService/UpdateTransaction", &pb.UpdateTransactionRequest{Transaction: &pb.Transaction{Amount: 5000}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}}}, false},
+		{"scheduled withdrawal", "/user.UserService/CreateSchedule", &pb.CreateScheduleRequest{Amount: 1000}, true},
+		{"small scheduled withdrawal", "/user.UserService/CreateSchedule", &pb.CreateScheduleRequest{Amount: 999}, false},
+		{"scheduled deposit", "/user.UserService/CreateSchedule", &pb.CreateScheduleRequest{Amount: 1000, TransactionType: pb.TransactionType_DEPOSIT}, false},
+		{"raised schedule", "/user.UserService/UpdateSchedule", &pb.UpdateScheduleRequest{Schedule: &pb.Schedule{Amount: 1000}}, true},
+		{"raised schedule w mask", "/user.UserService/UpdateSchedule", &pb.UpdateScheduleRequest{Schedule: &pb.Schedule{Amount: 1000}, UpdateMask: amountMask}, true},
+		{"small schedule", "/user.UserService/UpdateSchedule", &pb.UpdateScheduleRequest{Schedule: &pb.Schedule{Amount: 999}}, false},
+		{"schedule amount not in mask", "/user.UserService/UpdateSchedule", &pb.UpdateScheduleRequest{Schedule: &pb.Schedule{Amount: 5000}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"cron"}}}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			u := &userServiceImpl{
-				dal:      tt.fields.dal,
+			required, err := a.stepUpRequired(context.TODO(), tt.method, tt.req)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, required)
+		})
+	}
+}
+
+func TestAuthServerInterceptor_stepUpCurrency(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	userID := created.User.Id
+	accounts := map[string]int64{}
+	for _, currency := range []string{"VND", "USD", "EUR"} {
+		acc, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Currency: currency})
+		require.NoError(t, err)
+		accounts[currency] = acc.Account.Id
+	}
+	sched, err := s.CreateSchedule(ctx, &pb.CreateScheduleRequest{UserId: userID, AccountId: accounts["USD"], TransactionType: pb.TransactionType_DEPOSIT, Amount: 1, Cron: "@daily"})
+	require.NoError(t, err)
+	rates, err := fx.NewStatic("USD", map[string]string{"VND": "23000"})
+	require.NoError(t, err)
+	a := NewAuthServerInterceptor(nil, nil, nil, nil, WithStepUp(nil, 1000000, nil),
+		WithStepUpCurrency("VND", NewAccountCurrencyStore(u.dal), rates)).(*AuthServerInterceptor)
+
+	withdraw := func(currency string, amount float64) *pb.CreateTransactionRequest {
+		return &pb.CreateTransactionRequest{UserId: userID, AccountId: accounts[currency], Amount: amount}
+	}
+	tests := []struct {
+		name   string
+		method string
+		req    interface{}
+		want   bool
+		err    error
+	}{
+		{"same currency", "/user.UserService/CreateTransaction", withdraw("VND", 1000000), true, nil},
+		{"small same currency", "/user.UserService/CreateTransaction", withdraw("VND", 999999), false, nil},
+		// 44 USD = 1012000 VND
+		{"converted", "/user.UserService/CreateTransaction", withdraw("USD", 44), true, nil},
+		{"small converted", "/user.UserService/CreateTransaction", withdraw("USD", 43), false, nil},
+		{"transfer", "/user.UserService/CreateTransfer", &pb.CreateTransferRequest{UserId: userID, FromAccountId: accounts["USD"], ToAccountId: accounts["VND"], Amount: 44}, true, nil},
+		{"schedule", "/user.UserService/UpdateSchedule", &pb.UpdateScheduleRequest{UserId: userID, Schedule: &pb.Schedule{Id: sched.Schedule.Id, Amount: 44}}, true, nil},
+		{"account not found", "/user.UserService/CreateTransaction", &pb.CreateTransactionRequest{UserId: userID, AccountId: notFoundID, Amount: 44}, false, nil},
+		{"rate not found", "/user.UserService/CreateTransaction", withdraw("EUR", 44), false, errorSrv.ErrExchangeRateNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			required, err := a.stepUpRequired(ctx, tt.method, tt.req)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, required)
+		})
+	}
+}
+
+func Test_apiKeyAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		method string
+		want   bool
+	}{
+		{name: "ReadList", scopes: []string{APIKeyScopeRead}, method: "/user.UserService/ListAccounts", want: true},
+		{name: "ReadCreate", scopes: []string{APIKeyScopeRead}, method: "/user.UserService/CreateTransaction"},
+		{name: "Write", scopes: []string{APIKeyScopeWrite}, method: "/user.UserService/CreateTransaction", want: true},
+		{name: "Method", scopes: []string{APIKeyScopeRead, "/user.UserService/CreateTransaction"}, method: "/user.UserService/CreateTransaction", want: true},
+		{name: "OtherMethod", scopes: []string{"/user.UserService/CreateTransaction"}, method: "/user.UserService/DeleteTransaction"},
+		{name: "Denied", scopes: []string{APIKeyScopeWrite}, method: "/user.UserService/CreateAPIKey"},
+		{name: "DeniedUpdate", scopes: []string{APIKeyScopeWrite}, method: "/user.UserService/Update"},
+		{name: "DeniedDelete", scopes: []string{APIKeyScopeWrite}, method: "/user.UserService/Delete"},
+		{name: "DeniedScope", scopes: []string{"/user.UserService/Update"}, method: "/user.UserService/Update"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, apiKeyAllows(tt.scopes, tt.method))
+		})
+	}
+}
+
+func Test_userServiceImpl_APIKey(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	now := time.Now()
+	u.now = func() time.Time { return now }
+	store := NewAPIKeyStore(u.dal).(*dbAPIKeyStore)
+	store.now = u.now
+
+	rspCreated, err := s.Create(context.TODO(), &pb.CreateUserRequest{
+		Email:    "abc@gmail.com",
+		Password: "abc123456",
+	})
+	require.NoError(t, err)
+	id := rspCreated.User.Id
+	expiresAt := timestamppb.New(now.Add(time.Hour))
+
+	tests := []struct {
+		name string
+		req  *pb.CreateAPIKeyRequest
+		err  error
+	}{
+		{
+			name: "MissingUserID",
+			req:  &pb.CreateAPIKeyRequest{Name: "batch", Scopes: []string{APIKeyScopeRead}},
+			err:  errorSrv.ErrMissingUserID,
+		},
+		{
+			name: "MissingName",
+			req:  &pb.CreateAPIKeyRequest{UserId: id, Scopes: []string{APIKeyScopeRead}},
+			err:  errorSrv.ErrMissingAPIKeyName,
+		},
+		{
+			name: "MissingScopes",
+			req:  &pb.CreateAPIKeyRequest{UserId: id, Name: "batch"},
+			err:  errorSrv.ErrInvalidAPIKeyScope,
+		},
+		{
+			name: "UnknownScope",
+			req:  &pb.CreateAPIKeyRequest{UserId: id, Name: "batch", Scopes: []string{"/user.UserService/Unknown"}},
+			err:  errorSrv.ErrInvalidAPIKeyScope,
+		},
+		{
+			name: "DeniedScope",
+			req:  &pb.CreateAPIKeyRequest{UserId: id, Name: "batch", Scopes: []string{"/user.UserService/CreateAPIKey"}},
+			err:  errorSrv.ErrInvalidAPIKeyScope,
+		},
+		{
+			name: "PastExpiry",
+			req:  &pb.CreateAPIKeyRequest{UserId: id, Name: "batch", Scopes: []string{APIKeyScopeRead}, ExpiresAt: timestamppb.New(now)},
+			err:  errorSrv.ErrInvalidAPIKeyExpiry,
+		},
+		{
+			name: "UserNotFound",
+			req:  &pb.CreateAPIKeyRequest{UserId: notFoundID, Name: "batch", Scopes: []string{APIKeyScopeRead}},
+			err:  errorSrv.ErrUserNotFound,
+		},
+		{
+			name: "Success",
+			req:  &pb.CreateAPIKeyRequest{UserId: id, Name: "batch", Scopes: []string{APIKeyScopeRead, APIKeyScopeRead, "/user.UserService/CreateTransaction"}, ExpiresAt: expiresAt},
+		},
+	}
+	var created *pb.CreateAPIKeyResponse
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp, err := s.CreateAPIKey(context.TODO(), tt.req)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				require.Nil(t, rsp)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(rsp.Key, rsp.ApiKey.Prefix))
+			require.Equal(t, []string{APIKeyScopeRead, "/user.UserService/CreateTransaction"}, rsp.ApiKey.Scopes)
+			require.True(t, expiresAt.AsTime().Equal(rsp.ApiKey.ExpiresAt.AsTime()))
+			created = rsp
+		})
+	}
+	require.NotNil(t, created)
+	keyID := created.ApiKey.Id
+
+	// authenticate w key, only its hash is stored
+	var stored model.APIKey
+	require.NoError(t, u.dal.GetDatabase().First(&stored, keyID).Error)
+	require.NotContains(t, stored.KeyHash, created.Key)
+	claims, scopes, err := store.AuthenticateAPIKey(context.TODO(), created.Key)
+	require.NoError(t, err)
+	require.Equal(t, id, claims.ID)
+	require.Equal(t, "abc@gmail.com", claims.Email)
+	require.Equal(t, created.ApiKey.Scopes, scopes)
+	_, _, err = store.AuthenticateAPIKey(context.TODO(), created.Key+"x")
+	require.ErrorIs(t, err, errorSrv.ErrAPIKeyInvalid)
+	listed, err := s.ListAPIKeys(context.TODO(), &pb.ListAPIKeysRequest{UserId: id})
+	require.NoError(t, err)
+	require.Len(t, listed.ApiKeys, 1)
+	require.NotNil(t, listed.ApiKeys[0].LastUsedAt)
+
+	// expired key
+	now = now.Add(time.Hour)
+	_, _, err = store.AuthenticateAPIKey(context.TODO(), created.Key)
+	require.ErrorIs(t, err, errorSrv.ErrAPIKeyInvalid)
+
+	// set scopes & clear expiry
+	_, err = s.UpdateAPIKey(context.TODO(), &pb.UpdateAPIKeyRequest{UserId: id, Id: keyID, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}}})
+	require.Error(t, err)
+	_, err = s.UpdateAPIKey(context.TODO(), &pb.UpdateAPIKeyRequest{UserId: notFoundID, Id: keyID, Scopes: []string{APIKeyScopeWrite}})
+	require.ErrorIs(t, err, errorSrv.ErrAPIKeyNotFound)
+	updated, err := s.UpdateAPIKey(context.TODO(), &pb.UpdateAPIKeyRequest{UserId: id, Id: keyID, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"expires_at"}}})
+	require.NoError(t, err)
+	require.Nil(t, updated.ApiKey.ExpiresAt)
+	require.Equal(t, created.ApiKey.Scopes, updated.ApiKey.Scopes)
+	updated, err = s.UpdateAPIKey(context.TODO(), &pb.UpdateAPIKeyRequest{UserId: id, Id: keyID, Scopes: []string{APIKeyScopeWrite}})
+	require.NoError(t, err)
+	require.Equal(t, []string{APIKeyScopeWrite}, updated.ApiKey.Scopes)
+	_, scopes, err = store.AuthenticateAPIKey(context.TODO(), created.Key)
+	require.NoError(t, err)
+	require.Equal(t, []string{APIKeyScopeWrite}, scopes)
+
+	// revoke
+	_, err = s.RevokeAPIKey(context.TODO(), &pb.RevokeAPIKeyRequest{UserId: notFoundID, Id: keyID})
+	require.ErrorIs(t, err, errorSrv.ErrAPIKeyNotFound)
+	_, err = s.RevokeAPIKey(context.TODO(), &pb.RevokeAPIKeyRequest{UserId: id, Id: keyID})
+	require.NoError(t, err)
+	_, err = s.RevokeAPIKey(context.TODO(), &pb.RevokeAPIKeyRequest{UserId: id, Id: keyID})
+	require.NoError(t, err)
+	_, _, err = store.AuthenticateAPIKey(context.TODO(), created.Key)
+	require.ErrorIs(t, err, errorSrv.ErrAPIKeyInvalid)
+	_, err = s.UpdateAPIKey(context.TODO(), &pb.UpdateAPIKeyRequest{UserId: id, Id: keyID, Scopes: []string{APIKeyScopeRead}})
+	require.ErrorIs(t, err, errorSrv.ErrAPIKeyNotFound)
+	listed, err = s.ListAPIKeys(context.TODO(), &pb.ListAPIKeysRequest{UserId: id})
+	require.NoError(t, err)
+	require.True(t, listed.ApiKeys[0].Revoked)
+}
+
+func Test_userServiceImpl_LoginOIDC(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	db := u.dal.GetDatabase()
+
+	// unverified user may be registered by someone else
+	unverified, err := s.Create(context.TODO(), &pb.CreateUserRequest{Email: "unverified@gmail.com", Password: "abc123456"})
+	require.NoError(t, err)
+	verified, err := s.Create(context.TODO(), &pb.CreateUserRequest{Email: "verified@gmail.com", Password: "abc123456"})
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&model.User{}).Where("id = ?", verified.User.Id).Update("email_verified", true).Error)
+	totp, err := s.Create(context.TODO(), &pb.CreateUserRequest{Email: "totp@gmail.com", Password: "abc123456"})
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&model.User{}).Where("id = ?", totp.User.Id).Updates(map[string]interface{}{"email_verified": true, "totp_enabled": true}).Error)
+
+	tests := []struct {
+		name  string
+		email string
+		// user linked
+		wantID            int64
+		wantTwoFactor     bool
+		wantPasswordReset bool
+		err               error
+	}{
+		{name: "InvalidEmail", email: "invalid", err: errorSrv.ErrInvalidEmail},
+		{name: "NewUser", email: "New@gmail.com"},
+		{name: "UnverifiedUser", email: "unverified@gmail.com", wantID: unverified.User.Id, wantPasswordReset: true},
+		{name: "VerifiedUser", email: "VERIFIED@gmail.com", wantID: verified.User.Id},
+		{name: "TwoFactor", email: "totp@gmail.com", wantID: totp.User.Id, wantTwoFactor: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp, err := u.LoginOIDC(context.TODO(), tt.email, "10.0.0.1", "test")
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				require.Nil(t, rsp)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, strings.ToLower(tt.email), rsp.User.Email)
+			require.True(t, rsp.User.EmailVerified)
+			if tt.wantID != 0 {
+				require.Equal(t, tt.wantID, rsp.User.Id)
+			}
+			if tt.wantTwoFactor {
+				require.True(t, rsp.TwoFactorRequired)
+				require.Empty(t, rsp.Token)
+				return
+			}
+			_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: rsp.Token})
+			require.NoError(t, err)
+			// password of a possibly hijacked account is reset & its tokens revoked
+			_, err = s.Login(context.TODO(), &pb.LoginRequest{Email: tt.email, Password: "abc123456"})
+			if tt.wantPasswordReset {
+				require.ErrorIs(t, err, errorSrv.ErrIncorrectPassword)
+				_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: unverified.Token})
+				require.ErrorIs(t, err, errorSrv.ErrTokenInvalid)
+			} else if tt.wantID != 0 {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_userServiceImpl_Sessions(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	// claims of the token set by AuthServerInterceptor
+	authCtx := func(token string) context.Context {
+		claims, err := u.tokenSrv.Verify(context.TODO(), token)
+		require.NoError(t, err)
+		return withClaims(context.TODO(), claims)
+	}
+
+	created, err := s.Create(context.TODO(), &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "abc123456"})
+	require.NoError(t, err)
+	id := created.User.Id
+	login, err := s.Login(context.TODO(), &pb.LoginRequest{Email: "abc@gmail.com", Password: "abc123456"})
+	require.NoError(t, err)
+
+	// refreshed token keeps its session
+	refreshed, err := s.RefreshToken(context.TODO(), &pb.RefreshTokenRequest{Token: login.Token})
+	require.NoError(t, err)
+	listed, err := s.ListSessions(authCtx(refreshed.Token), &pb.ListSessionsRequest{UserId: id})
+	require.NoError(t, err)
+	require.Len(t, listed.Sessions, 2)
+	require.True(t, listed.Sessions[0].Current)
+	loginSession := listed.Sessions[0].Id
+
+	// logout revokes current session only
+	_, err = s.Logout(authCtx(refreshed.Token), &pb.LogoutRequest{Id: id})
+	require.NoError(t, err)
+	for _, token := range []string{login.Token, refreshed.Token} {
+		_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: token})
+		require.ErrorIs(t, err, errorSrv.ErrTokenInvalid)
+		_, err = s.RefreshToken(context.TODO(), &pb.RefreshTokenRequest{Token: token})
+		require.ErrorIs(t, err, errorSrv.ErrTokenInvalid)
+	}
+	_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: created.Token})
+	require.NoError(t, err)
+	listed, err = s.ListSessions(context.TODO(), &pb.ListSessionsRequest{UserId: id})
+	require.NoError(t, err)
+	require.Len(t, listed.Sessions, 1)
+	require.NotEqual(t, loginSession, listed.Sessions[0].Id)
+
+	// revoke session of other user or unknown
+	_, err = s.RevokeSession(context.TODO(), &pb.RevokeSessionRequest{UserId: id + 1, Id: listed.Sessions[0].Id})
+	require.ErrorIs(t, err, errorSrv.ErrSessionNotFound)
+	_, err = s.RevokeSession(context.TODO(), &pb.RevokeSessionRequest{UserId: id, Id: notFoundID})
+	require.ErrorIs(t, err, errorSrv.ErrSessionNotFound)
+	// revoking a revoked session succeeds
+	_, err = s.RevokeSession(context.TODO(), &pb.RevokeSessionRequest{UserId: id, Id: loginSession})
+	require.NoError(t, err)
+
+	// password change revokes sessions, the returned token starts a new one
+	changed, err := s.ChangePassword(context.TODO(), &pb.ChangePasswordRequest{UserId: id, CurrentPassword: "abc123456", NewPassword: "new123456"})
+	require.NoError(t, err)
+	listed, err = s.ListSessions(authCtx(changed.Token), &pb.ListSessionsRequest{UserId: id})
+	require.NoError(t, err)
+	require.Len(t, listed.Sessions, 1)
+	require.True(t, listed.Sessions[0].Current)
+
+	// expired sessions aren't listed
+	now := time.Now()
+	u.now = func() time.Time { return now.Add(u.tokenSrv.TTL()) }
+	listed, err = s.ListSessions(context.TODO(), &pb.ListSessionsRequest{UserId: id})
+	require.NoError(t, err)
+	require.Empty(t, listed.Sessions)
+	u.now = time.Now
+
+	// logout everywhere revokes tokens w/o session too
+	legacy, err := u.tokenSrv.Generate(&model.User{ID: id, Email: "abc@gmail.com", TokenVersion: 1}, 0)
+	require.NoError(t, err)
+	_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: legacy})
+	require.NoError(t, err)
+	_, err = s.Logout(context.TODO(), &pb.LogoutRequest{Id: id, All: true})
+	require.NoError(t, err)
+	for _, token := range []string{changed.Token, legacy} {
+		_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: token})
+		require.ErrorIs(t, err, errorSrv.ErrTokenInvalid)
+	}
+	listed, err = s.ListSessions(context.TODO(), &pb.ListSessionsRequest{UserId: id})
+	require.NoError(t, err)
+	require.Empty(t, listed.Sessions)
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	strict := NewPasswordPolicy(&configs.PasswordPolicy{MinLength: 10, MinCharClasses: 3, RejectCommon: true})
+	tests := []struct {
+		name     string
+		policy   *PasswordPolicy
+		password string
+		wantErr  bool
+	}{
+		{name: "Default", policy: NewPasswordPolicy(nil), password: "abcdefgh"},
+		{name: "DefaultTooShort", policy: NewPasswordPolicy(nil), password: "abcdefg", wantErr: true},
+		{name: "Strict", policy: strict, password: "Correct-horse"},
+		{name: "TooShort", policy: strict, password: "Abc-12345", wantErr: true},
+		{name: "CharClasses", policy: strict, password: "correcthorse1", wantErr: true},
+		{name: "Unicode", policy: strict, password: "Pässwörtchen1"},
+		{name: "Common", policy: strict, password: "Password123", wantErr: true},
+		{name: "CommonCaseInsensitive", policy: NewPasswordPolicy(&configs.PasswordPolicy{RejectCommon: true}), password: "BASEBALL", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate(tt.password)
+			if !tt.wantErr {
+				require.NoError(t, err)
+				return
+			}
+			st := status.Convert(err)
+			require.Equal(t, codes.InvalidArgument, st.Code())
+			require.Equal(t, "Invalid password", st.Message())
+		})
+	}
+}
+
+func Test_userServiceImpl_PasswordHistory(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	u.passwordPolicy = NewPasswordPolicy(&configs.PasswordPolicy{HistorySize: 3})
+
+	created, err := s.Create(context.TODO(), &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "password-0"})
+	require.NoError(t, err)
+	change := func(current, password string) error {
+		_, err := s.ChangePassword(context.TODO(), &pb.ChangePasswordRequest{UserId: created.User.Id, CurrentPassword: current, NewPassword: password})
+		return err
+	}
+	require.ErrorIs(t, change("password-0", "password-0"), errorSrv.ErrPasswordReused)
+	require.NoError(t, change("password-0", "password-1"))
+	require.NoError(t, change("password-1", "password-2"))
+	// last 3 passwords w the current one
+	for _, reused := range []string{"password-0", "password-1", "password-2"} {
+		require.ErrorIs(t, change("password-2", reused), errorSrv.ErrPasswordReused)
+	}
+	require.NoError(t, change("password-2", "password-3"))
+	// oldest dropped from history
+	require.NoError(t, change("password-3", "password-0"))
+	var count int64
+	require.NoError(t, u.dal.GetDatabase().Model(&model.PasswordHistory{}).Where("user_id = ?", created.User.Id).Count(&count).Error)
+	require.EqualValues(t, 2, count)
+
+	// reset checks history too
+	notifier := newTestNotifier()
+	u.notifier = notifier
+	_, err = s.RequestPasswordReset(context.TODO(), &pb.RequestPasswordResetRequest{Email: "abc@gmail.com"})
+	require.NoError(t, err)
+	token := notifier.resets["abc@gmail.com"]
+	_, err = s.ConfirmPasswordReset(context.TODO(), &pb.ConfirmPasswordResetRequest{Token: token, NewPassword: "password-3"})
+	require.ErrorIs(t, err, errorSrv.ErrPasswordReused)
+	// rejected reset keeps its token
+	_, err = s.ConfirmPasswordReset(context.TODO(), &pb.ConfirmPasswordResetRequest{Token: token, NewPassword: "password-4"})
+	require.NoError(t, err)
+}
+
+func Test_userServiceImpl_LoginRehash(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	created, err := s.Create(context.TODO(), &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "abc123456"})
+	require.NoError(t, err)
+	stored := func() string {
+		var user model.User
+		require.NoError(t, u.dal.GetDatabase().First(&user, created.User.Id).Error)
+		return user.Password
+	}
+	bcryptHash := stored()
+	require.True(t, strings.HasPrefix(bcryptHash, "$2a$"))
+
+	// switch to argon2id, bcrypt hash upgraded on next login
+	hasher, err := utils.NewPasswordHasher(&configs.PasswordHashing{Algorithm: utils.PasswordHashArgon2id, Argon2Time: 1, Argon2Memory: 1024, Argon2Threads: 1})
+	require.NoError(t, err)
+	utils.SetPasswordHasher(hasher)
+	defer func() {
+		hasher, _ := utils.NewPasswordHasher(nil)
+		utils.SetPasswordHasher(hasher)
+	}()
+	_, err = s.Login(context.TODO(), &pb.LoginRequest{Email: "abc@gmail.com", Password: "wrong-password"})
+	require.ErrorIs(t, err, errorSrv.ErrIncorrectPassword)
+	require.Equal(t, bcryptHash, stored())
+	_, err = s.Login(context.TODO(), &pb.LoginRequest{Email: "abc@gmail.com", Password: "abc123456"})
+	require.NoError(t, err)
+	argon2Hash := stored()
+	require.True(t, strings.HasPrefix(argon2Hash, "$argon2id$"))
+	// same password, issued tokens stay valid
+	_, err = s.Validate(context.TODO(), &pb.ValidateRequest{Token: created.Token})
+	require.NoError(t, err)
+	_, err = s.Login(context.TODO(), &pb.LoginRequest{Email: "abc@gmail.com", Password: "abc123456"})
+	require.NoError(t, err)
+	require.Equal(t, argon2Hash, stored())
+}
+
+func Test_userServiceImpl_Logout(t *testing.T) {
+	type fields struct {
+		dal      *postgres.DataAccessLayer
+		logger   log.Factory
+		tokenSrv *TokenService
+	}
+	type args struct {
+		ctx context.Context
+		req *pb.LogoutRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *pb.LogoutResponse
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &userServiceImpl{
+				dal:      tt.fields.dal,
+				logger:   tt.fields.logger,
+				tokenSrv: tt.fields.tokenSrv,
+			}
+			got, err := u.Logout(tt.args.ctx, tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("userServiceImpl.Logout() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userServiceImpl.Logout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_userServiceImpl_Validate(t *testing.T) {
+	type fields struct {
+		dal      *postgres.DataAccessLayer
+		logger   log.Factory
+		tokenSrv *TokenService
+	}
+	type args struct {
+		ctx context.Context
+		req *pb.ValidateRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *pb.ValidateResponse
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &userServiceImpl{
+				dal:      tt.fields.dal,
 				logger:   tt.fields.logger,
 				tokenSrv: tt.fields.tokenSrv,
 			}
@@ -569,7 +1530,7 @@ func Test_userServiceImpl_CreateAccount(t *testing.T) {
 		{
 			name: "ErrUserNotFound",
 			req: &pb.CreateAccountRequest{
-				UserId: 1,
+				UserId: notFoundID,
 			},
 			err: errorSrv.ErrUserNotFound,
 		},
@@ -582,6 +1543,16 @@ func Test_userServiceImpl_CreateAccount(t *testing.T) {
 			},
 			err: errorSrv.ErrInvalidAccountBalance,
 		},
+		{
+			name: "ErrInvalidAmountPrecision",
+			req: &pb.CreateAccountRequest{
+				UserId:   rspUserCreated.User.Id,
+				Bank:     pb.Bank_ACB,
+				Balance:  10.005,
+				Currency: "USD",
+			},
+			err: errorSrv.ErrInvalidAmountPrecision,
+		},
 		{
 			name: "Success",
 			req: &pb.CreateAccountRequest{
@@ -665,7 +1636,7 @@ func Test_userServiceImpl_ListAccounts(t *testing.T) {
 		{
 			name: "ErrUserNotFound",
 			req: &pb.ListAccountsRequest{
-				UserId: wrapperspb.Int64(1),
+				UserId: wrapperspb.Int64(notFoundID),
 			},
 			err: errorSrv.ErrUserNotFound,
 		},
@@ -761,8 +1732,8 @@ func Test_userServiceImpl_CreateTransaction(t *testing.T) {
 		{
 			name: "ErrAccountNotFound",
 			req: &pb.CreateTransactionRequest{
-				UserId:    1,
-				AccountId: 1,
+				UserId:    notFoundID,
+				AccountId: notFoundID,
 				Amount:    10000,
 			},
 			err: errorSrv.ErrAccountNotFound,
@@ -950,8 +1921,8 @@ func Test_userServiceImpl_ListTransactions(t *testing.T) {
 		{
 			name: "ErrTransactionNotFound",
 			req: &pb.ListTransactionsRequest{
-				UserId:    1,
-				AccountId: 2,
+				UserId:    notFoundID,
+				AccountId: notFoundID,
 			},
 			err: errorSrv.ErrTransactionNotFound,
 		},
@@ -1079,8 +2050,8 @@ func Test_userServiceImpl_DeleteTransaction(t *testing.T) {
 		{
 			name: "ErrTransactionNotFound",
 			req: &pb.DeleteTransactionRequest{
-				UserId:    1,
-				AccountId: wrapperspb.Int64(1),
+				UserId:    notFoundID,
+				AccountId: wrapperspb.Int64(notFoundID),
 			},
 			err: errorSrv.ErrTransactionNotFound,
 		},
@@ -1230,10 +2201,10 @@ func Test_userServiceImpl_UpdateTransaction(t *testing.T) {
 		{
 			name: "ErrAccountNotFound",
 			req: &pb.UpdateTransactionRequest{
-				UserId:    1,
-				AccountId: 1,
+				UserId:    notFoundID,
+				AccountId: notFoundID,
 				Transaction: &pb.Transaction{
-					Id: 1,
+					Id: notFoundID,
 				},
 			},
 			err: errorSrv.ErrAccountNotFound,
@@ -1244,7 +2215,7 @@ func Test_userServiceImpl_UpdateTransaction(t *testing.T) {
 				UserId:    rspUserCreated.User.Id,
 				AccountId: rspAccCreated[0].Account.Id,
 				Transaction: &pb.Transaction{
-					Id: 1,
+					Id: notFoundID,
 				},
 			},
 			err: errorSrv.ErrTransactionNotFound,
@@ -1304,19 +2275,65 @@ func Test_userServiceImpl_UpdateTransaction(t *testing.T) {
 			err: errorSrv.ErrInvalidTransactionAmountGT0,
 		},
 		{
-			name: "ErrInvalidUpdateTransactionID",
+			name: "ErrInvalidTransactionAmountLE0",
 			req: &pb.UpdateTransactionRequest{
 				UserId:    rspUserCreated.User.Id,
 				AccountId: rspAccCreated[0].Account.Id,
 				Transaction: &pb.Transaction{
 					Id:     rspTransCreated[1].Transaction.Id,
-					Amount: 10000,
+					Amount: -100,
 				},
 				UpdateMask: &fieldmaskpb.FieldMask{
-					Paths: []string{"id"},
+					Paths: []string{"amount"},
 				},
 			},
-			err: errors.BadRequest("cannot update id", map[string]string{"update_mask": "cannot update id field"}),
+			err: errorSrv.ErrInvalidTransactionAmountGT0,
+		},
+		{
+			name: "ErrInvalidAmountPrecision",
+			req: &pb.UpdateTransactionRequest{
+				UserId:    rspUserCreated.User.Id,
+				AccountId: rspAccCreated[0].Account.Id,
+				Transaction: &pb.Transaction{
+					Id:     rspTransCreated[1].Transaction.Id,
+					Amount: 100.5,
+				},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"amount"},
+				},
+			},
+			err: errorSrv.ErrInvalidAmountPrecision,
+		},
+		{
+			name: "ErrCurrencyMismatch",
+			req: &pb.UpdateTransactionRequest{
+				UserId:    rspUserCreated.User.Id,
+				AccountId: rspAccCreated[0].Account.Id,
+				Transaction: &pb.Transaction{
+					Id:       rspTransCreated[1].Transaction.Id,
+					Amount:   100,
+					Currency: "USD",
+				},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"amount"},
+				},
+			},
+			err: errorSrv.ErrCurrencyMismatch,
+		},
+		{
+			name: "ErrInvalidUpdateTransactionID",
+			req: &pb.UpdateTransactionRequest{
+				UserId:    rspUserCreated.User.Id,
+				AccountId: rspAccCreated[0].Account.Id,
+				Transaction: &pb.Transaction{
+					Id:     rspTransCreated[1].Transaction.Id,
+					Amount: 10000,
+				},
+				UpdateMask: &fieldmaskpb.FieldMask{
+					Paths: []string{"id"},
+				},
+			},
+			err: errors.BadRequest("cannot update id", map[string]string{"update_mask": "cannot update id field"}),
 		},
 		{
 			name: "ErrInvalidUpdateTransactionType",
@@ -1413,3 +2430,1189 @@ func Test_userServiceImpl_UpdateTransaction(t *testing.T) {
 		})
 	}
 }
+
+func TestNewTransactionLimitPolicy(t *testing.T) {
+	p, err := NewTransactionLimitPolicy(nil)
+	require.NoError(t, err)
+	require.True(t, p.tiers[DefaultTransactionTier].Unlimited())
+	require.Equal(t, model.DefaultCurrency, p.currency)
+
+	tests := []struct {
+		name   string
+		config *configs.TransactionLimits
+	}{
+		{name: "UnknownDefaultTier", config: &configs.TransactionLimits{DefaultTier: "gold"}},
+		{name: "NegativeLimit", config: &configs.TransactionLimits{Tiers: map[string]*configs.TransactionLimit{"standard": {DailyWithdrawal: -1}}}},
+		{name: "InvalidLocation", config: &configs.TransactionLimits{Location: "Mars/Olympus"}},
+		{name: "InvalidCurrency", config: &configs.TransactionLimits{Currency: "dollar"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTransactionLimitPolicy(tt.config)
+			require.Error(t, err)
+		})
+	}
+
+	// day & month windows in the configured time zone
+	p, err = NewTransactionLimitPolicy(&configs.TransactionLimits{Location: "Asia/Tokyo"})
+	require.NoError(t, err)
+	dayStart, dayEnd, monthStart, monthEnd := p.windows(time.Date(2021, 1, 31, 16, 0, 0, 0, time.UTC))
+	require.Equal(t, time.Date(2021, 1, 31, 15, 0, 0, 0, time.UTC), dayStart.UTC())
+	require.Equal(t, 24*time.Hour, dayEnd.Sub(dayStart))
+	require.Equal(t, time.Date(2021, 1, 31, 15, 0, 0, 0, time.UTC), monthStart.UTC())
+	require.Equal(t, time.Date(2021, 2, 28, 15, 0, 0, 0, time.UTC), monthEnd.UTC())
+}
+
+func Test_userServiceImpl_TransactionLimits(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	policy, err := NewTransactionLimitPolicy(&configs.TransactionLimits{
+		Tiers: map[string]*configs.TransactionLimit{
+			"standard": {MaxWithdrawal: 500, DailyWithdrawal: 800, MonthlyWithdrawal: 1000, DailyTransactions: 4},
+			"Premium":  {},
+		},
+	})
+	require.NoError(t, err)
+	u.transactionLimits = policy
+	now := time.Date(2021, 3, 10, 9, 0, 0, 0, time.UTC)
+	u.now = func() time.Time { return now }
+
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	userID := created.User.Id
+	acc, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Balance: 10000})
+	require.NoError(t, err)
+	accID := acc.Account.Id
+
+	create := func(typ pb.TransactionType, amount float64) (*pb.Transaction, error) {
+		rsp, err := s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: accID, TransactionType: typ, Amount: amount})
+		return rsp.GetTransaction(), err
+	}
+	// limit hit & amount or number of transactions left
+	requireLimit := func(t *testing.T, err error, limit, left string) {
+		st := status.Convert(err)
+		require.Equal(t, codes.FailedPrecondition, st.Code())
+		require.Len(t, st.Details(), 1)
+		violations := st.Details()[0].(*errdetails.PreconditionFailure).GetViolations()
+		require.Len(t, violations, 1)
+		require.Equal(t, limit, violations[0].GetSubject())
+		require.Equal(t, left, violations[0].GetDescription())
+	}
+
+	// max single withdrawal
+	_, err = create(pb.TransactionType_WITHDRAW, 600)
+	requireLimit(t, err, limitMaxWithdrawal, "500")
+	// daily withdrawal
+	_, err = create(pb.TransactionType_WITHDRAW, 500)
+	require.NoError(t, err)
+	_, err = create(pb.TransactionType_WITHDRAW, 400)
+	requireLimit(t, err, limitDailyWithdrawal, "300")
+	_, err = create(pb.TransactionType_WITHDRAW, 300)
+	require.NoError(t, err)
+	// daily transactions, deposits included
+	_, err = create(pb.TransactionType_DEPOSIT, 100)
+	require.NoError(t, err)
+	_, err = create(pb.TransactionType_DEPOSIT, 100)
+	require.NoError(t, err)
+	_, err = create(pb.TransactionType_DEPOSIT, 100)
+	requireLimit(t, err, limitDailyTransactions, "0")
+	// rejected transactions don't change the balance
+	accs, err := s.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(userID)})
+	require.NoError(t, err)
+	require.EqualValues(t, 10000-800+200, accs.Accounts[0].Balance)
+	// deleted transactions still count
+	deleted, err := s.DeleteTransaction(ctx, &pb.DeleteTransactionRequest{UserId: userID, AccountId: wrapperspb.Int64(accID)})
+	require.NoError(t, err)
+	require.Len(t, deleted.Ids, 4)
+	_, err = create(pb.TransactionType_DEPOSIT, 100)
+	requireLimit(t, err, limitDailyTransactions, "0")
+
+	// monthly withdrawal w the deleted ones, next day
+	now = now.Add(24 * time.Hour)
+	trans, err := create(pb.TransactionType_WITHDRAW, 200)
+	require.NoError(t, err)
+	_, err = create(pb.TransactionType_WITHDRAW, 1)
+	requireLimit(t, err, limitMonthlyWithdrawal, "0")
+
+	limits, err := s.GetTransactionLimits(ctx, &pb.GetTransactionLimitsRequest{UserId: userID, AccountId: accID})
+	require.NoError(t, err)
+	require.Equal(t, DefaultTransactionTier, limits.Tier)
+	require.False(t, limits.AccountLimits)
+	require.EqualValues(t, 800, limits.Limits.DailyWithdrawal)
+	require.EqualValues(t, 200, limits.Usage.DailyWithdrawal)
+	require.EqualValues(t, 1000, limits.Usage.MonthlyWithdrawal)
+	require.EqualValues(t, 1, limits.Usage.DailyTransactions)
+	_, err = s.GetTransactionLimits(ctx, &pb.GetTransactionLimitsRequest{UserId: userID, AccountId: notFoundID})
+	require.ErrorIs(t, err, errorSrv.ErrAccountNotFound)
+
+	// raising a withdrawal is checked, lowering it isn't
+	update := func(amount float64) error {
+		_, err := s.UpdateTransaction(ctx, &pb.UpdateTransactionRequest{UserId: userID, AccountId: accID, Transaction: &pb.Transaction{Id: trans.Id, Amount: amount}})
+		return err
+	}
+	// the transaction may withdraw what its month has left w/o it
+	requireLimit(t, update(300), limitMonthlyWithdrawal, "200")
+	require.NoError(t, update(100))
+	require.NoError(t, update(200))
+
+	// set limits
+	set := func(req *pb.SetTransactionLimitsRequest) (*pb.SetTransactionLimitsResponse, error) {
+		req.UserId = userID
+		return s.SetTransactionLimits(ctx, req)
+	}
+	_, err = set(&pb.SetTransactionLimitsRequest{Tier: "gold"})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidTier)
+	_, err = set(&pb.SetTransactionLimitsRequest{AccountId: accID, Limits: &pb.TransactionLimits{DailyWithdrawal: -1}})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidTransactionLimit)
+	_, err = set(&pb.SetTransactionLimitsRequest{AccountId: notFoundID, Limits: &pb.TransactionLimits{}})
+	require.ErrorIs(t, err, errorSrv.ErrAccountNotFound)
+	_, err = s.SetTransactionLimits(ctx, &pb.SetTransactionLimitsRequest{UserId: notFoundID, Tier: "premium"})
+	require.ErrorIs(t, err, errorSrv.ErrUserNotFound)
+
+	// account limits override the user tier
+	for i := 0; i < 2; i++ {
+		rsp, err := set(&pb.SetTransactionLimitsRequest{AccountId: accID, Limits: &pb.TransactionLimits{DailyWithdrawal: 5000 + float64(i)}})
+		require.NoError(t, err)
+		require.Equal(t, DefaultTransactionTier, rsp.Tier)
+		require.EqualValues(t, 5000+i, rsp.Limits.DailyWithdrawal)
+	}
+	_, err = create(pb.TransactionType_WITHDRAW, 2000)
+	require.NoError(t, err)
+	limits, err = s.GetTransactionLimits(ctx, &pb.GetTransactionLimitsRequest{UserId: userID, AccountId: accID})
+	require.NoError(t, err)
+	require.True(t, limits.AccountLimits)
+	require.EqualValues(t, 5001, limits.Limits.DailyWithdrawal)
+	require.Zero(t, limits.Limits.MaxWithdrawal)
+
+	// reset account to its user tier
+	rsp, err := set(&pb.SetTransactionLimitsRequest{AccountId: accID})
+	require.NoError(t, err)
+	require.EqualValues(t, 800, rsp.Limits.DailyWithdrawal)
+	_, err = create(pb.TransactionType_WITHDRAW, 1)
+	// already over the limit
+	requireLimit(t, err, limitDailyWithdrawal, "0")
+
+	// unlimited tier, names are case insensitive
+	rsp, err = set(&pb.SetTransactionLimitsRequest{Tier: "PREMIUM"})
+	require.NoError(t, err)
+	require.Equal(t, "premium", rsp.Tier)
+	_, err = create(pb.TransactionType_WITHDRAW, 1000)
+	require.NoError(t, err)
+
+	// usage is per calendar month
+	_, err = set(&pb.SetTransactionLimitsRequest{Tier: DefaultTransactionTier})
+	require.NoError(t, err)
+	now = time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	_, err = create(pb.TransactionType_WITHDRAW, 500)
+	require.NoError(t, err)
+
+	// tier limits are used by all accounts of the user
+	acc, err = s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_ACB, Balance: 10000})
+	require.NoError(t, err)
+	accID = acc.Account.Id
+	_, err = create(pb.TransactionType_WITHDRAW, 400)
+	requireLimit(t, err, limitDailyWithdrawal, "300")
+	limits, err = s.GetTransactionLimits(ctx, &pb.GetTransactionLimitsRequest{UserId: userID, AccountId: accID})
+	require.NoError(t, err)
+	require.EqualValues(t, 500, limits.Usage.DailyWithdrawal)
+	require.EqualValues(t, 1, limits.Usage.DailyTransactions)
+	_, err = create(pb.TransactionType_WITHDRAW, 300)
+	require.NoError(t, err)
+	// own limits are used by the account only
+	_, err = set(&pb.SetTransactionLimitsRequest{AccountId: accID, Limits: &pb.TransactionLimits{DailyWithdrawal: 500}})
+	require.NoError(t, err)
+	_, err = create(pb.TransactionType_WITHDRAW, 200)
+	require.NoError(t, err)
+	limits, err = s.GetTransactionLimits(ctx, &pb.GetTransactionLimitsRequest{UserId: userID, AccountId: accID})
+	require.NoError(t, err)
+	require.EqualValues(t, 500, limits.Usage.DailyWithdrawal)
+}
+
+func Test_userServiceImpl_TransactionLimitsCurrency(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	policy, err := NewTransactionLimitPolicy(&configs.TransactionLimits{
+		Currency: "vnd",
+		Tiers: map[string]*configs.TransactionLimit{
+			"standard": {DailyWithdrawal: 2300000},
+		},
+	})
+	require.NoError(t, err)
+	u.transactionLimits = policy
+	u.rates, err = fx.NewStatic("USD", map[string]string{"VND": "23000"})
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	userID := created.User.Id
+	vnd, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Balance: 10000000})
+	require.NoError(t, err)
+	usd, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_ACB, Balance: 1000, Currency: "USD"})
+	require.NoError(t, err)
+	withdraw := func(accID int64, amount float64) error {
+		_, err := s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: accID, TransactionType: pb.TransactionType_WITHDRAW, Amount: amount})
+		return err
+	}
+	limits := func(accID int64) *pb.GetTransactionLimitsResponse {
+		rsp, err := s.GetTransactionLimits(ctx, &pb.GetTransactionLimitsRequest{UserId: userID, AccountId: accID})
+		require.NoError(t, err)
+		return rsp
+	}
+
+	// withdrawals of all accounts are converted to the tier limits currency
+	require.NoError(t, withdraw(vnd.Account.Id, 1150000))
+	err = withdraw(usd.Account.Id, 60)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Contains(t, status.Convert(err).Details()[0].(*errdetails.PreconditionFailure).GetViolations()[0].GetDescription(), "1150000")
+	require.NoError(t, withdraw(usd.Account.Id, 50))
+	got := limits(usd.Account.Id)
+	require.Equal(t, "VND", got.Currency)
+	require.EqualValues(t, 2300000, got.Usage.DailyWithdrawal)
+	require.Error(t, withdraw(vnd.Account.Id, 1))
+
+	// own limits are in the account currency
+	_, err = s.SetTransactionLimits(ctx, &pb.SetTransactionLimitsRequest{UserId: userID, AccountId: usd.Account.Id, Limits: &pb.TransactionLimits{DailyWithdrawal: 100}})
+	require.NoError(t, err)
+	got = limits(usd.Account.Id)
+	require.Equal(t, "USD", got.Currency)
+	require.EqualValues(t, 50, got.Usage.DailyWithdrawal)
+	require.NoError(t, withdraw(usd.Account.Id, 50))
+
+	// usage in other currencies can't be checked w/o rates
+	u.rates = nil
+	require.ErrorIs(t, withdraw(vnd.Account.Id, 1), errorSrv.ErrExchangeRateNotFound)
+}
+
+func TestNewOverdraftFees(t *testing.T) {
+	fees, err := NewOverdraftFees(nil, nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, fees)
+	fees, err = NewOverdraftFees(nil, &configs.Overdraft{}, nil)
+	require.NoError(t, err)
+	require.Nil(t, fees)
+	_, err = NewOverdraftFees(nil, &configs.Overdraft{DailyFee: -1}, nil)
+	require.Error(t, err)
+	_, err = NewOverdraftFees(nil, &configs.Overdraft{DailyFee: 1, Location: "Mars/Base"}, nil)
+	require.Error(t, err)
+	_, err = NewOverdraftFees(nil, &configs.Overdraft{DailyFee: 1, Currency: "dollar"}, nil)
+	require.Error(t, err)
+	fees, err = NewOverdraftFees(nil, &configs.Overdraft{DailyFee: 1, Location: "Asia/Tokyo"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, DefaultOverdraftCheckInterval, fees.interval)
+	require.Equal(t, "Asia/Tokyo", fees.location.String())
+	require.Equal(t, model.DefaultCurrency, fees.currency)
+}
+
+func Test_userServiceImpl_OverdraftCurrency(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	now := time.Date(2021, 3, 10, 9, 0, 0, 0, time.UTC)
+	rates, err := fx.NewStatic("USD", map[string]string{"VND": "23000"})
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	userID := created.User.Id
+	balances := map[string]float64{}
+	for _, currency := range []string{"VND", "USD", "EUR"} {
+		acc, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Currency: currency})
+		require.NoError(t, err)
+		_, err = s.SetOverdraftLimit(ctx, &pb.SetOverdraftLimitRequest{UserId: userID, AccountId: acc.Account.Id, OverdraftLimit: 100})
+		require.NoError(t, err)
+		_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: acc.Account.Id, TransactionType: pb.TransactionType_WITHDRAW, Amount: 10})
+		require.NoError(t, err)
+	}
+	requireBalances := func(t *testing.T) {
+		accs, err := s.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(userID)})
+		require.NoError(t, err)
+		require.Len(t, accs.Accounts, len(balances))
+		for _, acc := range accs.Accounts {
+			require.EqualValues(t, balances[acc.Currency], acc.Balance, acc.Currency)
+		}
+	}
+
+	// fee converted to the account currency, in its minor units
+	fees, err := NewOverdraftFees(u.dal, &configs.Overdraft{DailyFee: 23000, Currency: "VND"}, rates)
+	require.NoError(t, err)
+	fees.now = func() time.Time { return now }
+	n, err := fees.Charge(ctx)
+	// no rate of EUR, charged once there is one
+	require.ErrorIs(t, err, fx.ErrRateNotFound)
+	require.Equal(t, 2, n)
+	balances["VND"], balances["USD"], balances["EUR"] = -23010, -11, -10
+	requireBalances(t)
+}
+
+func Test_userServiceImpl_Overdraft(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	now := time.Date(2021, 3, 10, 9, 0, 0, 0, time.UTC)
+	u.now = func() time.Time { return now }
+	fees, err := NewOverdraftFees(u.dal, &configs.Overdraft{DailyFee: 5}, nil)
+	require.NoError(t, err)
+	fees.now = func() time.Time { return now }
+
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	userID := created.User.Id
+	acc, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Balance: 100})
+	require.NoError(t, err)
+	accID := acc.Account.Id
+	// not overdrawn, never charged
+	_, err = s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_ACB})
+	require.NoError(t, err)
+
+	create := func(typ pb.TransactionType, amount float64) (*pb.Transaction, error) {
+		rsp, err := s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: accID, TransactionType: typ, Amount: amount})
+		return rsp.GetTransaction(), err
+	}
+	account := func() *pb.Account {
+		accs, err := s.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(userID)})
+		require.NoError(t, err)
+		for _, acc := range accs.Accounts {
+			if acc.Id == accID {
+				return acc
+			}
+		}
+		require.FailNow(t, "account not found")
+		return nil
+	}
+
+	// no overdraft by default
+	_, err = create(pb.TransactionType_WITHDRAW, 150)
+	require.ErrorIs(t, err, errorSrv.ErrInvalidWithdrawTransactionAmount)
+
+	// set overdraft limit
+	_, err = s.SetOverdraftLimit(ctx, &pb.SetOverdraftLimitRequest{UserId: userID, AccountId: accID, OverdraftLimit: -1})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidOverdraftLimit)
+	_, err = s.SetOverdraftLimit(ctx, &pb.SetOverdraftLimitRequest{UserId: userID, AccountId: notFoundID, OverdraftLimit: 500})
+	require.ErrorIs(t, err, errorSrv.ErrAccountNotFound)
+	_, err = s.SetOverdraftLimit(ctx, &pb.SetOverdraftLimitRequest{UserId: notFoundID, AccountId: accID, OverdraftLimit: 500})
+	require.ErrorIs(t, err, errorSrv.ErrAccountNotFound)
+	rsp, err := s.SetOverdraftLimit(ctx, &pb.SetOverdraftLimitRequest{UserId: userID, AccountId: accID, OverdraftLimit: 500})
+	require.NoError(t, err)
+	require.EqualValues(t, 500, rsp.Account.OverdraftLimit)
+
+	// balance down to -overdraft_limit
+	trans, err := create(pb.TransactionType_WITHDRAW, 150)
+	require.NoError(t, err)
+	got := account()
+	require.EqualValues(t, -50, got.Balance)
+	require.EqualValues(t, 50, got.OverdraftUsed)
+	require.EqualValues(t, 500, got.OverdraftLimit)
+	_, err = create(pb.TransactionType_WITHDRAW, 451)
+	require.ErrorIs(t, err, errorSrv.ErrInvalidWithdrawTransactionAmount)
+	_, err = s.UpdateTransaction(ctx, &pb.UpdateTransactionRequest{UserId: userID, AccountId: accID, Transaction: &pb.Transaction{Id: trans.Id, Amount: 601}})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidWithdrawTransactionAmount)
+	_, err = s.UpdateTransaction(ctx, &pb.UpdateTransactionRequest{UserId: userID, AccountId: accID, Transaction: &pb.Transaction{Id: trans.Id, Amount: 600}})
+	require.NoError(t, err)
+	require.EqualValues(t, 500, account().OverdraftUsed)
+	// fees are posted by the service only
+	_, err = create(pb.TransactionType_FEE, 5)
+	require.ErrorIs(t, err, errorSrv.ErrInvalidTransactionType)
+
+	// fee charged once a day while negative
+	n, err := fees.Charge(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	n, err = fees.Charge(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	// fees may exceed the overdraft limit
+	got = account()
+	require.EqualValues(t, -505, got.Balance)
+	require.EqualValues(t, 505, got.OverdraftUsed)
+	now = now.Add(24 * time.Hour)
+	n, err = fees.Charge(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.EqualValues(t, -510, account().Balance)
+
+	// fees are listed, can't be changed & are kept on delete
+	list, err := s.ListTransactions(ctx, &pb.ListTransactionsRequest{UserId: userID, AccountId: accID})
+	require.NoError(t, err)
+	var feeIDs []int64
+	for _, trans := range list.Transactions {
+		if trans.TransactionType == pb.TransactionType_FEE {
+			require.EqualValues(t, 5, trans.Amount)
+			feeIDs = append(feeIDs, trans.Id)
+		}
+	}
+	require.Len(t, feeIDs, 2)
+	_, err = s.UpdateTransaction(ctx, &pb.UpdateTransactionRequest{UserId: userID, AccountId: accID, Transaction: &pb.Transaction{Id: feeIDs[0], Amount: 1}})
+	st := status.Convert(err)
+	require.Equal(t, codes.FailedPrecondition, st.Code())
+	require.Equal(t, status.Convert(errorSrv.ErrSystemTransaction).Message(), st.Message())
+	deleted, err := s.DeleteTransaction(ctx, &pb.DeleteTransactionRequest{UserId: userID, AccountId: wrapperspb.Int64(accID)})
+	require.NoError(t, err)
+	require.Equal(t, []int64{trans.Id}, deleted.Ids)
+	list, err = s.ListTransactions(ctx, &pb.ListTransactionsRequest{UserId: userID, AccountId: accID})
+	require.NoError(t, err)
+	require.Len(t, list.Transactions, 2)
+
+	// repaid, no more fees
+	_, err = create(pb.TransactionType_DEPOSIT, 1000)
+	require.NoError(t, err)
+	got = account()
+	require.EqualValues(t, 490, got.Balance)
+	require.Zero(t, got.OverdraftUsed)
+	now = now.Add(24 * time.Hour)
+	n, err = fees.Charge(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// exact balance withdrawn despite float drift, 3.3 - 1.1 - 2.2 < 0
+	usd, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Balance: 3.3, Currency: "USD"})
+	require.NoError(t, err)
+	for _, amount := range []float64{1.1, 2.2} {
+		_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: usd.Account.Id, TransactionType: pb.TransactionType_WITHDRAW, Amount: amount})
+		require.NoError(t, err)
+	}
+	_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: usd.Account.Id, TransactionType: pb.TransactionType_WITHDRAW, Amount: 0.01})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidWithdrawTransactionAmount)
+	// stored w/o drift, not overdrawn
+	accs, err := s.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(userID)})
+	require.NoError(t, err)
+	for _, acc := range accs.Accounts {
+		if acc.Id == usd.Account.Id {
+			require.Zero(t, acc.Balance)
+			require.Zero(t, acc.OverdraftUsed)
+		}
+	}
+	now = now.Add(24 * time.Hour)
+	n, err = fees.Charge(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func Test_userServiceImpl_Holds(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	now := time.Date(2021, 3, 10, 9, 0, 0, 0, time.UTC)
+	u.now = func() time.Time { return now }
+	expiry := NewHoldExpiry(u.dal, nil)
+	expiry.now = func() time.Time { return now }
+
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	userID := created.User.Id
+	acc, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Balance: 1000})
+	require.NoError(t, err)
+	accID := acc.Account.Id
+
+	authorize := func(amount float64) (*pb.Transaction, error) {
+		rsp, err := s.AuthorizeHold(ctx, &pb.AuthorizeHoldRequest{UserId: userID, AccountId: accID, Amount: amount})
+		return rsp.GetTransaction(), err
+	}
+	capture := func(id int64, amount float64) (*pb.Transaction, error) {
+		rsp, err := s.CaptureHold(ctx, &pb.CaptureHoldRequest{UserId: userID, AccountId: accID, Id: id, Amount: amount})
+		return rsp.GetTransaction(), err
+	}
+	void := func(id int64) (*pb.Transaction, error) {
+		rsp, err := s.VoidHold(ctx, &pb.VoidHoldRequest{UserId: userID, AccountId: accID, Id: id})
+		return rsp.GetTransaction(), err
+	}
+	// ledger & available balances
+	requireBalances := func(t *testing.T, ledger, available float64) {
+		accs, err := s.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(userID)})
+		require.NoError(t, err)
+		require.Len(t, accs.Accounts, 1)
+		require.EqualValues(t, ledger, accs.Accounts[0].LedgerBalance)
+		require.EqualValues(t, ledger, accs.Accounts[0].Balance)
+		require.EqualValues(t, available, accs.Accounts[0].AvailableBalance)
+	}
+
+	_, err = authorize(0)
+	require.ErrorIs(t, err, errorSrv.ErrInvalidTransactionAmountGT0)
+	_, err = s.AuthorizeHold(ctx, &pb.AuthorizeHoldRequest{UserId: userID, AccountId: notFoundID, Amount: 100})
+	require.ErrorIs(t, err, errorSrv.ErrAccountNotFound)
+	_, err = authorize(1001)
+	require.ErrorIs(t, err, errorSrv.ErrInvalidWithdrawTransactionAmount)
+
+	// holds reserve the available balance only
+	hold, err := authorize(600)
+	require.NoError(t, err)
+	require.Equal(t, pb.TransactionStatus_PENDING, hold.Status)
+	require.Equal(t, pb.TransactionType_WITHDRAW, hold.TransactionType)
+	require.True(t, now.Add(DefaultHoldTTL).Equal(hold.ExpiresAt.AsTime()))
+	requireBalances(t, 1000, 400)
+	_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: accID, TransactionType: pb.TransactionType_WITHDRAW, Amount: 500})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidWithdrawTransactionAmount)
+	_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: accID, TransactionType: pb.TransactionType_WITHDRAW, Amount: 100})
+	require.NoError(t, err)
+	requireBalances(t, 900, 300)
+	// holds change by capture or void only
+	_, err = s.UpdateTransaction(ctx, &pb.UpdateTransactionRequest{UserId: userID, AccountId: accID, Transaction: &pb.Transaction{Id: hold.Id, Amount: 1}})
+	require.ErrorIs(t, err, errorSrv.ErrTransactionNotPosted)
+
+	// partial capture releases the rest
+	_, err = capture(hold.Id, 601)
+	require.ErrorIs(t, err, errorSrv.ErrInvalidCaptureAmount)
+	_, err = capture(hold.Id, 499.5)
+	require.ErrorIs(t, err, errorSrv.ErrInvalidAmountPrecision)
+	_, err = capture(notFoundID, 0)
+	require.ErrorIs(t, err, errorSrv.ErrTransactionNotFound)
+	posted, err := capture(hold.Id, 500)
+	require.NoError(t, err)
+	require.Equal(t, pb.TransactionStatus_POSTED, posted.Status)
+	require.EqualValues(t, 500, posted.Amount)
+	require.Nil(t, posted.ExpiresAt)
+	requireBalances(t, 400, 400)
+	_, err = capture(hold.Id, 0)
+	require.ErrorIs(t, err, errorSrv.ErrHoldNotPending)
+	_, err = void(hold.Id)
+	require.ErrorIs(t, err, errorSrv.ErrHoldNotPending)
+
+	// full capture
+	hold, err = authorize(100)
+	require.NoError(t, err)
+	posted, err = capture(hold.Id, 0)
+	require.NoError(t, err)
+	require.EqualValues(t, 100, posted.Amount)
+	requireBalances(t, 300, 300)
+
+	// void
+	hold, err = authorize(200)
+	require.NoError(t, err)
+	requireBalances(t, 300, 100)
+	voided, err := void(hold.Id)
+	require.NoError(t, err)
+	require.Equal(t, pb.TransactionStatus_VOIDED, voided.Status)
+	requireBalances(t, 300, 300)
+	_, err = s.UpdateTransaction(ctx, &pb.UpdateTransactionRequest{UserId: userID, AccountId: accID, Transaction: &pb.Transaction{Id: hold.Id, Amount: 1}})
+	require.ErrorIs(t, err, errorSrv.ErrTransactionNotPosted)
+
+	// expiry
+	expired, err := authorize(100)
+	require.NoError(t, err)
+	_, err = authorize(50)
+	require.NoError(t, err)
+	requireBalances(t, 300, 150)
+	now = now.Add(time.Hour)
+	pending, err := authorize(50)
+	require.NoError(t, err)
+	n, err := expiry.Expire(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	now = now.Add(DefaultHoldTTL - time.Hour)
+	_, err = capture(expired.Id, 0)
+	require.ErrorIs(t, err, errorSrv.ErrHoldExpired)
+	n, err = expiry.Expire(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	n, err = expiry.Expire(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	requireBalances(t, 300, 250)
+
+	// statuses are listed, pending holds are kept on delete
+	list, err := s.ListTransactions(ctx, &pb.ListTransactionsRequest{UserId: userID, AccountId: accID})
+	require.NoError(t, err)
+	statuses := map[pb.TransactionStatus]int{}
+	for _, trans := range list.Transactions {
+		statuses[trans.Status]++
+	}
+	require.Equal(t, map[pb.TransactionStatus]int{pb.TransactionStatus_POSTED: 3, pb.TransactionStatus_VOIDED: 3, pb.TransactionStatus_PENDING: 1}, statuses)
+	_, err = s.DeleteTransaction(ctx, &pb.DeleteTransactionRequest{UserId: userID, AccountId: wrapperspb.Int64(accID)})
+	require.NoError(t, err)
+	list, err = s.ListTransactions(ctx, &pb.ListTransactionsRequest{UserId: userID, AccountId: accID})
+	require.NoError(t, err)
+	require.Len(t, list.Transactions, 1)
+	require.Equal(t, pending.Id, list.Transactions[0].Id)
+	require.Equal(t, pb.TransactionStatus_PENDING, list.Transactions[0].Status)
+}
+
+func Test_userServiceImpl_HoldsLimits(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	policy, err := NewTransactionLimitPolicy(&configs.TransactionLimits{
+		Tiers: map[string]*configs.TransactionLimit{"standard": {DailyWithdrawal: 300}},
+	})
+	require.NoError(t, err)
+	u.transactionLimits = policy
+
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	acc, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: created.User.Id, Bank: pb.Bank_VCB, Balance: 1000})
+	require.NoError(t, err)
+	req := &pb.AuthorizeHoldRequest{UserId: created.User.Id, AccountId: acc.Account.Id, Amount: 200}
+
+	// pending holds count as withdrawals, voided ones don't
+	hold, err := s.AuthorizeHold(ctx, req)
+	require.NoError(t, err)
+	_, err = s.AuthorizeHold(ctx, req)
+	st := status.Convert(err)
+	require.Equal(t, codes.FailedPrecondition, st.Code())
+	require.Equal(t, "Transaction limit exceeded", st.Message())
+	_, err = s.VoidHold(ctx, &pb.VoidHoldRequest{UserId: created.User.Id, AccountId: acc.Account.Id, Id: hold.Transaction.Id})
+	require.NoError(t, err)
+	_, err = s.AuthorizeHold(ctx, req)
+	require.NoError(t, err)
+}
+
+func Test_userServiceImpl_Schedules(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	// wednesday
+	now := time.Date(2021, 3, 10, 9, 0, 0, 0, time.UTC)
+	u.now = func() time.Time { return now }
+
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	userID := created.User.Id
+	acc, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Balance: 1000})
+	require.NoError(t, err)
+	accID := acc.Account.Id
+
+	requireBalance := func(t *testing.T, balance float64) {
+		accs, err := s.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(userID)})
+		require.NoError(t, err)
+		require.Len(t, accs.Accounts, 1)
+		require.EqualValues(t, balance, accs.Accounts[0].Balance)
+	}
+	get := func(t *testing.T, id int64) *pb.Schedule {
+		rsp, err := s.GetSchedule(ctx, &pb.GetScheduleRequest{UserId: userID, Id: id})
+		require.NoError(t, err)
+		return rsp.Schedule
+	}
+	post := func(t *testing.T, at time.Time, want int) {
+		now = at
+		n, err := u.PostDueSchedules(ctx)
+		require.NoError(t, err)
+		require.Equal(t, want, n)
+	}
+	deposit := &pb.CreateScheduleRequest{UserId: userID, AccountId: accID, TransactionType: pb.TransactionType_DEPOSIT, Amount: 100, Cron: "0 9 * * *", TimeZone: "Asia/Ho_Chi_Minh"}
+
+	// validate
+	tests := []struct {
+		name string
+		req  func(r *pb.CreateScheduleRequest)
+		err  error
+	}{
+		{"amount", func(r *pb.CreateScheduleRequest) { r.Amount = 0 }, errorSrv.ErrInvalidTransactionAmountGT0},
+		{"precision", func(r *pb.CreateScheduleRequest) { r.Amount = 100.5 }, errorSrv.ErrInvalidAmountPrecision},
+		{"fee", func(r *pb.CreateScheduleRequest) { r.TransactionType = pb.TransactionType_FEE }, errorSrv.ErrInvalidTransactionType},
+		{"cron", func(r *pb.CreateScheduleRequest) { r.Cron = "0 9 * *" }, errorSrv.ErrInvalidScheduleCron},
+		{"cron time zone", func(r *pb.CreateScheduleRequest) { r.Cron = "CRON_TZ=UTC 0 9 * * *" }, errorSrv.ErrInvalidScheduleCron},
+		{"time zone", func(r *pb.CreateScheduleRequest) { r.TimeZone = "Mars/Base" }, errorSrv.ErrInvalidTimeZone},
+		{"end before start", func(r *pb.CreateScheduleRequest) {
+			r.EndAt = timestamppb.New(now.Add(-time.Hour))
+		}, errorSrv.ErrInvalidScheduleRange},
+		{"end before next run", func(r *pb.CreateScheduleRequest) {
+			r.EndAt = timestamppb.New(now.Add(time.Hour))
+		}, errorSrv.ErrInvalidScheduleRange},
+		{"account", func(r *pb.CreateScheduleRequest) { r.AccountId = notFoundID }, errorSrv.ErrAccountNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := proto.Clone(deposit).(*pb.CreateScheduleRequest)
+			tt.req(req)
+			_, err := s.CreateSchedule(ctx, req)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	// first run at 9:00 in its time zone
+	rsp, err := s.CreateSchedule(ctx, deposit)
+	require.NoError(t, err)
+	sched := rsp.Schedule
+	require.True(t, now.Equal(sched.StartAt.AsTime()))
+	require.True(t, time.Date(2021, 3, 11, 2, 0, 0, 0, time.UTC).Equal(sched.NextRunAt.AsTime()))
+	post(t, now, 0)
+
+	// posted once per run
+	post(t, time.Date(2021, 3, 11, 2, 0, 0, 0, time.UTC), 1)
+	post(t, time.Date(2021, 3, 11, 2, 30, 0, 0, time.UTC), 0)
+	requireBalance(t, 1100)
+	sched = get(t, sched.Id)
+	require.NotZero(t, sched.LastTransactionId)
+	require.Empty(t, sched.LastError)
+	require.True(t, time.Date(2021, 3, 11, 2, 0, 0, 0, time.UTC).Equal(sched.LastRunAt.AsTime()))
+	require.True(t, time.Date(2021, 3, 12, 2, 0, 0, 0, time.UTC).Equal(sched.NextRunAt.AsTime()))
+	trans, err := s.ListTransactions(ctx, &pb.ListTransactionsRequest{UserId: userID})
+	require.NoError(t, err)
+	require.Len(t, trans.Transactions, 1)
+	require.Equal(t, sched.LastTransactionId, trans.Transactions[0].Id)
+
+	// missed runs are skipped
+	post(t, time.Date(2021, 3, 14, 5, 0, 0, 0, time.UTC), 1)
+	requireBalance(t, 1200)
+	require.True(t, time.Date(2021, 3, 15, 2, 0, 0, 0, time.UTC).Equal(get(t, sched.Id).NextRunAt.AsTime()))
+
+	// rejected transactions are recorded, the schedule goes on
+	withdraw, err := s.CreateSchedule(ctx, &pb.CreateScheduleRequest{UserId: userID, AccountId: accID, TransactionType: pb.TransactionType_WITHDRAW, Amount: 5000, Cron: "@hourly"})
+	require.NoError(t, err)
+	post(t, now.Add(time.Hour), 0)
+	failed := get(t, withdraw.Schedule.Id)
+	require.Equal(t, status.Convert(errorSrv.ErrInvalidWithdrawTransactionAmount).Message(), failed.LastError)
+	require.Zero(t, failed.LastTransactionId)
+	require.True(t, now.Add(time.Hour).Equal(failed.NextRunAt.AsTime()))
+	requireBalance(t, 1200)
+
+	// updated schedules are planned again
+	_, err = s.UpdateSchedule(ctx, &pb.UpdateScheduleRequest{UserId: userID, Schedule: &pb.Schedule{Id: failed.Id, Amount: 50}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"transaction_type"}}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.UpdateSchedule(ctx, &pb.UpdateScheduleRequest{UserId: userID, Schedule: &pb.Schedule{Id: failed.Id, Cron: "0 9 * *"}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"cron"}}})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidScheduleCron)
+	_, err = s.UpdateSchedule(ctx, &pb.UpdateScheduleRequest{UserId: userID, Schedule: &pb.Schedule{Id: failed.Id, Amount: 50.5}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"amount"}}})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidAmountPrecision)
+	end := now.Add(3 * time.Hour)
+	updated, err := s.UpdateSchedule(ctx, &pb.UpdateScheduleRequest{UserId: userID, Schedule: &pb.Schedule{Id: failed.Id, Amount: 50, EndAt: timestamppb.New(end)}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"amount", "end_at"}}})
+	require.NoError(t, err)
+	require.EqualValues(t, 50, updated.Schedule.Amount)
+	require.Equal(t, "@hourly", updated.Schedule.Cron)
+	require.True(t, end.Equal(updated.Schedule.EndAt.AsTime()))
+	post(t, now.Add(time.Hour), 1)
+	requireBalance(t, 1150)
+	require.Empty(t, get(t, failed.Id).LastError)
+
+	// ended after its last run
+	post(t, end, 1)
+	requireBalance(t, 1100)
+	require.Nil(t, get(t, failed.Id).NextRunAt)
+	post(t, end.Add(time.Hour), 0)
+
+	// list, by account
+	list, err := s.ListSchedules(ctx, &pb.ListSchedulesRequest{UserId: userID})
+	require.NoError(t, err)
+	require.Len(t, list.Schedules, 2)
+	list, err = s.ListSchedules(ctx, &pb.ListSchedulesRequest{UserId: userID, AccountId: wrapperspb.Int64(notFoundID)})
+	require.NoError(t, err)
+	require.Empty(t, list.Schedules)
+
+	// schedules of the user only
+	_, err = s.GetSchedule(ctx, &pb.GetScheduleRequest{UserId: notFoundID, Id: sched.Id})
+	require.ErrorIs(t, err, errorSrv.ErrScheduleNotFound)
+	_, err = s.DeleteSchedule(ctx, &pb.DeleteScheduleRequest{UserId: notFoundID, Id: sched.Id})
+	require.ErrorIs(t, err, errorSrv.ErrScheduleNotFound)
+
+	// posted transactions are kept
+	deleted, err := s.DeleteSchedule(ctx, &pb.DeleteScheduleRequest{UserId: userID, Id: sched.Id})
+	require.NoError(t, err)
+	require.Equal(t, sched.Id, deleted.Id)
+	_, err = s.GetSchedule(ctx, &pb.GetScheduleRequest{UserId: userID, Id: sched.Id})
+	require.ErrorIs(t, err, errorSrv.ErrScheduleNotFound)
+	post(t, time.Date(2021, 3, 15, 2, 0, 0, 0, time.UTC), 0)
+	requireBalance(t, 1100)
+
+	// scheduler takes its lock
+	_, err = s.CreateSchedule(ctx, deposit)
+	require.NoError(t, err)
+	now = time.Date(2021, 3, 16, 2, 0, 0, 0, time.UTC)
+	n, err := NewScheduler(u.dal, u, nil).post(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	requireBalance(t, 1200)
+}
+
+func Test_userServiceImpl_SchedulesVerifiedEmail(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	u.scheduleVerifiedEmail = true
+	now := time.Date(2021, 3, 10, 9, 0, 0, 0, time.UTC)
+	u.now = func() time.Time { return now }
+
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	userID := created.User.Id
+	acc, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Balance: 1000})
+	require.NoError(t, err)
+	sched, err := s.CreateSchedule(ctx, &pb.CreateScheduleRequest{UserId: userID, AccountId: acc.Account.Id, TransactionType: pb.TransactionType_WITHDRAW, Amount: 100, Cron: "@hourly"})
+	require.NoError(t, err)
+	verify := func(verified bool) {
+		require.NoError(t, u.dal.GetDatabase().Model(&model.User{}).Where("id = ?", userID).Update("email_verified", verified).Error)
+	}
+
+	// runs of unverified users are skipped & recorded
+	now = now.Add(time.Hour)
+	n, err := u.PostDueSchedules(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	got, err := s.GetSchedule(ctx, &pb.GetScheduleRequest{UserId: userID, Id: sched.Schedule.Id})
+	require.NoError(t, err)
+	require.Equal(t, status.Convert(errorSrv.ErrEmailNotVerified).Message(), got.Schedule.LastError)
+	require.True(t, now.Add(time.Hour).Equal(got.Schedule.NextRunAt.AsTime()))
+
+	verify(true)
+	now = now.Add(time.Hour)
+	n, err = u.PostDueSchedules(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// ex: email changed
+	verify(false)
+	now = now.Add(time.Hour)
+	n, err = u.PostDueSchedules(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestNewInterestAccrual(t *testing.T) {
+	accrual, err := NewInterestAccrual(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, DefaultInterestCheckInterval, accrual.interval)
+	require.Equal(t, time.UTC, accrual.location)
+	_, err = NewInterestAccrual(nil, &configs.Interest{Location: "Mars/Base"})
+	require.Error(t, err)
+	accrual, err = NewInterestAccrual(nil, &configs.Interest{CheckInterval: time.Minute, Location: "Asia/Tokyo"})
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, accrual.interval)
+	// days close in the accrual time zone
+	accrual.now = func() time.Time { return time.Date(2021, 3, 10, 20, 0, 0, 0, time.UTC) }
+	require.Equal(t, "2021-03-10", accrual.LastClosedDay())
+	_, err = accrual.Accrue(context.TODO(), "03/10/2021")
+	require.Error(t, err)
+	require.NoError(t, accrual.CheckDay("2021-03-10"))
+	require.NoError(t, accrual.CheckDay("2020-12-31"))
+	// the current & future days aren't closed
+	for _, day := range []string{"2021-03-11", "2022-01-01"} {
+		_, err = accrual.Accrue(context.TODO(), day)
+		require.ErrorIs(t, err, errorSrv.ErrAccrualDayNotClosed)
+	}
+}
+
+func Test_userServiceImpl_Interest(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+	now := time.Date(2021, 3, 10, 9, 0, 0, 0, time.UTC)
+	u.now = func() time.Time { return now }
+	accrual, err := NewInterestAccrual(u.dal, nil)
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	userID := created.User.Id
+	acc, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Balance: 1000, Currency: "USD"})
+	require.NoError(t, err)
+	savingsID := acc.Account.Id
+	acc, err = s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_ACB, Balance: 500, Currency: "USD"})
+	require.NoError(t, err)
+	checkingID := acc.Account.Id
+
+	account := func(t *testing.T, id int64) *pb.Account {
+		accs, err := s.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(userID)})
+		require.NoError(t, err)
+		for _, acc := range accs.Accounts {
+			if acc.Id == id {
+				return acc
+			}
+		}
+		require.FailNow(t, "account not found")
+		return nil
+	}
+	accrue := func(t *testing.T, day string, want int) {
+		n, err := accrual.Accrue(ctx, day)
+		require.NoError(t, err)
+		require.Equal(t, want, n)
+	}
+	transactions := func(t *testing.T, id int64, typ pb.TransactionType) []*pb.ListTransactionsResponse_Result {
+		list, err := s.ListTransactions(ctx, &pb.ListTransactionsRequest{UserId: userID, AccountId: id})
+		require.NoError(t, err)
+		var found []*pb.ListTransactionsResponse_Result
+		for _, trans := range list.Transactions {
+			if trans.TransactionType == typ {
+				found = append(found, trans)
+			}
+		}
+		return found
+	}
+
+	// rate plans
+	tests := []struct {
+		name string
+		req  *pb.CreateRatePlanRequest
+		err  error
+	}{
+		{"name", &pb.CreateRatePlanRequest{Name: " ", AnnualInterestRate: "0.01"}, errorSrv.ErrMissingRatePlanName},
+		{"rate", &pb.CreateRatePlanRequest{Name: "savings", AnnualInterestRate: "1%"}, errorSrv.ErrInvalidInterestRate},
+		{"negative rate", &pb.CreateRatePlanRequest{Name: "savings", AnnualInterestRate: "-0.01"}, errorSrv.ErrInvalidInterestRate},
+		{"fee", &pb.CreateRatePlanRequest{Name: "savings", MonthlyFee: -1}, errorSrv.ErrInvalidMonthlyFee},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.CreateRatePlan(ctx, tt.req)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+	// 1000 at 3.65% accrues 0.1 a day
+	savings, err := s.CreateRatePlan(ctx, &pb.CreateRatePlanRequest{Name: "savings", AnnualInterestRate: "0.0365"})
+	require.NoError(t, err)
+	require.Equal(t, "0.0365", savings.RatePlan.AnnualInterestRate)
+	_, err = s.CreateRatePlan(ctx, &pb.CreateRatePlanRequest{Name: "savings", AnnualInterestRate: "0.01"})
+	require.ErrorIs(t, err, errorSrv.ErrDuplicateRatePlanName)
+	checking, err := s.CreateRatePlan(ctx, &pb.CreateRatePlanRequest{Name: "checking", MonthlyFee: 2})
+	require.NoError(t, err)
+	require.Equal(t, "0", checking.RatePlan.AnnualInterestRate)
+	plans, err := s.ListRatePlans(ctx, &pb.ListRatePlansRequest{})
+	require.NoError(t, err)
+	require.Len(t, plans.RatePlans, 2)
+
+	// attach
+	_, err = s.SetAccountRatePlan(ctx, &pb.SetAccountRatePlanRequest{UserId: userID, AccountId: savingsID, RatePlanId: notFoundID})
+	require.ErrorIs(t, err, errorSrv.ErrRatePlanNotFound)
+	_, err = s.SetAccountRatePlan(ctx, &pb.SetAccountRatePlanRequest{UserId: notFoundID, AccountId: savingsID, RatePlanId: savings.RatePlan.Id})
+	require.ErrorIs(t, err, errorSrv.ErrAccountNotFound)
+	rsp, err := s.SetAccountRatePlan(ctx, &pb.SetAccountRatePlanRequest{UserId: userID, AccountId: savingsID, RatePlanId: savings.RatePlan.Id})
+	require.NoError(t, err)
+	require.Equal(t, savings.RatePlan.Id, rsp.Account.RatePlanId)
+	_, err = s.SetAccountRatePlan(ctx, &pb.SetAccountRatePlanRequest{UserId: userID, AccountId: checkingID, RatePlanId: checking.RatePlan.Id})
+	require.NoError(t, err)
+
+	// accrued from the day attached, once a day
+	accrue(t, "2021-03-09", 0)
+	accrue(t, "2021-03-10", 2)
+	accrue(t, "2021-03-10", 0)
+	accrue(t, "2021-03-05", 0)
+	require.EqualValues(t, 0.1, account(t, savingsID).AccruedInterest)
+	require.EqualValues(t, 1000, account(t, savingsID).Balance)
+	require.Empty(t, transactions(t, savingsID, pb.TransactionType_INTEREST))
+
+	// missed days caught up, interest & fees posted at month end
+	accrue(t, "2021-03-31", 2)
+	accrue(t, "2021-03-31", 0)
+	got := account(t, savingsID)
+	require.EqualValues(t, 1002.2, got.Balance)
+	require.Zero(t, got.AccruedInterest)
+	interest := transactions(t, savingsID, pb.TransactionType_INTEREST)
+	require.Len(t, interest, 1)
+	require.EqualValues(t, 2.2, interest[0].Amount)
+	require.True(t, time.Date(2021, 3, 31, 23, 59, 59, 0, time.UTC).Equal(interest[0].CreatedAt.AsTime()))
+	require.EqualValues(t, 498, account(t, checkingID).Balance)
+	fees := transactions(t, checkingID, pb.TransactionType_FEE)
+	require.Len(t, fees, 1)
+	require.EqualValues(t, 2, fees[0].Amount)
+	require.Empty(t, transactions(t, checkingID, pb.TransactionType_INTEREST))
+
+	// interest is posted by the service only, can't be changed & is kept on delete
+	_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: savingsID, TransactionType: pb.TransactionType_INTEREST, Amount: 1})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidTransactionType)
+	_, err = s.UpdateTransaction(ctx, &pb.UpdateTransactionRequest{UserId: userID, AccountId: savingsID, Transaction: &pb.Transaction{Id: interest[0].Id, Amount: 1}})
+	require.Equal(t, status.Convert(errorSrv.ErrSystemTransaction).Message(), status.Convert(err).Message())
+	deleted, err := s.DeleteTransaction(ctx, &pb.DeleteTransactionRequest{UserId: userID, AccountId: wrapperspb.Int64(savingsID)})
+	require.NoError(t, err)
+	require.Empty(t, deleted.Ids)
+	require.Len(t, transactions(t, savingsID, pb.TransactionType_INTEREST), 1)
+
+	// accrued on the balance w interest, sub-cent interest carried over
+	accrue(t, "2021-04-01", 2)
+	require.EqualValues(t, 0.1, account(t, savingsID).AccruedInterest)
+
+	// detached, accrued interest is posted
+	now = time.Date(2021, 4, 2, 9, 0, 0, 0, time.UTC)
+	rsp, err = s.SetAccountRatePlan(ctx, &pb.SetAccountRatePlanRequest{UserId: userID, AccountId: savingsID})
+	require.NoError(t, err)
+	require.Zero(t, rsp.Account.RatePlanId)
+	require.EqualValues(t, 1002.3, rsp.Account.Balance)
+	require.Len(t, transactions(t, savingsID, pb.TransactionType_INTEREST), 2)
+	accrue(t, "2021-04-02", 1)
+	require.EqualValues(t, 1002.3, account(t, savingsID).Balance)
+
+	// posted in whole units of currencies w/o minor units, 1500 VND at 3.65% accrues 0.15 a day
+	plan, err := s.CreateRatePlan(ctx, &pb.CreateRatePlanRequest{Name: "vnd", AnnualInterestRate: "0.0365", MonthlyFee: 2.4})
+	require.NoError(t, err)
+	acc, err = s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Balance: 1500})
+	require.NoError(t, err)
+	vndID := acc.Account.Id
+	_, err = s.SetAccountRatePlan(ctx, &pb.SetAccountRatePlanRequest{UserId: userID, AccountId: vndID, RatePlanId: plan.RatePlan.Id})
+	require.NoError(t, err)
+	accrue(t, "2021-04-30", 2)
+	got = account(t, vndID)
+	require.EqualValues(t, 1500+4-2, got.Balance)
+	require.EqualValues(t, 0.35, got.AccruedInterest)
+	interest = transactions(t, vndID, pb.TransactionType_INTEREST)
+	require.Len(t, interest, 1)
+	require.EqualValues(t, 4, interest[0].Amount)
+	fees = transactions(t, vndID, pb.TransactionType_FEE)
+	require.Len(t, fees, 1)
+	require.EqualValues(t, 2, fees[0].Amount)
+
+	// caught up days accrue on their closing balance, w/o later deposits: 1502 accrues 0.1502 a day
+	now = time.Date(2021, 5, 4, 9, 0, 0, 0, time.UTC)
+	_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: vndID, TransactionType: pb.TransactionType_DEPOSIT, Amount: 365000})
+	require.NoError(t, err)
+	accrue(t, "2021-05-03", 2)
+	require.EqualValues(t, 0.35+0.45, account(t, vndID).AccruedInterest)
+	accrue(t, "2021-05-04", 2)
+	require.EqualValues(t, 0.8+36.65, account(t, vndID).AccruedInterest)
+}
+
+func Test_userServiceImpl_Transfers(t *testing.T) {
+	s := newUserService(t)
+	require.NotNil(t, s)
+	u := s.(*userServiceImpl)
+
+	ctx := context.TODO()
+	created, err := s.Create(ctx, &pb.CreateUserRequest{Email: "abc@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	userID := created.User.Id
+	other, err := s.Create(ctx, &pb.CreateUserRequest{Email: "xyz@gmail.com", Password: "stringstring"})
+	require.NoError(t, err)
+	otherID := other.User.Id
+
+	// currencies of accounts
+	_, err = s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Currency: "US"})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidCurrency)
+	acc, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_VCB, Balance: 1000000})
+	require.NoError(t, err)
+	require.Equal(t, model.DefaultCurrency, acc.Account.Currency)
+	vndID := acc.Account.Id
+	acc, err = s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: userID, Bank: pb.Bank_ACB, Balance: 100, Currency: "usd"})
+	require.NoError(t, err)
+	require.Equal(t, "USD", acc.Account.Currency)
+	usdID := acc.Account.Id
+	acc, err = s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: otherID, Bank: pb.Bank_VIB, Currency: "JPY"})
+	require.NoError(t, err)
+	jpyID := acc.Account.Id
+	acc, err = s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: otherID, Bank: pb.Bank_VIB, Currency: "EUR"})
+	require.NoError(t, err)
+	eurID := acc.Account.Id
+
+	// transactions in another currency than the account are rejected
+	_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: usdID, Amount: 10, TransactionType: pb.TransactionType_DEPOSIT, Currency: "VND"})
+	require.ErrorIs(t, err, errorSrv.ErrCurrencyMismatch)
+	_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: usdID, Amount: 10, TransactionType: pb.TransactionType_DEPOSIT, Currency: "dollar"})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidCurrency)
+	_, err = s.AuthorizeHold(ctx, &pb.AuthorizeHoldRequest{UserId: userID, AccountId: usdID, Amount: 10, Currency: "VND"})
+	require.ErrorIs(t, err, errorSrv.ErrCurrencyMismatch)
+	// amounts are in minor units of the account currency
+	_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: usdID, Amount: 10.001, TransactionType: pb.TransactionType_DEPOSIT})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidAmountPrecision)
+	_, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: vndID, Amount: 0.5, TransactionType: pb.TransactionType_WITHDRAW})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidAmountPrecision)
+	_, err = s.AuthorizeHold(ctx, &pb.AuthorizeHoldRequest{UserId: userID, AccountId: vndID, Amount: 1000.5})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidAmountPrecision)
+	trans, err := s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: usdID, Amount: 10, TransactionType: pb.TransactionType_DEPOSIT, Currency: "usd"})
+	require.NoError(t, err)
+	require.Equal(t, "USD", trans.Transaction.Currency)
+	depositID := trans.Transaction.Id
+	trans, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: vndID, Amount: 1000, TransactionType: pb.TransactionType_WITHDRAW})
+	require.NoError(t, err)
+	require.Equal(t, "VND", trans.Transaction.Currency)
+
+	// invalid transfers
+	tests := []struct {
+		name string
+		req  *pb.CreateTransferRequest
+		err  error
+	}{
+		{"user", &pb.CreateTransferRequest{FromAccountId: usdID, ToAccountId: jpyID, Amount: 1}, errorSrv.ErrMissingUserID},
+		{"account", &pb.CreateTransferRequest{UserId: userID, FromAccountId: usdID, Amount: 1}, errorSrv.ErrMissingAccountID},
+		{"same account", &pb.CreateTransferRequest{UserId: userID, FromAccountId: usdID, ToAccountId: usdID, Amount: 1}, errorSrv.ErrSameTransferAccount},
+		{"amount", &pb.CreateTransferRequest{UserId: userID, FromAccountId: usdID, ToAccountId: jpyID}, errorSrv.ErrInvalidTransactionAmountGT0},
+		{"account of another user", &pb.CreateTransferRequest{UserId: userID, FromAccountId: jpyID, ToAccountId: usdID, Amount: 1}, errorSrv.ErrAccountNotFound},
+		{"destination", &pb.CreateTransferRequest{UserId: userID, FromAccountId: usdID, ToAccountId: notFoundID, Amount: 1}, errorSrv.ErrAccountNotFound},
+		{"currency", &pb.CreateTransferRequest{UserId: userID, FromAccountId: usdID, ToAccountId: jpyID, Amount: 1, Currency: "JPY"}, errorSrv.ErrCurrencyMismatch},
+		{"precision", &pb.CreateTransferRequest{UserId: userID, FromAccountId: vndID, ToAccountId: usdID, Amount: 0.4}, errorSrv.ErrInvalidAmountPrecision},
+		{"precision w minor units", &pb.CreateTransferRequest{UserId: userID, FromAccountId: usdID, ToAccountId: jpyID, Amount: 0.005}, errorSrv.ErrInvalidAmountPrecision},
+		{"no rates", &pb.CreateTransferRequest{UserId: userID, FromAccountId: usdID, ToAccountId: jpyID, Amount: 1}, errorSrv.ErrExchangeRateNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.CreateTransfer(ctx, tt.req)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	// same currency transfers need no rate
+	vnd2, err := s.CreateAccount(ctx, &pb.CreateAccountRequest{UserId: otherID, Bank: pb.Bank_VCB})
+	require.NoError(t, err)
+	transfer, err := s.CreateTransfer(ctx, &pb.CreateTransferRequest{UserId: userID, FromAccountId: vndID, ToAccountId: vnd2.Account.Id, Amount: 5000})
+	require.NoError(t, err)
+	require.Equal(t, "1", transfer.Withdrawal.FxRate)
+	require.Equal(t, float64(5000), transfer.Deposit.Amount)
+
+	u.rates, err = fx.NewStatic("USD", map[string]string{"VND": "23000", "JPY": "110"})
+	require.NoError(t, err)
+	_, err = s.CreateTransfer(ctx, &pb.CreateTransferRequest{UserId: userID, FromAccountId: usdID, ToAccountId: eurID, Amount: 1})
+	require.ErrorIs(t, err, errorSrv.ErrExchangeRateNotFound)
+	_, err = s.CreateTransfer(ctx, &pb.CreateTransferRequest{UserId: userID, FromAccountId: vndID, ToAccountId: usdID, Amount: 1e9})
+	require.ErrorIs(t, err, errorSrv.ErrInvalidWithdrawTransactionAmount)
+
+	// rate & amounts of both legs are recorded
+	transfer, err = s.CreateTransfer(ctx, &pb.CreateTransferRequest{UserId: userID, FromAccountId: usdID, ToAccountId: jpyID, Amount: 10, Currency: "USD"})
+	require.NoError(t, err)
+	w, d := transfer.Withdrawal, transfer.Deposit
+	require.Equal(t, pb.TransactionType_WITHDRAW, w.TransactionType)
+	require.Equal(t, usdID, w.AccountId)
+	require.Equal(t, float64(10), w.Amount)
+	require.Equal(t, "USD", w.Currency)
+	require.Equal(t, "110", w.FxRate)
+	require.Equal(t, float64(1100), w.CounterAmount)
+	require.Equal(t, "JPY", w.CounterCurrency)
+	require.Equal(t, d.Id, w.CounterTransactionId)
+	require.Equal(t, pb.TransactionType_DEPOSIT, d.TransactionType)
+	require.Equal(t, jpyID, d.AccountId)
+	require.Equal(t, float64(1100), d.Amount)
+	require.Equal(t, "JPY", d.Currency)
+	require.Equal(t, "110", d.FxRate)
+	require.Equal(t, float64(10), d.CounterAmount)
+	require.Equal(t, "USD", d.CounterCurrency)
+	require.Equal(t, w.Id, d.CounterTransactionId)
+
+	// cross rates are rounded to 12 decimals & amounts to the minor unit of the currency
+	transfer, err = s.CreateTransfer(ctx, &pb.CreateTransferRequest{UserId: userID, FromAccountId: vndID, ToAccountId: usdID, Amount: 100000})
+	require.NoError(t, err)
+	require.Equal(t, "0.000043478261", transfer.Withdrawal.FxRate)
+	require.Equal(t, 4.35, transfer.Deposit.Amount)
+	transfer, err = s.CreateTransfer(ctx, &pb.CreateTransferRequest{UserId: userID, FromAccountId: vndID, ToAccountId: jpyID, Amount: 1000})
+	require.NoError(t, err)
+	require.Equal(t, "0.004782608696", transfer.Withdrawal.FxRate)
+	require.Equal(t, float64(5), transfer.Deposit.Amount)
+	// converted amounts below 1
+	transfer, err = s.CreateTransfer(ctx, &pb.CreateTransferRequest{UserId: userID, FromAccountId: vndID, ToAccountId: usdID, Amount: 10000})
+	require.NoError(t, err)
+	require.Equal(t, 0.43, transfer.Deposit.Amount)
+	trans, err = s.CreateTransaction(ctx, &pb.CreateTransactionRequest{UserId: userID, AccountId: usdID, Amount: 0.5, TransactionType: pb.TransactionType_DEPOSIT})
+	require.NoError(t, err)
+	halfID := trans.Transaction.Id
+
+	// balances
+	accs, err := s.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(userID)})
+	require.NoError(t, err)
+	for _, acc := range accs.Accounts {
+		switch acc.Id {
+		case vndID:
+			require.InDelta(t, 1000000-1000-5000-100000-1000-10000, acc.Balance, 1e-9)
+		case usdID:
+			require.InDelta(t, 100+10-10+4.35+0.43+0.5, acc.Balance, 1e-9)
+		}
+	}
+	accs, err = s.ListAccounts(ctx, &pb.ListAccountsRequest{UserId: wrapperspb.Int64(otherID)})
+	require.NoError(t, err)
+	for _, acc := range accs.Accounts {
+		if acc.Id == jpyID {
+			require.Equal(t, float64(1105), acc.Balance)
+		}
+	}
+	list, err := s.ListTransactions(ctx, &pb.ListTransactionsRequest{UserId: otherID, AccountId: jpyID})
+	require.NoError(t, err)
+	require.Len(t, list.Transactions, 2)
+	for _, trans := range list.Transactions {
+		require.Equal(t, "JPY", trans.Currency)
+	}
+
+	// legs can't be changed nor deleted
+	_, err = s.UpdateTransaction(ctx, &pb.UpdateTransactionRequest{UserId: userID, AccountId: usdID, Transaction: &pb.Transaction{Id: w.Id, Amount: 1}})
+	require.ErrorIs(t, err, errorSrv.ErrTransferTransaction)
+	deleted, err := s.DeleteTransaction(ctx, &pb.DeleteTransactionRequest{UserId: userID, AccountId: wrapperspb.Int64(usdID)})
+	require.NoError(t, err)
+	require.Equal(t, []int64{depositID, halfID}, deleted.Ids)
+}
@@ -0,0 +1,100 @@
+package user
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/1412335/moneyforward-go-coding-challenge/pkg/dal/postgres"
+	errorSrv "github.com/1412335/moneyforward-go-coding-challenge/service/user/error"
+	"github.com/1412335/moneyforward-go-coding-challenge/service/user/model"
+)
+
+// user states checked per request, read from the primary so changes apply immediately
+type dbUserStore struct {
+	dal *postgres.DataAccessLayer
+}
+
+var (
+	_ TokenVersionStore      = (*dbUserStore)(nil)
+	_ EmailVerificationStore = (*dbUserStore)(nil)
+)
+
+func NewTokenVersionStore(dal *postgres.DataAccessLayer) TokenVersionStore {
+	return &dbUserStore{dal: dal}
+}
+
+func NewEmailVerificationStore(dal *postgres.DataAccessLayer) EmailVerificationStore {
+	return &dbUserStore{dal: dal}
+}
+
+// select columns of user by id
+func (s *dbUserStore) find(ctx context.Context, userID int64, columns ...string) (*model.User, error) {
+	db := s.dal.GetDatabase()
+	if db == nil {
+		return nil, errorSrv.ErrDBUnavailable
+	}
+	var user model.User
+	if e := db.WithContext(ctx).Select(columns).Where("id = ?", userID).First(&user).Error; e == gorm.ErrRecordNotFound {
+		return nil, errorSrv.ErrUserNotFound
+	} else if e != nil {
+		return nil, e
+	}
+	return &user, nil
+}
+
+func (s *dbUserStore) TokenVersion(ctx context.Context, userID int64) (int64, error) {
+	user, err := s.find(ctx, userID, "token_version")
+	if err != nil {
+		return 0, err
+	}
+	return user.TokenVersion, nil
+}
+
+func (s *dbUserStore) EmailVerified(ctx context.Context, userID int64) (bool, error) {
+	user, err := s.find(ctx, userID, "email_verified")
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerified, nil
+}
+
+// currencies of accounts & schedules, converting amounts of requests checked per request
+type dbAccountStore struct {
+	dal *postgres.DataAccessLayer
+}
+
+var _ AccountCurrencyStore = (*dbAccountStore)(nil)
+
+func NewAccountCurrencyStore(dal *postgres.DataAccessLayer) AccountCurrencyStore {
+	return &dbAccountStore{dal: dal}
+}
+
+func (s *dbAccountStore) AccountCurrency(ctx context.Context, userID, accountID int64) (string, error) {
+	db := s.dal.GetDatabase()
+	if db == nil {
+		return "", errorSrv.ErrDBUnavailable
+	}
+	var acc model.Account
+	if e := db.WithContext(ctx).Select("currency").Where(&model.Account{ID: accountID, UserID: userID}).First(&acc).Error; e == gorm.ErrRecordNotFound {
+		return "", errorSrv.ErrAccountNotFound
+	} else if e != nil {
+		return "", e
+	}
+	return acc.Currency, nil
+}
+
+func (s *dbAccountStore) ScheduleCurrency(ctx context.Context, userID, scheduleID int64) (string, error) {
+	db := s.dal.GetDatabase()
+	if db == nil {
+		return "", errorSrv.ErrDBUnavailable
+	}
+	var acc model.Account
+	if e := db.WithContext(ctx).Select("accounts.currency").Joins("JOIN schedules ON schedules.account_id = accounts.id").
+		Where("schedules.id = ? AND schedules.user_id = ?", scheduleID, userID).First(&acc).Error; e == gorm.ErrRecordNotFound {
+		return "", errorSrv.ErrScheduleNotFound
+	} else if e != nil {
+		return "", e
+	}
+	return acc.Currency, nil
+}
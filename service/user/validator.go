@@ -12,7 +12,3 @@ func isValidEmail(email string) bool {
 	}
 	return emailRegex.MatchString(email)
 }
-
-func isValidPassword(password string) bool {
-	return len(password) >= 8
-}